@@ -5,15 +5,43 @@ import (
 	"fmt"
 	"net/http"
 	"prodata/logs"
+	"strconv"
 )
 
 type ApiFunc func(ctx *Context)
-type ApiFuncMiddleWare func(ctx *Context, userId string)
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// Principal é quem fez a requisição, ele é preenchido pelos middlewares
+// de autenticação e lido pelos handlers com ctx.User()
+type Principal struct {
+	UserId   string
+	DeviceId string
+	Roles    []string
+}
+
+func (p *Principal) HasRole(role string) bool {
+	if p == nil {
+		return false
+	}
+
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
 
 type Context struct {
 	Request      *http.Request
 	Writer       http.ResponseWriter
 	Json         func(v any) error
+	JsonStatus   func(code int, v any) error
 	ReadJson     func(v any) error
 	Logger       logs.Logger
 	PureRoute    string
@@ -21,101 +49,76 @@ type Context struct {
 	WriteHeader  func(code int)
 	IP           string
 	IfErrNotNull func(err error) bool
-}
-
-type Routes struct {
-	RealRoute    string
-	DynamicRoute func() string
-}
-
-func (ctx *Context) NewRoutes() *Routes {
-	return &Routes{
-		RealRoute: ctx.Request.URL.Path,
-		DynamicRoute: func() string {
-			r := ctx.PureRoute
-			if len(r) == len(ctx.Request.URL.Path) {
-				return ""
-			}
-
-			return ctx.Request.URL.Path[len(r):]
-		},
-	}
+	Params       map[string]string
+	principal    *Principal
 }
 
 func NewContext(w http.ResponseWriter, r *http.Request, route string) *Context {
-	return &Context{
-		Request: r,
-		Writer:  w,
-		Json: func(v any) error {
-			w.Header().Set("Content-Type", "application/json")
-			return json.NewEncoder(w).Encode(v)
-		},
-		ReadJson: func(v any) error {
-			return json.NewDecoder(r.Body).Decode(v)
-		},
+	ctx := &Context{
+		Request:   r,
+		Writer:    w,
 		Logger:    *logs.NewSistemLogger(),
 		PureRoute: route,
-		Error: func(error any, code int) {
-			http.Error(w, fmt.Sprintf("%s", error), code)
-		},
-		WriteHeader: func(code int) {
-			w.WriteHeader(code)
-		},
-		IP: r.RemoteAddr,
-		IfErrNotNull: func(err error) bool {
-			if err != nil {
-				logs.NewLogger().LogAndSendSystemMessage(err.Error())
-				w.WriteHeader(http.StatusBadRequest)
-				return false
-			}
+		IP:        r.RemoteAddr,
+	}
 
-			return true
-		},
+	// As funções usam ctx.Writer e não o w original, assim um middleware
+	// pode trocar o Writer (para capturar o status, por exemplo)
+	ctx.Json = func(v any) error {
+		ctx.Writer.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(ctx.Writer).Encode(v)
 	}
+	// O Content-Type precisa ir antes do WriteHeader, depois dele o
+	// net/http ignora mudanças nos headers
+	ctx.JsonStatus = func(code int, v any) error {
+		ctx.Writer.Header().Set("Content-Type", "application/json")
+		ctx.Writer.WriteHeader(code)
+		return json.NewEncoder(ctx.Writer).Encode(v)
+	}
+	ctx.ReadJson = func(v any) error {
+		return json.NewDecoder(ctx.Request.Body).Decode(v)
+	}
+	ctx.Error = func(error any, code int) {
+		http.Error(ctx.Writer, fmt.Sprintf("%s", error), code)
+	}
+	ctx.WriteHeader = func(code int) {
+		ctx.Writer.WriteHeader(code)
+	}
+	ctx.IfErrNotNull = func(err error) bool {
+		if err != nil {
+			logs.NewLogger().LogAndSendSystemMessage(err.Error())
+			ctx.Writer.WriteHeader(http.StatusBadRequest)
+			return false
+		}
+
+		return true
+	}
+
+	return ctx
 }
 
 func (ctx *Context) Return() {
 	return
 }
 
-func Post(route string, handler func(ctx *Context)) {
-	http.HandleFunc(route, func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		//w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
-
-		if r.Method == http.MethodOptions {
-			w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-
-		if r.Method != http.MethodPost {
-			http.Error(w, "Wrong Method", http.StatusMethodNotAllowed)
-			return
-		}
+// QueryInt lê um inteiro positivo da query string, como ?page= e ?limit=
+func (ctx *Context) QueryInt(name string, fallback int) int {
+	value, err := strconv.Atoi(ctx.Request.URL.Query().Get(name))
+	if err != nil || value <= 0 {
+		return fallback
+	}
 
-		ctx := NewContext(w, r, route)
-		handler(ctx)
-	})
+	return value
 }
 
-func Get(route string, handler func(ctx *Context)) {
-	http.HandleFunc(route, func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
-
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
+func (ctx *Context) Param(name string) string {
+	return ctx.Params[name]
+}
 
-		if r.Method != http.MethodGet {
-			http.Error(w, "Wrong Method", http.StatusMethodNotAllowed)
-			return
-		}
+func (ctx *Context) User() *Principal {
+	return ctx.principal
+}
 
-		ctx := NewContext(w, r, route)
-		handler(ctx)
-	})
+func (ctx *Context) SetUser(principal *Principal) {
+	ctx.principal = principal
 }
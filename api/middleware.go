@@ -0,0 +1,144 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Evita que um panic em um handler derrube o servidor inteiro. Fica dentro
+// do Logging para que o 500 apareça no log da requisição
+func Recovery(next ApiFunc) ApiFunc {
+	return func(ctx *Context) {
+		defer func() {
+			if err := recover(); err != nil {
+				ctx.Logger.LogAndSendSystemMessage(fmt.Sprintf("panic: %v\n%s", err, debug.Stack()))
+				ctx.Error("Internal Server Error", http.StatusInternalServerError)
+			}
+		}()
+
+		next(ctx)
+	}
+}
+
+func Logging(next ApiFunc) ApiFunc {
+	return func(ctx *Context) {
+		start := time.Now()
+		writer := &statusWriter{ResponseWriter: ctx.Writer}
+		ctx.Writer = writer
+
+		next(ctx)
+
+		if writer.status == 0 {
+			writer.status = http.StatusOK
+		}
+
+		userId := "-"
+		if ctx.User() != nil {
+			userId = ctx.User().UserId
+		}
+
+		ctx.Logger.LogAndSendSystemMessage(fmt.Sprintf("%s %s %d %s IP: %s User: %s",
+			ctx.Request.Method,
+			ctx.Request.URL.Path,
+			writer.status,
+			time.Since(start),
+			ctx.IP,
+			userId))
+	}
+}
+
+func CORS(origin string) Middleware {
+	return func(next ApiFunc) ApiFunc {
+		return func(ctx *Context) {
+			ctx.Writer.Header().Set("Access-Control-Allow-Origin", origin)
+			ctx.Writer.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			ctx.Writer.Header().Set("Access-Control-Expose-Headers", "X-Total-Count")
+			next(ctx)
+		}
+	}
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// Limita a quantidade de requisições por IP dentro de uma janela de tempo,
+// cada chamada de RateLimit tem o seu próprio contador
+func RateLimit(limit int, window time.Duration) Middleware {
+	var mu sync.Mutex
+	clients := map[string]*rateWindow{}
+
+	return func(next ApiFunc) ApiFunc {
+		return func(ctx *Context) {
+			ip, _, err := net.SplitHostPort(ctx.IP)
+			if err != nil {
+				ip = ctx.IP
+			}
+
+			now := time.Now()
+
+			mu.Lock()
+			for key, w := range clients {
+				if now.Sub(w.start) > window {
+					delete(clients, key)
+				}
+			}
+
+			w, ok := clients[ip]
+			if !ok {
+				w = &rateWindow{start: now}
+				clients[ip] = w
+			}
+			w.count++
+			count, start := w.count, w.start
+			mu.Unlock()
+
+			if count > limit {
+				retry := window - now.Sub(start)
+				ctx.Writer.Header().Set("Retry-After", fmt.Sprintf("%d", int(retry.Seconds())+1))
+				ctx.Error("Too Many Requests", http.StatusTooManyRequests)
+				return
+			}
+
+			next(ctx)
+		}
+	}
+}
+
+// Deve ser usado depois de um middleware de autenticação
+func RequireRole(role string) Middleware {
+	return func(next ApiFunc) ApiFunc {
+		return func(ctx *Context) {
+			if !ctx.User().HasRole(role) {
+				ctx.Error("Forbidden", http.StatusForbidden)
+				return
+			}
+
+			next(ctx)
+		}
+	}
+}
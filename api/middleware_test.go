@@ -0,0 +1,178 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestContext(method, path, ip string) (*Context, *httptest.ResponseRecorder) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, nil)
+	req.RemoteAddr = ip
+	return NewContext(rec, req, ""), rec
+}
+
+func TestRecovery(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler ApiFunc
+		code    int
+	}{
+		{"panic", func(ctx *Context) { panic("boom") }, http.StatusInternalServerError},
+		{"panic com error", func(ctx *Context) { panic(http.ErrAbortHandler) }, http.StatusInternalServerError},
+		{"sem panic", func(ctx *Context) { ctx.WriteHeader(http.StatusCreated) }, http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		ctx, rec := newTestContext(http.MethodGet, "/", "127.0.0.1:1234")
+		Recovery(tt.handler)(ctx)
+
+		if rec.Code != tt.code {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.code)
+		}
+	}
+}
+
+func TestLoggingStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler ApiFunc
+		code    int
+	}{
+		{"sem resposta", func(ctx *Context) {}, http.StatusOK},
+		{"só corpo", func(ctx *Context) { ctx.Writer.Write([]byte("ok")) }, http.StatusOK},
+		{"status", func(ctx *Context) { ctx.WriteHeader(http.StatusCreated) }, http.StatusCreated},
+		{"json", func(ctx *Context) { ctx.JsonStatus(http.StatusAccepted, map[string]string{}) }, http.StatusAccepted},
+		{"erro", func(ctx *Context) { ctx.Error("Not Found", http.StatusNotFound) }, http.StatusNotFound},
+		{"primeiro status vale", func(ctx *Context) {
+			ctx.WriteHeader(http.StatusConflict)
+			ctx.WriteHeader(http.StatusOK)
+		}, http.StatusConflict},
+		{"panic dentro do Logging", Recovery(func(ctx *Context) { panic("boom") }), http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		ctx, rec := newTestContext(http.MethodGet, "/", "127.0.0.1:1234")
+		Logging(tt.handler)(ctx)
+
+		// O status do statusWriter é o que vai para o log
+		writer, ok := ctx.Writer.(*statusWriter)
+		if !ok {
+			t.Fatalf("%s: Writer = %T, want *statusWriter", tt.name, ctx.Writer)
+		}
+
+		if writer.status != tt.code || rec.Code != tt.code {
+			t.Errorf("%s: status logado %d, resposta %d, want %d", tt.name, writer.status, rec.Code, tt.code)
+		}
+	}
+}
+
+func TestCORS(t *testing.T) {
+	r := NewRouter()
+	r.Use(CORS("https://painel.example.com"))
+	r.Get("/services/{id}", func(ctx *Context) {})
+
+	tests := []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{http.MethodGet, "/services/1", http.StatusOK},
+		{http.MethodOptions, "/services/1", http.StatusOK},
+		{http.MethodPost, "/services/1", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/nada", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		rec := serve(r, tt.method, tt.path)
+		h := rec.Header()
+
+		if rec.Code != tt.code || h.Get("Access-Control-Allow-Origin") != "https://painel.example.com" ||
+			h.Get("Access-Control-Allow-Headers") != "Authorization, Content-Type" || h.Get("Access-Control-Expose-Headers") != "X-Total-Count" {
+			t.Errorf("%s %s = %d %v, want %d com os headers de CORS", tt.method, tt.path, rec.Code, h, tt.code)
+		}
+	}
+
+	rec := serve(r, http.MethodOptions, "/services/1")
+	if methods := rec.Header().Get("Access-Control-Allow-Methods"); methods != "GET, OPTIONS" {
+		t.Errorf("Access-Control-Allow-Methods = %q, want %q", methods, "GET, OPTIONS")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	ok := func(ctx *Context) {}
+	limited := RateLimit(2, time.Minute)(ok)
+	other := RateLimit(2, time.Minute)(ok)
+
+	tests := []struct {
+		name    string
+		handler ApiFunc
+		ip      string
+		code    int
+	}{
+		{"primeira", limited, "10.0.0.1:1000", http.StatusOK},
+		{"segunda de outra porta", limited, "10.0.0.1:2000", http.StatusOK},
+		{"terceira", limited, "10.0.0.1:3000", http.StatusTooManyRequests},
+		{"outro ip", limited, "10.0.0.2:1000", http.StatusOK},
+		{"ip sem porta", limited, "10.0.0.3", http.StatusOK},
+		{"outro contador", other, "10.0.0.1:1000", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		ctx, rec := newTestContext(http.MethodPost, "/account/login", tt.ip)
+		tt.handler(ctx)
+
+		if rec.Code != tt.code {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.code)
+		}
+
+		if tt.code == http.StatusTooManyRequests && rec.Header().Get("Retry-After") != "60" {
+			t.Errorf("%s: Retry-After = %q, want 60", tt.name, rec.Header().Get("Retry-After"))
+		}
+	}
+
+	// Passada a janela o contador recomeça
+	short := RateLimit(1, 20*time.Millisecond)(ok)
+	for i, code := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		ctx, rec := newTestContext(http.MethodPost, "/", "10.0.0.1:1000")
+		short(ctx)
+		if rec.Code != code {
+			t.Fatalf("requisição %d: status = %d, want %d", i+1, rec.Code, code)
+		}
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	ctx, rec := newTestContext(http.MethodPost, "/", "10.0.0.1:1000")
+	short(ctx)
+	if rec.Code != http.StatusOK {
+		t.Errorf("depois da janela: status = %d, want 200", rec.Code)
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	tests := []struct {
+		name string
+		user *Principal
+		code int
+	}{
+		{"sem login", nil, http.StatusForbidden},
+		{"sem papel", &Principal{UserId: "u1"}, http.StatusForbidden},
+		{"outro papel", &Principal{UserId: "u1", Roles: []string{RoleUser}}, http.StatusForbidden},
+		{"admin", &Principal{UserId: "u1", Roles: []string{RoleUser, RoleAdmin}}, http.StatusNoContent},
+	}
+
+	handler := RequireRole(RoleAdmin)(func(ctx *Context) { ctx.WriteHeader(http.StatusNoContent) })
+
+	for _, tt := range tests {
+		ctx, rec := newTestContext(http.MethodGet, "/admin", "127.0.0.1:1234")
+		ctx.SetUser(tt.user)
+		handler(ctx)
+
+		if rec.Code != tt.code {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.code)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+type Middleware func(next ApiFunc) ApiFunc
+
+type route struct {
+	method   string
+	pattern  string
+	segments []string
+	handler  ApiFunc
+	group    *Group
+}
+
+// Router substitui o http.DefaultServeMux, ele sabe o método de cada rota
+// e extrai os parâmetros nomeados do caminho, como /services/{id}
+type Router struct {
+	routes      []*route
+	middlewares []Middleware
+}
+
+type Group struct {
+	router      *Router
+	parent      *Group
+	prefix      string
+	middlewares []Middleware
+}
+
+func NewRouter() *Router {
+	return &Router{}
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+
+	return strings.Split(path, "/")
+}
+
+func isParam(segment string) bool {
+	return len(segment) > 2 && segment[0] == '{' && segment[len(segment)-1] == '}'
+}
+
+func joinPath(prefix, pattern string) string {
+	return "/" + strings.Trim(strings.TrimRight(prefix, "/")+"/"+strings.TrimLeft(pattern, "/"), "/")
+}
+
+func chain(handler ApiFunc, middlewares []Middleware) ApiFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+
+	return handler
+}
+
+// Os middlewares do Router rodam em todas as requisições, inclusive
+// 404, 405 e OPTIONS, por isso é o lugar de CORS, logs e recovery
+func (r *Router) Use(middlewares ...Middleware) {
+	r.middlewares = append(r.middlewares, middlewares...)
+}
+
+func (r *Router) Handle(method, pattern string, handler ApiFunc) {
+	r.handle(method, pattern, handler, nil)
+}
+
+func (r *Router) handle(method, pattern string, handler ApiFunc, group *Group) {
+	pattern = joinPath("", pattern)
+
+	for _, rt := range r.routes {
+		if rt.method == method && rt.pattern == pattern {
+			panic("api: duplicated route " + method + " " + pattern)
+		}
+	}
+
+	r.routes = append(r.routes, &route{
+		method:   method,
+		pattern:  pattern,
+		segments: splitPath(pattern),
+		handler:  handler,
+		group:    group,
+	})
+}
+
+func (r *Router) Get(pattern string, handler ApiFunc) {
+	r.Handle(http.MethodGet, pattern, handler)
+}
+
+func (r *Router) Post(pattern string, handler ApiFunc) {
+	r.Handle(http.MethodPost, pattern, handler)
+}
+
+func (r *Router) Put(pattern string, handler ApiFunc) {
+	r.Handle(http.MethodPut, pattern, handler)
+}
+
+func (r *Router) Patch(pattern string, handler ApiFunc) {
+	r.Handle(http.MethodPatch, pattern, handler)
+}
+
+func (r *Router) Delete(pattern string, handler ApiFunc) {
+	r.Handle(http.MethodDelete, pattern, handler)
+}
+
+func (r *Router) Group(prefix string, middlewares ...Middleware) *Group {
+	return &Group{
+		router:      r,
+		prefix:      joinPath("", prefix),
+		middlewares: middlewares,
+	}
+}
+
+func (g *Group) Use(middlewares ...Middleware) {
+	g.middlewares = append(g.middlewares, middlewares...)
+}
+
+func (g *Group) Handle(method, pattern string, handler ApiFunc) {
+	g.router.handle(method, joinPath(g.prefix, pattern), handler, g)
+}
+
+// Os middlewares do grupo pai rodam antes dos middlewares do grupo filho
+func (g *Group) chainMiddlewares() []Middleware {
+	if g == nil {
+		return nil
+	}
+
+	return append(g.parent.chainMiddlewares(), g.middlewares...)
+}
+
+func (g *Group) Get(pattern string, handler ApiFunc) {
+	g.Handle(http.MethodGet, pattern, handler)
+}
+
+func (g *Group) Post(pattern string, handler ApiFunc) {
+	g.Handle(http.MethodPost, pattern, handler)
+}
+
+func (g *Group) Put(pattern string, handler ApiFunc) {
+	g.Handle(http.MethodPut, pattern, handler)
+}
+
+func (g *Group) Patch(pattern string, handler ApiFunc) {
+	g.Handle(http.MethodPatch, pattern, handler)
+}
+
+func (g *Group) Delete(pattern string, handler ApiFunc) {
+	g.Handle(http.MethodDelete, pattern, handler)
+}
+
+func (g *Group) Group(prefix string, middlewares ...Middleware) *Group {
+	return &Group{
+		router:      g.router,
+		parent:      g,
+		prefix:      joinPath(g.prefix, prefix),
+		middlewares: middlewares,
+	}
+}
+
+// Retorna os parâmetros do caminho e um peso, segmentos fixos valem mais
+// que parâmetros para que /services/new ganhe de /services/{id}
+func (rt *route) match(segments []string) (map[string]string, int, bool) {
+	if len(segments) != len(rt.segments) {
+		return nil, 0, false
+	}
+
+	params := map[string]string{}
+	score := 0
+
+	for i, segment := range rt.segments {
+		if isParam(segment) {
+			if segments[i] == "" {
+				return nil, 0, false
+			}
+
+			params[segment[1:len(segment)-1]] = segments[i]
+			continue
+		}
+
+		if segment != segments[i] {
+			return nil, 0, false
+		}
+
+		score += 1 << (len(segments) - i)
+	}
+
+	return params, score, true
+}
+
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ctx := NewContext(w, req, "")
+	chain(r.dispatch, r.middlewares)(ctx)
+}
+
+func (r *Router) dispatch(ctx *Context) {
+	req := ctx.Request
+	segments := splitPath(req.URL.Path)
+
+	method := req.Method
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+
+	var found *route
+	var foundParams map[string]string
+	bestScore := -1
+	allowed := map[string]bool{}
+
+	for _, rt := range r.routes {
+		params, score, ok := rt.match(segments)
+		if !ok {
+			continue
+		}
+
+		allowed[rt.method] = true
+
+		if rt.method == method && score > bestScore {
+			found, foundParams, bestScore = rt, params, score
+		}
+	}
+
+	if len(allowed) == 0 {
+		ctx.Error("Not Found", http.StatusNotFound)
+		return
+	}
+
+	allowed[http.MethodOptions] = true
+	methods := make([]string, 0, len(allowed))
+	for m := range allowed {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	allow := strings.Join(methods, ", ")
+
+	if req.Method == http.MethodOptions {
+		ctx.Writer.Header().Set("Allow", allow)
+		ctx.Writer.Header().Set("Access-Control-Allow-Methods", allow)
+		ctx.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if found == nil {
+		ctx.Writer.Header().Set("Allow", allow)
+		ctx.Error("Method Not Allowed. Allowed: "+allow, http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx.PureRoute = found.pattern
+	ctx.Params = foundParams
+	chain(found.handler, found.group.chainMiddlewares())(ctx)
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// named responde com o nome da rota e os parâmetros, para o teste saber
+// qual handler atendeu
+func named(name string) ApiFunc {
+	return func(ctx *Context) {
+		ctx.Writer.Write([]byte(name + " " + ctx.Param("id") + " " + ctx.Param("item")))
+	}
+}
+
+// trace anota a ordem em que os middlewares rodaram no header X-Trace
+func trace(name string) Middleware {
+	return func(next ApiFunc) ApiFunc {
+		return func(ctx *Context) {
+			ctx.Writer.Header().Add("X-Trace", name)
+			next(ctx)
+		}
+	}
+}
+
+func serve(r *Router, method, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+	return rec
+}
+
+func TestRouterMatch(t *testing.T) {
+	r := NewRouter()
+	r.Get("/services/{id}", named("get"))
+	r.Get("/services/new", named("new"))
+	r.Get("/services/{id}/items/{item}", named("item"))
+	r.Get("/services/{id}/items/all", named("all"))
+	r.Delete("/services/{id}", named("delete"))
+	r.Get("/", named("root"))
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{http.MethodGet, "/services/new", "new  "},
+		{http.MethodGet, "/services/42", "get 42 "},
+		{http.MethodGet, "/services/42/", "get 42 "},
+		{http.MethodDelete, "/services/new", "delete new "},
+		{http.MethodGet, "/services/42/items/all", "all 42 "},
+		{http.MethodGet, "/services/42/items/7", "item 42 7"},
+		// HEAD usa a rota GET, quem descarta o corpo é o net/http
+		{http.MethodHead, "/services/42", "get 42 "},
+		{http.MethodGet, "/", "root  "},
+	}
+
+	for _, tt := range tests {
+		rec := serve(r, tt.method, tt.path)
+		if rec.Code != http.StatusOK || rec.Body.String() != tt.body {
+			t.Errorf("%s %s = %d %q, want 200 %q", tt.method, tt.path, rec.Code, rec.Body.String(), tt.body)
+		}
+	}
+}
+
+func TestRouterNotFoundAndMethodNotAllowed(t *testing.T) {
+	r := NewRouter()
+	r.Get("/services/{id}", named("get"))
+	r.Delete("/services/{id}", named("delete"))
+	r.Post("/services", named("create"))
+
+	tests := []struct {
+		method string
+		path   string
+		code   int
+		allow  string
+	}{
+		{http.MethodGet, "/nada", http.StatusNotFound, ""},
+		{http.MethodGet, "/services/42/items", http.StatusNotFound, ""},
+		{http.MethodPost, "/services/42", http.StatusMethodNotAllowed, "DELETE, GET, OPTIONS"},
+		{http.MethodGet, "/services", http.StatusMethodNotAllowed, "OPTIONS, POST"},
+	}
+
+	for _, tt := range tests {
+		rec := serve(r, tt.method, tt.path)
+		if rec.Code != tt.code || rec.Header().Get("Allow") != tt.allow {
+			t.Errorf("%s %s = %d Allow %q, want %d Allow %q", tt.method, tt.path, rec.Code, rec.Header().Get("Allow"), tt.code, tt.allow)
+		}
+	}
+}
+
+func TestRouterOptions(t *testing.T) {
+	r := NewRouter()
+	r.Use(trace("router"))
+
+	g := r.Group("/admin", trace("admin"))
+	g.Get("/users/{id}", named("user"))
+	g.Patch("/users/{id}", named("patch"))
+
+	rec := serve(r, http.MethodOptions, "/admin/users/1")
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Fatalf("OPTIONS = %d %q, want 200 sem corpo", rec.Code, rec.Body.String())
+	}
+
+	if allow := rec.Header().Get("Allow"); allow != "GET, OPTIONS, PATCH" {
+		t.Errorf("Allow = %q, want %q", allow, "GET, OPTIONS, PATCH")
+	}
+
+	// Só o middleware do Router roda, o do grupo (autenticação, por
+	// exemplo) não pode barrar o preflight
+	if got := strings.Join(rec.Header().Values("X-Trace"), ","); got != "router" {
+		t.Errorf("middlewares = %q, want %q", got, "router")
+	}
+
+	if rec := serve(r, http.MethodOptions, "/nada"); rec.Code != http.StatusNotFound {
+		t.Errorf("OPTIONS sem rota = %d, want 404", rec.Code)
+	}
+}
+
+func TestRouterGroups(t *testing.T) {
+	r := NewRouter()
+	r.Use(trace("router"))
+
+	api := r.Group("/api/", trace("api"))
+	admin := api.Group("admin", trace("admin"))
+	admin.Use(trace("admin-use"))
+	admin.Get("/services/{id}", named("admin"))
+	api.Get("/services/{id}", named("api"))
+	r.Get("/health", named("health"))
+
+	tests := []struct {
+		path  string
+		body  string
+		trace string
+	}{
+		{"/api/admin/services/9", "admin 9 ", "router,api,admin,admin-use"},
+		{"/api/services/9", "api 9 ", "router,api"},
+		{"/health", "health  ", "router"},
+	}
+
+	for _, tt := range tests {
+		rec := serve(r, http.MethodGet, tt.path)
+		got := strings.Join(rec.Header().Values("X-Trace"), ",")
+		if rec.Code != http.StatusOK || rec.Body.String() != tt.body || got != tt.trace {
+			t.Errorf("GET %s = %d %q middlewares %q, want 200 %q middlewares %q", tt.path, rec.Code, rec.Body.String(), got, tt.body, tt.trace)
+		}
+	}
+
+	// 404 e 405 passam pelos middlewares do Router mas não pelos de grupo
+	rec := serve(r, http.MethodPost, "/api/services/9")
+	if got := strings.Join(rec.Header().Values("X-Trace"), ","); rec.Code != http.StatusMethodNotAllowed || got != "router" {
+		t.Errorf("POST /api/services/9 = %d middlewares %q, want 405 middlewares %q", rec.Code, got, "router")
+	}
+}
+
+func TestRouterDuplicatedRoute(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("rota duplicada não deu panic")
+		}
+	}()
+
+	r := NewRouter()
+	r.Group("/services").Get("/{id}", named("a"))
+	r.Get("/services/{id}", named("b"))
+}
@@ -0,0 +1,370 @@
+package bank
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"prodata/api"
+	"prodata/money"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const ProviderFake = "fake"
+
+var ErrFakeTransition = errors.New("fake gateway: payment cannot change to this status")
+
+// FakeGateway guarda os pagamentos em memória. PIX e boleto não são
+// aprovados sozinhos, use Approve, Reject ou SetStatus, e OnChange recebe
+// cada mudança como se fosse a notificação do webhook. Cartão responde na
+// hora: aprovado, ou recusado quando o token tem "reject"
+type FakeGateway struct {
+	mu        sync.Mutex
+	nextId    int
+	payments  map[string]*Payment
+	keys      map[string]string
+	refunds   map[string][]Refund
+	customers map[string]string
+	cards     map[string]map[string]fakeCard
+	onChange  func(ctx context.Context, payment Payment)
+}
+
+type fakeCard struct {
+	Card
+	declines bool
+}
+
+func NewFakeGateway() *FakeGateway {
+	return &FakeGateway{
+		nextId:    1000000,
+		payments:  map[string]*Payment{},
+		keys:      map[string]string{},
+		refunds:   map[string][]Refund{},
+		customers: map[string]string{},
+		cards:     map[string]map[string]fakeCard{},
+	}
+}
+
+func (f *FakeGateway) Name() string {
+	return ProviderFake
+}
+
+// OnChange registra quem é avisado quando um pagamento muda de status
+func (f *FakeGateway) OnChange(handler func(ctx context.Context, payment Payment)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.onChange = handler
+}
+
+func (f *FakeGateway) CreatePayment(ctx context.Context, request PaymentRequest) (*Payment, error) {
+	if !request.Amount.IsPositive() {
+		return nil, fmt.Errorf("%w: amount must be positive", ErrPaymentRefused)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if id, ok := f.keys[request.IdempotencyKey]; ok {
+		result := *f.payments[id]
+		return &result, nil
+	}
+
+	f.nextId++
+	id := strconv.Itoa(f.nextId)
+
+	p := &Payment{
+		Id:                id,
+		Status:            StatusPending,
+		Method:            request.Method,
+		Amount:            request.Amount,
+		RefundedAmount:    money.New(0, request.Amount.Currency()),
+		ExternalReference: request.ExternalReference,
+		IdempotencyKey:    request.IdempotencyKey,
+		TicketURL:         "https://fake-gateway.local/payments/" + id,
+	}
+
+	if request.ExpiresAt != nil {
+		p.ExpiresAt = *request.ExpiresAt
+	}
+
+	switch {
+	case request.Token != "" || request.CardId != "":
+		declines := strings.Contains(request.Token, "reject")
+		if request.CardId != "" {
+			card, ok := f.cards[request.CustomerId][request.CardId]
+			if !ok {
+				return nil, fmt.Errorf("%w: %w", ErrPaymentRefused, ErrCardNotFound)
+			}
+			declines = card.declines
+		}
+
+		// O cartão responde na criação, sem webhook: quem cobrou ainda não
+		// salvou o pagamento e concilia com a resposta
+		p.TicketURL = ""
+		p.Status = StatusApproved
+		p.StatusDetail = "accredited"
+		if declines {
+			p.Status = StatusRejected
+			p.StatusDetail = "cc_rejected_other_reason"
+		}
+	case request.Method == "pix":
+		p.QRCode = fmt.Sprintf("00020126FAKEPIX%s5204000053039865406%s", id, request.Amount.Decimal())
+		p.QRCodeBase64 = base64.StdEncoding.EncodeToString([]byte(p.QRCode))
+	default:
+		// Qualquer outro método sem cartão é tratado como boleto
+		if len(request.PayerDocument) != 11 && len(request.PayerDocument) != 14 {
+			return nil, fmt.Errorf("%w: boleto requires a CPF or CNPJ", ErrPaymentRefused)
+		}
+
+		p.StatusDetail = DetailWaitingPayment
+		p.TicketURL += ".pdf"
+		p.BarcodeLine = fmt.Sprintf("23790.00009 %05s.%05s 00000.000000 1 0000%010d", id[:5], id[len(id)-5:], request.Amount.Cents())
+	}
+
+	f.payments[id] = p
+	if request.IdempotencyKey != "" {
+		f.keys[request.IdempotencyKey] = id
+	}
+
+	result := *p
+	return &result, nil
+}
+
+func (f *FakeGateway) GetPayment(ctx context.Context, id string) (*Payment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, ok := f.payments[id]
+	if !ok {
+		return nil, ErrPaymentNotFound
+	}
+
+	result := *p
+	result.Refunds = slices.Clone(f.refunds[id])
+	return &result, nil
+}
+
+func (f *FakeGateway) Refund(ctx context.Context, id string, amount *money.Money) (*Refund, error) {
+	f.mu.Lock()
+
+	p, ok := f.payments[id]
+	if !ok {
+		f.mu.Unlock()
+		return nil, ErrPaymentNotFound
+	}
+
+	if p.Status != StatusApproved {
+		f.mu.Unlock()
+		return nil, ErrFakeTransition
+	}
+
+	available, err := p.Amount.Sub(p.RefundedAmount)
+	if err != nil {
+		f.mu.Unlock()
+		return nil, err
+	}
+
+	value := available
+	if amount != nil {
+		value = *amount
+	}
+
+	if !value.IsPositive() || value.Compare(available) > 0 {
+		f.mu.Unlock()
+		return nil, errors.New("fake gateway: invalid refund amount")
+	}
+
+	refund := f.addRefund(p, value)
+
+	// O Mercado Pago só muda o status quando o estorno é total, mas avisa
+	// pelo webhook também nos parciais
+	if p.RefundedAmount.Compare(p.Amount) == 0 {
+		p.Status = StatusRefunded
+	}
+
+	result := *p
+	f.mu.Unlock()
+
+	f.notify(ctx, result)
+	return &refund, nil
+}
+
+// addRefund precisa do lock
+func (f *FakeGateway) addRefund(p *Payment, value money.Money) Refund {
+	p.RefundedAmount, _ = p.RefundedAmount.Add(value)
+
+	f.nextId++
+	refund := Refund{
+		Id:        strconv.Itoa(f.nextId),
+		PaymentId: p.Id,
+		Status:    StatusApproved,
+		Amount:    value,
+	}
+	f.refunds[p.Id] = append(f.refunds[p.Id], refund)
+
+	return refund
+}
+
+func (f *FakeGateway) Cancel(ctx context.Context, id string) (*Payment, error) {
+	return f.SetStatus(ctx, id, StatusCancelled, "by_collector")
+}
+
+func (f *FakeGateway) Approve(ctx context.Context, id string) (*Payment, error) {
+	return f.SetStatus(ctx, id, StatusApproved, "accredited")
+}
+
+// Compensate simula o boleto pago que ainda espera a compensação do banco
+func (f *FakeGateway) Compensate(ctx context.Context, id string) (*Payment, error) {
+	return f.SetStatus(ctx, id, StatusPending, DetailWaitingTransfer)
+}
+
+func (f *FakeGateway) Reject(ctx context.Context, id string) (*Payment, error) {
+	return f.SetStatus(ctx, id, StatusRejected, "cc_rejected_other_reason")
+}
+
+func (f *FakeGateway) SaveCard(ctx context.Context, request CardRequest) (*Card, error) {
+	if request.Token == "" {
+		return nil, errors.New("fake gateway: card token is required")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	customerId := request.CustomerId
+	if customerId == "" {
+		customerId = f.customers[request.Email]
+	}
+	if customerId == "" {
+		f.nextId++
+		customerId = "fake-customer-" + strconv.Itoa(f.nextId)
+		f.customers[request.Email] = customerId
+	}
+
+	lastFour := "4242"
+	if len(request.Token) >= 4 {
+		if _, err := strconv.Atoi(request.Token[len(request.Token)-4:]); err == nil {
+			lastFour = request.Token[len(request.Token)-4:]
+		}
+	}
+
+	f.nextId++
+	card := fakeCard{
+		Card: Card{
+			Id:              strconv.Itoa(f.nextId),
+			CustomerId:      customerId,
+			Brand:           "visa",
+			LastFour:        lastFour,
+			ExpirationMonth: 12,
+			ExpirationYear:  2099,
+			HolderName:      strings.TrimSpace(request.FirstName + " " + request.LastName),
+		},
+		declines: strings.Contains(request.Token, "reject"),
+	}
+
+	if f.cards[customerId] == nil {
+		f.cards[customerId] = map[string]fakeCard{}
+	}
+	f.cards[customerId][card.Id] = card
+
+	result := card.Card
+	return &result, nil
+}
+
+func (f *FakeGateway) DeleteCard(ctx context.Context, customerId, cardId string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.cards[customerId][cardId]; !ok {
+		return ErrCardNotFound
+	}
+
+	delete(f.cards[customerId], cardId)
+	return nil
+}
+
+// Para onde cada status pode ir, igual ao que acontece no Mercado Pago
+var fakeTransitions = map[string][]string{
+	StatusPending:   {StatusPending, StatusInProcess, StatusApproved, StatusRejected, StatusCancelled},
+	StatusInProcess: {StatusApproved, StatusRejected, StatusCancelled},
+	StatusApproved:  {StatusRefunded, StatusChargedBack},
+}
+
+func (f *FakeGateway) SetStatus(ctx context.Context, id, status, detail string) (*Payment, error) {
+	f.mu.Lock()
+
+	p, ok := f.payments[id]
+	if !ok {
+		f.mu.Unlock()
+		return nil, ErrPaymentNotFound
+	}
+
+	allowed := false
+	for _, next := range fakeTransitions[p.Status] {
+		if next == status {
+			allowed = true
+		}
+	}
+
+	if !allowed {
+		f.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s -> %s", ErrFakeTransition, p.Status, status)
+	}
+
+	p.Status = status
+	p.StatusDetail = detail
+	// Simula o estorno feito direto no painel do gateway
+	if status == StatusRefunded {
+		if remaining, err := p.Amount.Sub(p.RefundedAmount); err == nil && remaining.IsPositive() {
+			f.addRefund(p, remaining)
+		}
+	}
+
+	result := *p
+	f.mu.Unlock()
+
+	f.notify(ctx, result)
+	return &result, nil
+}
+
+// notify roda fora do lock porque o webhook consulta o pagamento de novo
+func (f *FakeGateway) notify(ctx context.Context, payment Payment) {
+	f.mu.Lock()
+	handler := f.onChange
+	f.mu.Unlock()
+
+	if handler != nil {
+		handler(ctx, payment)
+	}
+}
+
+// POST /admin/fake-gateway/payments/{id}/{status}?detail= muda o status de
+// um pagamento do fake e dispara o webhook, só existe com PAYMENT_GATEWAY=fake
+func (f *FakeGateway) HandleSetStatus(ctx *api.Context) {
+	detail := ctx.Request.URL.Query().Get("detail")
+	if detail == "" {
+		detail = "simulated"
+	}
+
+	p, err := f.SetStatus(ctx.Request.Context(), ctx.Param("id"), ctx.Param("status"), detail)
+	switch {
+	case errors.Is(err, ErrPaymentNotFound):
+		ctx.Error(err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, ErrFakeTransition):
+		ctx.Error(err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		ctx.Logger.LogAndSendSystemMessage(err.Error())
+		ctx.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	ctx.Json(p)
+}
+
+var _ PaymentGateway = (*FakeGateway)(nil)
@@ -0,0 +1,106 @@
+package bank
+
+import (
+	"context"
+	"errors"
+	"prodata/money"
+	"testing"
+)
+
+func TestFakeCreatePayment(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFakeGateway()
+
+	pix, err := fake.CreatePayment(ctx, PaymentRequest{Amount: money.FromCents(5000), Method: "pix", IdempotencyKey: "p1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pix.Status != StatusPending || pix.QRCode == "" || pix.IdempotencyKey != "p1" {
+		t.Errorf("pix = %+v, want pending com QR code", pix)
+	}
+
+	again, err := fake.CreatePayment(ctx, PaymentRequest{Amount: money.FromCents(5000), Method: "pix", IdempotencyKey: "p1"})
+	if err != nil || again.Id != pix.Id {
+		t.Errorf("mesma chave criou %v (%v), want %s", again, err, pix.Id)
+	}
+
+	tests := []struct {
+		name    string
+		request PaymentRequest
+		status  string
+		err     error
+	}{
+		{"cartão aprovado", PaymentRequest{Amount: money.FromCents(100), Token: "tok_4242"}, StatusApproved, nil},
+		{"cartão recusado", PaymentRequest{Amount: money.FromCents(100), Token: "tok_reject"}, StatusRejected, nil},
+		{"cartão salvo que não existe", PaymentRequest{Amount: money.FromCents(100), CustomerId: "c", CardId: "x"}, "", ErrCardNotFound},
+		{"boleto sem documento", PaymentRequest{Amount: money.FromCents(100), Method: "bolbradesco"}, "", ErrPaymentRefused},
+		{"valor zero", PaymentRequest{Amount: money.FromCents(0), Method: "pix"}, "", ErrPaymentRefused},
+	}
+
+	for _, tt := range tests {
+		p, err := fake.CreatePayment(ctx, tt.request)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		if err == nil && p.Status != tt.status {
+			t.Errorf("%s: status = %s, want %s", tt.name, p.Status, tt.status)
+		}
+	}
+}
+
+func TestFakeStatusAndRefunds(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFakeGateway()
+
+	notified := []string{}
+	fake.OnChange(func(ctx context.Context, p Payment) {
+		notified = append(notified, p.Status)
+	})
+
+	p, err := fake.CreatePayment(ctx, PaymentRequest{Amount: money.FromCents(10000), Method: "pix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fake.Refund(ctx, p.Id, nil); !errors.Is(err, ErrFakeTransition) {
+		t.Errorf("estorno de pendente err = %v, want %v", err, ErrFakeTransition)
+	}
+
+	if _, err := fake.Approve(ctx, p.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fake.Reject(ctx, p.Id); !errors.Is(err, ErrFakeTransition) {
+		t.Errorf("recusar aprovado err = %v, want %v", err, ErrFakeTransition)
+	}
+
+	partial := money.FromCents(3000)
+	if _, err := fake.Refund(ctx, p.Id, &partial); err != nil {
+		t.Fatal(err)
+	}
+
+	// O resto é estornado pelo painel
+	if _, err := fake.SetStatus(ctx, p.Id, StatusRefunded, "by_admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := fake.GetPayment(ctx, p.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Status != StatusRefunded || got.RefundedAmount.Cents() != 10000 || len(got.Refunds) != 2 || got.Refunds[1].Amount.Cents() != 7000 {
+		t.Errorf("payment = %+v, want refunded em dois estornos", got)
+	}
+
+	want := []string{StatusApproved, StatusApproved, StatusRefunded}
+	if len(notified) != len(want) {
+		t.Fatalf("notified = %v, want %v", notified, want)
+	}
+	for i := range want {
+		if notified[i] != want[i] {
+			t.Errorf("notified = %v, want %v", notified, want)
+		}
+	}
+}
@@ -1,19 +1,371 @@
 package bank
 
 import (
-	"os"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"prodata/money"
+	"strconv"
 
 	"github.com/mercadopago/sdk-go/pkg/config"
+	"github.com/mercadopago/sdk-go/pkg/customer"
+	"github.com/mercadopago/sdk-go/pkg/customercard"
+	"github.com/mercadopago/sdk-go/pkg/mperror"
 	"github.com/mercadopago/sdk-go/pkg/payment"
+	"github.com/mercadopago/sdk-go/pkg/refund"
+	"github.com/mercadopago/sdk-go/pkg/requester"
 )
 
-func Client() payment.Client {
-	accessToken := os.Getenv("MP_ACCESS_TOKEN")
+const ProviderMercadoPago = "mercadopago"
+
+type MercadoPago struct {
+	accessToken string
+	payments    payment.Client
+	refunds     refund.Client
+	customers   customer.Client
+	cards       customercard.Client
+}
+
+var _ PaymentGateway = (*MercadoPago)(nil)
+
+type idempotencyKey struct{}
+
+// O SDK manda uma X-Idempotency-Key aleatória em toda requisição, o que não
+// protege nada numa nova tentativa. Com uma chave no contexto ela vale
+type idempotentRequester struct {
+	next requester.Requester
+}
+
+func (r idempotentRequester) Do(req *http.Request) (*http.Response, error) {
+	if key, ok := req.Context().Value(idempotencyKey{}).(string); ok && key != "" {
+		req.Header.Set("X-Idempotency-Key", key)
+	}
+
+	return r.next.Do(req)
+}
+
+func NewMercadoPago(accessToken string) (*MercadoPago, error) {
+	if accessToken == "" {
+		return nil, errors.New("MP_ACCESS_TOKEN is not set")
+	}
+
 	cfg, err := config.New(accessToken)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	cfg.Requester = idempotentRequester{next: cfg.Requester}
+
+	return &MercadoPago{
+		accessToken: accessToken,
+		payments:    payment.NewClient(cfg),
+		refunds:     refund.NewClient(cfg),
+		customers:   customer.NewClient(cfg),
+		cards:       customercard.NewClient(cfg),
+	}, nil
+}
+
+func (m *MercadoPago) Name() string {
+	return ProviderMercadoPago
+}
+
+func paymentId(id string) (int, error) {
+	value, err := strconv.Atoi(id)
+	if err != nil {
+		return 0, ErrPaymentNotFound
+	}
+
+	return value, nil
+}
+
+func fromResponse(response *payment.Response) (*Payment, error) {
+	amount, err := money.FromFloat(response.TransactionAmount, money.BRL)
+	if err != nil {
+		return nil, err
+	}
+
+	refunded, err := money.FromFloat(response.TransactionAmountRefunded, money.BRL)
+	if err != nil {
+		return nil, err
+	}
+
+	refunds := make([]Refund, 0, len(response.Refunds))
+	for _, r := range response.Refunds {
+		value, err := money.FromFloat(r.Amount, money.BRL)
+		if err != nil {
+			return nil, err
+		}
+
+		refunds = append(refunds, Refund{
+			Id:        strconv.Itoa(r.ID),
+			PaymentId: strconv.Itoa(response.ID),
+			Status:    r.Status,
+			Amount:    value,
+		})
+	}
+
+	// Só a tarifa paga pelo recebedor sai do que o Mercado Pago repassa
+	fee := money.New(0, money.BRL)
+	for _, detail := range response.FeeDetails {
+		if detail.FeePayer != "collector" {
+			continue
+		}
+
+		value, err := money.FromFloat(detail.Amount, money.BRL)
+		if err != nil {
+			return nil, err
+		}
+
+		if fee, err = fee.Add(value); err != nil {
+			return nil, err
+		}
+	}
+
+	idempotencyKey, _ := response.Metadata["idempotency_key"].(string)
+
+	// Boleto não tem point_of_interaction, o PDF vem em transaction_details
+	ticketURL := response.PointOfInteraction.TransactionData.TicketURL
+	if ticketURL == "" {
+		ticketURL = response.TransactionDetails.ExternalResourceURL
+	}
+
+	return &Payment{
+		Id:                strconv.Itoa(response.ID),
+		Status:            response.Status,
+		StatusDetail:      response.StatusDetail,
+		Method:            response.PaymentMethodID,
+		Amount:            amount,
+		RefundedAmount:    refunded,
+		Fee:               fee,
+		ExternalReference: response.ExternalReference,
+		QRCode:            response.PointOfInteraction.TransactionData.QRCode,
+		QRCodeBase64:      response.PointOfInteraction.TransactionData.QRCodeBase64,
+		TicketURL:         ticketURL,
+		BarcodeLine:       response.TransactionDetails.DigitableLine,
+		ExpiresAt:         response.DateOfExpiration,
+		Refunds:           refunds,
+		IdempotencyKey:    idempotencyKey,
+	}, nil
+}
+
+func payer(request PaymentRequest) *payment.PayerRequest {
+	p := &payment.PayerRequest{
+		Email:     request.PayerEmail,
+		FirstName: request.PayerFirstName,
+		LastName:  request.PayerLastName,
+	}
+
+	switch len(request.PayerDocument) {
+	case 11:
+		p.Identification = &payment.IdentificationRequest{Type: "CPF", Number: request.PayerDocument}
+	case 14:
+		p.Identification = &payment.IdentificationRequest{Type: "CNPJ", Number: request.PayerDocument}
+	}
+
+	return p
+}
+
+func (m *MercadoPago) CreatePayment(ctx context.Context, request PaymentRequest) (*Payment, error) {
+	token := request.Token
+	if token == "" && request.CardId != "" {
+		var err error
+		token, err = m.savedCardToken(ctx, request.CustomerId, request.CardId)
+		if err != nil {
+			// A cobrança nem chegou a ser pedida
+			return nil, fmt.Errorf("%w: %w", ErrPaymentRefused, err)
+		}
+	}
+
+	p := payer(request)
+	installments := 0
+	if token != "" {
+		installments = max(request.Installments, 1)
+		if request.CustomerId != "" {
+			p.Type = "customer"
+			p.ID = request.CustomerId
+		}
+	}
+
+	response, err := m.payments.Create(context.WithValue(ctx, idempotencyKey{}, request.IdempotencyKey), payment.Request{
+		Token:             token,
+		Installments:      installments,
+		TransactionAmount: request.Amount.Float64(),
+		PaymentMethodID:   request.Method,
+		Description:       request.Description,
+		ExternalReference: request.ExternalReference,
+		NotificationURL:   request.NotificationURL,
+		DateOfExpiration:  request.ExpiresAt,
+		Payer:             p,
+		Metadata:          map[string]any{"idempotency_key": request.IdempotencyKey},
+	})
+	if err != nil {
+		// 4xx é a requisição recusada, menos o 408 que pode ter sido
+		// processado depois do timeout
+		var responseErr *mperror.ResponseError
+		if errors.As(err, &responseErr) && responseErr.StatusCode < 500 && responseErr.StatusCode != http.StatusRequestTimeout {
+			return nil, fmt.Errorf("%w: %v", ErrPaymentRefused, err)
+		}
+		return nil, err
+	}
+
+	return fromResponse(response)
+}
+
+func (m *MercadoPago) GetPayment(ctx context.Context, id string) (*Payment, error) {
+	value, err := paymentId(id)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := m.payments.Get(ctx, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return fromResponse(response)
+}
+
+func (m *MercadoPago) Refund(ctx context.Context, id string, amount *money.Money) (*Refund, error) {
+	value, err := paymentId(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var response *refund.Response
+	if amount == nil {
+		response, err = m.refunds.Create(ctx, value)
+	} else {
+		response, err = m.refunds.CreatePartialRefund(ctx, value, amount.Float64())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	refunded, err := money.FromFloat(response.Amount, money.BRL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Refund{
+		Id:        strconv.Itoa(response.ID),
+		PaymentId: id,
+		Status:    response.Status,
+		Amount:    refunded,
+	}, nil
+}
+
+func (m *MercadoPago) Cancel(ctx context.Context, id string) (*Payment, error) {
+	value, err := paymentId(id)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := m.payments.Cancel(ctx, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return fromResponse(response)
+}
+
+// savedCardToken gera no servidor um token para o cartão salvo, é o que
+// permite cobrar a renovação sem o cliente digitar o código de segurança.
+// O SDK não expõe card_id no card_tokens, por isso a chamada é direta
+func (m *MercadoPago) savedCardToken(ctx context.Context, customerId, cardId string) (string, error) {
+	body, err := json.Marshal(map[string]string{"card_id": cardId, "customer_id": customerId})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.mercadopago.com/v1/card_tokens", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+m.accessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return "", ErrCardNotFound
+	}
+
+	if response.StatusCode >= 300 {
+		return "", fmt.Errorf("card token: status %d", response.StatusCode)
+	}
+
+	var token struct {
+		Id string `json:"id"`
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(&token); err != nil {
+		return "", err
+	}
+
+	return token.Id, nil
+}
+
+// customerId devolve o cliente do Mercado Pago com esse e-mail, criando um
+// quando ainda não existe
+func (m *MercadoPago) customerId(ctx context.Context, request CardRequest) (string, error) {
+	found, err := m.customers.Search(ctx, customer.SearchRequest{
+		Limit:   1,
+		Filters: map[string]string{"email": request.Email},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if len(found.Results) > 0 {
+		return found.Results[0].ID, nil
+	}
+
+	created, err := m.customers.Create(ctx, customer.Request{
+		Email:     request.Email,
+		FirstName: request.FirstName,
+		LastName:  request.LastName,
+	})
+	if err != nil {
+		return "", err
 	}
 
-	client := payment.NewClient(cfg)
-	return client
+	return created.ID, nil
+}
+
+func (m *MercadoPago) SaveCard(ctx context.Context, request CardRequest) (*Card, error) {
+	customerId := request.CustomerId
+	if customerId == "" {
+		var err error
+		customerId, err = m.customerId(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	response, err := m.cards.Create(ctx, customerId, customercard.Request{Token: request.Token})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Card{
+		Id:              response.ID,
+		CustomerId:      customerId,
+		Brand:           response.PaymentMethod.ID,
+		LastFour:        response.LastFourDigits,
+		ExpirationMonth: response.ExpirationMonth,
+		ExpirationYear:  response.ExpirationYear,
+		HolderName:      response.Cardholder.Name,
+	}, nil
+}
+
+func (m *MercadoPago) DeleteCard(ctx context.Context, customerId, cardId string) error {
+	_, err := m.cards.Delete(ctx, customerId, cardId)
+	return err
 }
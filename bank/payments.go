@@ -2,15 +2,138 @@ package bank
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"os"
+	"prodata/money"
+	"time"
+)
 
-	"github.com/mercadopago/sdk-go/pkg/payment"
+var (
+	ErrPaymentNotFound = errors.New("payment not found in gateway")
+	ErrCardNotFound    = errors.New("card not found in gateway")
+	// O gateway recusou a requisição de CreatePayment, a cobrança com
+	// certeza não foi criada. Qualquer outro erro pode ter criado ela
+	ErrPaymentRefused = errors.New("payment request refused by gateway")
 )
 
-func Create(request payment.Request) (*payment.Response, error) {
-	paymentInfo, err := Client().Create(context.Background(), request)
-	if err != nil {
-		return paymentInfo, err
-	}
+// Os status seguem o vocabulário do Mercado Pago (approved, pending,
+// in_process, rejected, cancelled, refunded, charged_back), o fake usa os
+// mesmos para que a conciliação não precise saber qual gateway está ativo
+const (
+	StatusPending     = "pending"
+	StatusInProcess   = "in_process"
+	StatusApproved    = "approved"
+	StatusRejected    = "rejected"
+	StatusCancelled   = "cancelled"
+	StatusRefunded    = "refunded"
+	StatusChargedBack = "charged_back"
+)
+
+// Detalhes que mudam o significado do status. O boleto pago continua
+// pending com pending_waiting_transfer até o banco compensar, o que leva
+// alguns dias úteis, e o vencido vira cancelled com expired
+const (
+	DetailWaitingPayment  = "pending_waiting_payment"
+	DetailWaitingTransfer = "pending_waiting_transfer"
+	DetailExpired         = "expired"
+)
+
+type PaymentRequest struct {
+	Amount            money.Money
+	Method            string
+	Description       string
+	ExternalReference string
+	NotificationURL   string
+	ExpiresAt         *time.Time
+	PayerEmail        string
+	PayerFirstName    string
+	PayerLastName     string
+	// CPF ou CNPJ só com números, obrigatório para boleto
+	PayerDocument string
+	// Cartão: Token vem do navegador, ou CustomerId e CardId de um cartão
+	// salvo quando a cobrança é feita sem o cliente presente
+	Token        string
+	Installments int
+	CustomerId   string
+	CardId       string
+	// Repetir a criação com a mesma chave devolve a cobrança já criada em
+	// vez de criar outra
+	IdempotencyKey string
+}
+
+type Payment struct {
+	Id             string
+	Status         string
+	StatusDetail   string
+	Method         string
+	Amount         money.Money
+	RefundedAmount money.Money
+	// Tarifa do gateway descontada do repasse
+	Fee               money.Money
+	ExternalReference string
+	QRCode            string
+	QRCodeBase64      string
+	TicketURL         string
+	BarcodeLine       string
+	ExpiresAt         time.Time
+	// Estornos já feitos no gateway, inclusive os feitos direto no painel
+	Refunds []Refund
+	// A chave de idempotência usada na criação, é por ela que a notificação
+	// acha o pagamento que ainda não sabia o id da cobrança
+	IdempotencyKey string
+}
 
-	return paymentInfo, nil
+type Refund struct {
+	Id        string
+	PaymentId string
+	Status    string
+	Amount    money.Money
+}
+
+// Card é o cartão guardado no gateway, aqui só ficam os ids e o que dá
+// para mostrar ao cliente, nunca o número
+type Card struct {
+	Id              string
+	CustomerId      string
+	Brand           string
+	LastFour        string
+	ExpirationMonth int
+	ExpirationYear  int
+	HolderName      string
+}
+
+// CardRequest salva o cartão do token no cliente CustomerId, sem ele o
+// gateway acha ou cria o cliente pelo e-mail
+type CardRequest struct {
+	Token      string
+	CustomerId string
+	Email      string
+	FirstName  string
+	LastName   string
+}
+
+// PaymentGateway é tudo que o billing precisa de um meio de pagamento.
+// Refund com amount nil estorna o valor inteiro
+type PaymentGateway interface {
+	Name() string
+	CreatePayment(ctx context.Context, request PaymentRequest) (*Payment, error)
+	GetPayment(ctx context.Context, id string) (*Payment, error)
+	Refund(ctx context.Context, id string, amount *money.Money) (*Refund, error)
+	Cancel(ctx context.Context, id string) (*Payment, error)
+	SaveCard(ctx context.Context, request CardRequest) (*Card, error)
+	DeleteCard(ctx context.Context, customerId, cardId string) error
+}
+
+// GatewayFromEnv usa PAYMENT_GATEWAY para escolher o gateway, "fake" roda
+// tudo em memória e o padrão é o Mercado Pago com MP_ACCESS_TOKEN
+func GatewayFromEnv() (PaymentGateway, error) {
+	switch name := os.Getenv("PAYMENT_GATEWAY"); name {
+	case "", "mercadopago":
+		return NewMercadoPago(os.Getenv("MP_ACCESS_TOKEN"))
+	case "fake":
+		return NewFakeGateway(), nil
+	default:
+		return nil, fmt.Errorf("unknown payment gateway %q", name)
+	}
 }
@@ -0,0 +1,55 @@
+package tx
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Deliver manda para o handler uma notificação de pagamento assinada do
+// mesmo jeito que o Mercado Pago faz, passando pela rota de verdade. É o
+// que o gateway fake usa para simular o webhook sem rede
+func Deliver(handler http.Handler, path, secret, paymentId string) (int, error) {
+	now := time.Now()
+	notification := map[string]any{
+		"id":           now.UnixNano(),
+		"live_mode":    false,
+		"type":         "payment",
+		"date_created": now.Format(time.RFC3339),
+		"api_version":  "v1",
+		"action":       "payment.updated",
+		"data":         map[string]string{"id": paymentId},
+	}
+
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, path+"?data.id="+paymentId+"&type=payment", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	requestId := uuid.New().String()
+	ts := strconv.FormatInt(now.UnixMilli(), 10)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-request-id", requestId)
+	req.Header.Set("x-signature", Sign(secret, requestId, paymentId, ts))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	if recorder.Code >= 300 {
+		return recorder.Code, fmt.Errorf("webhook answered %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	return recorder.Code, nil
+}
@@ -0,0 +1,145 @@
+package tx
+
+import (
+	"context"
+	"database/sql"
+	"prodata/database"
+	"time"
+)
+
+type EventStatus string
+
+const (
+	EventReceived   EventStatus = "received"
+	EventProcessing EventStatus = "processing"
+	EventProcessed  EventStatus = "processed"
+	EventFailed     EventStatus = "failed"
+	EventIgnored    EventStatus = "ignored"
+)
+
+// Um evento preso em processing por mais tempo que isso é de um processo
+// que caiu no meio, e pode ser pego de novo na próxima entrega
+const staleProcessing = 5 * time.Minute
+
+type WebhookEvent struct {
+	Id          int64
+	Provider    string
+	EventId     string
+	RequestId   string
+	Type        string
+	Action      string
+	ResourceId  string
+	LiveMode    bool
+	Payload     string
+	Status      EventStatus
+	Attempts    int
+	ReceivedAt  string
+	ProcessedAt string
+}
+
+type EventRepository struct {
+	db *sql.DB
+}
+
+func NewEventRepository(db *sql.DB) *EventRepository {
+	return &EventRepository{db: db}
+}
+
+// Save grava o evento antes de qualquer efeito colateral. Retorna false
+// quando o evento já tinha sido recebido antes
+func (r *EventRepository) Save(ctx context.Context, event *WebhookEvent) (bool, error) {
+	now := time.Now().Format(time.DateTime)
+	event.Status = EventReceived
+	event.ReceivedAt = now
+
+	result, err := r.db.ExecContext(ctx, "INSERT INTO webhook_events (provider, event_id, request_id, type, action, resource_id, live_mode, payload, status, received_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		event.Provider,
+		event.EventId,
+		event.RequestId,
+		event.Type,
+		event.Action,
+		event.ResourceId,
+		event.LiveMode,
+		event.Payload,
+		event.Status,
+		now,
+		now)
+	if database.IsDuplicate(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	event.Id, err = result.LastInsertId()
+	return true, err
+}
+
+// Claim marca o evento como processing. Só um processo consegue pegar o
+// evento por vez, os outros recebem false
+func (r *EventRepository) Claim(ctx context.Context, provider, eventId string) (*WebhookEvent, bool, error) {
+	now := time.Now()
+
+	result, err := r.db.ExecContext(ctx, `UPDATE webhook_events SET status = ?, attempts = attempts + 1, updated_at = ?
+WHERE provider = ? AND event_id = ? AND (status IN (?, ?) OR (status = ? AND updated_at < ?))`,
+		EventProcessing,
+		now.Format(time.DateTime),
+		provider,
+		eventId,
+		EventReceived,
+		EventFailed,
+		EventProcessing,
+		now.Add(-staleProcessing).Format(time.DateTime))
+	if err != nil {
+		return nil, false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil || affected == 0 {
+		return nil, false, err
+	}
+
+	event, err := r.Get(ctx, provider, eventId)
+	return event, err == nil, err
+}
+
+func (r *EventRepository) Get(ctx context.Context, provider, eventId string) (*WebhookEvent, error) {
+	var event WebhookEvent
+	var processedAt sql.NullString
+
+	err := r.db.QueryRowContext(ctx, "SELECT id, provider, event_id, request_id, type, action, resource_id, live_mode, payload, status, attempts, received_at, processed_at FROM webhook_events WHERE provider = ? AND event_id = ?", provider, eventId).Scan(
+		&event.Id,
+		&event.Provider,
+		&event.EventId,
+		&event.RequestId,
+		&event.Type,
+		&event.Action,
+		&event.ResourceId,
+		&event.LiveMode,
+		&event.Payload,
+		&event.Status,
+		&event.Attempts,
+		&event.ReceivedAt,
+		&processedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	event.ProcessedAt = processedAt.String
+	return &event, nil
+}
+
+// Finish fecha o evento como processed, ignored ou failed
+func (r *EventRepository) Finish(ctx context.Context, id int64, status EventStatus, cause error) error {
+	now := time.Now().Format(time.DateTime)
+
+	var lastError any
+	var processedAt any = now
+	if cause != nil {
+		lastError = cause.Error()
+		processedAt = nil
+	}
+
+	_, err := r.db.ExecContext(ctx, "UPDATE webhook_events SET status = ?, last_error = ?, processed_at = ?, updated_at = ? WHERE id = ?", status, lastError, processedAt, now, id)
+	return err
+}
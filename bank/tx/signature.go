@@ -0,0 +1,72 @@
+package tx
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// parseSignature lê o header x-signature, que vem como "ts=...,v1=..."
+func parseSignature(header string) (ts, v1 string) {
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+
+		switch key {
+		case "ts":
+			ts = value
+		case "v1":
+			v1 = value
+		}
+	}
+
+	return ts, v1
+}
+
+func manifestHMAC(secret, requestId, dataId, ts string) string {
+	var manifest strings.Builder
+	if dataId != "" {
+		manifest.WriteString("id:" + strings.ToLower(dataId) + ";")
+	}
+	if requestId != "" {
+		manifest.WriteString("request-id:" + requestId + ";")
+	}
+	manifest.WriteString("ts:" + ts + ";")
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(manifest.String()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign monta um x-signature igual ao do Mercado Pago, usado pelo gateway
+// fake para entregar notificações
+func Sign(secret, requestId, dataId, ts string) string {
+	return "ts=" + ts + ",v1=" + manifestHMAC(secret, requestId, dataId, ts)
+}
+
+// VerifySignature confere o HMAC-SHA256 que o Mercado Pago manda no
+// x-signature. O manifesto é "id:<data.id>;request-id:<x-request-id>;ts:<ts>;"
+// e as partes que não vieram na notificação ficam de fora
+func VerifySignature(secret, signature, requestId, dataId string) error {
+	if secret == "" {
+		return ErrInvalidSignature
+	}
+
+	ts, v1 := parseSignature(signature)
+	if ts == "" || v1 == "" {
+		return ErrInvalidSignature
+	}
+
+	expected := manifestHMAC(secret, requestId, dataId, ts)
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(v1))) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
@@ -0,0 +1,35 @@
+package tx
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSignature(t *testing.T) {
+	signature := Sign("segredo", "req-1", "123456", "1700000000000")
+
+	if err := VerifySignature("segredo", signature, "req-1", "123456"); err != nil {
+		t.Fatalf("assinatura do Sign recusada: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		secret    string
+		signature string
+		requestId string
+		dataId    string
+	}{
+		{"outro segredo", "outro", signature, "req-1", "123456"},
+		{"outro pagamento", "segredo", signature, "req-1", "654321"},
+		{"outro request", "segredo", signature, "req-2", "123456"},
+		{"sem segredo", "", signature, "req-1", "123456"},
+		{"sem v1", "segredo", "ts=1700000000000", "req-1", "123456"},
+		{"vazia", "segredo", "", "req-1", "123456"},
+	}
+
+	for _, tt := range tests {
+		if err := VerifySignature(tt.secret, tt.signature, tt.requestId, tt.dataId); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, ErrInvalidSignature)
+		}
+	}
+}
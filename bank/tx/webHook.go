@@ -2,47 +2,147 @@ package tx
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"prodata/api"
-	"prodata/bank"
-	"strconv"
 )
 
+const providerMercadoPago = "mercadopago"
+
+// Notificações do Mercado Pago são pequenas, 1MB já sobra
+const maxNotificationSize = 1 << 20
+
 type PaymentNotification struct {
-	ID       int    `json:"id"`
-	LiveMode bool   `json:"live_mode"`
-	Type     string `json:"type"`
-	Date     string `json:"date"`
-	UserID   string `json:"user_id"`
-	Version  string `json:"version"`
-	Action   string `json:"action"`
+	ID       json.Number `json:"id"`
+	LiveMode bool        `json:"live_mode"`
+	Type     string      `json:"type"`
+	Date     string      `json:"date_created"`
+	UserID   json.Number `json:"user_id"`
+	Version  string      `json:"api_version"`
+	Action   string      `json:"action"`
 	Data     struct {
 		ID string `json:"id"`
 	} `json:"data"`
 }
 
-func WebHookHandler(ctx *api.Context) {
-	var notification PaymentNotification
+// EventHandler aplica os efeitos de um evento já gravado. Um erro faz o
+// webhook responder 500 para o Mercado Pago tentar de novo depois
+type EventHandler func(ctx context.Context, event *WebhookEvent) error
+
+type WebhookReceiver struct {
+	events   *EventRepository
+	secret   string
+	handlers map[string]EventHandler
+}
+
+func NewWebhookReceiver(events *EventRepository, secret string) *WebhookReceiver {
+	return &WebhookReceiver{
+		events:   events,
+		secret:   secret,
+		handlers: map[string]EventHandler{},
+	}
+}
+
+// Handle registra o handler para um tipo de notificação ("payment", ...),
+// tipos sem handler são gravados como ignored
+func (w *WebhookReceiver) Handle(eventType string, handler EventHandler) {
+	w.handlers[eventType] = handler
+}
 
-	if err := ctx.Json(&notification); err != nil {
+// POST /transaction/hook
+//
+// 200 quando o evento foi processado agora ou já tinha sido, 400 para corpo
+// inválido, 401 para assinatura inválida e 500 quando algo falhou e o
+// Mercado Pago deve reenviar
+func (w *WebhookReceiver) WebHookHandler(ctx *api.Context) {
+	body, err := io.ReadAll(io.LimitReader(ctx.Request.Body, maxNotificationSize))
+	if err != nil {
 		ctx.Error(err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	id, err := strconv.Atoi(notification.Data.ID)
-	if err != nil {
+	var notification PaymentNotification
+	if err := json.Unmarshal(body, &notification); err != nil {
 		ctx.Error(err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	paymentInfo, err := bank.Client().Get(context.Background(), id)
-	if err != nil {
-		ctx.Error(err.Error(), http.StatusInternalServerError)
+	// O data.id da query é o que entra na assinatura, o do corpo é reserva
+	dataId := ctx.Request.URL.Query().Get("data.id")
+	if dataId == "" {
+		dataId = notification.Data.ID
+	}
+
+	if notification.ID == "" || notification.Type == "" || dataId == "" {
+		ctx.Error("notification without id, type or data.id", http.StatusBadRequest)
 		return
 	}
 
-	fmt.Println(paymentInfo.Status)
+	requestId := ctx.Request.Header.Get("x-request-id")
+	if err := VerifySignature(w.secret, ctx.Request.Header.Get("x-signature"), requestId, dataId); err != nil {
+		ctx.Error(err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	event := &WebhookEvent{
+		Provider:   providerMercadoPago,
+		EventId:    notification.ID.String(),
+		RequestId:  requestId,
+		Type:       notification.Type,
+		Action:     notification.Action,
+		ResourceId: dataId,
+		LiveMode:   notification.LiveMode,
+		Payload:    string(body),
+	}
+
+	if _, err := w.events.Save(ctx.Request.Context(), event); err != nil {
+		ctx.Logger.LogAndSendSystemMessage(err.Error())
+		ctx.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := w.process(ctx.Request.Context(), event); err != nil {
+		ctx.Logger.LogAndSendSystemMessage("webhook " + event.EventId + ": " + err.Error())
+		ctx.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 
 	ctx.WriteHeader(http.StatusOK)
 }
+
+// process pega o evento e roda o handler. Se outro processo já pegou o
+// evento, ou ele já foi processado, não faz nada
+func (w *WebhookReceiver) process(ctx context.Context, received *WebhookEvent) error {
+	event, claimed, err := w.events.Claim(ctx, received.Provider, received.EventId)
+	if err != nil || !claimed {
+		return err
+	}
+
+	handler, ok := w.handlers[event.Type]
+	if !ok {
+		return w.events.Finish(ctx, event.Id, EventIgnored, nil)
+	}
+
+	if err := runHandler(ctx, handler, event); err != nil {
+		if finishErr := w.events.Finish(ctx, event.Id, EventFailed, err); finishErr != nil {
+			return errors.Join(err, finishErr)
+		}
+		return err
+	}
+
+	return w.events.Finish(ctx, event.Id, EventProcessed, nil)
+}
+
+// Um panic no handler vira erro para o evento ficar como failed
+func runHandler(ctx context.Context, handler EventHandler, event *WebhookEvent) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return handler(ctx, event)
+}
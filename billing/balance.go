@@ -0,0 +1,47 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"prodata/money"
+	"time"
+)
+
+type AdjustmentKind string
+
+const (
+	AdjustmentPartialPayment AdjustmentKind = "partial_payment"
+	AdjustmentOverpayment    AdjustmentKind = "overpayment"
+)
+
+// BalanceAdjustment registra dinheiro recebido que não quitou uma fatura
+// exatamente: pagamentos parciais e o que foi pago a mais
+type BalanceAdjustment struct {
+	Id          int64          `json:"id"`
+	OwnerId     string         `json:"-"`
+	Kind        AdjustmentKind `json:"kind"`
+	Amount      money.Money    `json:"amount"`
+	InvoiceId   string         `json:"invoice_id,omitempty"`
+	PaymentId   string         `json:"payment_id,omitempty"`
+	Description string         `json:"description"`
+	CreatedAt   string         `json:"created_at"`
+}
+
+func recordAdjustment(ctx context.Context, tx *sql.Tx, adjustment *BalanceAdjustment) error {
+	adjustment.CreatedAt = time.Now().Format(time.DateTime)
+
+	result, err := tx.ExecContext(ctx, "INSERT INTO balance_adjustments (owner_uuid, kind, amount, invoice_id, payment_id, description, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		adjustment.OwnerId,
+		adjustment.Kind,
+		adjustment.Amount,
+		nullable(adjustment.InvoiceId),
+		nullable(adjustment.PaymentId),
+		adjustment.Description,
+		adjustment.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	adjustment.Id, err = result.LastInsertId()
+	return err
+}
@@ -0,0 +1,652 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"prodata/bank"
+	"prodata/database/account"
+	"prodata/logs"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type CheckoutConfig struct {
+	PixExpiration   time.Duration
+	NotificationURL string
+	// Método do boleto no gateway, bolbradesco no Mercado Pago
+	BoletoMethod  string
+	BoletoDueDays int
+	// Dias úteis que o banco leva para avisar que o boleto foi pago
+	BoletoCompensationDays int
+}
+
+func CheckoutConfigFromEnv() CheckoutConfig {
+	expiration, err := time.ParseDuration(os.Getenv("PIX_EXPIRATION"))
+	if err != nil {
+		expiration = 30 * time.Minute
+	}
+
+	boletoMethod := os.Getenv("BOLETO_METHOD")
+	if boletoMethod == "" {
+		boletoMethod = "bolbradesco"
+	}
+
+	dueDays, err := strconv.Atoi(os.Getenv("BOLETO_DUE_DAYS"))
+	if err != nil || dueDays < 1 {
+		dueDays = 3
+	}
+
+	compensationDays, err := strconv.Atoi(os.Getenv("BOLETO_COMPENSATION_DAYS"))
+	if err != nil || compensationDays < 0 {
+		compensationDays = 3
+	}
+
+	return CheckoutConfig{
+		PixExpiration:          expiration,
+		NotificationURL:        os.Getenv("MP_NOTIFICATION_URL"),
+		BoletoMethod:           boletoMethod,
+		BoletoDueDays:          dueDays,
+		BoletoCompensationDays: compensationDays,
+	}
+}
+
+// Por quanto tempo um pagamento em criação segura novas cobranças da fatura.
+// Depois disso ele é dado como não criado
+const creatingTimeout = 10 * time.Minute
+
+// Checkout cria as cobranças no gateway para as faturas em aberto
+type Checkout struct {
+	db         *sql.DB
+	invoices   *InvoiceRepository
+	payments   *PaymentRepository
+	methods    *PaymentMethodRepository
+	users      *account.UserRepository
+	wallet     *WalletRepository
+	gateway    bank.PaymentGateway
+	reconciler *Reconciler
+	config     CheckoutConfig
+}
+
+func NewCheckout(db *sql.DB, invoices *InvoiceRepository, payments *PaymentRepository, methods *PaymentMethodRepository, users *account.UserRepository, wallet *WalletRepository, gateway bank.PaymentGateway, reconciler *Reconciler, config CheckoutConfig) *Checkout {
+	return &Checkout{
+		db:         db,
+		invoices:   invoices,
+		payments:   payments,
+		methods:    methods,
+		users:      users,
+		wallet:     wallet,
+		gateway:    gateway,
+		reconciler: reconciler,
+		config:     config,
+	}
+}
+
+// payableInvoice trava a fatura do usuário e confere se ela pode ser paga
+func (c *Checkout) payableInvoice(ctx context.Context, tx *sql.Tx, ownerId, invoiceId string) (*Invoice, error) {
+	inv, err := c.invoices.getForUpdate(ctx, tx, invoiceId)
+	if err != nil {
+		return nil, err
+	}
+
+	if inv.OwnerId != ownerId || inv.Status == InvoiceDraft {
+		return nil, ErrInvoiceNotFound
+	}
+
+	if inv.Status != InvoiceOpen && inv.Status != InvoiceOverdue {
+		return nil, ErrInvoiceNotPayable
+	}
+
+	if !inv.AmountDue().IsPositive() {
+		return nil, ErrInvoiceNotPayable
+	}
+
+	return inv, nil
+}
+
+// reusablePending olha as cobranças pendentes da fatura: a primeira que ainda
+// vale e tem o valor certo é devolvida, as outras voltam em replaced para
+// serem canceladas, assim o cliente nunca tem dois códigos válidos para a
+// mesma fatura
+func (c *Checkout) reusablePending(ctx context.Context, tx *sql.Tx, inv *Invoice, method PaymentMethod, now time.Time) (reuse *Payment, replaced []Payment, err error) {
+	pending, err := c.payments.PendingTx(ctx, tx, inv.Id, method)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i := range pending {
+		p := &pending[i]
+
+		expiresAt, err := time.ParseInLocation(time.DateTime, p.ExpiresAt, time.Local)
+		expired := err == nil && !expiresAt.After(now.Add(time.Minute))
+
+		if expired {
+			// O gateway já cancela sozinho quando o PIX ou o boleto vencem
+			if err := c.payments.UpdateStatusTx(ctx, tx, p.Id, PaymentExpired, ""); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		if p.DueDate != "" {
+			// Boleto vencido ainda dentro do prazo de compensação pode já ter
+			// sido pago, não dá para reaproveitar nem cancelar
+			dueDate, err := time.ParseInLocation(time.DateOnly, p.DueDate, time.Local)
+			if err == nil && !dueDate.AddDate(0, 0, 1).After(now) {
+				continue
+			}
+		}
+
+		if reuse == nil && p.Amount.Compare(inv.AmountDue()) == 0 {
+			reuse = p
+			continue
+		}
+
+		replaced = append(replaced, *p)
+	}
+
+	return reuse, replaced, nil
+}
+
+// cancelReplaced cancela no gateway as cobranças substituídas, já com a
+// fatura destravada
+func (c *Checkout) cancelReplaced(ctx context.Context, replaced []Payment) error {
+	for _, p := range replaced {
+		if _, err := c.gateway.Cancel(ctx, p.ProviderPaymentId); err != nil {
+			return fmt.Errorf("cancel payment %s: %w", p.ProviderPaymentId, err)
+		}
+
+		if err := c.payments.cancelPending(ctx, p.Id, "replaced"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reserveCredit debita do saldo o que der da fatura e grava o pagamento
+// pendente com o provider wallet. Um pagamento desses que ficou pendente
+// porque a conciliação caiu no meio é devolvido no lugar de debitar de novo
+func (c *Checkout) reserveCredit(ctx context.Context, ownerId, invoiceId string) (*Payment, error) {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	inv, err := c.payableInvoice(ctx, tx, ownerId, invoiceId)
+	if err != nil {
+		return nil, err
+	}
+
+	pending, err := c.payments.PendingTx(ctx, tx, inv.Id, MethodCredit)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pending) > 0 {
+		return &pending[0], tx.Commit()
+	}
+
+	// O boleto em compensação já vai quitar a fatura, o saldo fica para a
+	// próxima
+	processing, err := c.payments.CountByStatusTx(ctx, tx, inv.Id, PaymentProcessing)
+	if err != nil || processing > 0 {
+		return nil, err
+	}
+
+	balance, err := c.wallet.balanceTx(ctx, tx, ownerId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !balance.IsPositive() {
+		return nil, nil
+	}
+
+	amount := inv.AmountDue()
+	if balance.Compare(amount) < 0 {
+		amount = balance
+	}
+
+	p := &Payment{
+		Id:        uuid.New().String(),
+		InvoiceId: inv.Id,
+		OwnerId:   ownerId,
+		Provider:  ProviderWallet,
+		Method:    MethodCredit,
+		Status:    PaymentPending,
+		Amount:    amount,
+	}
+	p.ProviderPaymentId = p.Id
+
+	if err := c.payments.CreateTx(ctx, tx, p); err != nil {
+		return nil, err
+	}
+
+	_, err = c.wallet.addTx(ctx, tx, &WalletEntry{
+		OwnerId:     ownerId,
+		Source:      WalletInvoice,
+		Amount:      amount.Negate(),
+		InvoiceId:   inv.Id,
+		PaymentId:   p.Id,
+		Reference:   p.Id,
+		Description: "Pagamento da fatura " + inv.Number,
+		Actor:       SystemActor,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return p, tx.Commit()
+}
+
+// applyCredit usa o saldo da conta na fatura antes de ir ao gateway. paid diz
+// se o saldo quitou a fatura inteira
+func (c *Checkout) applyCredit(ctx context.Context, ownerId, invoiceId string) (p *Payment, paid bool, err error) {
+	p, err = c.reserveCredit(ctx, ownerId, invoiceId)
+	if err != nil || p == nil {
+		return nil, false, err
+	}
+
+	// O saldo já foi debitado, se a conciliação falhar o pagamento fica
+	// pendente e a próxima tentativa conclui ele
+	if err := c.reconciler.Reconcile(ctx, ProviderWallet, p.ProviderPaymentId, PaymentApproved, "", p.Amount); err != nil {
+		return nil, false, fmt.Errorf("reconcile credit payment %s: %w", p.Id, err)
+	}
+	p.Status = PaymentApproved
+
+	inv, err := c.invoices.Get(ctx, p.InvoiceId)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return p, inv.Status == InvoicePaid, nil
+}
+
+// charge cobra o que falta da fatura com o método pedido, devolvendo a
+// cobrança pendente que ainda vale se houver uma. O saldo da conta entra
+// antes e, se quitar a fatura, o gateway nem é chamado
+func (c *Checkout) charge(ctx context.Context, ownerId, invoiceId string, method PaymentMethod, build func(inv *Invoice, now time.Time) (bank.PaymentRequest, *Payment, error)) (*Payment, error) {
+	credit, paid, err := c.applyCredit(ctx, ownerId, invoiceId)
+	if err != nil {
+		return nil, err
+	}
+
+	if paid {
+		return credit, nil
+	}
+
+	p, request, replaced, err := c.reserve(ctx, ownerId, invoiceId, method, build)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.cancelReplaced(ctx, replaced); err != nil {
+		if p.Status == PaymentCreating {
+			err = errors.Join(err, c.payments.release(ctx, p.Id))
+		}
+		return nil, err
+	}
+
+	if p.Status != PaymentCreating {
+		return p, nil
+	}
+
+	return c.create(ctx, p, request)
+}
+
+// reserve trava a fatura e grava o pagamento em criação, ou devolve a
+// cobrança pendente que ainda vale. Nada aqui chama o gateway, uma resposta
+// dele não pode se perder num rollback
+func (c *Checkout) reserve(ctx context.Context, ownerId, invoiceId string, method PaymentMethod, build func(inv *Invoice, now time.Time) (bank.PaymentRequest, *Payment, error)) (p *Payment, request bank.PaymentRequest, replaced []Payment, err error) {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, request, nil, err
+	}
+	defer tx.Rollback()
+
+	inv, err := c.payableInvoice(ctx, tx, ownerId, invoiceId)
+	if err != nil {
+		return nil, request, nil, err
+	}
+
+	// Um boleto pago esperando compensação já vai quitar a fatura, cobrar
+	// de novo faria o cliente pagar duas vezes
+	processing, err := c.payments.CountByStatusTx(ctx, tx, inv.Id, PaymentProcessing)
+	if err != nil {
+		return nil, request, nil, err
+	}
+
+	if processing > 0 {
+		return nil, request, nil, ErrPaymentProcessing
+	}
+
+	now := time.Now()
+
+	// Uma cobrança que ainda está sendo criada pode já existir no gateway,
+	// principalmente no cartão, então espera ela antes de criar outra
+	if err := c.payments.releaseStaleTx(ctx, tx, inv.Id, now.Add(-creatingTimeout)); err != nil {
+		return nil, request, nil, err
+	}
+
+	creating, err := c.payments.CountByStatusTx(ctx, tx, inv.Id, PaymentCreating)
+	if err != nil {
+		return nil, request, nil, err
+	}
+
+	if creating > 0 {
+		return nil, request, nil, ErrPaymentInProgress
+	}
+
+	// Cartão não tem código para reaproveitar, cada tentativa é uma cobrança
+	if method != MethodCard {
+		reuse, replaced, err := c.reusablePending(ctx, tx, inv, method, now)
+		if err != nil {
+			return nil, request, nil, err
+		}
+
+		if reuse != nil {
+			return reuse, request, replaced, tx.Commit()
+		}
+	}
+
+	request, p, err = build(inv, now)
+	if err != nil {
+		return nil, request, nil, err
+	}
+
+	email, err := c.users.FindEmailByUUID(ctx, ownerId)
+	if err != nil {
+		return nil, request, nil, err
+	}
+
+	p.Id = uuid.New().String()
+	p.InvoiceId = inv.Id
+	p.OwnerId = ownerId
+	p.Provider = c.gateway.Name()
+	p.ProviderPaymentId = p.Id
+	p.Method = method
+	p.Status = PaymentCreating
+	p.Amount = inv.AmountDue()
+
+	request.Amount = inv.AmountDue()
+	request.Description = "Fatura " + inv.Number
+	request.ExternalReference = inv.Id
+	request.NotificationURL = c.config.NotificationURL
+	request.PayerEmail = email
+	request.IdempotencyKey = p.Id
+
+	if err := c.payments.CreateTx(ctx, tx, p); err != nil {
+		return nil, request, nil, err
+	}
+
+	return p, request, replaced, tx.Commit()
+}
+
+// create chama o gateway com o id do pagamento como chave de idempotência e
+// grava a resposta. Se a gravação falhar o pagamento continua em criação e a
+// notificação do gateway completa ele
+func (c *Checkout) create(ctx context.Context, p *Payment, request bank.PaymentRequest) (*Payment, error) {
+	response, err := c.gateway.CreatePayment(ctx, request)
+	if err != nil {
+		if errors.Is(err, bank.ErrPaymentRefused) {
+			err = errors.Join(err, c.payments.release(ctx, p.Id))
+		}
+		return nil, err
+	}
+
+	// Cartão pode voltar aprovado na hora. Ele é salvo pendente e a
+	// conciliação aplica a aprovação, do mesmo jeito que faria o webhook
+	status := p.applyGateway(response)
+
+	attached, err := c.payments.attach(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+
+	// A notificação chegou antes e já gravou a cobrança
+	if !attached {
+		return c.payments.Get(ctx, p.Id)
+	}
+
+	if status == PaymentApproved {
+		if err := c.reconciler.Reconcile(ctx, p.Provider, p.ProviderPaymentId, status, p.StatusDetail, response.Amount); err != nil {
+			// O webhook do gateway ainda vai chegar e tentar de novo
+			logs.NewSistemLogger().LogAndSendSystemMessage("reconcile card payment " + p.Id + ": " + err.Error())
+		}
+		p.Status = status
+	}
+
+	return p, nil
+}
+
+func (c *Checkout) PayPix(ctx context.Context, ownerId, invoiceId string) (*Payment, error) {
+	return c.charge(ctx, ownerId, invoiceId, MethodPix, func(inv *Invoice, now time.Time) (bank.PaymentRequest, *Payment, error) {
+		expiresAt := now.Add(c.config.PixExpiration)
+
+		request := bank.PaymentRequest{
+			Method:    string(MethodPix),
+			ExpiresAt: &expiresAt,
+		}
+
+		return request, &Payment{ExpiresAt: expiresAt.Format(time.DateTime)}, nil
+	})
+}
+
+// PayBoleto emite um boleto com vencimento em BoletoDueDays dias. O
+// pagamento só expira aqui depois dos dias de compensação, um boleto pago no
+// vencimento ainda pode ser aprovado nesse intervalo
+func (c *Checkout) PayBoleto(ctx context.Context, ownerId, invoiceId, document string) (*Payment, error) {
+	document, err := normalizeDocument(document)
+	if err != nil {
+		return nil, err
+	}
+
+	firstName, lastName, err := c.users.FindNameByUUID(ctx, ownerId)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.charge(ctx, ownerId, invoiceId, MethodBoleto, func(inv *Invoice, now time.Time) (bank.PaymentRequest, *Payment, error) {
+		year, month, day := now.AddDate(0, 0, c.config.BoletoDueDays).Date()
+		dueDate := time.Date(year, month, day, 23, 59, 59, 0, time.Local)
+		expiresAt := dueDate.AddDate(0, 0, businessDays(dueDate, c.config.BoletoCompensationDays))
+
+		request := bank.PaymentRequest{
+			Method:         c.config.BoletoMethod,
+			ExpiresAt:      &dueDate,
+			PayerFirstName: firstName,
+			PayerLastName:  lastName,
+			PayerDocument:  document,
+		}
+
+		p := &Payment{
+			DueDate:   dueDate.Format(time.DateOnly),
+			ExpiresAt: expiresAt.Format(time.DateTime),
+		}
+
+		return request, p, nil
+	})
+}
+
+type CardPayment struct {
+	// Token gerado pelo navegador com o SDK do gateway
+	Token           string `json:"token"`
+	PaymentMethodId string `json:"payment_method_id"`
+	Installments    int    `json:"installments"`
+	SaveCard        bool   `json:"save_card"`
+	// Cartão já salvo, no lugar do token
+	SavedMethodId string `json:"saved_method_id"`
+}
+
+// PayCard cobra a fatura no cartão. Com SaveCard o cartão é guardado no
+// gateway antes da cobrança, fora da transação que trava a fatura, e só
+// fica salvo aqui se ela não for recusada
+func (c *Checkout) PayCard(ctx context.Context, ownerId, invoiceId string, card CardPayment) (*Payment, error) {
+	if card.Token == "" && card.SavedMethodId == "" {
+		return nil, ErrCardRequired
+	}
+
+	var saved *SavedPaymentMethod
+	if card.SavedMethodId != "" {
+		var err error
+		saved, err = c.methods.GetForOwner(ctx, ownerId, card.SavedMethodId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var newCard *bank.Card
+	if saved == nil && card.SaveCard {
+		var err error
+		newCard, err = c.saveCard(ctx, ownerId, card.Token)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	p, err := c.charge(ctx, ownerId, invoiceId, MethodCard, func(inv *Invoice, now time.Time) (bank.PaymentRequest, *Payment, error) {
+		request := bank.PaymentRequest{
+			Method:       card.PaymentMethodId,
+			Installments: card.Installments,
+		}
+
+		switch {
+		case saved != nil:
+			request.Method = saved.Brand
+			request.CustomerId = saved.CustomerId
+			request.CardId = saved.CardId
+		case newCard != nil:
+			if request.Method == "" {
+				request.Method = newCard.Brand
+			}
+			request.CustomerId = newCard.CustomerId
+			request.CardId = newCard.Id
+		default:
+			request.Token = card.Token
+		}
+
+		return request, &Payment{}, nil
+	})
+
+	if newCard == nil {
+		return p, err
+	}
+
+	if err != nil || p.Status == PaymentRejected {
+		if err := c.gateway.DeleteCard(ctx, newCard.CustomerId, newCard.Id); err != nil {
+			logs.NewSistemLogger().LogAndSendSystemMessage("delete rejected card " + newCard.Id + ": " + err.Error())
+		}
+		return p, err
+	}
+
+	err = c.methods.Create(ctx, &SavedPaymentMethod{
+		OwnerId:         ownerId,
+		Provider:        c.gateway.Name(),
+		CustomerId:      newCard.CustomerId,
+		CardId:          newCard.Id,
+		Brand:           newCard.Brand,
+		LastFour:        newCard.LastFour,
+		ExpirationMonth: newCard.ExpirationMonth,
+		ExpirationYear:  newCard.ExpirationYear,
+		HolderName:      newCard.HolderName,
+	})
+	if err != nil {
+		// A cobrança já foi feita, perder o cartão salvo não pode virar erro
+		logs.NewSistemLogger().LogAndSendSystemMessage("save card " + newCard.Id + ": " + err.Error())
+	}
+
+	return p, nil
+}
+
+// ChargeSaved cobra a fatura num cartão salvo sem o cliente presente, é o
+// que as renovações automáticas usam. Sem methodId vai no cartão padrão
+func (c *Checkout) ChargeSaved(ctx context.Context, ownerId, invoiceId, methodId string) (*Payment, error) {
+	if methodId == "" {
+		method, err := c.methods.Default(ctx, ownerId)
+		if err != nil {
+			return nil, err
+		}
+		methodId = method.Id
+	}
+
+	return c.PayCard(ctx, ownerId, invoiceId, CardPayment{SavedMethodId: methodId})
+}
+
+func (c *Checkout) saveCard(ctx context.Context, ownerId, token string) (*bank.Card, error) {
+	customerId, err := c.methods.CustomerId(ctx, ownerId, c.gateway.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	email, err := c.users.FindEmailByUUID(ctx, ownerId)
+	if err != nil {
+		return nil, err
+	}
+
+	firstName, lastName, err := c.users.FindNameByUUID(ctx, ownerId)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.gateway.SaveCard(ctx, bank.CardRequest{
+		Token:      token,
+		CustomerId: customerId,
+		Email:      email,
+		FirstName:  firstName,
+		LastName:   lastName,
+	})
+}
+
+// DeletePaymentMethod apaga o cartão no gateway e aqui
+func (c *Checkout) DeletePaymentMethod(ctx context.Context, ownerId, id string) error {
+	method, err := c.methods.GetForOwner(ctx, ownerId, id)
+	if err != nil {
+		return err
+	}
+
+	err = c.gateway.DeleteCard(ctx, method.CustomerId, method.CardId)
+	if err != nil && !errors.Is(err, bank.ErrCardNotFound) {
+		return err
+	}
+
+	return c.methods.Delete(ctx, ownerId, id)
+}
+
+// businessDays devolve quantos dias corridos depois de from cabem days dias
+// úteis, sem contar feriados
+func businessDays(from time.Time, days int) int {
+	total := 0
+	for days > 0 {
+		total++
+		switch from.AddDate(0, 0, total).Weekday() {
+		case time.Saturday, time.Sunday:
+		default:
+			days--
+		}
+	}
+
+	return total
+}
+
+// normalizeDocument tira a pontuação do CPF ou CNPJ
+func normalizeDocument(document string) (string, error) {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, document)
+
+	if len(digits) != 11 && len(digits) != 14 {
+		return "", ErrInvalidDocument
+	}
+
+	return digits, nil
+}
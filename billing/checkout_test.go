@@ -0,0 +1,336 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"prodata/api"
+	"prodata/bank"
+	"prodata/bank/tx"
+	"prodata/catalog"
+	"prodata/database/account"
+	"prodata/database/dbtest"
+	"prodata/money"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const testWebhookSecret = "segredo-de-teste"
+
+// testBilling monta o faturamento como o main, com o gateway fake entregando
+// as mudanças de status pelo webhook assinado
+type testBilling struct {
+	db       *sql.DB
+	router   *api.Router
+	fake     *bank.FakeGateway
+	invoices *InvoiceRepository
+	payments *PaymentRepository
+	refunds  *RefundRepository
+	services *account.ServiceRepository
+	checkout *Checkout
+	refunder *Refunder
+}
+
+func newTestBilling(t *testing.T) *testBilling {
+	db := dbtest.Open(t)
+	account.Init(db)
+
+	fake := bank.NewFakeGateway()
+	invoices := NewInvoiceRepository(db)
+	payments := NewPaymentRepository(db)
+	refunds := NewRefundRepository(db)
+	wallet := NewWalletRepository(db)
+	dunning := NewDunningRepository(db)
+
+	refunder := NewRefunder(db, invoices, payments, refunds, account.Lifecycle(), wallet, fake)
+	disputes := NewDisputes(db, NewDisputeRepository(db), invoices, dunning, account.Lifecycle(), account.Users(), DisputeConfigFromEnv())
+	reconciler := NewReconciler(db, invoices, payments, account.ServicesRepository(), account.Lifecycle(), account.Users(), dunning, refunder, disputes, wallet, fake)
+	checkout := NewCheckout(db, invoices, payments, NewPaymentMethodRepository(db), account.Users(), wallet, fake, reconciler, CheckoutConfigFromEnv())
+
+	router := api.NewRouter()
+	webhook := tx.NewWebhookReceiver(tx.NewEventRepository(db), testWebhookSecret)
+	webhook.Handle("payment", reconciler.HandlePaymentEvent)
+	router.Post("/transaction/hook", webhook.WebHookHandler)
+
+	fake.OnChange(func(ctx context.Context, p bank.Payment) {
+		if _, err := tx.Deliver(router, "/transaction/hook", testWebhookSecret, p.Id); err != nil {
+			t.Errorf("webhook %s: %v", p.Id, err)
+		}
+	})
+
+	return &testBilling{
+		db:       db,
+		router:   router,
+		fake:     fake,
+		invoices: invoices,
+		payments: payments,
+		refunds:  refunds,
+		services: account.ServicesRepository(),
+		checkout: checkout,
+		refunder: refunder,
+	}
+}
+
+// order cria um cliente com um serviço pendente, ainda sem período, e a
+// fatura em aberto dele, como o pedido feito dois dias antes do pagamento
+func (b *testBilling) order(t *testing.T, price money.Money) (*account.Services, *Invoice) {
+	t.Helper()
+	ctx := context.Background()
+
+	ownerId := uuid.New().String()
+	_, err := b.db.ExecContext(ctx, "INSERT INTO userdata (uuid, first_name, last_name, email, password) VALUES (?, ?, ?, ?, ?)",
+		ownerId, "Cliente", "Teste", ownerId+"@example.com", "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ordered := time.Now().AddDate(0, 0, -2).Truncate(time.Second)
+	service := &account.Services{
+		OwnerId: ownerId,
+		Name:    "VPS",
+		Price:   price,
+		Type:    "vps",
+		Cycle:   "monthly",
+	}
+	if err := b.services.Create(ctx, service); err != nil {
+		t.Fatal(err)
+	}
+
+	inv := &Invoice{
+		OwnerId: ownerId,
+		Status:  InvoiceOpen,
+		DueDate: ordered.AddDate(0, 0, 3).Format(time.DateTime),
+		Items: []InvoiceItem{{
+			ServiceId:   service.Id,
+			Kind:        ItemService,
+			Description: "VPS",
+			Quantity:    1,
+			UnitPrice:   price,
+			PeriodStart: ordered.Format(time.DateTime),
+			PeriodEnd:   ordered.AddDate(0, 1, 0).Format(time.DateTime),
+		}},
+	}
+	if err := b.invoices.Create(ctx, inv); err != nil {
+		t.Fatal(err)
+	}
+
+	return service, inv
+}
+
+func (b *testBilling) expect(t *testing.T, invoiceId, paymentId string, invoice InvoiceStatus, payment PaymentStatus, service account.ServiceStatus) (*Invoice, *account.Services) {
+	t.Helper()
+	ctx := context.Background()
+
+	inv, err := b.invoices.Get(ctx, invoiceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := b.payments.Get(ctx, paymentId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := b.services.GetByID(ctx, inv.Items[0].ServiceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if inv.Status != invoice || p.Status != payment || s.Status != service {
+		t.Fatalf("invoice %s, payment %s, service %s, want %s, %s and %s", inv.Status, p.Status, s.Status, invoice, payment, service)
+	}
+
+	return inv, s
+}
+
+func TestCheckoutPixApproved(t *testing.T) {
+	b := newTestBilling(t)
+	ctx := context.Background()
+	service, inv := b.order(t, money.MustParse("49.90"))
+
+	p, err := b.checkout.PayPix(ctx, service.OwnerId, inv.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Status != PaymentPending || p.QRCode == "" {
+		t.Fatalf("payment = %+v, want pending com QR code", p)
+	}
+
+	// Pedir de novo devolve a mesma cobrança
+	again, err := b.checkout.PayPix(ctx, service.OwnerId, inv.Id)
+	if err != nil || again.Id != p.Id {
+		t.Fatalf("segundo PayPix = %v, %v, want %s", again, err, p.Id)
+	}
+
+	if _, err := b.fake.Approve(ctx, p.ProviderPaymentId); err != nil {
+		t.Fatal(err)
+	}
+
+	paid, s := b.expect(t, inv.Id, p.Id, InvoicePaid, PaymentApproved, account.StatusActive)
+	if paid.AmountPaid.Compare(inv.Total) != 0 {
+		t.Errorf("amount paid = %s, want %s", paid.AmountPaid, inv.Total)
+	}
+
+	// O primeiro período conta do pagamento, não do pedido
+	paidAt, _ := time.ParseInLocation(time.DateTime, paid.PaidAt, time.Local)
+	end := catalog.CycleMonthly.PeriodEnd(paidAt).Format(time.DateTime)
+	item := paid.Items[0]
+	if s.PeriodStart != paid.PaidAt || s.Date != end || s.AnchorDay != paidAt.Day() || item.PeriodStart != paid.PaidAt || item.PeriodEnd != end {
+		t.Errorf("service %s até %s dia %d, item %s até %s, want %s até %s dia %d", s.PeriodStart, s.Date, s.AnchorDay, item.PeriodStart, item.PeriodEnd, paid.PaidAt, end, paidAt.Day())
+	}
+
+	// Outra notificação do mesmo pagamento não paga a fatura de novo
+	if _, err := tx.Deliver(b.router, "/transaction/hook", testWebhookSecret, p.ProviderPaymentId); err != nil {
+		t.Fatal(err)
+	}
+
+	replayed, _ := b.expect(t, inv.Id, p.Id, InvoicePaid, PaymentApproved, account.StatusActive)
+	if replayed.AmountPaid.Compare(inv.Total) != 0 {
+		t.Errorf("amount paid = %s depois da segunda notificação, want %s", replayed.AmountPaid, inv.Total)
+	}
+}
+
+func TestCheckoutPixRejected(t *testing.T) {
+	b := newTestBilling(t)
+	ctx := context.Background()
+	service, inv := b.order(t, money.MustParse("49.90"))
+
+	p, err := b.checkout.PayPix(ctx, service.OwnerId, inv.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.fake.Reject(ctx, p.ProviderPaymentId); err != nil {
+		t.Fatal(err)
+	}
+
+	b.expect(t, inv.Id, p.Id, InvoiceOpen, PaymentRejected, account.StatusPending)
+
+	// A fatura continua pagável com uma cobrança nova
+	next, err := b.checkout.PayPix(ctx, service.OwnerId, inv.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Id == p.Id {
+		t.Fatal("PayPix devolveu a cobrança recusada")
+	}
+}
+
+func TestCheckoutCard(t *testing.T) {
+	b := newTestBilling(t)
+	ctx := context.Background()
+
+	service, inv := b.order(t, money.MustParse("99.90"))
+	rejected, err := b.checkout.PayCard(ctx, service.OwnerId, inv.Id, CardPayment{Token: "tok_reject", PaymentMethodId: "visa", Installments: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.expect(t, inv.Id, rejected.Id, InvoiceOpen, PaymentRejected, account.StatusPending)
+
+	// O cartão responde na criação, a conciliação roda sem esperar webhook
+	p, err := b.checkout.PayCard(ctx, service.OwnerId, inv.Id, CardPayment{Token: "tok_4242", PaymentMethodId: "visa", Installments: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.expect(t, inv.Id, p.Id, InvoicePaid, PaymentApproved, account.StatusActive)
+}
+
+func TestRefundSync(t *testing.T) {
+	b := newTestBilling(t)
+	ctx := context.Background()
+	service, inv := b.order(t, money.MustParse("100.00"))
+
+	p, err := b.checkout.PayPix(ctx, service.OwnerId, inv.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.fake.Approve(ctx, p.ProviderPaymentId); err != nil {
+		t.Fatal(err)
+	}
+
+	// O webhook do estorno chega antes da resposta do gateway e reconhece o
+	// estorno pending em vez de criar outro
+	partial := money.MustParse("30.00")
+	refund, err := b.refunder.Refund(ctx, p.Id, RefundRequest{Amount: &partial, Reason: "teste", Actor: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if refund.Status != RefundApproved || refund.ProviderRefundId == "" {
+		t.Fatalf("refund = %+v, want approved", refund)
+	}
+
+	// O resto é estornado direto no painel do gateway
+	if _, err := b.fake.SetStatus(ctx, p.ProviderPaymentId, bank.StatusRefunded, "by_admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	refunds, err := b.refunds.ListByInvoice(ctx, inv.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	total := money.New(0, money.BRL)
+	for _, r := range refunds {
+		if r.Status != RefundApproved {
+			t.Errorf("refund %s = %s, want approved", r.Id, r.Status)
+		}
+		total, _ = total.Add(r.Amount)
+	}
+
+	if len(refunds) != 2 || total.Compare(inv.Total) != 0 {
+		t.Fatalf("refunds = %+v, want dois somando %s", refunds, inv.Total)
+	}
+
+	refunded, _ := b.expect(t, inv.Id, p.Id, InvoicePaid, PaymentRefunded, account.StatusActive)
+	if refunded.AmountRefunded.Compare(inv.Total) != 0 {
+		t.Errorf("amount refunded = %s, want %s", refunded.AmountRefunded, inv.Total)
+	}
+
+	// Uma notificação repetida não estorna de novo
+	if _, err := tx.Deliver(b.router, "/transaction/hook", testWebhookSecret, p.ProviderPaymentId); err != nil {
+		t.Fatal(err)
+	}
+
+	again, err := b.refunds.ListByInvoice(ctx, inv.Id)
+	if err != nil || len(again) != 2 {
+		t.Errorf("refunds depois da notificação repetida = %d, %v", len(again), err)
+	}
+}
+
+func TestChargebackAfterPartialRefund(t *testing.T) {
+	b := newTestBilling(t)
+	ctx := context.Background()
+	service, inv := b.order(t, money.MustParse("100.00"))
+
+	p, err := b.checkout.PayPix(ctx, service.OwnerId, inv.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.fake.Approve(ctx, p.ProviderPaymentId); err != nil {
+		t.Fatal(err)
+	}
+
+	partial := money.MustParse("30.00")
+	if _, err := b.refunder.Refund(ctx, p.Id, RefundRequest{Amount: &partial, Reason: "teste", Actor: "admin"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.fake.SetStatus(ctx, p.ProviderPaymentId, bank.StatusChargedBack, "reimbursed"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A contestação só leva o que não foi estornado
+	dispute, err := NewDisputeRepository(b.db).GetByPayment(ctx, p.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := money.MustParse("70.00"); dispute.Amount.Compare(want) != 0 || dispute.Status != DisputeOpen {
+		t.Errorf("dispute = %s %s, want %s %s", dispute.Amount, dispute.Status, want, DisputeOpen)
+	}
+}
@@ -0,0 +1,393 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"prodata/database/account"
+	"prodata/money"
+	"slices"
+	"strings"
+	"time"
+)
+
+type CouponKind string
+
+const (
+	CouponPercent CouponKind = "percent"
+	CouponFixed   CouponKind = "fixed"
+)
+
+type CouponDuration string
+
+const (
+	// Só a primeira fatura do serviço
+	CouponOnce CouponDuration = "once"
+	// Também as renovações, por Cycles faturas ou para sempre
+	CouponRecurring CouponDuration = "recurring"
+)
+
+var (
+	ErrCouponNotFound      = errors.New("coupon code does not exist")
+	ErrCouponInactive      = errors.New("coupon is no longer active")
+	ErrCouponNotStarted    = errors.New("coupon is not valid yet")
+	ErrCouponExpired       = errors.New("coupon has expired")
+	ErrCouponExhausted     = errors.New("coupon has reached its usage limit")
+	ErrCouponUserLimit     = errors.New("you have already used this coupon the maximum number of times")
+	ErrCouponNotApplicable = errors.New("coupon does not apply to the selected plans")
+	ErrInvalidCoupon       = errors.New("invalid coupon")
+)
+
+type Coupon struct {
+	Id          string         `json:"id"`
+	Code        string         `json:"code"`
+	Description string         `json:"description"`
+	Kind        CouponKind     `json:"kind"`
+	Percent     int            `json:"percent,omitempty"`
+	Amount      money.Money    `json:"amount"`
+	Duration    CouponDuration `json:"duration"`
+	// Quantas faturas o desconto recorrente cobre contando a primeira, nil
+	// é para sempre
+	Cycles *int `json:"cycles,omitempty"`
+	// Vazio vale para todos os planos
+	PlanIds        []string `json:"plan_ids"`
+	MaxRedemptions *int     `json:"max_redemptions,omitempty"`
+	MaxPerUser     *int     `json:"max_per_user,omitempty"`
+	StartsAt       string   `json:"starts_at,omitempty"`
+	EndsAt         string   `json:"ends_at,omitempty"`
+	Active         bool     `json:"active"`
+	Redemptions    int      `json:"redemptions"`
+	CreatedAt      string   `json:"created_at"`
+	UpdatedAt      string   `json:"updated_at"`
+}
+
+// CouponRedemption é um uso do cupom, gravado na fatura em que ele entrou
+type CouponRedemption struct {
+	Id        int64       `json:"id"`
+	CouponId  string      `json:"coupon_id"`
+	OwnerId   string      `json:"owner_id"`
+	InvoiceId string      `json:"invoice_id"`
+	Amount    money.Money `json:"amount"`
+	CreatedAt string      `json:"created_at"`
+}
+
+func normalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func (c *Coupon) Validate() error {
+	c.Code = normalizeCode(c.Code)
+
+	if c.Code == "" || len(c.Code) > 32 || strings.ContainsAny(c.Code, " \t") {
+		return fmt.Errorf("%w: code is required, up to 32 characters without spaces", ErrInvalidCoupon)
+	}
+
+	switch c.Kind {
+	case CouponPercent:
+		if c.Percent < 1 || c.Percent > 100 {
+			return fmt.Errorf("%w: percent must be between 1 and 100", ErrInvalidCoupon)
+		}
+		c.Amount = money.New(0, money.BRL)
+	case CouponFixed:
+		if !c.Amount.IsPositive() {
+			return fmt.Errorf("%w: amount must be positive", ErrInvalidCoupon)
+		}
+		c.Percent = 0
+	default:
+		return fmt.Errorf("%w: kind must be percent or fixed", ErrInvalidCoupon)
+	}
+
+	switch c.Duration {
+	case CouponOnce:
+		c.Cycles = nil
+	case CouponRecurring:
+		if c.Cycles != nil && *c.Cycles < 2 {
+			return fmt.Errorf("%w: recurring cycles must be at least 2", ErrInvalidCoupon)
+		}
+	default:
+		return fmt.Errorf("%w: duration must be once or recurring", ErrInvalidCoupon)
+	}
+
+	if (c.MaxRedemptions != nil && *c.MaxRedemptions < 1) || (c.MaxPerUser != nil && *c.MaxPerUser < 1) {
+		return fmt.Errorf("%w: usage limits must be at least 1", ErrInvalidCoupon)
+	}
+
+	for _, value := range []string{c.StartsAt, c.EndsAt} {
+		if value == "" {
+			continue
+		}
+
+		if _, err := time.ParseInLocation(time.DateTime, value, time.Local); err != nil {
+			return fmt.Errorf("%w: starts_at and ends_at must be like 2006-01-02 15:04:05", ErrInvalidCoupon)
+		}
+	}
+
+	if c.StartsAt != "" && c.EndsAt != "" && c.EndsAt <= c.StartsAt {
+		return fmt.Errorf("%w: ends_at must be after starts_at", ErrInvalidCoupon)
+	}
+
+	if c.PlanIds == nil {
+		c.PlanIds = []string{}
+	}
+
+	return nil
+}
+
+// validAt confere status e janela de validade, os limites de uso dependem do
+// banco e ficam com o checkTx
+func (c *Coupon) validAt(now time.Time) error {
+	if !c.Active {
+		return ErrCouponInactive
+	}
+
+	current := now.Format(time.DateTime)
+
+	if c.StartsAt != "" && current < c.StartsAt {
+		return ErrCouponNotStarted
+	}
+
+	if c.EndsAt != "" && current >= c.EndsAt {
+		return ErrCouponExpired
+	}
+
+	return nil
+}
+
+func (c *Coupon) appliesTo(planId string) bool {
+	return len(c.PlanIds) == 0 || slices.Contains(c.PlanIds, planId)
+}
+
+// discount calcula o desconto do cupom sobre as linhas em que ele entra na
+// fatura, na ordem delas. O percentual vale em cada linha, o fixo é dado
+// uma vez por fatura e dividido entre as linhas pelo valor de cada uma.
+// Nenhum desconto passa do valor da linha
+func (c *Coupon) discount(amounts []money.Money) ([]money.Money, error) {
+	discounts := make([]money.Money, len(amounts))
+
+	if c.Kind == CouponPercent {
+		for i, amount := range amounts {
+			var err error
+			if discounts[i], err = amount.MulDiv(int64(c.Percent), 100); err != nil {
+				return nil, err
+			}
+		}
+		return discounts, nil
+	}
+
+	total := money.New(0, c.Amount.Currency())
+	ratios := make([]int64, len(amounts))
+	for i, amount := range amounts {
+		var err error
+		if total, err = total.Add(amount); err != nil {
+			return nil, err
+		}
+		ratios[i] = max(amount.Cents(), 0)
+		discounts[i] = money.New(0, amount.Currency())
+	}
+
+	if !total.IsPositive() {
+		return discounts, nil
+	}
+
+	value := c.Amount
+	if value.Compare(total) > 0 {
+		value = total
+	}
+
+	return value.Allocate(ratios...)
+}
+
+// Coupons valida os códigos e põe os descontos nas faturas como itens
+type Coupons struct {
+	db       *sql.DB
+	coupons  *CouponRepository
+	invoices *InvoiceRepository
+	services *account.ServiceRepository
+}
+
+func NewCoupons(db *sql.DB, coupons *CouponRepository, invoices *InvoiceRepository, services *account.ServiceRepository) *Coupons {
+	return &Coupons{
+		db:       db,
+		coupons:  coupons,
+		invoices: invoices,
+		services: services,
+	}
+}
+
+// checkTx trava o cupom e confere se o cliente ainda pode usar
+func (c *Coupons) checkTx(ctx context.Context, tx *sql.Tx, code, ownerId string) (*Coupon, error) {
+	coupon, err := c.coupons.getByCodeTx(ctx, tx, normalizeCode(code))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := coupon.validAt(time.Now()); err != nil {
+		return nil, err
+	}
+
+	if coupon.MaxRedemptions != nil && coupon.Redemptions >= *coupon.MaxRedemptions {
+		return nil, ErrCouponExhausted
+	}
+
+	if coupon.MaxPerUser != nil {
+		used, err := c.coupons.countByOwnerTx(ctx, tx, coupon.Id, ownerId)
+		if err != nil {
+			return nil, err
+		}
+
+		if used >= *coupon.MaxPerUser {
+			return nil, ErrCouponUserLimit
+		}
+	}
+
+	return coupon, nil
+}
+
+// Check valida o código para os planos escolhidos sem usar o cupom, é o que
+// o checkout mostra antes do pedido
+func (c *Coupons) Check(ctx context.Context, code, ownerId string, planIds []string) (*Coupon, error) {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	coupon, err := c.checkTx(ctx, tx, code, ownerId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !slices.ContainsFunc(planIds, coupon.appliesTo) {
+		return nil, ErrCouponNotApplicable
+	}
+
+	return coupon, nil
+}
+
+// CreateInvoiceTx grava a fatura com os descontos. Os itens de serviço que
+// têm um cupom recorrente ganham o desconto dele e, com code, o cupom novo
+// entra nos itens que sobraram e fica registrado como usado. O cupom fixo
+// desconta o valor dele uma vez na fatura, dividido entre os itens
+func (c *Coupons) CreateInvoiceTx(ctx context.Context, tx *sql.Tx, inv *Invoice, code string) error {
+	var coupon *Coupon
+	if code != "" {
+		var err error
+		if coupon, err = c.checkTx(ctx, tx, code, inv.OwnerId); err != nil {
+			return err
+		}
+	}
+
+	// Primeiro descobre qual cupom entra em cada linha, o desconto fixo
+	// depende de todas as linhas do mesmo cupom
+	applied := make([]*Coupon, len(inv.Items))
+	lines := map[string][]int{}
+	order := []*Coupon{}
+	attach := []string{}
+
+	for i, item := range inv.Items {
+		if item.Kind != ItemService || item.ServiceId == "" {
+			continue
+		}
+
+		recurring, err := c.coupons.renewServiceTx(ctx, tx, item.ServiceId)
+		if err != nil {
+			return err
+		}
+
+		applied[i] = recurring
+		if applied[i] == nil && coupon != nil {
+			service, err := c.services.GetForUpdate(ctx, tx, inv.OwnerId, item.ServiceId)
+			if err != nil {
+				return err
+			}
+
+			if coupon.appliesTo(service.PlanId) {
+				applied[i] = coupon
+				attach = append(attach, service.Id)
+			}
+		}
+
+		if applied[i] == nil {
+			continue
+		}
+
+		if _, ok := lines[applied[i].Id]; !ok {
+			order = append(order, applied[i])
+		}
+		lines[applied[i].Id] = append(lines[applied[i].Id], i)
+	}
+
+	discounts := make([]money.Money, len(inv.Items))
+	for _, current := range order {
+		amounts := make([]money.Money, len(lines[current.Id]))
+		for j, i := range lines[current.Id] {
+			var err error
+			if amounts[j], err = lineAmount(inv.Items[i]); err != nil {
+				return err
+			}
+		}
+
+		values, err := current.discount(amounts)
+		if err != nil {
+			return err
+		}
+
+		for j, i := range lines[current.Id] {
+			discounts[i] = values[j]
+		}
+	}
+
+	items := make([]InvoiceItem, 0, len(inv.Items))
+	var used money.Money
+
+	for i, item := range inv.Items {
+		items = append(items, item)
+
+		if applied[i] == nil || !discounts[i].IsPositive() {
+			continue
+		}
+
+		if applied[i] == coupon {
+			var err error
+			if used, err = used.Add(discounts[i]); err != nil {
+				return err
+			}
+		}
+
+		items = append(items, couponItem(applied[i], item, discounts[i]))
+	}
+
+	if coupon != nil && len(attach) == 0 {
+		return ErrCouponNotApplicable
+	}
+
+	inv.Items = items
+
+	if err := c.invoices.CreateTx(ctx, tx, inv); err != nil {
+		return err
+	}
+
+	if coupon == nil {
+		return nil
+	}
+
+	return c.coupons.redeemTx(ctx, tx, coupon, inv, used, attach)
+}
+
+func lineAmount(item InvoiceItem) (money.Money, error) {
+	return item.UnitPrice.Multiply(int64(max(item.Quantity, 1)))
+}
+
+func couponItem(coupon *Coupon, item InvoiceItem, discount money.Money) InvoiceItem {
+	return InvoiceItem{
+		ServiceId:   item.ServiceId,
+		CouponId:    coupon.Id,
+		Kind:        ItemDiscount,
+		Description: "Cupom " + coupon.Code + " (" + item.Description + ")",
+		Quantity:    1,
+		UnitPrice:   discount,
+		PeriodStart: item.PeriodStart,
+		PeriodEnd:   item.PeriodEnd,
+	}
+}
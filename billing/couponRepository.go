@@ -0,0 +1,440 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"prodata/money"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type CouponRepository struct {
+	db *sql.DB
+}
+
+func NewCouponRepository(db *sql.DB) *CouponRepository {
+	return &CouponRepository{db: db}
+}
+
+const couponColumns = "id, code, description, kind, percent, amount, duration, cycles, max_redemptions, max_per_user, starts_at, ends_at, active, redemptions, created_at, updated_at"
+
+func scanCoupon(row interface{ Scan(dest ...any) error }) (*Coupon, error) {
+	var c Coupon
+	var cycles, maxRedemptions, maxPerUser sql.NullInt64
+	var startsAt, endsAt sql.NullString
+
+	err := row.Scan(
+		&c.Id,
+		&c.Code,
+		&c.Description,
+		&c.Kind,
+		&c.Percent,
+		&c.Amount,
+		&c.Duration,
+		&cycles,
+		&maxRedemptions,
+		&maxPerUser,
+		&startsAt,
+		&endsAt,
+		&c.Active,
+		&c.Redemptions,
+		&c.CreatedAt,
+		&c.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Cycles = nullableInt(cycles)
+	c.MaxRedemptions = nullableInt(maxRedemptions)
+	c.MaxPerUser = nullableInt(maxPerUser)
+	c.StartsAt = startsAt.String
+	c.EndsAt = endsAt.String
+	c.PlanIds = []string{}
+
+	return &c, nil
+}
+
+func nullableInt(value sql.NullInt64) *int {
+	if !value.Valid {
+		return nil
+	}
+
+	n := int(value.Int64)
+	return &n
+}
+
+func intOrNil(value *int) any {
+	if value == nil {
+		return nil
+	}
+
+	return *value
+}
+
+func (r *CouponRepository) loadPlans(ctx context.Context, q querier, c *Coupon) error {
+	rows, err := q.QueryContext(ctx, "SELECT plan_id FROM coupon_plans WHERE coupon_id = ? ORDER BY plan_id", c.Id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var planId string
+		if err := rows.Scan(&planId); err != nil {
+			return err
+		}
+		c.PlanIds = append(c.PlanIds, planId)
+	}
+
+	return rows.Err()
+}
+
+func (r *CouponRepository) savePlans(ctx context.Context, tx *sql.Tx, c *Coupon) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM coupon_plans WHERE coupon_id = ?", c.Id); err != nil {
+		return err
+	}
+
+	for _, planId := range c.PlanIds {
+		if _, err := tx.ExecContext(ctx, "INSERT IGNORE INTO coupon_plans (coupon_id, plan_id) VALUES (?, ?)", c.Id, planId); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *CouponRepository) Create(ctx context.Context, c *Coupon) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	c.Id = uuid.New().String()
+	c.CreatedAt = time.Now().Format(time.DateTime)
+	c.UpdatedAt = c.CreatedAt
+	c.Redemptions = 0
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO coupons ("+couponColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		c.Id,
+		c.Code,
+		c.Description,
+		c.Kind,
+		c.Percent,
+		c.Amount,
+		c.Duration,
+		intOrNil(c.Cycles),
+		intOrNil(c.MaxRedemptions),
+		intOrNil(c.MaxPerUser),
+		nullable(c.StartsAt),
+		nullable(c.EndsAt),
+		c.Active,
+		c.Redemptions,
+		c.CreatedAt,
+		c.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	if err := r.savePlans(ctx, tx, c); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Update troca as regras do cupom. Os usos já feitos e os descontos
+// recorrentes em andamento não mudam
+func (r *CouponRepository) Update(ctx context.Context, c *Coupon) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	current, err := r.getTx(ctx, tx, "id", c.Id)
+	if err != nil {
+		return err
+	}
+
+	c.Redemptions = current.Redemptions
+	c.CreatedAt = current.CreatedAt
+	c.UpdatedAt = time.Now().Format(time.DateTime)
+
+	_, err = tx.ExecContext(ctx, "UPDATE coupons SET code = ?, description = ?, kind = ?, percent = ?, amount = ?, duration = ?, cycles = ?, max_redemptions = ?, max_per_user = ?, starts_at = ?, ends_at = ?, active = ?, updated_at = ? WHERE id = ?",
+		c.Code,
+		c.Description,
+		c.Kind,
+		c.Percent,
+		c.Amount,
+		c.Duration,
+		intOrNil(c.Cycles),
+		intOrNil(c.MaxRedemptions),
+		intOrNil(c.MaxPerUser),
+		nullable(c.StartsAt),
+		nullable(c.EndsAt),
+		c.Active,
+		c.UpdatedAt,
+		c.Id)
+	if err != nil {
+		return err
+	}
+
+	if err := r.savePlans(ctx, tx, c); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *CouponRepository) Get(ctx context.Context, id string) (*Coupon, error) {
+	c, err := scanCoupon(r.db.QueryRowContext(ctx, "SELECT "+couponColumns+" FROM coupons WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCouponNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return c, r.loadPlans(ctx, r.db, c)
+}
+
+// getTx trava o cupom pela coluna pedida, id ou code
+func (r *CouponRepository) getTx(ctx context.Context, tx *sql.Tx, column, value string) (*Coupon, error) {
+	c, err := scanCoupon(tx.QueryRowContext(ctx, "SELECT "+couponColumns+" FROM coupons WHERE "+column+" = ? FOR UPDATE", value))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCouponNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return c, r.loadPlans(ctx, tx, c)
+}
+
+func (r *CouponRepository) getByCodeTx(ctx context.Context, tx *sql.Tx, code string) (*Coupon, error) {
+	return r.getTx(ctx, tx, "code", code)
+}
+
+type CouponFilter struct {
+	Active *bool
+	Limit  int
+	Offset int
+}
+
+func (r *CouponRepository) List(ctx context.Context, filter CouponFilter) ([]Coupon, int, error) {
+	where := []string{"1 = 1"}
+	args := []any{}
+
+	if filter.Active != nil {
+		where = append(where, "active = ?")
+		args = append(args, *filter.Active)
+	}
+
+	clause := strings.Join(where, " AND ")
+
+	var total int
+	err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM coupons WHERE "+clause, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := "SELECT " + couponColumns + " FROM coupons WHERE " + clause + " ORDER BY created_at DESC, id"
+	if filter.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, filter.Limit, filter.Offset)
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	coupons := []Coupon{}
+	for rows.Next() {
+		c, err := scanCoupon(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		coupons = append(coupons, *c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	for i := range coupons {
+		if err := r.loadPlans(ctx, r.db, &coupons[i]); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return coupons, total, nil
+}
+
+func (r *CouponRepository) countByOwnerTx(ctx context.Context, tx *sql.Tx, couponId, ownerId string) (int, error) {
+	var count int
+	err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM coupon_redemptions WHERE coupon_id = ? AND owner_uuid = ?", couponId, ownerId).Scan(&count)
+	return count, err
+}
+
+// redeemTx registra o uso do cupom na fatura e, se ele for recorrente, deixa
+// o desconto preso aos serviços para as renovações
+func (r *CouponRepository) redeemTx(ctx context.Context, tx *sql.Tx, c *Coupon, inv *Invoice, amount money.Money, serviceIds []string) error {
+	now := time.Now().Format(time.DateTime)
+
+	_, err := tx.ExecContext(ctx, "INSERT INTO coupon_redemptions (coupon_id, owner_uuid, invoice_id, amount, created_at) VALUES (?, ?, ?, ?, ?)",
+		c.Id, inv.OwnerId, inv.Id, amount, now)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE coupons SET redemptions = redemptions + 1 WHERE id = ?", c.Id)
+	if err != nil {
+		return err
+	}
+
+	if c.Duration != CouponRecurring {
+		return nil
+	}
+
+	// remaining conta as renovações que ainda ganham desconto, NULL é para sempre
+	var remaining any
+	if c.Cycles != nil {
+		remaining = *c.Cycles - 1
+	}
+
+	for _, serviceId := range serviceIds {
+		_, err := tx.ExecContext(ctx, "REPLACE INTO service_coupons (service_id, coupon_id, remaining, created_at) VALUES (?, ?, ?, ?)",
+			serviceId, c.Id, remaining, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// releaseTx desfaz o uso do cupom na fatura anulada antes de ser paga, o
+// cliente pode usar de novo
+func (r *CouponRepository) releaseTx(ctx context.Context, tx *sql.Tx, invoiceId string, serviceIds []string) error {
+	_, err := tx.ExecContext(ctx, "UPDATE coupons c JOIN coupon_redemptions cr ON cr.coupon_id = c.id SET c.redemptions = c.redemptions - 1 WHERE cr.invoice_id = ?", invoiceId)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM coupon_redemptions WHERE invoice_id = ?", invoiceId); err != nil {
+		return err
+	}
+
+	for _, serviceId := range serviceIds {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM service_coupons WHERE service_id = ?", serviceId); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// renewServiceTx devolve o cupom recorrente do serviço para a fatura de
+// renovação e gasta um ciclo dele, anular a fatura devolve o ciclo
+func (r *CouponRepository) renewServiceTx(ctx context.Context, tx *sql.Tx, serviceId string) (*Coupon, error) {
+	var couponId string
+	var remaining sql.NullInt64
+
+	err := tx.QueryRowContext(ctx, "SELECT coupon_id, remaining FROM service_coupons WHERE service_id = ? FOR UPDATE", serviceId).Scan(&couponId, &remaining)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := scanCoupon(tx.QueryRowContext(ctx, "SELECT "+couponColumns+" FROM coupons WHERE id = ?", couponId))
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case !remaining.Valid:
+	case remaining.Int64 <= 1:
+		_, err = tx.ExecContext(ctx, "DELETE FROM service_coupons WHERE service_id = ?", serviceId)
+	default:
+		_, err = tx.ExecContext(ctx, "UPDATE service_coupons SET remaining = remaining - 1 WHERE service_id = ?", serviceId)
+	}
+
+	return c, err
+}
+
+// restoreServiceCouponsTx devolve aos serviços os ciclos do cupom recorrente
+// que a fatura anulada gastou na renovação. O cupom resgatado no pedido fica
+// com o releaseTx
+func restoreServiceCouponsTx(ctx context.Context, tx *sql.Tx, invoiceId string) error {
+	rows, err := tx.QueryContext(ctx, `SELECT ii.service_id, ii.coupon_id FROM invoice_items ii
+LEFT JOIN coupon_redemptions cr ON cr.invoice_id = ii.invoice_id AND cr.coupon_id = ii.coupon_id
+WHERE ii.invoice_id = ? AND ii.kind = ? AND ii.service_id IS NOT NULL AND ii.coupon_id IS NOT NULL AND cr.id IS NULL`, invoiceId, ItemDiscount)
+	if err != nil {
+		return err
+	}
+
+	type renewal struct{ serviceId, couponId string }
+	var renewals []renewal
+	for rows.Next() {
+		var r renewal
+		if err := rows.Scan(&r.serviceId, &r.couponId); err != nil {
+			rows.Close()
+			return err
+		}
+		renewals = append(renewals, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now().Format(time.DateTime)
+	for _, r := range renewals {
+		var couponId string
+		var remaining sql.NullInt64
+
+		err := tx.QueryRowContext(ctx, "SELECT coupon_id, remaining FROM service_coupons WHERE service_id = ? FOR UPDATE", r.serviceId).Scan(&couponId, &remaining)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			// A renovação gastou o último ciclo e apagou a linha
+			_, err = tx.ExecContext(ctx, "INSERT INTO service_coupons (service_id, coupon_id, remaining, created_at) VALUES (?, ?, 1, ?)", r.serviceId, r.couponId, now)
+		case err != nil:
+		case couponId != r.couponId || !remaining.Valid:
+		default:
+			_, err = tx.ExecContext(ctx, "UPDATE service_coupons SET remaining = remaining + 1 WHERE service_id = ?", r.serviceId)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *CouponRepository) Redemptions(ctx context.Context, couponId string, limit, offset int) ([]CouponRedemption, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id, coupon_id, owner_uuid, invoice_id, amount, created_at FROM coupon_redemptions WHERE coupon_id = ? ORDER BY id DESC LIMIT ? OFFSET ?",
+		couponId, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	redemptions := []CouponRedemption{}
+	for rows.Next() {
+		var redemption CouponRedemption
+		if err := rows.Scan(&redemption.Id, &redemption.CouponId, &redemption.OwnerId, &redemption.InvoiceId, &redemption.Amount, &redemption.CreatedAt); err != nil {
+			return nil, err
+		}
+		redemptions = append(redemptions, redemption)
+	}
+
+	return redemptions, rows.Err()
+}
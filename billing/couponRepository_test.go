@@ -0,0 +1,101 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"prodata/database/account"
+	"prodata/money"
+	"testing"
+	"time"
+)
+
+// serviceCoupon lê os ciclos que sobram do cupom recorrente do serviço, -1
+// é para sempre e -2 é sem cupom
+func serviceCoupon(t *testing.T, db *sql.DB, serviceId string) int64 {
+	t.Helper()
+
+	var remaining sql.NullInt64
+	err := db.QueryRow("SELECT remaining FROM service_coupons WHERE service_id = ?", serviceId).Scan(&remaining)
+	if errors.Is(err, sql.ErrNoRows) {
+		return -2
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !remaining.Valid {
+		return -1
+	}
+
+	return remaining.Int64
+}
+
+func TestVoidRenewalRestoresServiceCoupon(t *testing.T) {
+	b := newTestBilling(t)
+	ctx := context.Background()
+
+	repository := NewCouponRepository(b.db)
+	coupons := NewCoupons(b.db, repository, b.invoices, b.services)
+
+	cycles := 3
+	coupon := &Coupon{Code: "RENOVA10", Kind: CouponPercent, Percent: 10, Amount: money.New(0, money.BRL), Duration: CouponRecurring, Cycles: &cycles, Active: true}
+	if err := repository.Create(ctx, coupon); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		remaining any
+		renewed   int64
+		voided    int64
+	}{
+		{"sobram dois", 2, 1, 2},
+		{"último ciclo", 1, -2, 1},
+		{"para sempre", nil, -1, -1},
+	}
+
+	for _, tt := range tests {
+		service, _ := b.order(t, money.MustParse("50.00"))
+
+		_, err := b.db.ExecContext(ctx, "INSERT INTO service_coupons (service_id, coupon_id, remaining, created_at) VALUES (?, ?, ?, ?)",
+			service.Id, coupon.Id, tt.remaining, time.Now().Format(time.DateTime))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		inv, err := serviceInvoice(service.OwnerId, []account.Services{*service}, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tx, err := b.db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := coupons.CreateInvoiceTx(ctx, tx, inv, ""); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		if inv.Discount.Compare(money.MustParse("5.00")) != 0 {
+			t.Fatalf("%s: desconto = %s, want 5.00", tt.name, inv.Discount)
+		}
+
+		if got := serviceCoupon(t, b.db, service.Id); got != tt.renewed {
+			t.Errorf("%s: ciclos depois da renovação = %d, want %d", tt.name, got, tt.renewed)
+		}
+
+		if _, err := b.invoices.Void(ctx, inv.Id, "teste"); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := serviceCoupon(t, b.db, service.Id); got != tt.voided {
+			t.Errorf("%s: ciclos depois de anular = %d, want %d", tt.name, got, tt.voided)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package billing
+
+import (
+	"prodata/money"
+	"testing"
+)
+
+func TestCouponDiscount(t *testing.T) {
+	tests := []struct {
+		name    string
+		coupon  Coupon
+		amounts []int64
+		want    []int64
+	}{
+		{"percentual em cada linha", Coupon{Kind: CouponPercent, Percent: 10}, []int64{10000, 5000}, []int64{1000, 500}},
+		{"fixo uma vez na fatura", Coupon{Kind: CouponFixed, Amount: money.FromCents(3000)}, []int64{10000, 5000}, []int64{2000, 1000}},
+		{"fixo maior que a fatura", Coupon{Kind: CouponFixed, Amount: money.FromCents(50000)}, []int64{10000, 5000}, []int64{10000, 5000}},
+		{"fixo com resto", Coupon{Kind: CouponFixed, Amount: money.FromCents(1000)}, []int64{100, 100, 100}, []int64{100, 100, 100}},
+		{"fixo dividido com centavo sobrando", Coupon{Kind: CouponFixed, Amount: money.FromCents(100)}, []int64{1000, 1000, 1000}, []int64{34, 33, 33}},
+		{"linha grátis", Coupon{Kind: CouponFixed, Amount: money.FromCents(500)}, []int64{0, 2000}, []int64{0, 500}},
+		{"tudo grátis", Coupon{Kind: CouponFixed, Amount: money.FromCents(500)}, []int64{0}, []int64{0}},
+	}
+
+	for _, tt := range tests {
+		amounts := make([]money.Money, len(tt.amounts))
+		for i, cents := range tt.amounts {
+			amounts[i] = money.FromCents(cents)
+		}
+
+		got, err := tt.coupon.discount(amounts)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		for i, discount := range got {
+			if discount.Cents() != tt.want[i] {
+				t.Errorf("%s: discount[%d] = %d, want %d", tt.name, i, discount.Cents(), tt.want[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"prodata/database/account"
+	"prodata/finances"
+	"prodata/logs"
+	"prodata/money"
+	"strconv"
+)
+
+type DisputeStatus string
+
+const (
+	DisputeOpen DisputeStatus = "open"
+	DisputeWon  DisputeStatus = "won"
+	DisputeLost DisputeStatus = "lost"
+)
+
+var (
+	ErrDisputeNotFound       = errors.New("dispute not found")
+	ErrDisputeResolved       = errors.New("dispute already has an outcome")
+	ErrInvalidDisputeOutcome = errors.New("outcome must be won or lost")
+)
+
+// Dispute é a contestação de um pagamento aprovado, aberta quando o gateway
+// avisa o chargeback
+type Dispute struct {
+	Id                  string        `json:"id"`
+	PaymentId           string        `json:"payment_id"`
+	InvoiceId           string        `json:"invoice_id"`
+	OwnerId             string        `json:"owner_id"`
+	Provider            string        `json:"provider"`
+	Amount              money.Money   `json:"amount"`
+	Status              DisputeStatus `json:"status"`
+	ProviderDetail      string        `json:"provider_detail,omitempty"`
+	OutcomeNote         string        `json:"outcome_note,omitempty"`
+	ResolvedBy          string        `json:"resolved_by,omitempty"`
+	ServicesSuspendedAt string        `json:"services_suspended_at,omitempty"`
+	ResolvedAt          string        `json:"resolved_at,omitempty"`
+	CreatedAt           string        `json:"created_at"`
+	UpdatedAt           string        `json:"updated_at"`
+}
+
+type DisputeEvidence struct {
+	Id        int64  `json:"id"`
+	DisputeId string `json:"dispute_id"`
+	Note      string `json:"note"`
+	Author    string `json:"author"`
+	CreatedAt string `json:"created_at"`
+}
+
+type DisputeConfig struct {
+	// A partir de quantas contestações a conta do cliente é marcada
+	FlagAfter int
+}
+
+func DisputeConfigFromEnv() DisputeConfig {
+	flagAfter, err := strconv.Atoi(os.Getenv("DISPUTE_FLAG_AFTER"))
+	if err != nil || flagAfter <= 0 {
+		flagAfter = 2
+	}
+
+	return DisputeConfig{FlagAfter: flagAfter}
+}
+
+// Disputes suspende os serviços da fatura contestada, marca a conta de quem
+// contesta demais e aplica o resultado que o admin informar
+type Disputes struct {
+	db        *sql.DB
+	disputes  *DisputeRepository
+	invoices  *InvoiceRepository
+	dunning   *DunningRepository
+	lifecycle *account.ServiceLifecycle
+	users     *account.UserRepository
+	config    DisputeConfig
+}
+
+func NewDisputes(db *sql.DB, disputes *DisputeRepository, invoices *InvoiceRepository, dunning *DunningRepository, lifecycle *account.ServiceLifecycle, users *account.UserRepository, config DisputeConfig) *Disputes {
+	return &Disputes{
+		db:        db,
+		disputes:  disputes,
+		invoices:  invoices,
+		dunning:   dunning,
+		lifecycle: lifecycle,
+		users:     users,
+		config:    config,
+	}
+}
+
+// enforce roda depois que a conciliação abriu a contestação. Só age uma vez
+// por contestação, mas pode ser chamado de novo se caiu no meio
+func (d *Disputes) enforce(ctx context.Context, paymentId string) error {
+	dispute, err := d.disputes.GetByPayment(ctx, paymentId)
+	if errors.Is(err, ErrDisputeNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if dispute.Status != DisputeOpen || dispute.ServicesSuspendedAt != "" {
+		return nil
+	}
+
+	inv, err := d.invoices.Get(ctx, dispute.InvoiceId)
+	if err != nil {
+		return err
+	}
+
+	reason := "Contestação do pagamento da fatura " + inv.Number
+	suspended := []string{}
+
+	for _, item := range inv.Items {
+		if item.ServiceId == "" || item.Kind != ItemService {
+			continue
+		}
+
+		service, err := d.lifecycle.Suspend(ctx, item.ServiceId, reason)
+		switch {
+		case err == nil:
+			suspended = append(suspended, service.Id)
+		case errors.Is(err, account.ErrInvalidTransition):
+			// Já suspenso por outro motivo, a contestação também segura ele
+			var transition *account.TransitionError
+			if errors.As(err, &transition) && transition.From == account.StatusSuspended {
+				suspended = append(suspended, item.ServiceId)
+			}
+		case errors.Is(err, account.ErrServiceNotFound):
+		default:
+			return err
+		}
+	}
+
+	count, err := d.disputes.CountByOwner(ctx, dispute.OwnerId)
+	if err != nil {
+		return err
+	}
+
+	if count >= d.config.FlagAfter {
+		if err := d.users.SetDisputeFlag(ctx, dispute.OwnerId, true); err != nil {
+			return err
+		}
+	}
+
+	return d.disputes.markSuspended(ctx, dispute.Id, suspended)
+}
+
+// Resolve grava o resultado. Ganha, os serviços que a contestação segurava
+// voltam, a não ser que outra contestação ou a cobrança ainda segure algum.
+// Perdida, eles continuam suspensos
+func (d *Disputes) Resolve(ctx context.Context, id string, outcome DisputeStatus, note, actor string) (*Dispute, error) {
+	if outcome != DisputeWon && outcome != DisputeLost {
+		return nil, ErrInvalidDisputeOutcome
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	dispute, err := d.disputes.getTx(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if dispute.Status != DisputeOpen {
+		return nil, ErrDisputeResolved
+	}
+
+	dispute.Status = outcome
+	dispute.OutcomeNote = note
+	dispute.ResolvedBy = actor
+
+	if err := d.disputes.resolveTx(ctx, tx, dispute); err != nil {
+		return nil, err
+	}
+
+	// Ganha, o gateway devolve o valor que tinha tirado
+	if outcome == DisputeWon {
+		_, err := finances.PostTx(ctx, tx, finances.ChargebackReversed(dispute.Id, dispute.OwnerId, "Contestação "+dispute.Id+" ganha", dispute.Amount))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	services, err := d.disputes.servicesTx(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	if outcome == DisputeWon {
+		d.release(ctx, dispute, services)
+	}
+
+	return dispute, nil
+}
+
+// release reativa os serviços da contestação ganha. O resultado já foi
+// gravado, uma falha aqui só fica no log para um admin resolver
+func (d *Disputes) release(ctx context.Context, dispute *Dispute, services []string) {
+	for _, serviceId := range services {
+		held, err := d.disputes.holdsService(ctx, serviceId)
+		if err == nil && !held {
+			var suspended, stillOwed bool
+			suspended, stillOwed, err = d.dunning.suspendedByDunning(ctx, serviceId)
+			held = suspended && stillOwed
+		}
+
+		if err == nil && !held {
+			_, err = d.lifecycle.Unsuspend(ctx, serviceId, "Contestação "+dispute.Id+" ganha")
+		}
+
+		if err != nil && !errors.Is(err, account.ErrInvalidTransition) && !errors.Is(err, account.ErrServiceNotFound) {
+			logs.NewSistemLogger().LogAndSendSystemMessage("dispute " + dispute.Id + " unsuspend " + serviceId + ": " + err.Error())
+		}
+	}
+}
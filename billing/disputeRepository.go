@@ -0,0 +1,253 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"prodata/database"
+	"prodata/money"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type DisputeRepository struct {
+	db *sql.DB
+}
+
+func NewDisputeRepository(db *sql.DB) *DisputeRepository {
+	return &DisputeRepository{db: db}
+}
+
+type DisputeFilter struct {
+	OwnerId string
+	Status  DisputeStatus
+	Limit   int
+	Offset  int
+}
+
+const disputeColumns = "id, payment_id, invoice_id, owner_uuid, provider, amount, status, provider_detail, outcome_note, resolved_by, services_suspended_at, resolved_at, created_at, updated_at"
+
+func scanDispute(row interface{ Scan(dest ...any) error }) (*Dispute, error) {
+	var d Dispute
+	var suspendedAt, resolvedAt sql.NullString
+
+	err := row.Scan(
+		&d.Id,
+		&d.PaymentId,
+		&d.InvoiceId,
+		&d.OwnerId,
+		&d.Provider,
+		&d.Amount,
+		&d.Status,
+		&d.ProviderDetail,
+		&d.OutcomeNote,
+		&d.ResolvedBy,
+		&suspendedAt,
+		&resolvedAt,
+		&d.CreatedAt,
+		&d.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	d.ServicesSuspendedAt = suspendedAt.String
+	d.ResolvedAt = resolvedAt.String
+
+	return &d, nil
+}
+
+// openTx abre a contestação do pagamento no valor que o gateway tirou. O
+// INSERT IGNORE com a chave no payment_id faz o mesmo chargeback avisado de
+// novo não abrir outra
+func (r *DisputeRepository) openTx(ctx context.Context, tx *sql.Tx, p *Payment, amount money.Money, detail string) error {
+	now := time.Now().Format(time.DateTime)
+
+	_, err := tx.ExecContext(ctx, "INSERT IGNORE INTO disputes (id, payment_id, invoice_id, owner_uuid, provider, amount, status, provider_detail, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		uuid.New().String(),
+		p.Id,
+		p.InvoiceId,
+		p.OwnerId,
+		p.Provider,
+		amount,
+		DisputeOpen,
+		database.Truncate(detail, 64),
+		now,
+		now)
+	return err
+}
+
+func (r *DisputeRepository) Get(ctx context.Context, id string) (*Dispute, error) {
+	d, err := scanDispute(r.db.QueryRowContext(ctx, "SELECT "+disputeColumns+" FROM disputes WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrDisputeNotFound
+	}
+
+	return d, err
+}
+
+func (r *DisputeRepository) GetByPayment(ctx context.Context, paymentId string) (*Dispute, error) {
+	d, err := scanDispute(r.db.QueryRowContext(ctx, "SELECT "+disputeColumns+" FROM disputes WHERE payment_id = ?", paymentId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrDisputeNotFound
+	}
+
+	return d, err
+}
+
+func (r *DisputeRepository) getTx(ctx context.Context, tx *sql.Tx, id string) (*Dispute, error) {
+	d, err := scanDispute(tx.QueryRowContext(ctx, "SELECT "+disputeColumns+" FROM disputes WHERE id = ? FOR UPDATE", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrDisputeNotFound
+	}
+
+	return d, err
+}
+
+func (r *DisputeRepository) List(ctx context.Context, filter DisputeFilter) ([]Dispute, int, error) {
+	where := []string{"1 = 1"}
+	args := []any{}
+
+	if filter.OwnerId != "" {
+		where = append(where, "owner_uuid = ?")
+		args = append(args, filter.OwnerId)
+	}
+
+	if filter.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, filter.Status)
+	}
+
+	clause := strings.Join(where, " AND ")
+
+	var total int
+	err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM disputes WHERE "+clause, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := "SELECT " + disputeColumns + " FROM disputes WHERE " + clause + " ORDER BY created_at DESC, id"
+	if filter.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, filter.Limit, filter.Offset)
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	disputes := []Dispute{}
+	for rows.Next() {
+		d, err := scanDispute(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		disputes = append(disputes, *d)
+	}
+
+	return disputes, total, rows.Err()
+}
+
+// CountByOwner conta todas as contestações do cliente, ganhas ou não
+func (r *DisputeRepository) CountByOwner(ctx context.Context, ownerId string) (int, error) {
+	var count int
+	err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM disputes WHERE owner_uuid = ?", ownerId).Scan(&count)
+	return count, err
+}
+
+// markSuspended guarda quais serviços a contestação suspendeu e fecha o passo
+func (r *DisputeRepository) markSuspended(ctx context.Context, id string, serviceIds []string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, serviceId := range serviceIds {
+		_, err := tx.ExecContext(ctx, "INSERT IGNORE INTO dispute_services (dispute_id, service_id) VALUES (?, ?)", id, serviceId)
+		if err != nil {
+			return err
+		}
+	}
+
+	now := time.Now().Format(time.DateTime)
+	_, err = tx.ExecContext(ctx, "UPDATE disputes SET services_suspended_at = ?, updated_at = ? WHERE id = ?", now, now, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *DisputeRepository) servicesTx(ctx context.Context, tx *sql.Tx, id string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT service_id FROM dispute_services WHERE dispute_id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var serviceId string
+		if err := rows.Scan(&serviceId); err != nil {
+			return nil, err
+		}
+		ids = append(ids, serviceId)
+	}
+
+	return ids, rows.Err()
+}
+
+// holdsService diz se alguma contestação em aberto está segurando o serviço
+// suspenso
+func (r *DisputeRepository) holdsService(ctx context.Context, serviceId string) (bool, error) {
+	var count int
+	err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM dispute_services s JOIN disputes d ON d.id = s.dispute_id WHERE s.service_id = ? AND d.status = ?",
+		serviceId, DisputeOpen).Scan(&count)
+	return count > 0, err
+}
+
+func (r *DisputeRepository) resolveTx(ctx context.Context, tx *sql.Tx, d *Dispute) error {
+	now := time.Now().Format(time.DateTime)
+	d.ResolvedAt = now
+	d.UpdatedAt = now
+
+	_, err := tx.ExecContext(ctx, "UPDATE disputes SET status = ?, outcome_note = ?, resolved_by = ?, resolved_at = ?, updated_at = ? WHERE id = ?",
+		d.Status, database.Truncate(d.OutcomeNote, 255), d.ResolvedBy, d.ResolvedAt, d.UpdatedAt, d.Id)
+	return err
+}
+
+func (r *DisputeRepository) AddEvidence(ctx context.Context, evidence *DisputeEvidence) error {
+	evidence.CreatedAt = time.Now().Format(time.DateTime)
+
+	result, err := r.db.ExecContext(ctx, "INSERT INTO dispute_evidence (dispute_id, note, author, created_at) VALUES (?, ?, ?, ?)",
+		evidence.DisputeId, evidence.Note, evidence.Author, evidence.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	evidence.Id, err = result.LastInsertId()
+	return err
+}
+
+func (r *DisputeRepository) Evidence(ctx context.Context, disputeId string) ([]DisputeEvidence, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id, dispute_id, note, author, created_at FROM dispute_evidence WHERE dispute_id = ? ORDER BY created_at, id", disputeId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	evidence := []DisputeEvidence{}
+	for rows.Next() {
+		var e DisputeEvidence
+		if err := rows.Scan(&e.Id, &e.DisputeId, &e.Note, &e.Author, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		evidence = append(evidence, e)
+	}
+
+	return evidence, rows.Err()
+}
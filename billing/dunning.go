@@ -0,0 +1,423 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"prodata/database/account"
+	"prodata/emailHandler"
+	"prodata/finances"
+	"prodata/logs"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type DunningActionKind string
+
+const (
+	DunningOverdue         DunningActionKind = "overdue"
+	DunningReminder        DunningActionKind = "reminder"
+	DunningSuspend         DunningActionKind = "suspend"
+	DunningTerminate       DunningActionKind = "terminate"
+	DunningUnsuspend       DunningActionKind = "unsuspend"
+	DunningClosed          DunningActionKind = "closed"
+	DunningOverrideSet     DunningActionKind = "override_set"
+	DunningOverrideRemoved DunningActionKind = "override_removed"
+)
+
+// Ações automáticas ficam na auditoria com esse ator, as de admin com o id
+// do admin
+const SystemActor = "system"
+
+var (
+	ErrDunningOverrideNotFound = errors.New("dunning override not found")
+	ErrInvalidDunningOverride  = errors.New("terminate_after must be greater than suspend_after")
+)
+
+// DunningPolicy diz, em dias depois do vencimento, quando lembrar, suspender
+// e encerrar os serviços de uma fatura vencida
+type DunningPolicy struct {
+	ReminderDays   []int `json:"reminder_days"`
+	SuspendAfter   int   `json:"suspend_after"`
+	TerminateAfter int   `json:"terminate_after"`
+}
+
+type DunningConfig struct {
+	Policy   DunningPolicy
+	Interval time.Duration
+}
+
+func DunningConfigFromEnv() DunningConfig {
+	policy := DunningPolicy{
+		ReminderDays:   []int{1, 3, 7},
+		SuspendAfter:   10,
+		TerminateAfter: 30,
+	}
+
+	if value := os.Getenv("DUNNING_REMINDER_DAYS"); value != "" {
+		days := []int{}
+		for _, part := range strings.Split(value, ",") {
+			day, err := strconv.Atoi(strings.TrimSpace(part))
+			if err == nil && day >= 0 {
+				days = append(days, day)
+			}
+		}
+		slices.Sort(days)
+		policy.ReminderDays = days
+	}
+
+	if days, err := strconv.Atoi(os.Getenv("DUNNING_SUSPEND_DAYS")); err == nil && days > 0 {
+		policy.SuspendAfter = days
+	}
+
+	// O encerramento tem que vir depois da suspensão. Sem um valor válido ele
+	// mantém a distância da política padrão
+	if days, err := strconv.Atoi(os.Getenv("DUNNING_TERMINATE_DAYS")); err == nil && days > policy.SuspendAfter {
+		policy.TerminateAfter = days
+	} else if policy.TerminateAfter <= policy.SuspendAfter {
+		policy.TerminateAfter = policy.SuspendAfter + 20
+	}
+
+	interval, err := time.ParseDuration(os.Getenv("DUNNING_INTERVAL"))
+	if err != nil || interval <= 0 {
+		interval = time.Hour
+	}
+
+	return DunningConfig{Policy: policy, Interval: interval}
+}
+
+// DunningOverride troca a política de um cliente. Paused para tudo, os
+// prazos nil usam a política padrão e, passado ExpiresAt, a regra deixa de
+// valer sozinha
+type DunningOverride struct {
+	OwnerId        string `json:"owner_id"`
+	Paused         bool   `json:"paused"`
+	SuspendAfter   *int   `json:"suspend_after,omitempty"`
+	TerminateAfter *int   `json:"terminate_after,omitempty"`
+	ExpiresAt      string `json:"expires_at,omitempty"`
+	Reason         string `json:"reason"`
+	UpdatedBy      string `json:"updated_by"`
+	UpdatedAt      string `json:"updated_at"`
+}
+
+func (o *DunningOverride) activeAt(now time.Time) bool {
+	if o.ExpiresAt == "" {
+		return true
+	}
+
+	expiresAt, err := time.ParseInLocation(time.DateTime, o.ExpiresAt, time.Local)
+	return err != nil || expiresAt.After(now)
+}
+
+// DunningEntry é uma linha da auditoria da cobrança
+type DunningEntry struct {
+	Id        int64             `json:"id"`
+	OwnerId   string            `json:"-"`
+	InvoiceId string            `json:"invoice_id,omitempty"`
+	Action    DunningActionKind `json:"action"`
+	Target    string            `json:"target,omitempty"`
+	Detail    string            `json:"detail,omitempty"`
+	Actor     string            `json:"actor"`
+	CreatedAt string            `json:"created_at"`
+}
+
+// Dunning cobra as faturas vencidas: marca como overdue, manda os lembretes
+// e suspende e encerra os serviços nos prazos da política. A reativação
+// quando o pagamento chega fica na conciliação
+type Dunning struct {
+	db        *sql.DB
+	invoices  *InvoiceRepository
+	dunning   *DunningRepository
+	services  *account.ServiceRepository
+	lifecycle *account.ServiceLifecycle
+	users     *account.UserRepository
+	clock     finances.Clock
+	config    DunningConfig
+}
+
+func NewDunning(db *sql.DB, invoices *InvoiceRepository, dunning *DunningRepository, services *account.ServiceRepository, lifecycle *account.ServiceLifecycle, users *account.UserRepository, clock finances.Clock, config DunningConfig) *Dunning {
+	if clock == nil {
+		clock = finances.SystemClock
+	}
+
+	return &Dunning{
+		db:        db,
+		invoices:  invoices,
+		dunning:   dunning,
+		services:  services,
+		lifecycle: lifecycle,
+		users:     users,
+		clock:     clock,
+		config:    config,
+	}
+}
+
+// Run marca as faturas vencidas a cada Interval e leva cada uma pelos passos
+// da cobrança: avisos, suspensão e encerramento dos serviços
+func (d *Dunning) Run(ctx context.Context) {
+	every(ctx, d.config.Interval, "dunning", d.RunOnce)
+}
+
+func (d *Dunning) RunOnce(ctx context.Context) error {
+	now := d.clock.Now()
+
+	if err := d.markOverdue(ctx, now); err != nil {
+		return err
+	}
+
+	ids, err := d.dunning.pendingInvoices(ctx, 200)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		inv, err := d.invoices.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		if err := d.process(ctx, inv, now); err != nil {
+			// Uma fatura com problema não pode travar a cobrança das outras
+			logs.NewSistemLogger().LogAndSendSystemMessage("dunning invoice " + inv.Id + ": " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+func (d *Dunning) markOverdue(ctx context.Context, now time.Time) error {
+	rows, err := d.db.QueryContext(ctx, "SELECT id, owner_uuid FROM invoices WHERE status = ? AND due_date < ?", InvoiceOpen, now.Format(time.DateTime))
+	if err != nil {
+		return err
+	}
+
+	type overdue struct{ id, owner string }
+	found := []overdue{}
+	for rows.Next() {
+		var o overdue
+		if err := rows.Scan(&o.id, &o.owner); err != nil {
+			rows.Close()
+			return err
+		}
+		found = append(found, o)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, o := range found {
+		err := d.markInvoiceOverdue(ctx, o.id, o.owner)
+		if err != nil && !errors.Is(err, ErrInvoiceNotEditable) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *Dunning) markInvoiceOverdue(ctx context.Context, id, ownerId string) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := d.invoices.changeStatus(ctx, tx, id, InvoiceOverdue); err != nil {
+		return err
+	}
+
+	_, err = recordAction(ctx, tx, &DunningEntry{
+		OwnerId:   ownerId,
+		InvoiceId: id,
+		Action:    DunningOverdue,
+		Actor:     SystemActor,
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// policyFor aplica a regra do cliente por cima da política padrão. Com
+// paused a cobrança não faz nada com as faturas dele
+func (d *Dunning) policyFor(ctx context.Context, ownerId string, now time.Time) (DunningPolicy, bool, error) {
+	policy := d.config.Policy
+
+	override, err := d.dunning.Override(ctx, ownerId)
+	if errors.Is(err, ErrDunningOverrideNotFound) {
+		return policy, false, nil
+	}
+	if err != nil {
+		return policy, false, err
+	}
+
+	if !override.activeAt(now) {
+		return policy, false, nil
+	}
+
+	if override.SuspendAfter != nil {
+		policy.SuspendAfter = *override.SuspendAfter
+	}
+
+	if override.TerminateAfter != nil {
+		policy.TerminateAfter = *override.TerminateAfter
+	}
+
+	return policy, override.Paused, nil
+}
+
+func (d *Dunning) process(ctx context.Context, inv *Invoice, now time.Time) error {
+	policy, paused, err := d.policyFor(ctx, inv.OwnerId, now)
+	if err != nil || paused {
+		return err
+	}
+
+	dueDate, err := time.ParseInLocation(time.DateTime, inv.DueDate, time.Local)
+	if err != nil {
+		return err
+	}
+
+	days := int(now.Sub(dueDate).Hours() / 24)
+
+	if days >= policy.TerminateAfter {
+		reason := fmt.Sprintf("Fatura %s vencida há %d dias", inv.Number, days)
+		if err := d.applyToServices(ctx, inv, DunningTerminate, account.ActionTerminate, reason); err != nil {
+			return err
+		}
+
+		_, err := d.dunning.record(ctx, &DunningEntry{
+			OwnerId:   inv.OwnerId,
+			InvoiceId: inv.Id,
+			Action:    DunningClosed,
+			Detail:    reason,
+			Actor:     SystemActor,
+		})
+		return err
+	}
+
+	if days >= policy.SuspendAfter {
+		reason := fmt.Sprintf("Fatura %s vencida há %d dias", inv.Number, days)
+		if err := d.applyToServices(ctx, inv, DunningSuspend, account.ActionSuspend, reason); err != nil {
+			return err
+		}
+	}
+
+	return d.remind(ctx, inv, policy, days)
+}
+
+// remind manda só o lembrete mais recente que já venceu. Depois de um tempo
+// parado o cliente recebe um e-mail, não um para cada dia perdido
+func (d *Dunning) remind(ctx context.Context, inv *Invoice, policy DunningPolicy, days int) error {
+	step := -1
+	for _, day := range policy.ReminderDays {
+		if days >= day {
+			step = day
+		}
+	}
+
+	if step < 0 {
+		return nil
+	}
+
+	warning := fmt.Sprintf("Os serviços desta fatura serão suspensos em %d dia(s) se o pagamento não for feito.", policy.SuspendAfter-days)
+	if days >= policy.SuspendAfter {
+		warning = fmt.Sprintf("Os serviços desta fatura estão suspensos e serão encerrados em %d dia(s).", policy.TerminateAfter-days)
+	}
+
+	entry := &DunningEntry{
+		OwnerId:   inv.OwnerId,
+		InvoiceId: inv.Id,
+		Action:    DunningReminder,
+		Target:    "day-" + strconv.Itoa(step),
+		Detail:    warning,
+		Actor:     SystemActor,
+	}
+
+	inserted, err := d.dunning.record(ctx, entry)
+	if err != nil || !inserted {
+		return err
+	}
+
+	email, err := d.users.FindEmailByUUID(ctx, inv.OwnerId)
+	if err != nil {
+		return errors.Join(err, d.dunning.forget(ctx, entry.Id))
+	}
+
+	emailHandler.SendOverdueReminder(email, inv.Number, inv.AmountDue().String(), days, warning)
+	return nil
+}
+
+// applyToServices registra e aplica a ação em cada serviço da fatura. Se a
+// transição falhar o registro é desfeito para tentar na próxima rodada
+func (d *Dunning) applyToServices(ctx context.Context, inv *Invoice, kind DunningActionKind, action account.ServiceAction, reason string) error {
+	for _, item := range inv.Items {
+		if item.ServiceId == "" || item.Kind != ItemService {
+			continue
+		}
+
+		service, err := d.services.GetByID(ctx, item.ServiceId)
+		if errors.Is(err, account.ErrServiceNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if !account.CanTransition(service.Status, action) {
+			continue
+		}
+
+		entry := &DunningEntry{
+			OwnerId:   inv.OwnerId,
+			InvoiceId: inv.Id,
+			Action:    kind,
+			Target:    service.Id,
+			Detail:    reason,
+			Actor:     SystemActor,
+		}
+
+		inserted, err := d.dunning.record(ctx, entry)
+		if err != nil {
+			return err
+		}
+		if !inserted {
+			continue
+		}
+
+		_, err = d.lifecycle.Apply(ctx, service.Id, action, reason)
+		if err != nil && !errors.Is(err, account.ErrInvalidTransition) {
+			return errors.Join(err, d.dunning.forget(ctx, entry.Id))
+		}
+	}
+
+	return nil
+}
+
+// SetOverride valida a regra contra a política padrão antes de gravar
+func (d *Dunning) SetOverride(ctx context.Context, o *DunningOverride) error {
+	suspendAfter, terminateAfter := d.config.Policy.SuspendAfter, d.config.Policy.TerminateAfter
+	if o.SuspendAfter != nil {
+		suspendAfter = *o.SuspendAfter
+	}
+	if o.TerminateAfter != nil {
+		terminateAfter = *o.TerminateAfter
+	}
+
+	if suspendAfter < 0 || terminateAfter <= suspendAfter {
+		return ErrInvalidDunningOverride
+	}
+
+	if o.ExpiresAt != "" {
+		if _, err := time.ParseInLocation(time.DateTime, o.ExpiresAt, time.Local); err != nil {
+			return ErrInvalidDunningOverride
+		}
+	}
+
+	return d.dunning.SetOverride(ctx, o)
+}
@@ -0,0 +1,239 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"prodata/database"
+	"time"
+)
+
+type DunningRepository struct {
+	db *sql.DB
+}
+
+func NewDunningRepository(db *sql.DB) *DunningRepository {
+	return &DunningRepository{db: db}
+}
+
+func (r *DunningRepository) Override(ctx context.Context, ownerId string) (*DunningOverride, error) {
+	var o DunningOverride
+	var suspendAfter, terminateAfter sql.NullInt64
+	var expiresAt sql.NullString
+
+	err := r.db.QueryRowContext(ctx, "SELECT owner_uuid, paused, suspend_after, terminate_after, expires_at, reason, updated_by, updated_at FROM dunning_overrides WHERE owner_uuid = ?", ownerId).Scan(
+		&o.OwnerId,
+		&o.Paused,
+		&suspendAfter,
+		&terminateAfter,
+		&expiresAt,
+		&o.Reason,
+		&o.UpdatedBy,
+		&o.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrDunningOverrideNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if suspendAfter.Valid {
+		value := int(suspendAfter.Int64)
+		o.SuspendAfter = &value
+	}
+
+	if terminateAfter.Valid {
+		value := int(terminateAfter.Int64)
+		o.TerminateAfter = &value
+	}
+
+	o.ExpiresAt = expiresAt.String
+
+	return &o, nil
+}
+
+// SetOverride grava a regra do cliente e a ação na auditoria na mesma
+// transação
+func (r *DunningRepository) SetOverride(ctx context.Context, o *DunningOverride) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	o.UpdatedAt = time.Now().Format(time.DateTime)
+
+	var suspendAfter, terminateAfter any
+	if o.SuspendAfter != nil {
+		suspendAfter = *o.SuspendAfter
+	}
+	if o.TerminateAfter != nil {
+		terminateAfter = *o.TerminateAfter
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO dunning_overrides (owner_uuid, paused, suspend_after, terminate_after, expires_at, reason, updated_by, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+ON DUPLICATE KEY UPDATE paused = VALUES(paused), suspend_after = VALUES(suspend_after), terminate_after = VALUES(terminate_after), expires_at = VALUES(expires_at), reason = VALUES(reason), updated_by = VALUES(updated_by), updated_at = VALUES(updated_at)`,
+		o.OwnerId,
+		o.Paused,
+		suspendAfter,
+		terminateAfter,
+		nullable(o.ExpiresAt),
+		o.Reason,
+		o.UpdatedBy,
+		o.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	_, err = recordAction(ctx, tx, &DunningEntry{
+		OwnerId: o.OwnerId,
+		Action:  DunningOverrideSet,
+		Detail:  o.Reason,
+		Actor:   o.UpdatedBy,
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *DunningRepository) DeleteOverride(ctx context.Context, ownerId, actor string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM dunning_overrides WHERE owner_uuid = ?", ownerId)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrDunningOverrideNotFound
+	}
+
+	_, err = recordAction(ctx, tx, &DunningEntry{
+		OwnerId: ownerId,
+		Action:  DunningOverrideRemoved,
+		Actor:   actor,
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// record grava a ação na auditoria. Retorna false quando ela já tinha sido
+// feita para essa fatura e alvo, e quem chamou não deve repetir o efeito
+func (r *DunningRepository) record(ctx context.Context, entry *DunningEntry) (bool, error) {
+	return recordAction(ctx, r.db, entry)
+}
+
+func recordAction(ctx context.Context, exec execer, entry *DunningEntry) (bool, error) {
+	entry.CreatedAt = time.Now().Format(time.DateTime)
+
+	result, err := exec.ExecContext(ctx, "INSERT INTO dunning_actions (owner_uuid, invoice_id, action, target, detail, actor, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		entry.OwnerId,
+		nullable(entry.InvoiceId),
+		entry.Action,
+		entry.Target,
+		database.Truncate(entry.Detail, 255),
+		entry.Actor,
+		entry.CreatedAt)
+	if database.IsDuplicate(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	entry.Id, err = result.LastInsertId()
+	return true, err
+}
+
+// forget desfaz o registro de uma ação que falhou, para a próxima rodada
+// tentar de novo
+func (r *DunningRepository) forget(ctx context.Context, id int64) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM dunning_actions WHERE id = ?", id)
+	return err
+}
+
+// pendingInvoices devolve as faturas vencidas que a cobrança ainda não
+// encerrou
+func (r *DunningRepository) pendingInvoices(ctx context.Context, limit int) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT i.id FROM invoices i WHERE i.status = ?
+AND NOT EXISTS (SELECT 1 FROM dunning_actions d WHERE d.invoice_id = i.id AND d.action = ?)
+ORDER BY i.due_date LIMIT ?`, InvoiceOverdue, DunningClosed, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// suspendedByDunning diz se o serviço está suspenso pela cobrança e se
+// ainda sobra alguma fatura vencida que justifique a suspensão
+func (r *DunningRepository) suspendedByDunning(ctx context.Context, serviceId string) (suspended, stillOwed bool, err error) {
+	var last DunningActionKind
+	err = r.db.QueryRowContext(ctx, "SELECT action FROM dunning_actions WHERE target = ? AND action IN (?, ?) ORDER BY id DESC LIMIT 1",
+		serviceId, DunningSuspend, DunningUnsuspend).Scan(&last)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+
+	if last != DunningSuspend {
+		return false, false, nil
+	}
+
+	var owed int
+	err = r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM dunning_actions d JOIN invoices i ON i.id = d.invoice_id
+WHERE d.target = ? AND d.action = ? AND i.status = ?`, serviceId, DunningSuspend, InvoiceOverdue).Scan(&owed)
+	if err != nil {
+		return false, false, err
+	}
+
+	return true, owed > 0, nil
+}
+
+func (r *DunningRepository) ListByOwner(ctx context.Context, ownerId string, limit int) ([]DunningEntry, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id, owner_uuid, invoice_id, action, target, detail, actor, created_at FROM dunning_actions WHERE owner_uuid = ? ORDER BY id DESC LIMIT ?", ownerId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []DunningEntry{}
+	for rows.Next() {
+		var entry DunningEntry
+		var invoiceId sql.NullString
+		err := rows.Scan(&entry.Id, &entry.OwnerId, &invoiceId, &entry.Action, &entry.Target, &entry.Detail, &entry.Actor, &entry.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		entry.InvoiceId = invoiceId.String
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
@@ -0,0 +1,1119 @@
+package billing
+
+import (
+	"errors"
+	"net/http"
+	"prodata/api"
+	"prodata/bank"
+	"prodata/catalog"
+	"prodata/database"
+	"prodata/database/account"
+	"prodata/finances"
+	"prodata/money"
+	"strconv"
+	"time"
+)
+
+type Handler struct {
+	invoices *InvoiceRepository
+	services *account.ServiceRepository
+}
+
+func NewHandler(invoices *InvoiceRepository, services *account.ServiceRepository) *Handler {
+	return &Handler{invoices: invoices, services: services}
+}
+
+func (h *Handler) writeError(ctx *api.Context, err error) {
+	switch {
+	case errors.Is(err, ErrInvoiceNotFound), errors.Is(err, account.ErrServiceNotFound):
+		ctx.Error(err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrInvoiceNotEditable):
+		ctx.Error(err.Error(), http.StatusConflict)
+	default:
+		ctx.Logger.LogAndSendSystemMessage(err.Error())
+		ctx.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (h *Handler) listInvoices(ctx *api.Context, filter InvoiceFilter) {
+	limit := min(ctx.QueryInt("limit", 20), 100)
+	page := ctx.QueryInt("page", 1)
+
+	filter.Status = InvoiceStatus(ctx.Request.URL.Query().Get("status"))
+	filter.Limit = limit
+	filter.Offset = (page - 1) * limit
+
+	invoices, total, err := h.invoices.List(ctx.Request.Context(), filter)
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Writer.Header().Set("X-Total-Count", strconv.Itoa(total))
+	ctx.Json(invoices)
+}
+
+// GET /billing/invoices, rascunhos não aparecem para o cliente
+func (h *Handler) ListInvoices(ctx *api.Context) {
+	h.listInvoices(ctx, InvoiceFilter{OwnerId: ctx.User().UserId, ExcludeDrafts: true})
+}
+
+func (h *Handler) GetInvoice(ctx *api.Context) {
+	inv, err := h.invoices.GetForOwner(ctx.Request.Context(), ctx.User().UserId, ctx.Param("id"))
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	if err := h.invoices.loadCreditNotes(ctx.Request.Context(), inv); err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Json(inv)
+}
+
+// GET /admin/billing/invoices?owner=<uuid>&status=
+func (h *Handler) AdminListInvoices(ctx *api.Context) {
+	h.listInvoices(ctx, InvoiceFilter{OwnerId: ctx.Request.URL.Query().Get("owner")})
+}
+
+func (h *Handler) AdminGetInvoice(ctx *api.Context) {
+	inv, err := h.invoices.Get(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	if err := h.invoices.loadCreditNotes(ctx.Request.Context(), inv); err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Json(inv)
+}
+
+type createInvoiceRequest struct {
+	OwnerId string        `json:"owner_id"`
+	DueDate string        `json:"due_date"`
+	Notes   string        `json:"notes"`
+	Issue   bool          `json:"issue"`
+	Items   []InvoiceItem `json:"items"`
+}
+
+// POST /admin/billing/invoices, com "issue": true a fatura já sai emitida
+func (h *Handler) AdminCreateInvoice(ctx *api.Context) {
+	var body createInvoiceRequest
+	if err := ctx.ReadJson(&body); err != nil {
+		ctx.Error(err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if body.OwnerId == "" {
+		ctx.Error("owner_id is required", http.StatusBadRequest)
+		return
+	}
+
+	dueDate, err := time.ParseInLocation(time.DateOnly, body.DueDate, time.Local)
+	if err != nil {
+		ctx.Error("due_date must be in the format 2006-01-02", http.StatusBadRequest)
+		return
+	}
+
+	for _, item := range body.Items {
+		if item.ServiceId == "" {
+			continue
+		}
+
+		if _, err := h.services.Get(ctx.Request.Context(), body.OwnerId, item.ServiceId); err != nil {
+			h.writeError(ctx, err)
+			return
+		}
+	}
+
+	inv := &Invoice{
+		OwnerId: body.OwnerId,
+		Status:  InvoiceDraft,
+		DueDate: dueDate.Add(23*time.Hour + 59*time.Minute + 59*time.Second).Format(time.DateTime),
+		Notes:   body.Notes,
+		Items:   body.Items,
+	}
+
+	if body.Issue {
+		inv.Status = InvoiceOpen
+	}
+
+	if err := inv.Recalculate(); err != nil {
+		ctx.Error(err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.invoices.Create(ctx.Request.Context(), inv); err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.JsonStatus(http.StatusCreated, inv)
+}
+
+func (h *Handler) AdminIssueInvoice(ctx *api.Context) {
+	inv, err := h.invoices.Issue(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Json(inv)
+}
+
+func (h *Handler) AdminVoidInvoice(ctx *api.Context) {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+
+	if err := ctx.ReadJson(&body); err != nil || body.Reason == "" {
+		ctx.Error("reason is required", http.StatusBadRequest)
+		return
+	}
+
+	inv, err := h.invoices.Void(ctx.Request.Context(), ctx.Param("id"), body.Reason)
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Json(inv)
+}
+
+func (h *Handler) Register(billing *api.Group, admin *api.Group) {
+	billing.Get("/invoices", h.ListInvoices)
+	billing.Get("/invoices/{id}", h.GetInvoice)
+
+	admin.Get("/billing/invoices", h.AdminListInvoices)
+	admin.Post("/billing/invoices", h.AdminCreateInvoice)
+	admin.Get("/billing/invoices/{id}", h.AdminGetInvoice)
+	admin.Post("/billing/invoices/{id}/issue", h.AdminIssueInvoice)
+	admin.Post("/billing/invoices/{id}/void", h.AdminVoidInvoice)
+}
+
+type PlanChangeHandler struct {
+	changer *PlanChanger
+}
+
+func NewPlanChangeHandler(changer *PlanChanger) *PlanChangeHandler {
+	return &PlanChangeHandler{changer: changer}
+}
+
+func (h *PlanChangeHandler) writeError(ctx *api.Context, err error) {
+	switch {
+	case errors.Is(err, account.ErrServiceNotFound), errors.Is(err, catalog.ErrPlanNotFound):
+		ctx.Error(err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrInvalidCycle):
+		ctx.Error(err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrSamePlan), errors.Is(err, ErrServiceNotActive), errors.Is(err, finances.ErrOutsidePeriod):
+		ctx.Error(err.Error(), http.StatusConflict)
+	case errors.Is(err, catalog.ErrPriceNotFound), errors.Is(err, catalog.ErrPlanUnavailable), errors.Is(err, catalog.ErrOutOfStock):
+		ctx.Error(err.Error(), http.StatusUnprocessableEntity)
+	default:
+		ctx.Logger.LogAndSendSystemMessage(err.Error())
+		ctx.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// POST /services/{id}/change-plan com {"plan_id": "...", "cycle": "annual",
+// "preview": true} devolve a proporcionalidade sem aplicar a troca. Sem
+// plan_id só o ciclo muda
+func (h *PlanChangeHandler) ChangePlan(ctx *api.Context) {
+	var body struct {
+		PlanId  string               `json:"plan_id"`
+		Cycle   catalog.BillingCycle `json:"cycle"`
+		Preview bool                 `json:"preview"`
+	}
+
+	if err := ctx.ReadJson(&body); err != nil {
+		ctx.Error(err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if body.PlanId == "" && body.Cycle == "" {
+		ctx.Error("plan_id or cycle is required", http.StatusBadRequest)
+		return
+	}
+
+	change, err := h.changer.ChangePlan(ctx.Request.Context(), ctx.User().UserId, ctx.Param("id"), body.PlanId, body.Cycle, body.Preview)
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Json(change)
+}
+
+func (h *PlanChangeHandler) Register(services *api.Group) {
+	services.Post("/{id}/change-plan", h.ChangePlan)
+}
+
+type CheckoutHandler struct {
+	checkout *Checkout
+}
+
+func NewCheckoutHandler(checkout *Checkout) *CheckoutHandler {
+	return &CheckoutHandler{checkout: checkout}
+}
+
+func (h *CheckoutHandler) writeError(ctx *api.Context, err error) {
+	switch {
+	case errors.Is(err, ErrInvoiceNotFound):
+		ctx.Error(err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrInvoiceNotPayable), errors.Is(err, ErrPaymentProcessing), errors.Is(err, ErrPaymentInProgress):
+		ctx.Error(err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrInvalidDocument), errors.Is(err, ErrCardRequired):
+		ctx.Error(err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrPaymentMethodNotFound), errors.Is(err, bank.ErrCardNotFound):
+		ctx.Error(err.Error(), http.StatusNotFound)
+	case errors.Is(err, bank.ErrPaymentRefused):
+		ctx.Error(err.Error(), http.StatusUnprocessableEntity)
+	default:
+		ctx.Logger.LogAndSendSystemMessage(err.Error())
+		ctx.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// POST /billing/invoices/{id}/pay/pix, chamar de novo devolve o mesmo
+// PIX enquanto ele não expirar
+func (h *CheckoutHandler) PayPix(ctx *api.Context) {
+	p, err := h.checkout.PayPix(ctx.Request.Context(), ctx.User().UserId, ctx.Param("id"))
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Json(p)
+}
+
+// POST /billing/invoices/{id}/pay/boleto com o CPF ou CNPJ do pagador.
+// Devolve a linha digitável em barcode_line e o PDF em ticket_url
+func (h *CheckoutHandler) PayBoleto(ctx *api.Context) {
+	var body struct {
+		Document string `json:"document"`
+	}
+
+	if err := ctx.ReadJson(&body); err != nil || body.Document == "" {
+		ctx.Error("document is required", http.StatusBadRequest)
+		return
+	}
+
+	p, err := h.checkout.PayBoleto(ctx.Request.Context(), ctx.User().UserId, ctx.Param("id"), body.Document)
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Json(p)
+}
+
+// POST /billing/invoices/{id}/pay/card com o token do cartão gerado no
+// navegador ou o saved_method_id de um cartão salvo. Recusas voltam com
+// status rejected e o motivo em status_detail
+func (h *CheckoutHandler) PayCard(ctx *api.Context) {
+	var body CardPayment
+	if err := ctx.ReadJson(&body); err != nil {
+		ctx.Error(err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p, err := h.checkout.PayCard(ctx.Request.Context(), ctx.User().UserId, ctx.Param("id"), body)
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Json(p)
+}
+
+// GET /dashboard/payment-methods
+func (h *CheckoutHandler) ListPaymentMethods(ctx *api.Context) {
+	methods, err := h.checkout.methods.ListByOwner(ctx.Request.Context(), ctx.User().UserId)
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Json(methods)
+}
+
+// DELETE /dashboard/payment-methods/{id}
+func (h *CheckoutHandler) DeletePaymentMethod(ctx *api.Context) {
+	if err := h.checkout.DeletePaymentMethod(ctx.Request.Context(), ctx.User().UserId, ctx.Param("id")); err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.WriteHeader(http.StatusNoContent)
+}
+
+// POST /dashboard/payment-methods/{id}/default, o cartão padrão é o usado
+// nas renovações automáticas
+func (h *CheckoutHandler) SetDefaultPaymentMethod(ctx *api.Context) {
+	if err := h.checkout.methods.SetDefault(ctx.Request.Context(), ctx.User().UserId, ctx.Param("id")); err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.WriteHeader(http.StatusNoContent)
+}
+
+// GET /dashboard/auto-charge
+func (h *CheckoutHandler) GetAutoCharge(ctx *api.Context) {
+	enabled, err := h.checkout.methods.AutoCharge(ctx.Request.Context(), ctx.User().UserId)
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Json(map[string]bool{"enabled": enabled})
+}
+
+// PUT /dashboard/auto-charge liga ou desliga a cobrança das renovações no
+// cartão padrão
+func (h *CheckoutHandler) SetAutoCharge(ctx *api.Context) {
+	var body struct {
+		Enabled *bool `json:"enabled"`
+	}
+
+	if err := ctx.ReadJson(&body); err != nil || body.Enabled == nil {
+		ctx.Error("enabled is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.checkout.methods.SetAutoCharge(ctx.Request.Context(), ctx.User().UserId, *body.Enabled); err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Json(map[string]bool{"enabled": *body.Enabled})
+}
+
+func (h *CheckoutHandler) Register(billing, dashboard *api.Group) {
+	billing.Post("/invoices/{id}/pay/pix", h.PayPix)
+	billing.Post("/invoices/{id}/pay/boleto", h.PayBoleto)
+	billing.Post("/invoices/{id}/pay/card", h.PayCard)
+
+	dashboard.Get("/payment-methods", h.ListPaymentMethods)
+	dashboard.Delete("/payment-methods/{id}", h.DeletePaymentMethod)
+	dashboard.Post("/payment-methods/{id}/default", h.SetDefaultPaymentMethod)
+	dashboard.Get("/auto-charge", h.GetAutoCharge)
+	dashboard.Put("/auto-charge", h.SetAutoCharge)
+}
+
+type DunningHandler struct {
+	dunning *Dunning
+}
+
+func NewDunningHandler(dunning *Dunning) *DunningHandler {
+	return &DunningHandler{dunning: dunning}
+}
+
+func (h *DunningHandler) writeError(ctx *api.Context, err error) {
+	switch {
+	case errors.Is(err, ErrDunningOverrideNotFound):
+		ctx.Error(err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrInvalidDunningOverride):
+		ctx.Error(err.Error(), http.StatusBadRequest)
+	default:
+		ctx.Logger.LogAndSendSystemMessage(err.Error())
+		ctx.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// GET /admin/dunning/{owner} devolve a política que vale para o cliente, a
+// regra dele se tiver e a auditoria da cobrança
+func (h *DunningHandler) Get(ctx *api.Context) {
+	owner := ctx.Param("owner")
+
+	override, err := h.dunning.dunning.Override(ctx.Request.Context(), owner)
+	if err != nil && !errors.Is(err, ErrDunningOverrideNotFound) {
+		h.writeError(ctx, err)
+		return
+	}
+
+	policy, paused, err := h.dunning.policyFor(ctx.Request.Context(), owner, time.Now())
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	actions, err := h.dunning.dunning.ListByOwner(ctx.Request.Context(), owner, min(ctx.QueryInt("limit", 50), 200))
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Json(map[string]any{
+		"policy":   policy,
+		"paused":   paused,
+		"override": override,
+		"actions":  actions,
+	})
+}
+
+// PUT /admin/dunning/{owner} com {"paused": true, "suspend_after": 20,
+// "terminate_after": 60, "expires_at": "2006-01-02 15:04:05", "reason": "..."}
+func (h *DunningHandler) SetOverride(ctx *api.Context) {
+	var body DunningOverride
+	if err := ctx.ReadJson(&body); err != nil {
+		ctx.Error(err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if body.Reason == "" {
+		ctx.Error("reason is required", http.StatusBadRequest)
+		return
+	}
+
+	body.OwnerId = ctx.Param("owner")
+	body.UpdatedBy = ctx.User().UserId
+
+	if err := h.dunning.SetOverride(ctx.Request.Context(), &body); err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Json(body)
+}
+
+func (h *DunningHandler) DeleteOverride(ctx *api.Context) {
+	if err := h.dunning.dunning.DeleteOverride(ctx.Request.Context(), ctx.Param("owner"), ctx.User().UserId); err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.WriteHeader(http.StatusNoContent)
+}
+
+func (h *DunningHandler) Register(admin *api.Group) {
+	admin.Get("/dunning/{owner}", h.Get)
+	admin.Put("/dunning/{owner}", h.SetOverride)
+	admin.Delete("/dunning/{owner}", h.DeleteOverride)
+}
+
+type RefundHandler struct {
+	refunder *Refunder
+}
+
+func NewRefundHandler(refunder *Refunder) *RefundHandler {
+	return &RefundHandler{refunder: refunder}
+}
+
+func (h *RefundHandler) writeError(ctx *api.Context, err error) {
+	switch {
+	case errors.Is(err, ErrPaymentNotFound), errors.Is(err, bank.ErrPaymentNotFound):
+		ctx.Error(err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrPaymentNotRefundable), errors.Is(err, ErrRefundInProgress), errors.Is(err, ErrOverpaymentSpent):
+		ctx.Error(err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrInvalidRefundAmount):
+		ctx.Error(err.Error(), http.StatusBadRequest)
+	default:
+		ctx.Logger.LogAndSendSystemMessage(err.Error())
+		ctx.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// POST /admin/billing/payments/{id}/refund com {"amount": "10.00", "reason":
+// "...", "cancel_services": true, "to_wallet": false}, sem amount estorna o
+// que falta do pagamento
+func (h *RefundHandler) Refund(ctx *api.Context) {
+	var body struct {
+		Amount         *money.Money `json:"amount"`
+		Reason         string       `json:"reason"`
+		CancelServices bool         `json:"cancel_services"`
+		ToWallet       bool         `json:"to_wallet"`
+	}
+
+	if err := ctx.ReadJson(&body); err != nil {
+		ctx.Error(err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if body.Reason == "" {
+		ctx.Error("reason is required", http.StatusBadRequest)
+		return
+	}
+
+	refund, err := h.refunder.Refund(ctx.Request.Context(), ctx.Param("id"), RefundRequest{
+		Amount:         body.Amount,
+		Reason:         body.Reason,
+		CancelServices: body.CancelServices,
+		ToWallet:       body.ToWallet,
+		Actor:          ctx.User().UserId,
+	})
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.JsonStatus(http.StatusCreated, refund)
+}
+
+// GET /admin/billing/invoices/{id}/refunds
+func (h *RefundHandler) ListRefunds(ctx *api.Context) {
+	refunds, err := h.refunder.refunds.ListByInvoice(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Json(refunds)
+}
+
+func (h *RefundHandler) Register(admin *api.Group) {
+	admin.Post("/billing/payments/{id}/refund", h.Refund)
+	admin.Get("/billing/invoices/{id}/refunds", h.ListRefunds)
+}
+
+type DisputeHandler struct {
+	disputes *Disputes
+}
+
+func NewDisputeHandler(disputes *Disputes) *DisputeHandler {
+	return &DisputeHandler{disputes: disputes}
+}
+
+func (h *DisputeHandler) writeError(ctx *api.Context, err error) {
+	switch {
+	case errors.Is(err, ErrDisputeNotFound), errors.Is(err, account.ErrUserNotFound):
+		ctx.Error(err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrDisputeResolved):
+		ctx.Error(err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrInvalidDisputeOutcome):
+		ctx.Error(err.Error(), http.StatusBadRequest)
+	default:
+		ctx.Logger.LogAndSendSystemMessage(err.Error())
+		ctx.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// GET /admin/disputes?owner=<uuid>&status=
+func (h *DisputeHandler) List(ctx *api.Context) {
+	limit := min(ctx.QueryInt("limit", 20), 100)
+	page := ctx.QueryInt("page", 1)
+
+	disputes, total, err := h.disputes.disputes.List(ctx.Request.Context(), DisputeFilter{
+		OwnerId: ctx.Request.URL.Query().Get("owner"),
+		Status:  DisputeStatus(ctx.Request.URL.Query().Get("status")),
+		Limit:   limit,
+		Offset:  (page - 1) * limit,
+	})
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Writer.Header().Set("X-Total-Count", strconv.Itoa(total))
+	ctx.Json(disputes)
+}
+
+// GET /admin/disputes/{id} com as notas de evidência
+func (h *DisputeHandler) Get(ctx *api.Context) {
+	dispute, err := h.disputes.disputes.Get(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	evidence, err := h.disputes.disputes.Evidence(ctx.Request.Context(), dispute.Id)
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Json(map[string]any{
+		"dispute":  dispute,
+		"evidence": evidence,
+	})
+}
+
+// POST /admin/disputes/{id}/evidence com {"note": "..."}
+func (h *DisputeHandler) AddEvidence(ctx *api.Context) {
+	var body struct {
+		Note string `json:"note"`
+	}
+
+	if err := ctx.ReadJson(&body); err != nil || body.Note == "" {
+		ctx.Error("note is required", http.StatusBadRequest)
+		return
+	}
+
+	dispute, err := h.disputes.disputes.Get(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	evidence := &DisputeEvidence{DisputeId: dispute.Id, Note: body.Note, Author: ctx.User().UserId}
+	if err := h.disputes.disputes.AddEvidence(ctx.Request.Context(), evidence); err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.JsonStatus(http.StatusCreated, evidence)
+}
+
+// POST /admin/disputes/{id}/outcome com {"outcome": "won" | "lost", "note": "..."}
+func (h *DisputeHandler) Resolve(ctx *api.Context) {
+	var body struct {
+		Outcome DisputeStatus `json:"outcome"`
+		Note    string        `json:"note"`
+	}
+
+	if err := ctx.ReadJson(&body); err != nil {
+		ctx.Error(err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	dispute, err := h.disputes.Resolve(ctx.Request.Context(), ctx.Param("id"), body.Outcome, body.Note, ctx.User().UserId)
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Json(dispute)
+}
+
+// GET /admin/dispute-flags/{owner}
+func (h *DisputeHandler) GetFlag(ctx *api.Context) {
+	owner := ctx.Param("owner")
+
+	flaggedAt, err := h.disputes.users.DisputeFlaggedAt(ctx.Request.Context(), owner)
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	count, err := h.disputes.disputes.CountByOwner(ctx.Request.Context(), owner)
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Json(map[string]any{
+		"flagged":    flaggedAt != "",
+		"flagged_at": flaggedAt,
+		"disputes":   count,
+	})
+}
+
+// DELETE /admin/dispute-flags/{owner} tira a marca da conta, ela volta se o
+// cliente contestar de novo
+func (h *DisputeHandler) ClearFlag(ctx *api.Context) {
+	if err := h.disputes.users.SetDisputeFlag(ctx.Request.Context(), ctx.Param("owner"), false); err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.WriteHeader(http.StatusNoContent)
+}
+
+func (h *DisputeHandler) Register(admin *api.Group) {
+	admin.Get("/disputes", h.List)
+	admin.Get("/disputes/{id}", h.Get)
+	admin.Post("/disputes/{id}/evidence", h.AddEvidence)
+	admin.Post("/disputes/{id}/outcome", h.Resolve)
+	admin.Get("/dispute-flags/{owner}", h.GetFlag)
+	admin.Delete("/dispute-flags/{owner}", h.ClearFlag)
+}
+
+type WalletHandler struct {
+	wallet *WalletRepository
+}
+
+func NewWalletHandler(wallet *WalletRepository) *WalletHandler {
+	return &WalletHandler{wallet: wallet}
+}
+
+func (h *WalletHandler) writeError(ctx *api.Context, err error) {
+	switch {
+	case errors.Is(err, ErrInsufficientCredit), errors.Is(err, ErrInvalidWalletEntry):
+		ctx.Error(err.Error(), http.StatusBadRequest)
+	default:
+		ctx.Logger.LogAndSendSystemMessage(err.Error())
+		ctx.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// show devolve o saldo e uma página do extrato, o total de lançamentos vai
+// em X-Total-Count
+func (h *WalletHandler) show(ctx *api.Context, owner string) {
+	limit := min(ctx.QueryInt("limit", 20), 100)
+	page := ctx.QueryInt("page", 1)
+
+	balance, err := h.wallet.Balance(ctx.Request.Context(), owner)
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	entries, total, err := h.wallet.Entries(ctx.Request.Context(), owner, limit, (page-1)*limit)
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Writer.Header().Set("X-Total-Count", strconv.Itoa(total))
+	ctx.Json(map[string]any{
+		"balance": balance,
+		"entries": entries,
+	})
+}
+
+// GET /dashboard/wallet?limit=&page=
+func (h *WalletHandler) Get(ctx *api.Context) {
+	h.show(ctx, ctx.User().UserId)
+}
+
+// GET /admin/wallets/{owner}?limit=&page=
+func (h *WalletHandler) AdminGet(ctx *api.Context) {
+	h.show(ctx, ctx.Param("owner"))
+}
+
+// POST /admin/wallets/{owner}/adjust com {"amount": "-10.00", "description":
+// "..."}. Valor negativo tira do saldo, que nunca fica negativo
+func (h *WalletHandler) Adjust(ctx *api.Context) {
+	var body struct {
+		Amount      money.Money `json:"amount"`
+		Description string      `json:"description"`
+	}
+
+	if err := ctx.ReadJson(&body); err != nil {
+		ctx.Error(err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if body.Description == "" {
+		ctx.Error("description is required", http.StatusBadRequest)
+		return
+	}
+
+	entry := &WalletEntry{
+		OwnerId:     ctx.Param("owner"),
+		Source:      WalletManual,
+		Amount:      body.Amount,
+		Description: body.Description,
+		Actor:       ctx.User().UserId,
+	}
+
+	if err := h.wallet.Add(ctx.Request.Context(), entry); err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.JsonStatus(http.StatusCreated, entry)
+}
+
+func (h *WalletHandler) Register(dashboard, admin *api.Group) {
+	dashboard.Get("/wallet", h.Get)
+	admin.Get("/wallets/{owner}", h.AdminGet)
+	admin.Post("/wallets/{owner}/adjust", h.Adjust)
+}
+
+type CouponHandler struct {
+	coupons *Coupons
+}
+
+func NewCouponHandler(coupons *Coupons) *CouponHandler {
+	return &CouponHandler{coupons: coupons}
+}
+
+func (h *CouponHandler) writeError(ctx *api.Context, err error) {
+	switch {
+	case errors.Is(err, ErrCouponNotFound):
+		ctx.Error(err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrCouponInactive), errors.Is(err, ErrCouponNotStarted), errors.Is(err, ErrCouponExpired),
+		errors.Is(err, ErrCouponExhausted), errors.Is(err, ErrCouponUserLimit), errors.Is(err, ErrCouponNotApplicable):
+		ctx.Error(err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, ErrInvalidCoupon):
+		ctx.Error(err.Error(), http.StatusBadRequest)
+	case database.IsDuplicate(err):
+		ctx.Error("coupon code already in use", http.StatusConflict)
+	default:
+		ctx.Logger.LogAndSendSystemMessage(err.Error())
+		ctx.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// POST /billing/coupons/check com {"code": "LANCAMENTO", "plan_ids": [...]}.
+// Só valida, o cupom é usado quando a fatura é criada
+func (h *CouponHandler) Check(ctx *api.Context) {
+	var body struct {
+		Code    string   `json:"code"`
+		PlanIds []string `json:"plan_ids"`
+	}
+
+	if err := ctx.ReadJson(&body); err != nil || body.Code == "" || len(body.PlanIds) == 0 {
+		ctx.Error("code and plan_ids are required", http.StatusBadRequest)
+		return
+	}
+
+	coupon, err := h.coupons.Check(ctx.Request.Context(), body.Code, ctx.User().UserId, body.PlanIds)
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Json(map[string]any{
+		"code":        coupon.Code,
+		"description": coupon.Description,
+		"kind":        coupon.Kind,
+		"percent":     coupon.Percent,
+		"amount":      coupon.Amount,
+		"duration":    coupon.Duration,
+		"cycles":      coupon.Cycles,
+		"plan_ids":    coupon.PlanIds,
+	})
+}
+
+// GET /admin/coupons?active=true
+func (h *CouponHandler) List(ctx *api.Context) {
+	limit := min(ctx.QueryInt("limit", 20), 100)
+	page := ctx.QueryInt("page", 1)
+
+	filter := CouponFilter{Limit: limit, Offset: (page - 1) * limit}
+	if value := ctx.Request.URL.Query().Get("active"); value != "" {
+		active := value == "true"
+		filter.Active = &active
+	}
+
+	coupons, total, err := h.coupons.coupons.List(ctx.Request.Context(), filter)
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Writer.Header().Set("X-Total-Count", strconv.Itoa(total))
+	ctx.Json(coupons)
+}
+
+func (h *CouponHandler) Get(ctx *api.Context) {
+	coupon, err := h.coupons.coupons.Get(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Json(coupon)
+}
+
+// POST /admin/coupons com {"code": "LANCAMENTO", "kind": "percent",
+// "percent": 20, "duration": "recurring", "cycles": 3, "plan_ids": [],
+// "max_redemptions": 100, "max_per_user": 1, "ends_at": "...", "active": true}
+func (h *CouponHandler) Create(ctx *api.Context) {
+	var body Coupon
+	if err := ctx.ReadJson(&body); err != nil {
+		ctx.Error(err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := body.Validate(); err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	if err := h.coupons.coupons.Create(ctx.Request.Context(), &body); err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.JsonStatus(http.StatusCreated, body)
+}
+
+// PUT /admin/coupons/{id}, "active": false desativa o cupom. Descontos
+// recorrentes já presos aos serviços continuam
+func (h *CouponHandler) Update(ctx *api.Context) {
+	var body Coupon
+	if err := ctx.ReadJson(&body); err != nil {
+		ctx.Error(err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	body.Id = ctx.Param("id")
+	if err := body.Validate(); err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	if err := h.coupons.coupons.Update(ctx.Request.Context(), &body); err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Json(body)
+}
+
+// GET /admin/coupons/{id}/redemptions
+func (h *CouponHandler) ListRedemptions(ctx *api.Context) {
+	limit := min(ctx.QueryInt("limit", 50), 200)
+	page := ctx.QueryInt("page", 1)
+
+	redemptions, err := h.coupons.coupons.Redemptions(ctx.Request.Context(), ctx.Param("id"), limit, (page-1)*limit)
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Json(redemptions)
+}
+
+func (h *CouponHandler) Register(billing, admin *api.Group) {
+	billing.Post("/coupons/check", h.Check)
+
+	admin.Get("/coupons", h.List)
+	admin.Post("/coupons", h.Create)
+	admin.Get("/coupons/{id}", h.Get)
+	admin.Put("/coupons/{id}", h.Update)
+	admin.Get("/coupons/{id}/redemptions", h.ListRedemptions)
+}
+
+type OrderHandler struct {
+	orders *Orders
+}
+
+func NewOrderHandler(orders *Orders) *OrderHandler {
+	return &OrderHandler{orders: orders}
+}
+
+func (h *OrderHandler) writeError(ctx *api.Context, err error) {
+	switch {
+	case errors.Is(err, ErrOrderNotFound):
+		ctx.Error(err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrInvalidOrder):
+		ctx.Error(err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrOrderNotPending), errors.Is(err, ErrPaymentProcessing), errors.Is(err, ErrPaymentInProgress):
+		ctx.Error(err.Error(), http.StatusConflict)
+	case errors.Is(err, catalog.ErrPlanNotFound), errors.Is(err, catalog.ErrPlanUnavailable), errors.Is(err, catalog.ErrPriceNotFound),
+		errors.Is(err, catalog.ErrOutOfStock), errors.Is(err, catalog.ErrInvalidOption), errors.Is(err, catalog.ErrOptionRequired):
+		ctx.Error(err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, ErrCouponNotFound), errors.Is(err, ErrCouponInactive), errors.Is(err, ErrCouponNotStarted), errors.Is(err, ErrCouponExpired),
+		errors.Is(err, ErrCouponExhausted), errors.Is(err, ErrCouponUserLimit), errors.Is(err, ErrCouponNotApplicable):
+		ctx.Error(err.Error(), http.StatusUnprocessableEntity)
+	default:
+		ctx.Logger.LogAndSendSystemMessage(err.Error())
+		ctx.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// POST /orders com {"items": [{"plan_id": "...", "cycle": "monthly",
+// "options": {"<opção>": "<valor>"}}], "coupon": "LANCAMENTO"}. Devolve o
+// pedido com invoice_id, pago pelas rotas de pagamento da fatura
+func (h *OrderHandler) Create(ctx *api.Context) {
+	var body OrderRequest
+	if err := ctx.ReadJson(&body); err != nil {
+		ctx.Error(err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	order, err := h.orders.Create(ctx.Request.Context(), ctx.User().UserId, body)
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.JsonStatus(http.StatusCreated, order)
+}
+
+// GET /orders?status=pending
+func (h *OrderHandler) List(ctx *api.Context) {
+	h.list(ctx, ctx.User().UserId)
+}
+
+// GET /admin/orders?owner=<uuid>&status=pending
+func (h *OrderHandler) AdminList(ctx *api.Context) {
+	h.list(ctx, ctx.Request.URL.Query().Get("owner"))
+}
+
+func (h *OrderHandler) list(ctx *api.Context, ownerId string) {
+	limit := min(ctx.QueryInt("limit", 20), 100)
+	page := ctx.QueryInt("page", 1)
+
+	orders, total, err := h.orders.orders.List(ctx.Request.Context(), OrderFilter{
+		OwnerId: ownerId,
+		Status:  OrderStatus(ctx.Request.URL.Query().Get("status")),
+		Limit:   limit,
+		Offset:  (page - 1) * limit,
+	})
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Writer.Header().Set("X-Total-Count", strconv.Itoa(total))
+	ctx.Json(orders)
+}
+
+func (h *OrderHandler) Get(ctx *api.Context) {
+	order, err := h.orders.orders.GetForOwner(ctx.Request.Context(), ctx.User().UserId, ctx.Param("id"))
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Json(order)
+}
+
+func (h *OrderHandler) AdminGet(ctx *api.Context) {
+	order, err := h.orders.orders.Get(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Json(order)
+}
+
+// POST /orders/{id}/cancel, só antes do pagamento
+func (h *OrderHandler) Cancel(ctx *api.Context) {
+	if _, err := h.orders.orders.GetForOwner(ctx.Request.Context(), ctx.User().UserId, ctx.Param("id")); err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	order, err := h.orders.Cancel(ctx.Request.Context(), ctx.Param("id"), "Cancelado pelo cliente")
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Json(order)
+}
+
+// POST /admin/orders/{id}/cancel com {"reason": "..."} opcional
+func (h *OrderHandler) AdminCancel(ctx *api.Context) {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+
+	if ctx.Request.ContentLength != 0 {
+		if err := ctx.ReadJson(&body); err != nil {
+			ctx.Error(err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	order, err := h.orders.Cancel(ctx.Request.Context(), ctx.Param("id"), body.Reason)
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Json(order)
+}
+
+func (h *OrderHandler) Register(orders, admin *api.Group) {
+	orders.Post("/", h.Create)
+	orders.Get("/", h.List)
+	orders.Get("/{id}", h.Get)
+	orders.Post("/{id}/cancel", h.Cancel)
+
+	admin.Get("/orders", h.AdminList)
+	admin.Get("/orders/{id}", h.AdminGet)
+	admin.Post("/orders/{id}/cancel", h.AdminCancel)
+}
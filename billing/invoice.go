@@ -0,0 +1,159 @@
+package billing
+
+import (
+	"errors"
+	"fmt"
+	"prodata/money"
+	"slices"
+)
+
+type InvoiceStatus string
+
+const (
+	InvoiceDraft   InvoiceStatus = "draft"
+	InvoiceOpen    InvoiceStatus = "open"
+	InvoicePaid    InvoiceStatus = "paid"
+	InvoiceOverdue InvoiceStatus = "overdue"
+	InvoiceVoid    InvoiceStatus = "void"
+)
+
+type ItemKind string
+
+const (
+	ItemService  ItemKind = "service"
+	ItemFee      ItemKind = "fee"
+	ItemDiscount ItemKind = "discount"
+	ItemTax      ItemKind = "tax"
+)
+
+var (
+	ErrInvoiceNotFound     = errors.New("invoice not found")
+	ErrInvoiceNotEditable  = errors.New("invoice cannot be changed in its current status")
+	ErrInvoiceWithoutItems = errors.New("invoice needs at least one item")
+)
+
+// Status de origem permitidos para cada status de destino
+var invoiceTransitions = map[InvoiceStatus][]InvoiceStatus{
+	InvoiceOpen:    {InvoiceDraft},
+	InvoiceOverdue: {InvoiceOpen},
+	InvoicePaid:    {InvoiceOpen, InvoiceOverdue},
+	InvoiceVoid:    {InvoiceDraft, InvoiceOpen, InvoiceOverdue},
+}
+
+func canChangeInvoice(from, to InvoiceStatus) bool {
+	return slices.Contains(invoiceTransitions[to], from)
+}
+
+type InvoiceItem struct {
+	Id          int64       `json:"id"`
+	ServiceId   string      `json:"service_id,omitempty"`
+	CouponId    string      `json:"coupon_id,omitempty"`
+	Kind        ItemKind    `json:"kind"`
+	Description string      `json:"description"`
+	Quantity    int         `json:"quantity"`
+	UnitPrice   money.Money `json:"unit_price"`
+	Amount      money.Money `json:"amount"`
+	PeriodStart string      `json:"period_start,omitempty"`
+	PeriodEnd   string      `json:"period_end,omitempty"`
+}
+
+type Invoice struct {
+	Id             string         `json:"id"`
+	Number         string         `json:"number"`
+	OwnerId        string         `json:"owner_id"`
+	Status         InvoiceStatus  `json:"status"`
+	Currency       money.Currency `json:"currency"`
+	Subtotal       money.Money    `json:"subtotal"`
+	Discount       money.Money    `json:"discount"`
+	Tax            money.Money    `json:"tax"`
+	Total          money.Money    `json:"total"`
+	AmountPaid     money.Money    `json:"amount_paid"`
+	AmountRefunded money.Money    `json:"amount_refunded"`
+	DueDate        string         `json:"due_date"`
+	Notes          string         `json:"notes"`
+	VoidReason     string         `json:"void_reason,omitempty"`
+	IssuedAt       string         `json:"issued_at,omitempty"`
+	PaidAt         string         `json:"paid_at,omitempty"`
+	VoidedAt       string         `json:"voided_at,omitempty"`
+	CreatedAt      string         `json:"created_at"`
+	UpdatedAt      string         `json:"updated_at"`
+	Items          []InvoiceItem  `json:"items"`
+	CreditNotes    []CreditNote   `json:"credit_notes,omitempty"`
+}
+
+// AmountDue é o que falta pagar depois dos pagamentos parciais
+func (inv *Invoice) AmountDue() money.Money {
+	due, err := inv.Total.Sub(inv.AmountPaid)
+	if err != nil || due.IsNegative() {
+		return money.New(0, inv.Total.Currency())
+	}
+
+	return due
+}
+
+// Recalculate refaz o valor de cada item e os totais da fatura, itens de
+// desconto sempre entram negativos e itens de imposto somam no total
+func (inv *Invoice) Recalculate() error {
+	if len(inv.Items) == 0 {
+		return ErrInvoiceWithoutItems
+	}
+
+	currency := inv.Currency
+	if currency == "" {
+		currency = money.BRL
+	}
+
+	inv.Subtotal = money.New(0, currency)
+	inv.Discount = money.New(0, currency)
+	inv.Tax = money.New(0, currency)
+
+	for i := range inv.Items {
+		item := &inv.Items[i]
+
+		if item.Description == "" {
+			return fmt.Errorf("item %d needs a description", i+1)
+		}
+
+		if item.Quantity <= 0 {
+			item.Quantity = 1
+		}
+
+		amount, err := item.UnitPrice.Multiply(int64(item.Quantity))
+		if err != nil {
+			return fmt.Errorf("item %d: %w", i+1, err)
+		}
+
+		switch item.Kind {
+		case ItemService, ItemFee:
+			if amount.IsNegative() {
+				return fmt.Errorf("item %d cannot be negative", i+1)
+			}
+			inv.Subtotal, err = inv.Subtotal.Add(amount)
+		case ItemDiscount:
+			amount = amount.Abs().Negate()
+			inv.Discount, err = inv.Discount.Sub(amount)
+		case ItemTax:
+			inv.Tax, err = inv.Tax.Add(amount)
+		default:
+			return fmt.Errorf("item %d has an invalid kind %q", i+1, item.Kind)
+		}
+
+		if err != nil {
+			return fmt.Errorf("item %d: %w", i+1, err)
+		}
+
+		item.Amount = amount
+	}
+
+	if inv.Discount.Compare(inv.Subtotal) > 0 {
+		inv.Discount = inv.Subtotal
+	}
+
+	total, err := inv.Subtotal.Sub(inv.Discount)
+	if err != nil {
+		return err
+	}
+
+	inv.Total, err = total.Add(inv.Tax)
+	return err
+}
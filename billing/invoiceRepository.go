@@ -0,0 +1,572 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"prodata/catalog"
+	"prodata/database"
+	"prodata/database/account"
+	"prodata/finances"
+	"prodata/money"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type InvoiceRepository struct {
+	db *sql.DB
+}
+
+type InvoiceFilter struct {
+	OwnerId       string
+	Status        InvoiceStatus
+	ExcludeDrafts bool
+	Limit         int
+	Offset        int
+}
+
+func NewInvoiceRepository(db *sql.DB) *InvoiceRepository {
+	return &InvoiceRepository{db: db}
+}
+
+const invoiceColumns = "id, number, owner_uuid, status, currency, subtotal, discount, tax, total, amount_paid, amount_refunded, due_date, notes, void_reason, issued_at, paid_at, voided_at, created_at, updated_at"
+
+func scanInvoice(row interface{ Scan(dest ...any) error }) (*Invoice, error) {
+	var inv Invoice
+	var number, issuedAt, paidAt, voidedAt sql.NullString
+
+	err := row.Scan(
+		&inv.Id,
+		&number,
+		&inv.OwnerId,
+		&inv.Status,
+		&inv.Currency,
+		&inv.Subtotal,
+		&inv.Discount,
+		&inv.Tax,
+		&inv.Total,
+		&inv.AmountPaid,
+		&inv.AmountRefunded,
+		&inv.DueDate,
+		&inv.Notes,
+		&inv.VoidReason,
+		&issuedAt,
+		&paidAt,
+		&voidedAt,
+		&inv.CreatedAt,
+		&inv.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	inv.Number = number.String
+	inv.IssuedAt = issuedAt.String
+	inv.PaidAt = paidAt.String
+	inv.VoidedAt = voidedAt.String
+
+	return &inv, nil
+}
+
+// Os números são sequenciais por ano (BH-2025-000001) e só são gerados
+// quando a fatura sai de draft, assim rascunhos apagados não deixam buracos
+func nextInvoiceNumber(ctx context.Context, tx *sql.Tx, now time.Time) (string, error) {
+	year := now.Year()
+
+	_, err := tx.ExecContext(ctx, "INSERT IGNORE INTO invoice_sequences (year, last_number) VALUES (?, 0)", year)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE invoice_sequences SET last_number = last_number + 1 WHERE year = ?", year)
+	if err != nil {
+		return "", err
+	}
+
+	var number int
+	err = tx.QueryRowContext(ctx, "SELECT last_number FROM invoice_sequences WHERE year = ?", year).Scan(&number)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("BH-%d-%06d", year, number), nil
+}
+
+func nullable(value string) any {
+	if value == "" {
+		return nil
+	}
+
+	return value
+}
+
+// Create grava a fatura como draft, ou já emitida quando Status for open
+func (r *InvoiceRepository) Create(ctx context.Context, inv *Invoice) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := r.CreateTx(ctx, tx, inv); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CreateTx grava a fatura numa transação já aberta, para quando a fatura
+// nasce junto com outras mudanças (troca de plano, pedidos)
+func (r *InvoiceRepository) CreateTx(ctx context.Context, tx *sql.Tx, inv *Invoice) error {
+	if inv.Status == "" {
+		inv.Status = InvoiceDraft
+	}
+
+	if inv.Status != InvoiceDraft && inv.Status != InvoiceOpen {
+		return ErrInvoiceNotEditable
+	}
+
+	if inv.Currency == "" {
+		inv.Currency = money.BRL
+	}
+
+	if err := inv.Recalculate(); err != nil {
+		return err
+	}
+
+	var err error
+	now := time.Now()
+	inv.Id = uuid.New().String()
+	inv.CreatedAt = now.Format(time.DateTime)
+	inv.UpdatedAt = inv.CreatedAt
+
+	if inv.Status == InvoiceOpen {
+		inv.Number, err = nextInvoiceNumber(ctx, tx, now)
+		if err != nil {
+			return err
+		}
+		inv.IssuedAt = inv.CreatedAt
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO invoices ("+invoiceColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		inv.Id,
+		nullable(inv.Number),
+		inv.OwnerId,
+		inv.Status,
+		inv.Currency,
+		inv.Subtotal,
+		inv.Discount,
+		inv.Tax,
+		inv.Total,
+		inv.AmountPaid,
+		inv.AmountRefunded,
+		inv.DueDate,
+		inv.Notes,
+		inv.VoidReason,
+		nullable(inv.IssuedAt),
+		nil,
+		nil,
+		inv.CreatedAt,
+		inv.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	for i := range inv.Items {
+		item := &inv.Items[i]
+		result, err := tx.ExecContext(ctx, "INSERT INTO invoice_items (invoice_id, service_id, coupon_id, kind, description, quantity, unit_price, amount, period_start, period_end, position) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			inv.Id,
+			nullable(item.ServiceId),
+			nullable(item.CouponId),
+			item.Kind,
+			item.Description,
+			item.Quantity,
+			item.UnitPrice,
+			item.Amount,
+			nullable(item.PeriodStart),
+			nullable(item.PeriodEnd),
+			i)
+		if err != nil {
+			return err
+		}
+
+		item.Id, err = result.LastInsertId()
+		if err != nil {
+			return err
+		}
+	}
+
+	if inv.Status == InvoiceOpen {
+		return postIssued(ctx, tx, inv)
+	}
+
+	return nil
+}
+
+// postIssued lança no livro a receita da fatura que acabou de ser emitida
+func postIssued(ctx context.Context, tx *sql.Tx, inv *Invoice) error {
+	journal, err := finances.InvoiceIssued(inv.Id, inv.OwnerId, inv.Number, inv.Total, inv.Tax)
+	if err != nil {
+		return err
+	}
+
+	_, err = finances.PostTx(ctx, tx, journal)
+	return err
+}
+
+func (r *InvoiceRepository) loadItems(ctx context.Context, inv *Invoice) error {
+	return loadItems(ctx, r.db, inv)
+}
+
+func (r *InvoiceRepository) loadItemsTx(ctx context.Context, tx *sql.Tx, inv *Invoice) error {
+	return loadItems(ctx, tx, inv)
+}
+
+// loadCreditNotes traz as notas de crédito dos estornos da fatura
+func (r *InvoiceRepository) loadCreditNotes(ctx context.Context, inv *Invoice) error {
+	rows, err := r.db.QueryContext(ctx, "SELECT id, number, invoice_id, owner_uuid, refund_id, amount, reason, created_at FROM credit_notes WHERE invoice_id = ? ORDER BY created_at, number", inv.Id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	inv.CreditNotes = []CreditNote{}
+	for rows.Next() {
+		var note CreditNote
+		err := rows.Scan(&note.Id, &note.Number, &note.InvoiceId, &note.OwnerId, &note.RefundId, &note.Amount, &note.Reason, &note.CreatedAt)
+		if err != nil {
+			return err
+		}
+		inv.CreditNotes = append(inv.CreditNotes, note)
+	}
+
+	return rows.Err()
+}
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func loadItems(ctx context.Context, db querier, inv *Invoice) error {
+	rows, err := db.QueryContext(ctx, "SELECT id, service_id, coupon_id, kind, description, quantity, unit_price, amount, period_start, period_end FROM invoice_items WHERE invoice_id = ? ORDER BY position, id", inv.Id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	inv.Items = []InvoiceItem{}
+	for rows.Next() {
+		var item InvoiceItem
+		var serviceId, couponId, periodStart, periodEnd sql.NullString
+		if err := rows.Scan(&item.Id, &serviceId, &couponId, &item.Kind, &item.Description, &item.Quantity, &item.UnitPrice, &item.Amount, &periodStart, &periodEnd); err != nil {
+			return err
+		}
+		item.ServiceId = serviceId.String
+		item.CouponId = couponId.String
+		item.PeriodStart = periodStart.String
+		item.PeriodEnd = periodEnd.String
+		inv.Items = append(inv.Items, item)
+	}
+
+	return rows.Err()
+}
+
+func (r *InvoiceRepository) Get(ctx context.Context, id string) (*Invoice, error) {
+	inv, err := scanInvoice(r.db.QueryRowContext(ctx, "SELECT "+invoiceColumns+" FROM invoices WHERE id = ?", id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvoiceNotFound
+		}
+		return nil, err
+	}
+
+	return inv, r.loadItems(ctx, inv)
+}
+
+// getForUpdate trava a fatura até o fim da transação, sem carregar os itens
+func (r *InvoiceRepository) getForUpdate(ctx context.Context, tx *sql.Tx, id string) (*Invoice, error) {
+	inv, err := scanInvoice(tx.QueryRowContext(ctx, "SELECT "+invoiceColumns+" FROM invoices WHERE id = ? FOR UPDATE", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvoiceNotFound
+	}
+
+	return inv, err
+}
+
+// GetForOwner esconde faturas de outros usuários e rascunhos
+func (r *InvoiceRepository) GetForOwner(ctx context.Context, ownerId, id string) (*Invoice, error) {
+	inv, err := r.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if inv.OwnerId != ownerId || inv.Status == InvoiceDraft {
+		return nil, ErrInvoiceNotFound
+	}
+
+	return inv, nil
+}
+
+// List não carrega os itens, use Get para ver a fatura completa
+func (r *InvoiceRepository) List(ctx context.Context, filter InvoiceFilter) ([]Invoice, int, error) {
+	where := []string{"1 = 1"}
+	args := []any{}
+
+	if filter.OwnerId != "" {
+		where = append(where, "owner_uuid = ?")
+		args = append(args, filter.OwnerId)
+	}
+
+	if filter.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, filter.Status)
+	}
+
+	if filter.ExcludeDrafts {
+		where = append(where, "status <> ?")
+		args = append(args, InvoiceDraft)
+	}
+
+	clause := strings.Join(where, " AND ")
+
+	var total int
+	err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM invoices WHERE "+clause, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := "SELECT " + invoiceColumns + " FROM invoices WHERE " + clause + " ORDER BY created_at DESC, id"
+	if filter.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, filter.Limit, filter.Offset)
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	invoices := []Invoice{}
+	for rows.Next() {
+		inv, err := scanInvoice(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		invoices = append(invoices, *inv)
+	}
+
+	return invoices, total, rows.Err()
+}
+
+// changeStatus trava a fatura, valida a transição e deixa a transação
+// aberta para quem chamou gravar o resto das mudanças
+func (r *InvoiceRepository) changeStatus(ctx context.Context, tx *sql.Tx, id string, to InvoiceStatus) (InvoiceStatus, error) {
+	var from InvoiceStatus
+	err := tx.QueryRowContext(ctx, "SELECT status FROM invoices WHERE id = ? FOR UPDATE", id).Scan(&from)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrInvoiceNotFound
+		}
+		return "", err
+	}
+
+	if !canChangeInvoice(from, to) {
+		return from, ErrInvoiceNotEditable
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE invoices SET status = ?, updated_at = ? WHERE id = ?", to, time.Now().Format(time.DateTime), id)
+	return from, err
+}
+
+func (r *InvoiceRepository) Issue(ctx context.Context, id string) (*Invoice, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := r.changeStatus(ctx, tx, id, InvoiceOpen); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	number, err := nextInvoiceNumber(ctx, tx, now)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE invoices SET number = ?, issued_at = ? WHERE id = ?", number, now.Format(time.DateTime), id)
+	if err != nil {
+		return nil, err
+	}
+
+	inv, err := r.getForUpdate(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := postIssued(ctx, tx, inv); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return r.Get(ctx, id)
+}
+
+func (r *InvoiceRepository) Void(ctx context.Context, id, reason string) (*Invoice, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := r.voidTx(ctx, tx, id, reason); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return r.Get(ctx, id)
+}
+
+func (r *InvoiceRepository) voidTx(ctx context.Context, tx *sql.Tx, id, reason string) error {
+	from, err := r.changeStatus(ctx, tx, id, InvoiceVoid)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE invoices SET void_reason = ?, voided_at = ? WHERE id = ?", database.Truncate(reason, 255), time.Now().Format(time.DateTime), id)
+	if err != nil {
+		return err
+	}
+
+	if err := restoreServiceCouponsTx(ctx, tx, id); err != nil {
+		return err
+	}
+
+	// Rascunho nunca foi lançado, a fatura emitida desfaz o que não foi pago
+	if from == InvoiceDraft {
+		return nil
+	}
+
+	inv, err := r.getForUpdate(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	unpaid, err := inv.Total.Sub(inv.AmountPaid)
+	if err != nil {
+		return err
+	}
+
+	_, err = finances.PostTx(ctx, tx, finances.InvoiceVoided(inv.Id, inv.OwnerId, inv.Number, unpaid))
+	return err
+}
+
+func (r *InvoiceRepository) setItemPeriodTx(ctx context.Context, tx *sql.Tx, item *InvoiceItem) error {
+	_, err := tx.ExecContext(ctx, "UPDATE invoice_items SET period_start = ?, period_end = ? WHERE id = ?", item.PeriodStart, item.PeriodEnd, item.Id)
+	return err
+}
+
+// applyPaymentTx soma o valor recebido na fatura e marca como paga quando
+// ela é quitada, settled indica que ela foi quitada agora. O que passar do
+// total, ou tudo se a fatura já não estiver em aberto, volta como excedente
+func (r *InvoiceRepository) applyPaymentTx(ctx context.Context, tx *sql.Tx, id string, amount money.Money) (inv *Invoice, excess money.Money, settled bool, err error) {
+	inv, err = r.getForUpdate(ctx, tx, id)
+	if err != nil {
+		return nil, excess, false, err
+	}
+
+	if inv.Status != InvoiceOpen && inv.Status != InvoiceOverdue {
+		return inv, amount, false, r.loadItemsTx(ctx, tx, inv)
+	}
+
+	paid, err := inv.AmountPaid.Add(amount)
+	if err != nil {
+		return nil, excess, false, err
+	}
+
+	excess = money.New(0, amount.Currency())
+	now := time.Now().Format(time.DateTime)
+
+	if paid.Compare(inv.Total) >= 0 {
+		if excess, err = paid.Sub(inv.Total); err != nil {
+			return nil, excess, false, err
+		}
+
+		settled = true
+		inv.Status = InvoicePaid
+		inv.AmountPaid = inv.Total
+		inv.PaidAt = now
+	} else {
+		inv.AmountPaid = paid
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE invoices SET status = ?, amount_paid = ?, paid_at = ?, updated_at = ? WHERE id = ?", inv.Status, inv.AmountPaid, nullable(inv.PaidAt), now, id)
+	if err != nil {
+		return nil, excess, false, err
+	}
+
+	inv.UpdatedAt = now
+	return inv, excess, settled, r.loadItemsTx(ctx, tx, inv)
+}
+
+// GenerateInvoice emite uma fatura com um item para cada serviço, usando
+// o preço atual do serviço. Cada item cobre o próximo ciclo depois do
+// vencimento atual, e pagar a fatura renova o serviço para esse período
+func (r *InvoiceRepository) GenerateInvoice(ctx context.Context, ownerId string, services []account.Services, dueDate time.Time) (*Invoice, error) {
+	inv, err := serviceInvoice(ownerId, services, dueDate)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.Create(ctx, inv); err != nil {
+		return nil, err
+	}
+
+	return inv, nil
+}
+
+// serviceInvoice monta, sem salvar, a fatura de renovação dos serviços
+func serviceInvoice(ownerId string, services []account.Services, dueDate time.Time) (*Invoice, error) {
+	inv := &Invoice{
+		OwnerId: ownerId,
+		Status:  InvoiceOpen,
+		DueDate: dueDate.Format(time.DateTime),
+	}
+
+	for _, service := range services {
+		if service.OwnerId != ownerId {
+			return nil, fmt.Errorf("service %s does not belong to %s", service.Id, ownerId)
+		}
+
+		item := InvoiceItem{
+			ServiceId:   service.Id,
+			Kind:        ItemService,
+			Description: service.Name,
+			Quantity:    1,
+			UnitPrice:   service.Price,
+		}
+
+		if start, err := time.ParseInLocation(time.DateTime, service.Date, time.Local); err == nil {
+			item.PeriodStart = start.Format(time.DateTime)
+			item.PeriodEnd = catalog.CycleOrMonthly(service.Cycle).RenewalEnd(start, service.AnchorDay).Format(time.DateTime)
+		}
+
+		inv.Items = append(inv.Items, item)
+	}
+
+	return inv, nil
+}
@@ -0,0 +1,36 @@
+package billing
+
+import (
+	"prodata/database/account"
+	"prodata/money"
+	"testing"
+	"time"
+)
+
+func TestServiceInvoiceAnchor(t *testing.T) {
+	tests := []struct {
+		name   string
+		cycle  string
+		anchor int
+		date   string
+		end    string
+	}{
+		{"volta ao dia 31", "monthly", 31, "2024-02-29 10:00:00", "2024-03-31 10:00:00"},
+		{"corta em abril", "monthly", 31, "2024-03-31 10:00:00", "2024-04-30 10:00:00"},
+		{"trimestral", "quarterly", 30, "2024-11-30 10:00:00", "2025-02-28 10:00:00"},
+		{"serviço antigo sem dia", "", 0, "2024-02-29 10:00:00", "2024-03-29 10:00:00"},
+	}
+
+	for _, tt := range tests {
+		service := account.Services{Id: "s", OwnerId: "o", Name: "VPS", Price: money.FromCents(1000), Cycle: tt.cycle, AnchorDay: tt.anchor, Date: tt.date}
+
+		inv, err := serviceInvoice("o", []account.Services{service}, time.Now())
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if item := inv.Items[0]; item.PeriodStart != tt.date || item.PeriodEnd != tt.end {
+			t.Errorf("%s: período = %s a %s, want %s a %s", tt.name, item.PeriodStart, item.PeriodEnd, tt.date, tt.end)
+		}
+	}
+}
@@ -0,0 +1,517 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"prodata/bank"
+	"prodata/catalog"
+	"prodata/database/account"
+	"prodata/finances"
+	"prodata/logs"
+	"prodata/money"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type OrderStatus string
+
+const (
+	OrderPending   OrderStatus = "pending"
+	OrderPaid      OrderStatus = "paid"
+	OrderCancelled OrderStatus = "cancelled"
+	OrderExpired   OrderStatus = "expired"
+)
+
+var (
+	ErrOrderNotFound   = errors.New("order not found")
+	ErrOrderNotPending = errors.New("order can only be cancelled before it is paid")
+	ErrInvalidOrder    = errors.New("invalid order")
+)
+
+// Order é a compra feita pelo cliente. Cada item vira um serviço pending e
+// uma linha da fatura, pagar a fatura ativa os serviços
+type Order struct {
+	Id           string      `json:"id"`
+	OwnerId      string      `json:"owner_id"`
+	Status       OrderStatus `json:"status"`
+	InvoiceId    string      `json:"invoice_id"`
+	CouponCode   string      `json:"coupon_code,omitempty"`
+	Total        money.Money `json:"total"`
+	ExpiresAt    string      `json:"expires_at"`
+	CancelReason string      `json:"cancel_reason,omitempty"`
+	PaidAt       string      `json:"paid_at,omitempty"`
+	CancelledAt  string      `json:"cancelled_at,omitempty"`
+	CreatedAt    string      `json:"created_at"`
+	UpdatedAt    string      `json:"updated_at"`
+	Items        []OrderItem `json:"items"`
+}
+
+type OrderItem struct {
+	Id          int64                    `json:"id"`
+	PlanId      string                   `json:"plan_id"`
+	ServiceId   string                   `json:"service_id,omitempty"`
+	Cycle       catalog.BillingCycle     `json:"cycle"`
+	Description string                   `json:"description"`
+	Price       money.Money              `json:"price"`
+	Options     []catalog.SelectedOption `json:"options"`
+}
+
+// OrderRequest é o carrinho mandado pelo cliente. Options leva o id do valor
+// escolhido para cada id de opção do plano
+type OrderRequest struct {
+	Items []struct {
+		PlanId  string               `json:"plan_id"`
+		Cycle   catalog.BillingCycle `json:"cycle"`
+		Options map[string]string    `json:"options"`
+	} `json:"items"`
+	Coupon string `json:"coupon"`
+}
+
+type OrderConfig struct {
+	// Quanto tempo o pedido espera o pagamento
+	Expiration time.Duration
+	Interval   time.Duration
+	BatchSize  int
+	MaxItems   int
+}
+
+func OrderConfigFromEnv() OrderConfig {
+	expiration, err := time.ParseDuration(os.Getenv("ORDER_EXPIRATION"))
+	if err != nil || expiration <= 0 {
+		expiration = 72 * time.Hour
+	}
+
+	interval, err := time.ParseDuration(os.Getenv("ORDER_EXPIRE_INTERVAL"))
+	if err != nil || interval <= 0 {
+		interval = 10 * time.Minute
+	}
+
+	return OrderConfig{
+		Expiration: expiration,
+		Interval:   interval,
+		BatchSize:  50,
+		MaxItems:   10,
+	}
+}
+
+// Orders transforma o carrinho em pedido, fatura e serviços pending, e
+// cancela ou expira os pedidos que não foram pagos. Quem marca o pedido como
+// pago é a conciliação, junto com a fatura
+type Orders struct {
+	db         *sql.DB
+	orders     *OrderRepository
+	invoices   *InvoiceRepository
+	payments   *PaymentRepository
+	coupons    *Coupons
+	catalog    *catalog.Repository
+	services   *account.ServiceRepository
+	lifecycle  *account.ServiceLifecycle
+	wallet     *WalletRepository
+	gateway    bank.PaymentGateway
+	reconciler *Reconciler
+	clock      finances.Clock
+	config     OrderConfig
+}
+
+func NewOrders(db *sql.DB, orders *OrderRepository, invoices *InvoiceRepository, payments *PaymentRepository, coupons *Coupons, catalogRepo *catalog.Repository, services *account.ServiceRepository, lifecycle *account.ServiceLifecycle, wallet *WalletRepository, gateway bank.PaymentGateway, reconciler *Reconciler, clock finances.Clock, config OrderConfig) *Orders {
+	if clock == nil {
+		clock = finances.SystemClock
+	}
+
+	return &Orders{
+		db:         db,
+		orders:     orders,
+		invoices:   invoices,
+		payments:   payments,
+		coupons:    coupons,
+		catalog:    catalogRepo,
+		services:   services,
+		lifecycle:  lifecycle,
+		wallet:     wallet,
+		gateway:    gateway,
+		reconciler: reconciler,
+		clock:      clock,
+		config:     config,
+	}
+}
+
+// itemDescription junta o nome do plano com as opções escolhidas
+func itemDescription(name string, options []catalog.SelectedOption) string {
+	if len(options) == 0 {
+		return name
+	}
+
+	chosen := make([]string, 0, len(options))
+	for _, option := range options {
+		chosen = append(chosen, option.Name+": "+option.Label)
+	}
+
+	return name + " (" + strings.Join(chosen, ", ") + ")"
+}
+
+// Create reserva os planos, cria os serviços pending e a fatura do pedido
+// numa transação só, com o cupom já aplicado. Pedido de valor zero é quitado
+// na hora
+func (o *Orders) Create(ctx context.Context, ownerId string, request OrderRequest) (*Order, error) {
+	if len(request.Items) == 0 {
+		return nil, fmt.Errorf("%w: choose at least one plan", ErrInvalidOrder)
+	}
+
+	if len(request.Items) > o.config.MaxItems {
+		return nil, fmt.Errorf("%w: at most %d plans per order", ErrInvalidOrder, o.config.MaxItems)
+	}
+
+	tx, err := o.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := o.clock.Now()
+	order := &Order{
+		Id:         uuid.New().String(),
+		OwnerId:    ownerId,
+		Status:     OrderPending,
+		CouponCode: normalizeCode(request.Coupon),
+		ExpiresAt:  now.Add(o.config.Expiration).Format(time.DateTime),
+		CreatedAt:  now.Format(time.DateTime),
+	}
+	order.UpdatedAt = order.CreatedAt
+
+	inv := &Invoice{
+		OwnerId: ownerId,
+		Status:  InvoiceOpen,
+		DueDate: order.ExpiresAt,
+		Notes:   "Pedido " + order.Id,
+	}
+
+	for _, requested := range request.Items {
+		cycle := requested.Cycle
+		if cycle == "" {
+			cycle = catalog.CycleMonthly
+		}
+
+		if !cycle.Valid() {
+			return nil, fmt.Errorf("%w: invalid billing cycle %s", ErrInvalidOrder, cycle)
+		}
+
+		plan, err := o.catalog.ReservePlan(ctx, tx, requested.PlanId, cycle)
+		if err != nil {
+			return nil, err
+		}
+
+		options, err := o.catalog.ReserveOptions(ctx, tx, requested.PlanId, requested.Options)
+		if err != nil {
+			return nil, err
+		}
+
+		// As opções têm preço mensal, o item cobra o ciclo inteiro
+		price := plan.Price
+		for i := range options {
+			if options[i].Price, err = options[i].Price.Multiply(int64(cycle.Months())); err != nil {
+				return nil, err
+			}
+			if price, err = price.Add(options[i].Price); err != nil {
+				return nil, err
+			}
+		}
+
+		// O serviço fica sem período até o pagamento, a conciliação começa o
+		// primeiro período e o dia de vencimento na data em que a fatura é paga
+		service := &account.Services{
+			OwnerId: ownerId,
+			PlanId:  plan.PlanId,
+			Name:    plan.Name,
+			Price:   price,
+			Type:    plan.Type,
+			Cycle:   string(cycle),
+		}
+
+		if err := o.services.CreateTx(ctx, tx, service); err != nil {
+			return nil, err
+		}
+
+		description := itemDescription(plan.Name, options)
+
+		inv.Items = append(inv.Items, InvoiceItem{
+			ServiceId:   service.Id,
+			Kind:        ItemService,
+			Description: description,
+			Quantity:    1,
+			UnitPrice:   price,
+			PeriodStart: now.Format(time.DateTime),
+			PeriodEnd:   cycle.PeriodEnd(now).Format(time.DateTime),
+		})
+
+		order.Items = append(order.Items, OrderItem{
+			PlanId:      plan.PlanId,
+			ServiceId:   service.Id,
+			Cycle:       cycle,
+			Description: description,
+			Price:       price,
+			Options:     options,
+		})
+	}
+
+	if err := o.coupons.CreateInvoiceTx(ctx, tx, inv, order.CouponCode); err != nil {
+		return nil, err
+	}
+
+	order.InvoiceId = inv.Id
+	order.Total = inv.Total
+
+	if err := o.orders.createTx(ctx, tx, order); err != nil {
+		return nil, err
+	}
+
+	// Sem valor a pagar o pedido passa pela conciliação com um pagamento
+	// zerado, que quita a fatura e ativa os serviços como qualquer outro
+	var free *Payment
+	if !inv.Total.IsPositive() {
+		free = &Payment{
+			Id:        uuid.New().String(),
+			InvoiceId: inv.Id,
+			OwnerId:   ownerId,
+			Provider:  ProviderWallet,
+			Method:    MethodCredit,
+			Status:    PaymentPending,
+			Amount:    inv.Total,
+		}
+		free.ProviderPaymentId = free.Id
+
+		if err := o.payments.CreateTx(ctx, tx, free); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	if free != nil {
+		if err := o.reconciler.Reconcile(ctx, free.Provider, free.ProviderPaymentId, PaymentApproved, "", free.Amount); err != nil {
+			return nil, err
+		}
+	}
+
+	return o.orders.Get(ctx, order.Id)
+}
+
+// Cancel cancela o pedido ainda não pago a pedido do cliente ou de um admin
+func (o *Orders) Cancel(ctx context.Context, id, reason string) (*Order, error) {
+	if reason == "" {
+		reason = "Pedido cancelado"
+	}
+
+	return o.close(ctx, id, OrderCancelled, reason)
+}
+
+// close cancela as cobranças pendentes, anula a fatura, devolve o cupom e
+// põe na carteira o que já tinha sido pago. Os serviços pending são
+// cancelados depois do commit
+func (o *Orders) close(ctx context.Context, id string, status OrderStatus, reason string) (*Order, error) {
+	order, err := o.orders.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := o.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Mesma ordem da conciliação, fatura antes do pedido
+	inv, err := o.invoices.getForUpdate(ctx, tx, order.InvoiceId)
+	if err != nil {
+		return nil, err
+	}
+
+	if order, err = o.orders.getForUpdate(ctx, tx, id); err != nil {
+		return nil, err
+	}
+
+	if order.Status != OrderPending || inv.Status == InvoicePaid {
+		return nil, ErrOrderNotPending
+	}
+
+	now := o.clock.Now()
+	hold, err := o.cancelCharges(ctx, tx, inv, status, now)
+	if err != nil {
+		return nil, err
+	}
+
+	// O pedido passa a vencer junto com a cobrança que ainda vale
+	if !hold.IsZero() {
+		if err := o.orders.postponeTx(ctx, tx, order.Id, hold); err != nil {
+			return nil, err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+
+		return nil, errStillPayable
+	}
+
+	if inv.Status != InvoiceVoid {
+		if err := o.invoices.voidTx(ctx, tx, inv.Id, reason); err != nil {
+			return nil, err
+		}
+	}
+
+	if inv.AmountPaid.IsPositive() {
+		_, err = o.wallet.addTx(ctx, tx, &WalletEntry{
+			OwnerId:     order.OwnerId,
+			Source:      WalletCancellation,
+			Amount:      inv.AmountPaid,
+			InvoiceId:   inv.Id,
+			Reference:   order.Id,
+			Description: "Valor pago na fatura " + inv.Number + " do pedido cancelado",
+			Actor:       SystemActor,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	serviceIds := make([]string, 0, len(order.Items))
+	for _, item := range order.Items {
+		if item.ServiceId != "" {
+			serviceIds = append(serviceIds, item.ServiceId)
+		}
+	}
+
+	if err := o.coupons.coupons.releaseTx(ctx, tx, inv.Id, serviceIds); err != nil {
+		return nil, err
+	}
+
+	if err := o.orders.closeTx(ctx, tx, order.Id, status, reason, now); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	for _, serviceId := range serviceIds {
+		_, err := o.lifecycle.Cancel(ctx, serviceId, reason)
+		if err != nil && !errors.Is(err, account.ErrInvalidTransition) && !errors.Is(err, account.ErrServiceNotFound) {
+			logs.NewSistemLogger().LogAndSendSystemMessage("orders: cancel service " + serviceId + ": " + err.Error())
+		}
+	}
+
+	return o.orders.Get(ctx, order.Id)
+}
+
+// errStillPayable avisa que o pedido tem uma cobrança que ainda pode ser paga
+// e por isso não expira agora
+var errStillPayable = errors.New("order has a charge that can still be paid")
+
+// cancelCharges resolve as cobranças pendentes da fatura antes de anular. Uma
+// cobrança que ainda vale é cancelada no gateway quando o cliente desiste.
+// Na expiração ela segura o pedido, hold é até quando
+func (o *Orders) cancelCharges(ctx context.Context, tx *sql.Tx, inv *Invoice, status OrderStatus, now time.Time) (hold time.Time, err error) {
+	processing, err := o.payments.CountByStatusTx(ctx, tx, inv.Id, PaymentProcessing)
+	if err != nil {
+		return hold, err
+	}
+
+	if processing > 0 {
+		return hold, ErrPaymentProcessing
+	}
+
+	// Uma cobrança sendo criada agora ainda pode aparecer no gateway
+	if err := o.payments.releaseStaleTx(ctx, tx, inv.Id, now.Add(-creatingTimeout)); err != nil {
+		return hold, err
+	}
+
+	creating, err := o.payments.CountByStatusTx(ctx, tx, inv.Id, PaymentCreating)
+	if err != nil {
+		return hold, err
+	}
+
+	if creating > 0 {
+		return hold, ErrPaymentInProgress
+	}
+
+	for _, method := range []PaymentMethod{MethodPix, MethodBoleto, MethodCard, MethodCredit} {
+		pending, err := o.payments.PendingTx(ctx, tx, inv.Id, method)
+		if err != nil {
+			return hold, err
+		}
+
+		for _, p := range pending {
+			// Saldo debitado numa conciliação que caiu no meio, o próximo
+			// pagamento termina de aplicar
+			if method == MethodCredit {
+				return hold, ErrPaymentProcessing
+			}
+
+			expiresAt, err := time.ParseInLocation(time.DateTime, p.ExpiresAt, time.Local)
+			if err == nil && !expiresAt.After(now) {
+				if err := o.payments.UpdateStatusTx(ctx, tx, p.Id, PaymentExpired, ""); err != nil {
+					return hold, err
+				}
+				continue
+			}
+
+			if status == OrderExpired {
+				if err == nil && expiresAt.After(hold) {
+					hold = expiresAt
+				}
+				continue
+			}
+
+			if p.DueDate != "" {
+				// Boleto vencido dentro do prazo de compensação pode já ter
+				// sido pago
+				dueDate, err := time.ParseInLocation(time.DateOnly, p.DueDate, time.Local)
+				if err == nil && !dueDate.AddDate(0, 0, 1).After(now) {
+					return hold, ErrPaymentProcessing
+				}
+			}
+
+			if _, err := o.gateway.Cancel(ctx, p.ProviderPaymentId); err != nil {
+				return hold, fmt.Errorf("cancel payment %s: %w", p.ProviderPaymentId, err)
+			}
+
+			if err := o.payments.UpdateStatusTx(ctx, tx, p.Id, PaymentCancelled, "order cancelled"); err != nil {
+				return hold, err
+			}
+		}
+	}
+
+	return hold, nil
+}
+
+// Run expira a cada Interval os pedidos que não foram pagos no prazo
+func (o *Orders) Run(ctx context.Context) {
+	every(ctx, o.config.Interval, "orders", o.RunOnce)
+}
+
+// RunOnce expira os pedidos pending que passaram do prazo. Pedido com PIX ou
+// boleto ainda válido espera a cobrança vencer
+func (o *Orders) RunOnce(ctx context.Context) error {
+	ids, err := o.orders.expiredIds(ctx, o.clock.Now(), o.config.BatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		_, err := o.close(ctx, id, OrderExpired, "Pedido não pago no prazo")
+		if errors.Is(err, errStillPayable) || errors.Is(err, ErrPaymentProcessing) || errors.Is(err, ErrPaymentInProgress) || errors.Is(err, ErrOrderNotPending) {
+			continue
+		}
+		if err != nil {
+			logs.NewSistemLogger().LogAndSendSystemMessage("orders: expire " + id + ": " + err.Error())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,270 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"prodata/catalog"
+	"prodata/database"
+	"strings"
+	"time"
+)
+
+type OrderRepository struct {
+	db *sql.DB
+}
+
+func NewOrderRepository(db *sql.DB) *OrderRepository {
+	return &OrderRepository{db: db}
+}
+
+const orderColumns = "id, owner_uuid, status, invoice_id, coupon_code, total, expires_at, cancel_reason, paid_at, cancelled_at, created_at, updated_at"
+
+func scanOrder(row interface{ Scan(dest ...any) error }) (*Order, error) {
+	var o Order
+	var paidAt, cancelledAt sql.NullString
+
+	err := row.Scan(
+		&o.Id,
+		&o.OwnerId,
+		&o.Status,
+		&o.InvoiceId,
+		&o.CouponCode,
+		&o.Total,
+		&o.ExpiresAt,
+		&o.CancelReason,
+		&paidAt,
+		&cancelledAt,
+		&o.CreatedAt,
+		&o.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	o.PaidAt = paidAt.String
+	o.CancelledAt = cancelledAt.String
+	o.Items = []OrderItem{}
+
+	return &o, nil
+}
+
+func (r *OrderRepository) createTx(ctx context.Context, tx *sql.Tx, o *Order) error {
+	_, err := tx.ExecContext(ctx, "INSERT INTO orders (id, owner_uuid, status, invoice_id, coupon_code, total, expires_at, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		o.Id,
+		o.OwnerId,
+		o.Status,
+		o.InvoiceId,
+		o.CouponCode,
+		o.Total,
+		o.ExpiresAt,
+		o.CreatedAt,
+		o.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	for i := range o.Items {
+		item := &o.Items[i]
+
+		result, err := tx.ExecContext(ctx, "INSERT INTO order_items (order_id, plan_id, service_id, cycle, description, price) VALUES (?, ?, ?, ?, ?, ?)",
+			o.Id, item.PlanId, nullable(item.ServiceId), item.Cycle, database.Truncate(item.Description, 255), item.Price)
+		if err != nil {
+			return err
+		}
+
+		if item.Id, err = result.LastInsertId(); err != nil {
+			return err
+		}
+
+		for _, option := range item.Options {
+			_, err := tx.ExecContext(ctx, "INSERT INTO order_item_options (order_item_id, option_id, value_id, name, label, price) VALUES (?, ?, ?, ?, ?, ?)",
+				item.Id, option.OptionId, option.ValueId, option.Name, option.Label, option.Price)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r *OrderRepository) loadItems(ctx context.Context, q querier, o *Order) error {
+	rows, err := q.QueryContext(ctx, "SELECT id, plan_id, service_id, cycle, description, price FROM order_items WHERE order_id = ? ORDER BY id", o.Id)
+	if err != nil {
+		return err
+	}
+
+	index := map[int64]int{}
+	for rows.Next() {
+		var item OrderItem
+		var serviceId sql.NullString
+
+		if err := rows.Scan(&item.Id, &item.PlanId, &serviceId, &item.Cycle, &item.Description, &item.Price); err != nil {
+			rows.Close()
+			return err
+		}
+
+		item.ServiceId = serviceId.String
+		item.Options = []catalog.SelectedOption{}
+		index[item.Id] = len(o.Items)
+		o.Items = append(o.Items, item)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil || len(o.Items) == 0 {
+		return err
+	}
+
+	rows, err = q.QueryContext(ctx, "SELECT io.order_item_id, io.option_id, io.value_id, io.name, io.label, io.price FROM order_item_options io JOIN order_items i ON i.id = io.order_item_id WHERE i.order_id = ? ORDER BY io.order_item_id", o.Id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var itemId int64
+		var option catalog.SelectedOption
+
+		if err := rows.Scan(&itemId, &option.OptionId, &option.ValueId, &option.Name, &option.Label, &option.Price); err != nil {
+			return err
+		}
+
+		if i, ok := index[itemId]; ok {
+			o.Items[i].Options = append(o.Items[i].Options, option)
+		}
+	}
+
+	return rows.Err()
+}
+
+func (r *OrderRepository) Get(ctx context.Context, id string) (*Order, error) {
+	o, err := scanOrder(r.db.QueryRowContext(ctx, "SELECT "+orderColumns+" FROM orders WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrOrderNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return o, r.loadItems(ctx, r.db, o)
+}
+
+func (r *OrderRepository) GetForOwner(ctx context.Context, ownerId, id string) (*Order, error) {
+	o, err := r.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.OwnerId != ownerId {
+		return nil, ErrOrderNotFound
+	}
+
+	return o, nil
+}
+
+func (r *OrderRepository) getForUpdate(ctx context.Context, tx *sql.Tx, id string) (*Order, error) {
+	o, err := scanOrder(tx.QueryRowContext(ctx, "SELECT "+orderColumns+" FROM orders WHERE id = ? FOR UPDATE", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrOrderNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return o, r.loadItems(ctx, tx, o)
+}
+
+type OrderFilter struct {
+	OwnerId string
+	Status  OrderStatus
+	Limit   int
+	Offset  int
+}
+
+// List não carrega os itens, use Get para ver o pedido completo
+func (r *OrderRepository) List(ctx context.Context, filter OrderFilter) ([]Order, int, error) {
+	where := []string{"1 = 1"}
+	args := []any{}
+
+	if filter.OwnerId != "" {
+		where = append(where, "owner_uuid = ?")
+		args = append(args, filter.OwnerId)
+	}
+
+	if filter.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, filter.Status)
+	}
+
+	clause := strings.Join(where, " AND ")
+
+	var total int
+	err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM orders WHERE "+clause, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := "SELECT " + orderColumns + " FROM orders WHERE " + clause + " ORDER BY created_at DESC, id"
+	if filter.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, filter.Limit, filter.Offset)
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	orders := []Order{}
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		orders = append(orders, *o)
+	}
+
+	return orders, total, rows.Err()
+}
+
+func (r *OrderRepository) closeTx(ctx context.Context, tx *sql.Tx, id string, status OrderStatus, reason string, now time.Time) error {
+	_, err := tx.ExecContext(ctx, "UPDATE orders SET status = ?, cancel_reason = ?, cancelled_at = ?, updated_at = ? WHERE id = ?",
+		status, database.Truncate(reason, 255), now.Format(time.DateTime), now.Format(time.DateTime), id)
+	return err
+}
+
+func (r *OrderRepository) postponeTx(ctx context.Context, tx *sql.Tx, id string, until time.Time) error {
+	_, err := tx.ExecContext(ctx, "UPDATE orders SET expires_at = ?, updated_at = ? WHERE id = ?",
+		until.Format(time.DateTime), time.Now().Format(time.DateTime), id)
+	return err
+}
+
+func (r *OrderRepository) expiredIds(ctx context.Context, now time.Time, limit int) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id FROM orders WHERE status = ? AND expires_at <= ? ORDER BY expires_at LIMIT ?",
+		OrderPending, now.Format(time.DateTime), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// markOrderPaidTx roda na conciliação quando a fatura é quitada. Faturas que
+// não são de pedido não mudam nada
+func markOrderPaidTx(ctx context.Context, tx *sql.Tx, invoiceId string) error {
+	now := time.Now().Format(time.DateTime)
+	_, err := tx.ExecContext(ctx, "UPDATE orders SET status = ?, paid_at = ?, updated_at = ? WHERE invoice_id = ? AND status = ?",
+		OrderPaid, now, now, invoiceId, OrderPending)
+	return err
+}
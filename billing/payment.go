@@ -0,0 +1,113 @@
+package billing
+
+import (
+	"errors"
+	"prodata/bank"
+	"prodata/money"
+)
+
+type PaymentStatus string
+
+const (
+	// Gravado antes de chamar o gateway. Até ele responder o
+	// provider_payment_id é o próprio id do pagamento
+	PaymentCreating    PaymentStatus = "creating"
+	PaymentPending     PaymentStatus = "pending"
+	PaymentProcessing  PaymentStatus = "processing"
+	PaymentApproved    PaymentStatus = "approved"
+	PaymentRejected    PaymentStatus = "rejected"
+	PaymentCancelled   PaymentStatus = "cancelled"
+	PaymentExpired     PaymentStatus = "expired"
+	PaymentRefunded    PaymentStatus = "refunded"
+	PaymentChargedBack PaymentStatus = "charged_back"
+)
+
+// status_detail do pagamento que ficou em criação e foi dado como não criado
+const detailNotCreated = "not_created"
+
+type PaymentMethod string
+
+const (
+	MethodPix    PaymentMethod = "pix"
+	MethodBoleto PaymentMethod = "boleto"
+	MethodCard   PaymentMethod = "card"
+	// Pago com o saldo da conta, sempre com o provider wallet
+	MethodCredit PaymentMethod = "credit"
+)
+
+var (
+	ErrPaymentNotFound   = errors.New("payment not found")
+	ErrInvoiceNotPayable = errors.New("invoice is not open for payment")
+	ErrInvalidDocument   = errors.New("document must be a CPF or CNPJ")
+	ErrPaymentProcessing = errors.New("a payment for this invoice is waiting for bank compensation")
+	ErrPaymentInProgress = errors.New("a payment for this invoice is already being created")
+	ErrPaymentNotStored  = errors.New("gateway payment for one of our invoices is not stored yet")
+)
+
+// Converte o status do gateway para o nosso. in_process e o boleto pago que
+// espera a compensação do banco viram processing, o cliente já pagou mas o
+// dinheiro ainda não caiu. O boleto que venceu sem pagamento vira expired
+func PaymentStatusFromProvider(status, detail string) PaymentStatus {
+	switch status {
+	case bank.StatusApproved:
+		return PaymentApproved
+	case bank.StatusInProcess:
+		return PaymentProcessing
+	case bank.StatusRejected:
+		return PaymentRejected
+	case bank.StatusCancelled:
+		if detail == bank.DetailExpired {
+			return PaymentExpired
+		}
+		return PaymentCancelled
+	case bank.StatusRefunded:
+		return PaymentRefunded
+	case bank.StatusChargedBack:
+		return PaymentChargedBack
+	}
+
+	if detail == bank.DetailWaitingTransfer {
+		return PaymentProcessing
+	}
+
+	return PaymentPending
+}
+
+type Payment struct {
+	Id                string        `json:"id"`
+	InvoiceId         string        `json:"invoice_id"`
+	OwnerId           string        `json:"-"`
+	Provider          string        `json:"provider"`
+	ProviderPaymentId string        `json:"provider_payment_id"`
+	Method            PaymentMethod `json:"method"`
+	Status            PaymentStatus `json:"status"`
+	StatusDetail      string        `json:"status_detail,omitempty"`
+	Amount            money.Money   `json:"amount"`
+	QRCode            string        `json:"qr_code,omitempty"`
+	QRCodeBase64      string        `json:"qr_code_base64,omitempty"`
+	TicketURL         string        `json:"ticket_url,omitempty"`
+	BarcodeLine       string        `json:"barcode_line,omitempty"`
+	DueDate           string        `json:"due_date,omitempty"`
+	ExpiresAt         string        `json:"expires_at,omitempty"`
+	CreatedAt         string        `json:"created_at"`
+	UpdatedAt         string        `json:"updated_at"`
+}
+
+// applyGateway copia a cobrança do gateway para o pagamento e devolve o status
+// dela. Aprovado fica pendente, quem aplica a aprovação é a conciliação
+func (p *Payment) applyGateway(response *bank.Payment) PaymentStatus {
+	p.ProviderPaymentId = response.Id
+	p.StatusDetail = response.StatusDetail
+	p.QRCode = response.QRCode
+	p.QRCodeBase64 = response.QRCodeBase64
+	p.TicketURL = response.TicketURL
+	p.BarcodeLine = response.BarcodeLine
+
+	status := PaymentStatusFromProvider(response.Status, response.StatusDetail)
+	p.Status = status
+	if status == PaymentApproved {
+		p.Status = PaymentPending
+	}
+
+	return status
+}
@@ -0,0 +1,26 @@
+package billing
+
+import "errors"
+
+var (
+	ErrPaymentMethodNotFound = errors.New("payment method not found")
+	ErrNoPaymentMethod       = errors.New("no saved payment method")
+	ErrCardRequired          = errors.New("card token or saved payment method is required")
+)
+
+// SavedPaymentMethod é um cartão guardado no gateway. CustomerId e CardId são
+// os ids do gateway e nunca saem da API
+type SavedPaymentMethod struct {
+	Id              string `json:"id"`
+	OwnerId         string `json:"-"`
+	Provider        string `json:"provider"`
+	CustomerId      string `json:"-"`
+	CardId          string `json:"-"`
+	Brand           string `json:"brand"`
+	LastFour        string `json:"last_four"`
+	ExpirationMonth int    `json:"expiration_month"`
+	ExpirationYear  int    `json:"expiration_year"`
+	HolderName      string `json:"holder_name"`
+	IsDefault       bool   `json:"is_default"`
+	CreatedAt       string `json:"created_at"`
+}
@@ -0,0 +1,205 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type PaymentMethodRepository struct {
+	db *sql.DB
+}
+
+func NewPaymentMethodRepository(db *sql.DB) *PaymentMethodRepository {
+	return &PaymentMethodRepository{db: db}
+}
+
+const paymentMethodColumns = "id, owner_uuid, provider, customer_id, card_id, brand, last_four, expiration_month, expiration_year, holder_name, is_default, created_at"
+
+func scanPaymentMethod(row interface{ Scan(dest ...any) error }) (*SavedPaymentMethod, error) {
+	var m SavedPaymentMethod
+	err := row.Scan(
+		&m.Id,
+		&m.OwnerId,
+		&m.Provider,
+		&m.CustomerId,
+		&m.CardId,
+		&m.Brand,
+		&m.LastFour,
+		&m.ExpirationMonth,
+		&m.ExpirationYear,
+		&m.HolderName,
+		&m.IsDefault,
+		&m.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// Create salva o cartão, o primeiro do usuário já vira o padrão
+func (r *PaymentMethodRepository) Create(ctx context.Context, m *SavedPaymentMethod) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var count int
+	err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM payment_methods WHERE owner_uuid = ? FOR UPDATE", m.OwnerId).Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	if m.Id == "" {
+		m.Id = uuid.New().String()
+	}
+	m.IsDefault = count == 0
+	m.CreatedAt = time.Now().Format(time.DateTime)
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO payment_methods ("+paymentMethodColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		m.Id,
+		m.OwnerId,
+		m.Provider,
+		m.CustomerId,
+		m.CardId,
+		m.Brand,
+		m.LastFour,
+		m.ExpirationMonth,
+		m.ExpirationYear,
+		m.HolderName,
+		m.IsDefault,
+		m.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *PaymentMethodRepository) ListByOwner(ctx context.Context, ownerId string) ([]SavedPaymentMethod, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT "+paymentMethodColumns+" FROM payment_methods WHERE owner_uuid = ? ORDER BY is_default DESC, created_at DESC", ownerId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	methods := []SavedPaymentMethod{}
+	for rows.Next() {
+		m, err := scanPaymentMethod(rows)
+		if err != nil {
+			return nil, err
+		}
+		methods = append(methods, *m)
+	}
+
+	return methods, rows.Err()
+}
+
+func (r *PaymentMethodRepository) GetForOwner(ctx context.Context, ownerId, id string) (*SavedPaymentMethod, error) {
+	m, err := scanPaymentMethod(r.db.QueryRowContext(ctx, "SELECT "+paymentMethodColumns+" FROM payment_methods WHERE id = ? AND owner_uuid = ?", id, ownerId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPaymentMethodNotFound
+	}
+
+	return m, err
+}
+
+// Default devolve o cartão padrão do usuário, usado nas cobranças automáticas
+func (r *PaymentMethodRepository) Default(ctx context.Context, ownerId string) (*SavedPaymentMethod, error) {
+	m, err := scanPaymentMethod(r.db.QueryRowContext(ctx, "SELECT "+paymentMethodColumns+" FROM payment_methods WHERE owner_uuid = ? AND is_default = 1", ownerId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNoPaymentMethod
+	}
+
+	return m, err
+}
+
+// CustomerId devolve o cliente que o usuário já tem no gateway, vazio quando
+// ele ainda não salvou nenhum cartão
+func (r *PaymentMethodRepository) CustomerId(ctx context.Context, ownerId, provider string) (string, error) {
+	var customerId string
+	err := r.db.QueryRowContext(ctx, "SELECT customer_id FROM payment_methods WHERE owner_uuid = ? AND provider = ? LIMIT 1", ownerId, provider).Scan(&customerId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+
+	return customerId, err
+}
+
+func (r *PaymentMethodRepository) SetDefault(ctx context.Context, ownerId, id string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var count int
+	err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM payment_methods WHERE id = ? AND owner_uuid = ? FOR UPDATE", id, ownerId).Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return ErrPaymentMethodNotFound
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE payment_methods SET is_default = (id = ?) WHERE owner_uuid = ?", id, ownerId); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete remove o cartão e, se ele era o padrão, o mais novo vira o padrão
+func (r *PaymentMethodRepository) Delete(ctx context.Context, ownerId, id string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var wasDefault bool
+	err = tx.QueryRowContext(ctx, "SELECT is_default FROM payment_methods WHERE id = ? AND owner_uuid = ? FOR UPDATE", id, ownerId).Scan(&wasDefault)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrPaymentMethodNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM payment_methods WHERE id = ?", id); err != nil {
+		return err
+	}
+
+	if wasDefault {
+		_, err := tx.ExecContext(ctx, "UPDATE payment_methods SET is_default = 1 WHERE owner_uuid = ? ORDER BY created_at DESC LIMIT 1", ownerId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// AutoCharge diz se o usuário quer que as renovações sejam cobradas no cartão
+// padrão. Quem nunca mexeu na preferência não tem cobrança automática
+func (r *PaymentMethodRepository) AutoCharge(ctx context.Context, ownerId string) (bool, error) {
+	var enabled bool
+	err := r.db.QueryRowContext(ctx, "SELECT auto_charge FROM billing_preferences WHERE owner_uuid = ?", ownerId).Scan(&enabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+
+	return enabled, err
+}
+
+func (r *PaymentMethodRepository) SetAutoCharge(ctx context.Context, ownerId string, enabled bool) error {
+	_, err := r.db.ExecContext(ctx, "INSERT INTO billing_preferences (owner_uuid, auto_charge, updated_at) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE auto_charge = VALUES(auto_charge), updated_at = VALUES(updated_at)",
+		ownerId, enabled, time.Now().Format(time.DateTime))
+	return err
+}
@@ -0,0 +1,235 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type PaymentRepository struct {
+	db *sql.DB
+}
+
+func NewPaymentRepository(db *sql.DB) *PaymentRepository {
+	return &PaymentRepository{db: db}
+}
+
+const paymentColumns = "id, invoice_id, owner_uuid, provider, provider_payment_id, method, status, status_detail, amount, qr_code, qr_code_base64, ticket_url, barcode_line, due_date, expires_at, created_at, updated_at"
+
+func scanPayment(row interface{ Scan(dest ...any) error }) (*Payment, error) {
+	var p Payment
+	var qrCode, qrCodeBase64, barcodeLine, dueDate, expiresAt sql.NullString
+
+	err := row.Scan(
+		&p.Id,
+		&p.InvoiceId,
+		&p.OwnerId,
+		&p.Provider,
+		&p.ProviderPaymentId,
+		&p.Method,
+		&p.Status,
+		&p.StatusDetail,
+		&p.Amount,
+		&qrCode,
+		&qrCodeBase64,
+		&p.TicketURL,
+		&barcodeLine,
+		&dueDate,
+		&expiresAt,
+		&p.CreatedAt,
+		&p.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	p.QRCode = qrCode.String
+	p.QRCodeBase64 = qrCodeBase64.String
+	p.BarcodeLine = barcodeLine.String
+	p.DueDate = dueDate.String
+	p.ExpiresAt = expiresAt.String
+
+	return &p, nil
+}
+
+func (r *PaymentRepository) CreateTx(ctx context.Context, tx *sql.Tx, p *Payment) error {
+	if p.Id == "" {
+		p.Id = uuid.New().String()
+	}
+
+	p.CreatedAt = time.Now().Format(time.DateTime)
+	p.UpdatedAt = p.CreatedAt
+
+	_, err := tx.ExecContext(ctx, "INSERT INTO payments ("+paymentColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		p.Id,
+		p.InvoiceId,
+		p.OwnerId,
+		p.Provider,
+		p.ProviderPaymentId,
+		p.Method,
+		p.Status,
+		p.StatusDetail,
+		p.Amount,
+		nullable(p.QRCode),
+		nullable(p.QRCodeBase64),
+		p.TicketURL,
+		nullable(p.BarcodeLine),
+		nullable(p.DueDate),
+		nullable(p.ExpiresAt),
+		p.CreatedAt,
+		p.UpdatedAt)
+	return err
+}
+
+func (r *PaymentRepository) Get(ctx context.Context, id string) (*Payment, error) {
+	p, err := scanPayment(r.db.QueryRowContext(ctx, "SELECT "+paymentColumns+" FROM payments WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPaymentNotFound
+	}
+
+	return p, err
+}
+
+// GetTx trava o pagamento até o fim da transação
+func (r *PaymentRepository) GetTx(ctx context.Context, tx *sql.Tx, id string) (*Payment, error) {
+	p, err := scanPayment(tx.QueryRowContext(ctx, "SELECT "+paymentColumns+" FROM payments WHERE id = ? FOR UPDATE", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPaymentNotFound
+	}
+
+	return p, err
+}
+
+func (r *PaymentRepository) GetByProvider(ctx context.Context, provider, providerPaymentId string) (*Payment, error) {
+	p, err := scanPayment(r.db.QueryRowContext(ctx, "SELECT "+paymentColumns+" FROM payments WHERE provider = ? AND provider_payment_id = ?", provider, providerPaymentId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPaymentNotFound
+	}
+
+	return p, err
+}
+
+// GetByProviderTx trava o pagamento até o fim da transação
+func (r *PaymentRepository) GetByProviderTx(ctx context.Context, tx *sql.Tx, provider, providerPaymentId string) (*Payment, error) {
+	p, err := scanPayment(tx.QueryRowContext(ctx, "SELECT "+paymentColumns+" FROM payments WHERE provider = ? AND provider_payment_id = ? FOR UPDATE", provider, providerPaymentId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPaymentNotFound
+	}
+
+	return p, err
+}
+
+// PendingTx devolve os pagamentos ainda pendentes da fatura para um método,
+// o chamador já deve ter travado a fatura
+func (r *PaymentRepository) PendingTx(ctx context.Context, tx *sql.Tx, invoiceId string, method PaymentMethod) ([]Payment, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT "+paymentColumns+" FROM payments WHERE invoice_id = ? AND method = ? AND status = ? ORDER BY created_at DESC", invoiceId, method, PaymentPending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := []Payment{}
+	for rows.Next() {
+		p, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, *p)
+	}
+
+	return payments, rows.Err()
+}
+
+func (r *PaymentRepository) CountByStatusTx(ctx context.Context, tx *sql.Tx, invoiceId string, status PaymentStatus) (int, error) {
+	var count int
+	err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM payments WHERE invoice_id = ? AND status = ?", invoiceId, status).Scan(&count)
+	return count, err
+}
+
+func (r *PaymentRepository) ListByInvoice(ctx context.Context, invoiceId string) ([]Payment, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT "+paymentColumns+" FROM payments WHERE invoice_id = ? ORDER BY created_at DESC", invoiceId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := []Payment{}
+	for rows.Next() {
+		p, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, *p)
+	}
+
+	return payments, rows.Err()
+}
+
+func (r *PaymentRepository) UpdateStatusTx(ctx context.Context, tx *sql.Tx, id string, status PaymentStatus, detail string) error {
+	result, err := tx.ExecContext(ctx, "UPDATE payments SET status = ?, status_detail = ?, updated_at = ? WHERE id = ?", status, detail, time.Now().Format(time.DateTime), id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrPaymentNotFound
+	}
+
+	return nil
+}
+
+// cancelPending só cancela se o pagamento ainda está pendente, a conciliação
+// pode ter aprovado ele depois que a fatura foi destravada
+func (r *PaymentRepository) cancelPending(ctx context.Context, id, detail string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE payments SET status = ?, status_detail = ?, updated_at = ? WHERE id = ? AND status = ?",
+		PaymentCancelled, detail, time.Now().Format(time.DateTime), id, PaymentPending)
+	return err
+}
+
+// release dá como não criado um pagamento que ainda não tem cobrança. O
+// provider_payment_id continua o próprio id, então a notificação de uma
+// cobrança que existia ainda liga ela ao pagamento
+func (r *PaymentRepository) release(ctx context.Context, id string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE payments SET status = ?, status_detail = ?, updated_at = ? WHERE id = ? AND status = ?",
+		PaymentCancelled, detailNotCreated, time.Now().Format(time.DateTime), id, PaymentCreating)
+	return err
+}
+
+// releaseStaleTx faz o mesmo que release com os pagamentos da fatura em
+// criação desde antes de before
+func (r *PaymentRepository) releaseStaleTx(ctx context.Context, tx *sql.Tx, invoiceId string, before time.Time) error {
+	_, err := tx.ExecContext(ctx, "UPDATE payments SET status = ?, status_detail = ?, updated_at = ? WHERE invoice_id = ? AND status = ? AND created_at < ?",
+		PaymentCancelled, detailNotCreated, time.Now().Format(time.DateTime), invoiceId, PaymentCreating, before.Format(time.DateTime))
+	return err
+}
+
+// attach grava a cobrança do gateway num pagamento que ainda não tinha uma.
+// false quando outro caminho já gravou
+func (r *PaymentRepository) attach(ctx context.Context, p *Payment) (bool, error) {
+	p.UpdatedAt = time.Now().Format(time.DateTime)
+
+	result, err := r.db.ExecContext(ctx, "UPDATE payments SET provider_payment_id = ?, status = ?, status_detail = ?, qr_code = ?, qr_code_base64 = ?, ticket_url = ?, barcode_line = ?, updated_at = ? WHERE id = ? AND provider = ? AND provider_payment_id = id",
+		p.ProviderPaymentId,
+		p.Status,
+		p.StatusDetail,
+		nullable(p.QRCode),
+		nullable(p.QRCodeBase64),
+		p.TicketURL,
+		nullable(p.BarcodeLine),
+		p.UpdatedAt,
+		p.Id,
+		p.Provider)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
@@ -0,0 +1,272 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"prodata/catalog"
+	"prodata/database/account"
+	"prodata/finances"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrSamePlan         = errors.New("service is already on this plan and billing cycle")
+	ErrServiceNotActive = errors.New("only active services can change plans")
+	ErrInvalidCycle     = errors.New("invalid billing cycle")
+)
+
+type PlanChange struct {
+	Service   *account.Services    `json:"service"`
+	OldPlanId string               `json:"old_plan_id"`
+	NewPlanId string               `json:"new_plan_id"`
+	OldCycle  catalog.BillingCycle `json:"old_cycle"`
+	NewCycle  catalog.BillingCycle `json:"new_cycle"`
+	Proration *finances.Proration  `json:"proration"`
+	Invoice   *Invoice             `json:"invoice,omitempty"`
+	Preview   bool                 `json:"preview"`
+}
+
+// PlanChanger troca o plano ou o ciclo de um serviço no meio do período. O
+// plano novo vale na hora: no upgrade a diferença vira uma fatura em aberto e
+// no downgrade entra no saldo da conta. Trocar o ciclo começa um período novo
+// na data da troca, no upgrade só quando a fatura é paga
+type PlanChanger struct {
+	db       *sql.DB
+	invoices *InvoiceRepository
+	services *account.ServiceRepository
+	catalog  *catalog.Repository
+	prorator *finances.Prorator
+	wallet   *WalletRepository
+}
+
+func NewPlanChanger(db *sql.DB, invoices *InvoiceRepository, services *account.ServiceRepository, catalog *catalog.Repository, prorator *finances.Prorator, wallet *WalletRepository) *PlanChanger {
+	return &PlanChanger{
+		db:       db,
+		invoices: invoices,
+		services: services,
+		catalog:  catalog,
+		prorator: prorator,
+		wallet:   wallet,
+	}
+}
+
+// O período atual vai de period_start até o vencimento, sem period_start ele
+// é um ciclo do serviço antes do vencimento
+func servicePeriod(service *account.Services) (finances.Period, error) {
+	end, err := time.ParseInLocation(time.DateTime, service.Date, time.Local)
+	if err != nil {
+		return finances.Period{}, finances.ErrInvalidPeriod
+	}
+
+	start := finances.AddMonths(end, -catalog.CycleOrMonthly(service.Cycle).Months())
+	if service.PeriodStart != "" {
+		start, err = time.ParseInLocation(time.DateTime, service.PeriodStart, time.Local)
+		if err != nil {
+			return finances.Period{}, finances.ErrInvalidPeriod
+		}
+	}
+
+	return finances.Period{Start: start, End: end}, nil
+}
+
+// ChangePlan com preview = true calcula tudo e desfaz a transação no fim.
+// planId ou cycle vazios mantêm os do serviço
+func (c *PlanChanger) ChangePlan(ctx context.Context, ownerId, serviceId, planId string, cycle catalog.BillingCycle, preview bool) (*PlanChange, error) {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	service, err := c.services.GetForUpdate(ctx, tx, ownerId, serviceId)
+	if err != nil {
+		return nil, err
+	}
+
+	if service.Status != account.StatusActive {
+		return nil, ErrServiceNotActive
+	}
+
+	current := catalog.CycleOrMonthly(service.Cycle)
+
+	if planId == "" {
+		planId = service.PlanId
+	}
+
+	if cycle == "" {
+		cycle = current
+	}
+
+	if !cycle.Valid() {
+		return nil, ErrInvalidCycle
+	}
+
+	if service.PlanId == planId && cycle == current {
+		return nil, ErrSamePlan
+	}
+
+	period, err := servicePeriod(service)
+	if err != nil {
+		return nil, err
+	}
+
+	plan, err := c.catalog.ReservePlanChange(ctx, tx, planId, cycle, service.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	request := finances.ProrationRequest{
+		Period:   period,
+		OldPlan:  service.Name,
+		OldPrice: service.Price,
+		NewPlan:  plan.Name,
+		NewPrice: plan.Price,
+	}
+
+	if cycle != current {
+		request.NewMonths = cycle.Months()
+	}
+
+	proration, err := c.prorator.Calculate(request)
+	if err != nil {
+		return nil, err
+	}
+
+	change := &PlanChange{
+		Service:   service,
+		OldPlanId: service.PlanId,
+		NewPlanId: planId,
+		OldCycle:  current,
+		NewCycle:  cycle,
+		Proration: proration,
+		Preview:   preview,
+	}
+
+	if preview {
+		return change, nil
+	}
+
+	oldPrice := service.Price
+	oldName := service.Name
+	service.PlanId = plan.PlanId
+	service.Name = plan.Name
+	service.Type = plan.Type
+	service.Price = plan.Price
+	service.Cycle = string(cycle)
+
+	// Com fatura o período novo vem no item dela e só vale quando ela for
+	// paga, sem nada a cobrar ele já começa
+	if proration.NewPeriod != nil && !proration.IsUpgrade() {
+		service.AnchorDay = proration.NewPeriod.Start.Day()
+		service.PeriodStart = proration.NewPeriod.Start.Format(time.DateTime)
+		service.Date = proration.NewPeriod.End.Format(time.DateTime)
+	}
+
+	if err := c.services.UpdateTx(ctx, tx, service); err != nil {
+		return nil, err
+	}
+
+	if proration.IsUpgrade() {
+		change.Invoice, err = c.upgradeInvoice(ctx, tx, service, proration)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var invoiceId string
+	if change.Invoice != nil {
+		invoiceId = change.Invoice.Id
+	}
+
+	result, err := tx.ExecContext(ctx, "INSERT INTO service_plan_changes (service_id, owner_uuid, old_plan_id, new_plan_id, old_cycle, new_cycle, old_price, new_price, charge, credit, period_start, period_end, invoice_id, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		service.Id,
+		ownerId,
+		nullable(change.OldPlanId),
+		change.NewPlanId,
+		change.OldCycle,
+		change.NewCycle,
+		oldPrice,
+		service.Price,
+		proration.Charge,
+		proration.Credit,
+		period.Start.Format(time.DateTime),
+		period.End.Format(time.DateTime),
+		nullable(invoiceId),
+		proration.ChangedAt.Format(time.DateTime))
+	if err != nil {
+		return nil, err
+	}
+
+	if proration.IsDowngrade() {
+		changeId, err := result.LastInsertId()
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = c.wallet.addTx(ctx, tx, &WalletEntry{
+			OwnerId:     ownerId,
+			Source:      WalletDowngrade,
+			Amount:      proration.Credit,
+			Reference:   strconv.FormatInt(changeId, 10),
+			Description: "Troca do plano " + oldName + " para " + plan.Name,
+			Actor:       ownerId,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return change, nil
+}
+
+// A fatura do upgrade leva os dois itens da proporcionalidade, o crédito
+// do plano antigo entra como desconto e o total fica igual ao Charge. Na
+// troca de ciclo o item do plano novo leva o período novo, que a conciliação
+// aplica no serviço
+func (c *PlanChanger) upgradeInvoice(ctx context.Context, tx *sql.Tx, service *account.Services, proration *finances.Proration) (*Invoice, error) {
+	inv := &Invoice{
+		OwnerId:  service.OwnerId,
+		Status:   InvoiceOpen,
+		Currency: proration.Charge.Currency(),
+		DueDate:  proration.ChangedAt.AddDate(0, 0, 1).Format(time.DateTime),
+		Notes:    "Troca de plano",
+	}
+
+	for _, item := range proration.Items {
+		kind := ItemService
+		if item.Amount.IsNegative() {
+			kind = ItemDiscount
+		}
+
+		if item.Amount.IsZero() {
+			continue
+		}
+
+		line := InvoiceItem{
+			ServiceId:   service.Id,
+			Kind:        kind,
+			Description: item.Description,
+			Quantity:    1,
+			UnitPrice:   item.Amount.Abs(),
+		}
+
+		if kind == ItemService && proration.NewPeriod != nil {
+			line.PeriodStart = proration.NewPeriod.Start.Format(time.DateTime)
+			line.PeriodEnd = proration.NewPeriod.End.Format(time.DateTime)
+		}
+
+		inv.Items = append(inv.Items, line)
+	}
+
+	if err := c.invoices.CreateTx(ctx, tx, inv); err != nil {
+		return nil, err
+	}
+
+	return inv, nil
+}
@@ -0,0 +1,453 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"prodata/bank"
+	"prodata/bank/tx"
+	"prodata/catalog"
+	"prodata/database/account"
+	"prodata/emailHandler"
+	"prodata/finances"
+	"prodata/logs"
+	"prodata/money"
+	"time"
+)
+
+// Reconciler aplica nos nossos pagamentos o que o gateway avisou pelo
+// webhook. O status sempre vem de uma consulta ao gateway, nunca do corpo da
+// notificação
+type Reconciler struct {
+	db        *sql.DB
+	invoices  *InvoiceRepository
+	payments  *PaymentRepository
+	services  *account.ServiceRepository
+	lifecycle *account.ServiceLifecycle
+	users     *account.UserRepository
+	dunning   *DunningRepository
+	refunder  *Refunder
+	disputes  *Disputes
+	wallet    *WalletRepository
+	gateway   bank.PaymentGateway
+}
+
+func NewReconciler(db *sql.DB, invoices *InvoiceRepository, payments *PaymentRepository, services *account.ServiceRepository, lifecycle *account.ServiceLifecycle, users *account.UserRepository, dunning *DunningRepository, refunder *Refunder, disputes *Disputes, wallet *WalletRepository, gateway bank.PaymentGateway) *Reconciler {
+	return &Reconciler{
+		db:        db,
+		invoices:  invoices,
+		payments:  payments,
+		services:  services,
+		lifecycle: lifecycle,
+		users:     users,
+		dunning:   dunning,
+		refunder:  refunder,
+		disputes:  disputes,
+		wallet:    wallet,
+		gateway:   gateway,
+	}
+}
+
+func (r *Reconciler) HandlePaymentEvent(ctx context.Context, event *tx.WebhookEvent) error {
+	response, err := r.gateway.GetPayment(ctx, event.ResourceId)
+	if err != nil {
+		return err
+	}
+
+	if err := r.attachCreated(ctx, response); err != nil {
+		return err
+	}
+
+	err = r.Reconcile(ctx, r.gateway.Name(), event.ResourceId, PaymentStatusFromProvider(response.Status, response.StatusDetail), response.StatusDetail, response.Amount)
+	if err != nil {
+		return err
+	}
+
+	if err := r.postFee(ctx, response); err != nil {
+		return err
+	}
+
+	// O Mercado Pago avisa estornos, parciais ou não, como atualização do
+	// pagamento
+	return r.refunder.Sync(ctx, r.gateway.Name(), response)
+}
+
+// attachCreated cuida da notificação que chega antes do checkout gravar a
+// resposta do gateway: a chave de idempotência leva ao pagamento que ainda
+// está em criação. Uma cobrança de fatura nossa que não tem pagamento volta
+// como erro para o gateway reenviar, só as de fora do sistema são ignoradas
+func (r *Reconciler) attachCreated(ctx context.Context, response *bank.Payment) error {
+	_, err := r.payments.GetByProvider(ctx, r.gateway.Name(), response.Id)
+	if !errors.Is(err, ErrPaymentNotFound) {
+		return err
+	}
+
+	if response.IdempotencyKey != "" {
+		p := &Payment{Id: response.IdempotencyKey, Provider: r.gateway.Name()}
+		p.applyGateway(response)
+
+		attached, err := r.payments.attach(ctx, p)
+		if err != nil || attached {
+			return err
+		}
+
+		// O checkout pode ter gravado entre a consulta e o attach
+		_, err = r.payments.GetByProvider(ctx, r.gateway.Name(), response.Id)
+		if !errors.Is(err, ErrPaymentNotFound) {
+			return err
+		}
+	}
+
+	if response.ExternalReference == "" {
+		return nil
+	}
+
+	_, err = r.invoices.Get(ctx, response.ExternalReference)
+	if errors.Is(err, ErrInvoiceNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return fmt.Errorf("%w: %s", ErrPaymentNotStored, response.Id)
+}
+
+// postFee lança a tarifa que o gateway cobrou no pagamento, assim o saldo no
+// gateway do livro bate com os repasses
+func (r *Reconciler) postFee(ctx context.Context, response *bank.Payment) error {
+	if !response.Fee.IsPositive() {
+		return nil
+	}
+
+	dbTx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer dbTx.Rollback()
+
+	p, err := r.payments.GetByProviderTx(ctx, dbTx, r.gateway.Name(), response.Id)
+	if errors.Is(err, ErrPaymentNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := finances.PostTx(ctx, dbTx, finances.GatewayFee(p.Id, p.OwnerId, r.gateway.Name(), response.Fee)); err != nil {
+		return err
+	}
+
+	return dbTx.Commit()
+}
+
+// Reconcile muda o status do pagamento e, quando ele acabou de ser aprovado,
+// lança o valor na fatura. pending, in_process, rejected e cancelled só mudam
+// o pagamento, a fatura continua em aberto para uma nova tentativa.
+// charged_back abre a contestação e suspende os serviços da fatura
+func (r *Reconciler) Reconcile(ctx context.Context, provider, providerPaymentId string, status PaymentStatus, detail string, received money.Money) error {
+	dbTx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer dbTx.Rollback()
+
+	p, err := r.payments.GetByProviderTx(ctx, dbTx, provider, providerPaymentId)
+	if errors.Is(err, ErrPaymentNotFound) {
+		// Pagamento criado fora do sistema, não tem fatura para conciliar. A
+		// notificação confere isso antes em attachCreated
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	approvedNow := status == PaymentApproved && p.Status != PaymentApproved
+
+	if status != p.Status || detail != p.StatusDetail {
+		if err := r.payments.UpdateStatusTx(ctx, dbTx, p.Id, status, detail); err != nil {
+			return err
+		}
+	}
+
+	if status == PaymentChargedBack {
+		// O que já foi estornado não volta na contestação
+		captured, err := r.refunder.capturedTx(ctx, dbTx, p)
+		if err != nil {
+			return err
+		}
+
+		if err := r.disputes.disputes.openTx(ctx, dbTx, p, captured, detail); err != nil {
+			return err
+		}
+
+		_, err = finances.PostTx(ctx, dbTx, finances.ChargedBack(p.Id, p.OwnerId, "Chargeback do pagamento "+p.ProviderPaymentId, captured))
+		if err != nil {
+			return err
+		}
+	}
+
+	if !approvedNow {
+		if err := dbTx.Commit(); err != nil {
+			return err
+		}
+
+		if status == PaymentChargedBack {
+			return r.disputes.enforce(ctx, p.Id)
+		}
+
+		// Reentrega de um pagamento já aprovado, garante que os serviços
+		// novos foram ativados caso a última tentativa tenha caído no meio
+		if status == PaymentApproved {
+			inv, err := r.invoices.Get(ctx, p.InvoiceId)
+			if err != nil {
+				return err
+			}
+
+			if inv.Status == InvoicePaid {
+				return r.activateServices(ctx, inv, false)
+			}
+		}
+
+		return nil
+	}
+
+	inv, excess, settled, err := r.invoices.applyPaymentTx(ctx, dbTx, p.InvoiceId, received)
+	if err != nil {
+		return err
+	}
+
+	applied, err := received.Sub(excess)
+	if err != nil {
+		return err
+	}
+
+	journal, err := finances.PaymentReceived(p.Id, inv.OwnerId, "Pagamento da fatura "+inv.Number, p.Provider == ProviderWallet, applied, excess)
+	if err != nil {
+		return err
+	}
+
+	if _, err := finances.PostTx(ctx, dbTx, journal); err != nil {
+		return err
+	}
+
+	if inv.Status != InvoicePaid {
+		err = recordAdjustment(ctx, dbTx, &BalanceAdjustment{
+			OwnerId:     inv.OwnerId,
+			Kind:        AdjustmentPartialPayment,
+			Amount:      received,
+			InvoiceId:   inv.Id,
+			PaymentId:   p.Id,
+			Description: "Pagamento parcial da fatura " + inv.Number,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if excess.IsPositive() {
+		err = recordAdjustment(ctx, dbTx, &BalanceAdjustment{
+			OwnerId:     inv.OwnerId,
+			Kind:        AdjustmentOverpayment,
+			Amount:      excess,
+			InvoiceId:   inv.Id,
+			PaymentId:   p.Id,
+			Description: "Valor pago a mais na fatura " + inv.Number,
+		})
+		if err != nil {
+			return err
+		}
+
+		// O excedente vira saldo para as próximas faturas
+		_, err = r.wallet.addTx(ctx, dbTx, &WalletEntry{
+			OwnerId:     inv.OwnerId,
+			Source:      WalletOverpayment,
+			Amount:      excess,
+			InvoiceId:   inv.Id,
+			PaymentId:   p.Id,
+			Reference:   p.Id,
+			Description: "Valor pago a mais na fatura " + inv.Number,
+			Actor:       SystemActor,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if settled {
+		if err := r.renewServices(ctx, dbTx, inv); err != nil {
+			return err
+		}
+
+		if err := markOrderPaidTx(ctx, dbTx, inv.Id); err != nil {
+			return err
+		}
+	}
+
+	if err := dbTx.Commit(); err != nil {
+		return err
+	}
+
+	if !settled {
+		return nil
+	}
+
+	if err := r.activateServices(ctx, inv, true); err != nil {
+		return err
+	}
+
+	r.sendReceipt(ctx, inv)
+	return nil
+}
+
+// renewServices leva o vencimento dos serviços para o período que a fatura
+// cobriu, inclusive o período novo de uma troca de ciclo. Serviços que já
+// estão nesse período não mudam. O serviço de um pedido começa o primeiro
+// período no pagamento, e o item da fatura passa a mostrar esse período
+func (r *Reconciler) renewServices(ctx context.Context, dbTx *sql.Tx, inv *Invoice) error {
+	for i := range inv.Items {
+		item := &inv.Items[i]
+		if item.ServiceId == "" || item.PeriodEnd == "" {
+			continue
+		}
+
+		service, err := r.services.GetForUpdate(ctx, dbTx, inv.OwnerId, item.ServiceId)
+		if errors.Is(err, account.ErrServiceNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if service.Status == account.StatusPending {
+			paidAt, err := time.ParseInLocation(time.DateTime, inv.PaidAt, time.Local)
+			if err != nil {
+				return err
+			}
+
+			service.AnchorDay = paidAt.Day()
+			item.PeriodStart = paidAt.Format(time.DateTime)
+			item.PeriodEnd = catalog.CycleOrMonthly(service.Cycle).RenewalEnd(paidAt, service.AnchorDay).Format(time.DateTime)
+
+			if err := r.invoices.setItemPeriodTx(ctx, dbTx, item); err != nil {
+				return err
+			}
+		} else if item.PeriodStart < service.Date {
+			// Começa no meio do período, é a troca de ciclo. Vale só se ainda
+			// for do período atual e começa um dia de vencimento novo
+			if item.PeriodStart <= service.PeriodStart {
+				continue
+			}
+
+			if start, err := time.ParseInLocation(time.DateTime, item.PeriodStart, time.Local); err == nil {
+				service.AnchorDay = start.Day()
+			}
+		} else if service.Date >= item.PeriodEnd {
+			continue
+		}
+
+		service.PeriodStart = item.PeriodStart
+		service.Date = item.PeriodEnd
+
+		if err := r.services.UpdateTx(ctx, dbTx, service); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// activateServices ativa os serviços novos e, com unsuspend, reativa os
+// suspensos por falta de pagamento. Um serviço suspenso pela cobrança só volta
+// quando não sobra outra fatura vencida segurando ele. Pode rodar mais de uma
+// vez sem efeito extra
+func (r *Reconciler) activateServices(ctx context.Context, inv *Invoice, unsuspend bool) error {
+	for _, item := range inv.Items {
+		if item.ServiceId == "" || item.Kind != ItemService {
+			continue
+		}
+
+		service, err := r.services.GetByID(ctx, item.ServiceId)
+		if errors.Is(err, account.ErrServiceNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		reason := "Fatura " + inv.Number + " paga"
+
+		switch service.Status {
+		case account.StatusPending:
+			_, err = r.lifecycle.Activate(ctx, service.Id, reason)
+		case account.StatusSuspended:
+			if unsuspend {
+				err = r.unsuspend(ctx, inv, service.Id, reason)
+			}
+		}
+
+		if err != nil && !errors.Is(err, account.ErrInvalidTransition) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Reconciler) unsuspend(ctx context.Context, inv *Invoice, serviceId, reason string) error {
+	// Suspenso por contestação só volta pelo resultado dela
+	held, err := r.disputes.disputes.holdsService(ctx, serviceId)
+	if err != nil || held {
+		return err
+	}
+
+	suspended, stillOwed, err := r.dunning.suspendedByDunning(ctx, serviceId)
+	if err != nil {
+		return err
+	}
+
+	if !suspended {
+		_, err := r.lifecycle.Unsuspend(ctx, serviceId, reason)
+		return err
+	}
+
+	if stillOwed {
+		return nil
+	}
+
+	entry := &DunningEntry{
+		OwnerId:   inv.OwnerId,
+		InvoiceId: inv.Id,
+		Action:    DunningUnsuspend,
+		Target:    serviceId,
+		Detail:    reason,
+		Actor:     SystemActor,
+	}
+
+	inserted, err := r.dunning.record(ctx, entry)
+	if err != nil || !inserted {
+		return err
+	}
+
+	if _, err := r.lifecycle.Unsuspend(ctx, serviceId, reason); err != nil {
+		return errors.Join(err, r.dunning.forget(ctx, entry.Id))
+	}
+
+	return nil
+}
+
+func (r *Reconciler) sendReceipt(ctx context.Context, inv *Invoice) {
+	email, err := r.users.FindEmailByUUID(ctx, inv.OwnerId)
+	if err != nil {
+		logs.NewSistemLogger().LogAndSendSystemMessage("receipt " + inv.Id + ": " + err.Error())
+		return
+	}
+
+	items := make([]emailHandler.ReceiptItem, 0, len(inv.Items))
+	for _, item := range inv.Items {
+		items = append(items, emailHandler.ReceiptItem{Name: item.Description, Price: item.Amount.String()})
+	}
+
+	go emailHandler.SendPaymentReceipt(email, items, inv.Total.String())
+}
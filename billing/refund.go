@@ -0,0 +1,643 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"prodata/bank"
+	"prodata/database/account"
+	"prodata/finances"
+	"prodata/logs"
+	"prodata/money"
+	"time"
+)
+
+type RefundStatus string
+
+const (
+	RefundPending  RefundStatus = "pending"
+	RefundApproved RefundStatus = "approved"
+	RefundFailed   RefundStatus = "failed"
+)
+
+type RefundSource string
+
+const (
+	// Pedido por um admin pela API
+	RefundFromAdmin RefundSource = "admin"
+	// Feito direto no painel do gateway, só conhecemos pelo webhook
+	RefundFromGateway RefundSource = "gateway"
+)
+
+// Um estorno pending sem id do gateway e mais velho que isso é de um processo
+// que caiu no meio da chamada
+const refundStaleAfter = 10 * time.Minute
+
+var (
+	ErrRefundNotFound       = errors.New("refund not found")
+	ErrPaymentNotRefundable = errors.New("only approved payments can be refunded")
+	ErrInvalidRefundAmount  = errors.New("refund amount must be positive and at most what is left of the payment")
+	ErrRefundInProgress     = errors.New("another refund for this payment is in progress")
+	ErrOverpaymentSpent     = errors.New("the overpaid amount of this payment was already used from the credit balance")
+)
+
+type Refund struct {
+	Id               string       `json:"id"`
+	PaymentId        string       `json:"payment_id"`
+	InvoiceId        string       `json:"invoice_id"`
+	OwnerId          string       `json:"-"`
+	Provider         string       `json:"provider"`
+	ProviderRefundId string       `json:"provider_refund_id,omitempty"`
+	Amount           money.Money  `json:"amount"`
+	Status           RefundStatus `json:"status"`
+	Source           RefundSource `json:"source"`
+	Reason           string       `json:"reason"`
+	Actor            string       `json:"actor"`
+	CancelServices   bool         `json:"cancel_services"`
+	LastError        string       `json:"last_error,omitempty"`
+	CreatedAt        string       `json:"created_at"`
+	UpdatedAt        string       `json:"updated_at"`
+	CreditNote       *CreditNote  `json:"credit_note,omitempty"`
+}
+
+// CreditNote é o documento do valor devolvido, cada estorno aprovado gera
+// uma e a soma delas fica em Invoice.AmountRefunded
+type CreditNote struct {
+	Id        string      `json:"id"`
+	Number    string      `json:"number"`
+	InvoiceId string      `json:"invoice_id"`
+	OwnerId   string      `json:"-"`
+	RefundId  string      `json:"refund_id"`
+	Amount    money.Money `json:"amount"`
+	Reason    string      `json:"reason"`
+	CreatedAt string      `json:"created_at"`
+}
+
+// RefundRequest com Amount nil estorna tudo que ainda não foi devolvido.
+// ToWallet devolve como saldo da conta em vez de passar pelo gateway
+type RefundRequest struct {
+	Amount         *money.Money
+	Reason         string
+	CancelServices bool
+	ToWallet       bool
+	Actor          string
+}
+
+// Refunder devolve dinheiro pelo gateway. O estorno é gravado como pending
+// antes da chamada, assim o valor fica reservado e o webhook que chega no
+// meio reconhece o estorno em vez de creditar de novo
+type Refunder struct {
+	db        *sql.DB
+	invoices  *InvoiceRepository
+	payments  *PaymentRepository
+	refunds   *RefundRepository
+	lifecycle *account.ServiceLifecycle
+	wallet    *WalletRepository
+	gateway   bank.PaymentGateway
+}
+
+func NewRefunder(db *sql.DB, invoices *InvoiceRepository, payments *PaymentRepository, refunds *RefundRepository, lifecycle *account.ServiceLifecycle, wallet *WalletRepository, gateway bank.PaymentGateway) *Refunder {
+	return &Refunder{
+		db:        db,
+		invoices:  invoices,
+		payments:  payments,
+		refunds:   refunds,
+		lifecycle: lifecycle,
+		wallet:    wallet,
+		gateway:   gateway,
+	}
+}
+
+func (r *Refunder) Refund(ctx context.Context, paymentId string, request RefundRequest) (*Refund, error) {
+	refund, p, full, err := r.reserve(ctx, paymentId, request)
+	if err != nil {
+		return nil, err
+	}
+
+	// Devolvido como saldo já sai aprovado do reserve
+	if refund.Status == RefundApproved {
+		if refund.CancelServices {
+			r.cancelServices(ctx, refund)
+		}
+		return refund, nil
+	}
+
+	var amount *money.Money
+	if !full {
+		amount = &refund.Amount
+	}
+
+	response, err := r.gateway.Refund(ctx, p.ProviderPaymentId, amount)
+	if err != nil {
+		return nil, errors.Join(err, r.fail(ctx, refund, err))
+	}
+
+	refund, approvedNow, err := r.settle(ctx, refund.Id, response)
+	if err != nil {
+		return nil, err
+	}
+
+	if approvedNow && refund.CancelServices {
+		r.cancelServices(ctx, refund)
+	}
+
+	return refund, nil
+}
+
+// reserve trava o pagamento, confere quanto ainda dá para devolver e grava o
+// estorno como pending. full diz se é o valor inteiro do pagamento. O
+// estorno para o saldo não depende do gateway e é aprovado aqui mesmo
+func (r *Refunder) reserve(ctx context.Context, paymentId string, request RefundRequest) (*Refund, *Payment, bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	defer tx.Rollback()
+
+	p, err := r.payments.GetTx(ctx, tx, paymentId)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	if p.Status != PaymentApproved || (!request.ToWallet && p.Provider != r.gateway.Name()) {
+		return nil, nil, false, ErrPaymentNotRefundable
+	}
+
+	refunds, err := r.refunds.byPaymentTx(ctx, tx, p.Id)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	now := time.Now()
+	for i := range refunds {
+		existing := &refunds[i]
+		if existing.Status != RefundPending || existing.ProviderRefundId != "" {
+			continue
+		}
+
+		createdAt, err := time.ParseInLocation(time.DateTime, existing.CreatedAt, time.Local)
+		if err == nil && now.Sub(createdAt) < refundStaleAfter {
+			return nil, nil, false, ErrRefundInProgress
+		}
+
+		// Se o gateway chegou a fazer esse estorno o webhook traz ele de
+		// volta como estorno do gateway
+		if err := r.failTx(ctx, tx, existing, errors.New("abandoned before the gateway answered")); err != nil {
+			return nil, nil, false, err
+		}
+	}
+
+	used, err := reserved(refunds, p.Amount.Currency())
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	available, err := p.Amount.Sub(used)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	// O valor pago a mais já está no saldo. Para o saldo só dá para devolver
+	// o que foi aplicado na fatura, pelo gateway o que passar disso tira o
+	// crédito de volta
+	applied, excess, err := r.appliedTx(ctx, tx, p, available)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	limit := available
+	if request.ToWallet {
+		limit = applied
+	}
+
+	value := limit
+	if request.Amount != nil {
+		value = *request.Amount
+	}
+
+	if !value.IsPositive() || value.Compare(limit) > 0 {
+		return nil, nil, false, ErrInvalidRefundAmount
+	}
+
+	refund := &Refund{
+		PaymentId:      p.Id,
+		InvoiceId:      p.InvoiceId,
+		OwnerId:        p.OwnerId,
+		Provider:       p.Provider,
+		Amount:         value,
+		Status:         RefundPending,
+		Source:         RefundFromAdmin,
+		Reason:         request.Reason,
+		Actor:          request.Actor,
+		CancelServices: request.CancelServices,
+	}
+
+	if request.ToWallet {
+		refund.Provider = ProviderWallet
+	}
+
+	if err := r.refunds.createTx(ctx, tx, refund); err != nil {
+		return nil, nil, false, err
+	}
+
+	if request.ToWallet {
+		if err := r.approveTx(ctx, tx, p, refund, ""); err != nil {
+			return nil, nil, false, err
+		}
+
+		_, err := r.wallet.addTx(ctx, tx, &WalletEntry{
+			OwnerId:     p.OwnerId,
+			Source:      WalletRefund,
+			Amount:      refund.Amount,
+			InvoiceId:   refund.InvoiceId,
+			PaymentId:   p.Id,
+			Reference:   refund.Id,
+			Description: "Estorno " + refund.CreditNote.Number,
+			Actor:       request.Actor,
+		})
+		if err != nil {
+			return nil, nil, false, err
+		}
+	} else if err := r.reverseOverpaymentTx(ctx, tx, p, refund, creditShare(value, applied, excess)); err != nil {
+		return nil, nil, false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, false, err
+	}
+
+	return refund, p, !used.IsPositive() && value.Compare(p.Amount) == 0, nil
+}
+
+// settle aplica a resposta do gateway. Se o webhook chegou antes e já
+// aprovou o estorno, só devolve ele com approvedNow false
+func (r *Refunder) settle(ctx context.Context, refundId string, response *bank.Refund) (refund *Refund, approvedNow bool, err error) {
+	current, err := r.refunds.Get(ctx, refundId)
+	if err != nil {
+		return nil, false, err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	// Sempre o pagamento antes do estorno, na mesma ordem do webhook
+	p, err := r.payments.GetTx(ctx, tx, current.PaymentId)
+	if err != nil {
+		return nil, false, err
+	}
+
+	refund, err = r.refunds.getTx(ctx, tx, refundId)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if refund.Status == RefundPending {
+		switch response.Status {
+		case bank.StatusApproved:
+			approvedNow = true
+			err = r.approveTx(ctx, tx, p, refund, response.Id)
+		case bank.StatusRejected, bank.StatusCancelled:
+			err = r.failTx(ctx, tx, refund, errors.New("gateway answered "+response.Status))
+		default:
+			// Em processamento no gateway, o webhook aprova depois
+			refund.ProviderRefundId = response.Id
+			_, err = tx.ExecContext(ctx, "UPDATE refunds SET provider_refund_id = ?, updated_at = ? WHERE id = ?", response.Id, time.Now().Format(time.DateTime), refund.Id)
+		}
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+
+	return refund, approvedNow, nil
+}
+
+// approveTx aprova o estorno, emite a nota de crédito e, quando o pagamento
+// foi devolvido inteiro, marca ele como refunded
+func (r *Refunder) approveTx(ctx context.Context, tx *sql.Tx, p *Payment, refund *Refund, providerRefundId string) error {
+	if err := r.refunds.approveTx(ctx, tx, refund, providerRefundId); err != nil {
+		return err
+	}
+
+	note, err := r.refunds.createCreditNoteTx(ctx, tx, refund)
+	if err != nil {
+		return err
+	}
+	refund.CreditNote = note
+
+	fromCredit, err := r.wallet.reversedTx(ctx, tx, refund.Id)
+	if err != nil {
+		return err
+	}
+
+	journal, err := finances.Refunded(refund.Id, refund.OwnerId, "Estorno "+note.Number, refund.Provider == ProviderWallet, refund.Amount, fromCredit)
+	if err != nil {
+		return err
+	}
+
+	if _, err := finances.PostTx(ctx, tx, journal); err != nil {
+		return err
+	}
+
+	refunds, err := r.refunds.byPaymentTx(ctx, tx, p.Id)
+	if err != nil {
+		return err
+	}
+
+	approved := money.New(0, p.Amount.Currency())
+	for _, existing := range refunds {
+		if existing.Status == RefundApproved {
+			if approved, err = approved.Add(existing.Amount); err != nil {
+				return err
+			}
+		}
+	}
+
+	if approved.Compare(p.Amount) >= 0 && p.Status != PaymentRefunded {
+		p.Status = PaymentRefunded
+		return r.payments.UpdateStatusTx(ctx, tx, p.Id, PaymentRefunded, bank.StatusRefunded)
+	}
+
+	return nil
+}
+
+// appliedTx separa o que ainda dá para devolver do pagamento entre o que
+// foi aplicado na fatura e o excesso que ficou como crédito no saldo. Os
+// estornos consomem primeiro a parte aplicada
+func (r *Refunder) appliedTx(ctx context.Context, tx *sql.Tx, p *Payment, available money.Money) (applied, excess money.Money, err error) {
+	excess, err = r.wallet.overpaidTx(ctx, tx, p.Id)
+	if err != nil {
+		return applied, excess, err
+	}
+
+	applied, err = available.Sub(excess)
+	if err != nil {
+		return applied, excess, err
+	}
+
+	// Um estorno feito no painel pode ter passado da parte aplicada
+	if applied.IsNegative() {
+		applied = money.New(0, available.Currency())
+	}
+
+	return applied, excess, nil
+}
+
+// creditShare é a parte do estorno que passa do valor aplicado e sai do
+// crédito deixado pelo pagamento
+func creditShare(amount, applied, excess money.Money) money.Money {
+	share, err := amount.Sub(applied)
+	if err != nil || !share.IsPositive() {
+		return money.New(0, amount.Currency())
+	}
+
+	if share.Compare(excess) > 0 {
+		return excess
+	}
+
+	return share
+}
+
+// reverseOverpaymentTx tira do saldo o crédito do valor pago a mais que o
+// estorno devolve pelo gateway. Se o cliente já usou esse crédito o estorno
+// não pode ser feito
+func (r *Refunder) reverseOverpaymentTx(ctx context.Context, tx *sql.Tx, p *Payment, refund *Refund, share money.Money) error {
+	if !share.IsPositive() {
+		return nil
+	}
+
+	_, err := r.wallet.addTx(ctx, tx, &WalletEntry{
+		OwnerId:     p.OwnerId,
+		Source:      WalletOverpaymentRefund,
+		Amount:      share.Negate(),
+		InvoiceId:   p.InvoiceId,
+		PaymentId:   p.Id,
+		Reference:   refund.Id,
+		Description: "Valor pago a mais devolvido pelo " + p.Provider,
+		Actor:       refund.Actor,
+	})
+	if errors.Is(err, ErrInsufficientCredit) {
+		return ErrOverpaymentSpent
+	}
+	return err
+}
+
+// failTx marca o estorno como falho e devolve ao saldo o crédito que ele
+// tinha tirado
+func (r *Refunder) failTx(ctx context.Context, tx *sql.Tx, refund *Refund, cause error) error {
+	failed, err := r.refunds.failWith(ctx, tx, refund.Id, cause)
+	if err != nil || !failed {
+		return err
+	}
+	refund.Status = RefundFailed
+
+	reversed, err := r.wallet.reversedTx(ctx, tx, refund.Id)
+	if err != nil || !reversed.IsPositive() {
+		return err
+	}
+
+	_, err = r.wallet.addTx(ctx, tx, &WalletEntry{
+		OwnerId:     refund.OwnerId,
+		Source:      WalletOverpayment,
+		Amount:      reversed,
+		InvoiceId:   refund.InvoiceId,
+		PaymentId:   refund.PaymentId,
+		Reference:   refund.Id,
+		Description: "Estorno falhou, valor pago a mais voltou ao saldo",
+		Actor:       SystemActor,
+	})
+	return err
+}
+
+func (r *Refunder) fail(ctx context.Context, refund *Refund, cause error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := r.failTx(ctx, tx, refund, cause); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Sync concilia os estornos que o gateway informou com os nossos. Um estorno
+// já conhecido pelo id não muda, um pending nosso com o mesmo valor é o que
+// acabou de ser pedido e ainda espera a resposta, e o resto foi feito fora
+// do sistema e entra como estorno do gateway
+func (r *Refunder) Sync(ctx context.Context, provider string, response *bank.Payment) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	p, err := r.payments.GetByProviderTx(ctx, tx, provider, response.Id)
+	if errors.Is(err, ErrPaymentNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	refunds, err := r.refunds.byPaymentTx(ctx, tx, p.Id)
+	if err != nil {
+		return err
+	}
+
+	local := make([]*Refund, len(refunds))
+	for i := range refunds {
+		local[i] = &refunds[i]
+	}
+
+	used, err := reserved(refunds, p.Amount.Currency())
+	if err != nil {
+		return err
+	}
+
+	settled := []*Refund{}
+	for _, remote := range response.Refunds {
+		if remote.Status != bank.StatusApproved {
+			continue
+		}
+
+		refund := matchRefund(local, remote)
+		if refund == nil {
+			refund = &Refund{
+				PaymentId:        p.Id,
+				InvoiceId:        p.InvoiceId,
+				OwnerId:          p.OwnerId,
+				Provider:         provider,
+				ProviderRefundId: remote.Id,
+				Amount:           remote.Amount,
+				Status:           RefundPending,
+				Source:           RefundFromGateway,
+				Reason:           "Estorno feito no " + provider,
+				Actor:            provider,
+			}
+
+			if err := r.refunds.createTx(ctx, tx, refund); err != nil {
+				return err
+			}
+			local = append(local, refund)
+
+			if err := r.reverseExternalTx(ctx, tx, p, refund, used); err != nil {
+				return err
+			}
+
+			if used, err = used.Add(refund.Amount); err != nil {
+				return err
+			}
+		}
+
+		if refund.Status == RefundApproved {
+			continue
+		}
+
+		if err := r.approveTx(ctx, tx, p, refund, remote.Id); err != nil {
+			return err
+		}
+		settled = append(settled, refund)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, refund := range settled {
+		if refund.CancelServices {
+			r.cancelServices(ctx, refund)
+		}
+	}
+
+	return nil
+}
+
+// capturedTx é o que sobrou do pagamento com a gente, o valor pago menos os
+// estornos já aprovados. É o que um chargeback consegue tirar
+func (r *Refunder) capturedTx(ctx context.Context, tx *sql.Tx, p *Payment) (money.Money, error) {
+	refunds, err := r.refunds.byPaymentTx(ctx, tx, p.Id)
+	if err != nil {
+		return p.Amount, err
+	}
+
+	captured := p.Amount
+	for _, refund := range refunds {
+		if refund.Status != RefundApproved {
+			continue
+		}
+
+		if captured, err = captured.Sub(refund.Amount); err != nil {
+			return p.Amount, err
+		}
+	}
+
+	return captured, nil
+}
+
+// reverseExternalTx tira do saldo o valor pago a mais que um estorno feito
+// no painel devolveu. O dinheiro já saiu, se o crédito foi usado só fica o
+// aviso para um admin cobrar a diferença
+func (r *Refunder) reverseExternalTx(ctx context.Context, tx *sql.Tx, p *Payment, refund *Refund, used money.Money) error {
+	available, err := p.Amount.Sub(used)
+	if err != nil {
+		return err
+	}
+
+	applied, excess, err := r.appliedTx(ctx, tx, p, available)
+	if err != nil {
+		return err
+	}
+
+	err = r.reverseOverpaymentTx(ctx, tx, p, refund, creditShare(refund.Amount, applied, excess))
+	if errors.Is(err, ErrOverpaymentSpent) {
+		logs.NewSistemLogger().LogAndSendSystemMessage("refund " + refund.Id + ": " + err.Error())
+		return nil
+	}
+	return err
+}
+
+func matchRefund(local []*Refund, remote bank.Refund) *Refund {
+	for _, refund := range local {
+		if refund.ProviderRefundId == remote.Id {
+			return refund
+		}
+	}
+
+	for _, refund := range local {
+		if refund.Status == RefundPending && refund.ProviderRefundId == "" && refund.Amount.Compare(remote.Amount) == 0 {
+			return refund
+		}
+	}
+
+	return nil
+}
+
+// cancelServices cancela os serviços da fatura estornada. O estorno já foi
+// feito, uma falha aqui só fica no log para um admin resolver
+func (r *Refunder) cancelServices(ctx context.Context, refund *Refund) {
+	inv, err := r.invoices.Get(ctx, refund.InvoiceId)
+	if err != nil {
+		logs.NewSistemLogger().LogAndSendSystemMessage("refund " + refund.Id + ": " + err.Error())
+		return
+	}
+
+	for _, item := range inv.Items {
+		if item.ServiceId == "" || item.Kind != ItemService {
+			continue
+		}
+
+		_, err := r.lifecycle.Cancel(ctx, item.ServiceId, "Estorno da fatura "+inv.Number)
+		if err != nil && !errors.Is(err, account.ErrInvalidTransition) && !errors.Is(err, account.ErrServiceNotFound) {
+			logs.NewSistemLogger().LogAndSendSystemMessage("refund " + refund.Id + " cancel service " + item.ServiceId + ": " + err.Error())
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"prodata/database"
+	"prodata/money"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type RefundRepository struct {
+	db *sql.DB
+}
+
+func NewRefundRepository(db *sql.DB) *RefundRepository {
+	return &RefundRepository{db: db}
+}
+
+const refundColumns = "id, payment_id, invoice_id, owner_uuid, provider, provider_refund_id, amount, status, source, reason, actor, cancel_services, last_error, created_at, updated_at"
+
+func scanRefund(row interface{ Scan(dest ...any) error }) (*Refund, error) {
+	var r Refund
+	var providerRefundId sql.NullString
+
+	err := row.Scan(
+		&r.Id,
+		&r.PaymentId,
+		&r.InvoiceId,
+		&r.OwnerId,
+		&r.Provider,
+		&providerRefundId,
+		&r.Amount,
+		&r.Status,
+		&r.Source,
+		&r.Reason,
+		&r.Actor,
+		&r.CancelServices,
+		&r.LastError,
+		&r.CreatedAt,
+		&r.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	r.ProviderRefundId = providerRefundId.String
+
+	return &r, nil
+}
+
+func (r *RefundRepository) createTx(ctx context.Context, tx *sql.Tx, refund *Refund) error {
+	refund.Id = uuid.New().String()
+	refund.CreatedAt = time.Now().Format(time.DateTime)
+	refund.UpdatedAt = refund.CreatedAt
+
+	_, err := tx.ExecContext(ctx, "INSERT INTO refunds ("+refundColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		refund.Id,
+		refund.PaymentId,
+		refund.InvoiceId,
+		refund.OwnerId,
+		refund.Provider,
+		nullable(refund.ProviderRefundId),
+		refund.Amount,
+		refund.Status,
+		refund.Source,
+		database.Truncate(refund.Reason, 255),
+		refund.Actor,
+		refund.CancelServices,
+		refund.LastError,
+		refund.CreatedAt,
+		refund.UpdatedAt)
+	return err
+}
+
+func (r *RefundRepository) Get(ctx context.Context, id string) (*Refund, error) {
+	refund, err := scanRefund(r.db.QueryRowContext(ctx, "SELECT "+refundColumns+" FROM refunds WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRefundNotFound
+	}
+
+	return refund, err
+}
+
+func (r *RefundRepository) getTx(ctx context.Context, tx *sql.Tx, id string) (*Refund, error) {
+	refund, err := scanRefund(tx.QueryRowContext(ctx, "SELECT "+refundColumns+" FROM refunds WHERE id = ? FOR UPDATE", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRefundNotFound
+	}
+
+	return refund, err
+}
+
+// byPaymentTx devolve os estornos do pagamento, o chamador já deve ter
+// travado o pagamento
+func (r *RefundRepository) byPaymentTx(ctx context.Context, tx *sql.Tx, paymentId string) ([]Refund, error) {
+	return r.list(ctx, tx, "payment_id = ?", paymentId)
+}
+
+func (r *RefundRepository) ListByInvoice(ctx context.Context, invoiceId string) ([]Refund, error) {
+	return r.list(ctx, r.db, "invoice_id = ?", invoiceId)
+}
+
+func (r *RefundRepository) list(ctx context.Context, db querier, clause string, args ...any) ([]Refund, error) {
+	rows, err := db.QueryContext(ctx, "SELECT "+refundColumns+" FROM refunds WHERE "+clause+" ORDER BY created_at, id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refunds := []Refund{}
+	for rows.Next() {
+		refund, err := scanRefund(rows)
+		if err != nil {
+			return nil, err
+		}
+		refunds = append(refunds, *refund)
+	}
+
+	return refunds, rows.Err()
+}
+
+// approveTx marca o estorno como feito no gateway
+func (r *RefundRepository) approveTx(ctx context.Context, tx *sql.Tx, refund *Refund, providerRefundId string) error {
+	refund.Status = RefundApproved
+	refund.ProviderRefundId = providerRefundId
+	refund.UpdatedAt = time.Now().Format(time.DateTime)
+
+	_, err := tx.ExecContext(ctx, "UPDATE refunds SET status = ?, provider_refund_id = ?, updated_at = ? WHERE id = ?",
+		refund.Status, nullable(refund.ProviderRefundId), refund.UpdatedAt, refund.Id)
+	return err
+}
+
+// failWith só muda um estorno pending, failed diz se ele mudou
+func (r *RefundRepository) failWith(ctx context.Context, exec execer, id string, cause error) (failed bool, err error) {
+	result, err := exec.ExecContext(ctx, "UPDATE refunds SET status = ?, last_error = ?, updated_at = ? WHERE id = ? AND status = ?",
+		RefundFailed, database.Truncate(cause.Error(), 512), time.Now().Format(time.DateTime), id, RefundPending)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// Os números das notas de crédito seguem o das faturas (NC-2025-000001),
+// com uma sequência própria por ano
+func nextCreditNoteNumber(ctx context.Context, tx *sql.Tx, now time.Time) (string, error) {
+	year := now.Year()
+
+	_, err := tx.ExecContext(ctx, "INSERT IGNORE INTO credit_note_sequences (year, last_number) VALUES (?, 0)", year)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE credit_note_sequences SET last_number = last_number + 1 WHERE year = ?", year)
+	if err != nil {
+		return "", err
+	}
+
+	var number int
+	err = tx.QueryRowContext(ctx, "SELECT last_number FROM credit_note_sequences WHERE year = ?", year).Scan(&number)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("NC-%d-%06d", year, number), nil
+}
+
+// createCreditNoteTx emite a nota de crédito do estorno e soma o valor no
+// que já foi devolvido da fatura
+func (r *RefundRepository) createCreditNoteTx(ctx context.Context, tx *sql.Tx, refund *Refund) (*CreditNote, error) {
+	now := time.Now()
+
+	number, err := nextCreditNoteNumber(ctx, tx, now)
+	if err != nil {
+		return nil, err
+	}
+
+	note := &CreditNote{
+		Id:        uuid.New().String(),
+		Number:    number,
+		InvoiceId: refund.InvoiceId,
+		OwnerId:   refund.OwnerId,
+		RefundId:  refund.Id,
+		Amount:    refund.Amount,
+		Reason:    database.Truncate(refund.Reason, 255),
+		CreatedAt: now.Format(time.DateTime),
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO credit_notes (id, number, invoice_id, owner_uuid, refund_id, amount, reason, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		note.Id,
+		note.Number,
+		note.InvoiceId,
+		note.OwnerId,
+		note.RefundId,
+		note.Amount,
+		note.Reason,
+		note.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE invoices SET amount_refunded = amount_refunded + ?, updated_at = ? WHERE id = ?", note.Amount, note.CreatedAt, note.InvoiceId)
+	if err != nil {
+		return nil, err
+	}
+
+	return note, nil
+}
+
+// reserved soma os estornos pendentes e aprovados, o que já saiu ou está
+// saindo do pagamento
+func reserved(refunds []Refund, currency money.Currency) (money.Money, error) {
+	total := money.New(0, currency)
+
+	for _, refund := range refunds {
+		if refund.Status == RefundFailed {
+			continue
+		}
+
+		var err error
+		if total, err = total.Add(refund.Amount); err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
+}
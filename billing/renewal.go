@@ -0,0 +1,292 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"prodata/database/account"
+	"prodata/emailHandler"
+	"prodata/finances"
+	"prodata/logs"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type RenewalStatus string
+
+const (
+	RenewalPending RenewalStatus = "pending"
+	RenewalDone    RenewalStatus = "done"
+	RenewalSkipped RenewalStatus = "skipped"
+	RenewalFailed  RenewalStatus = "failed"
+)
+
+// Quanto tempo uma instância fica com as renovações que pegou, depois disso
+// outra instância pode assumir
+const renewalLease = 10 * time.Minute
+
+// Renewal é a renovação de um serviço para o período que começa no
+// vencimento atual dele
+type Renewal struct {
+	Id            string
+	ServiceId     string
+	OwnerId       string
+	PeriodStart   string
+	Status        RenewalStatus
+	InvoiceId     string
+	RemindedAt    string
+	ChargedAt     string
+	Attempts      int
+	LastError     string
+	NextAttemptAt string
+}
+
+type RenewalConfig struct {
+	// Quantos dias antes do vencimento a fatura é gerada
+	DaysBefore  int
+	Interval    time.Duration
+	BatchSize   int
+	MaxAttempts int
+}
+
+func RenewalConfigFromEnv() RenewalConfig {
+	daysBefore, err := strconv.Atoi(os.Getenv("RENEWAL_DAYS_BEFORE"))
+	if err != nil || daysBefore < 0 {
+		daysBefore = 7
+	}
+
+	interval, err := time.ParseDuration(os.Getenv("RENEWAL_INTERVAL"))
+	if err != nil || interval <= 0 {
+		interval = 10 * time.Minute
+	}
+
+	return RenewalConfig{
+		DaysBefore:  daysBefore,
+		Interval:    interval,
+		BatchSize:   50,
+		MaxAttempts: 5,
+	}
+}
+
+// RenewalScheduler gera as faturas de renovação, manda o lembrete e tenta a
+// cobrança automática. Pode rodar em várias instâncias, cada renovação é
+// reservada no banco antes de ser processada. Quem avança o vencimento do
+// serviço é a conciliação, quando a fatura é paga
+type RenewalScheduler struct {
+	db       *sql.DB
+	invoices *InvoiceRepository
+	coupons  *Coupons
+	renewals *RenewalRepository
+	services *account.ServiceRepository
+	methods  *PaymentMethodRepository
+	users    *account.UserRepository
+	checkout *Checkout
+	clock    finances.Clock
+	config   RenewalConfig
+}
+
+func NewRenewalScheduler(db *sql.DB, invoices *InvoiceRepository, coupons *Coupons, renewals *RenewalRepository, services *account.ServiceRepository, methods *PaymentMethodRepository, users *account.UserRepository, checkout *Checkout, clock finances.Clock, config RenewalConfig) *RenewalScheduler {
+	if clock == nil {
+		clock = finances.SystemClock
+	}
+
+	return &RenewalScheduler{
+		db:       db,
+		invoices: invoices,
+		coupons:  coupons,
+		renewals: renewals,
+		services: services,
+		methods:  methods,
+		users:    users,
+		checkout: checkout,
+		clock:    clock,
+		config:   config,
+	}
+}
+
+// Run agenda e processa as renovações a cada Interval. Mais de uma instância
+// pode rodar ao mesmo tempo, cada uma só mexe nas renovações que reservou
+func (s *RenewalScheduler) Run(ctx context.Context) {
+	every(ctx, s.config.Interval, "renewals", s.RunOnce)
+}
+
+func (s *RenewalScheduler) RunOnce(ctx context.Context) error {
+	now := s.clock.Now()
+
+	if _, err := s.renewals.Schedule(ctx, now.AddDate(0, 0, s.config.DaysBefore), now); err != nil {
+		return err
+	}
+
+	claimed, err := s.renewals.Claim(ctx, uuid.New().String(), s.config.BatchSize, renewalLease, now)
+	if err != nil {
+		return err
+	}
+
+	for i := range claimed {
+		renewal := &claimed[i]
+
+		status, err := s.process(ctx, renewal)
+		if err == nil {
+			err = s.renewals.finish(ctx, renewal.Id, status, s.clock.Now())
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		logs.NewSistemLogger().LogAndSendSystemMessage("renewal " + renewal.Id + ": " + err.Error())
+
+		status = RenewalPending
+		if renewal.Attempts+1 >= s.config.MaxAttempts {
+			status = RenewalFailed
+		}
+
+		next := now.Add(time.Duration(renewal.Attempts+1) * s.config.Interval)
+		if err := s.renewals.retry(ctx, renewal.Id, status, err, next, s.clock.Now()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// process faz os passos que ainda faltam. Cada passo é gravado quando
+// termina, uma renovação retomada não gera outra fatura nem outro lembrete
+func (s *RenewalScheduler) process(ctx context.Context, renewal *Renewal) (RenewalStatus, error) {
+	if renewal.InvoiceId == "" {
+		created, err := s.createInvoice(ctx, renewal)
+		if err != nil {
+			return "", err
+		}
+
+		if !created {
+			return RenewalSkipped, nil
+		}
+	}
+
+	inv, err := s.invoices.Get(ctx, renewal.InvoiceId)
+	if err != nil {
+		return "", err
+	}
+
+	if inv.Status != InvoiceOpen && inv.Status != InvoiceOverdue {
+		// Já paga ou cancelada, não tem o que lembrar nem cobrar
+		return RenewalDone, nil
+	}
+
+	autoCharge, err := s.methods.AutoCharge(ctx, renewal.OwnerId)
+	if err != nil {
+		return "", err
+	}
+
+	if renewal.RemindedAt == "" {
+		if err := s.remind(ctx, renewal, inv, autoCharge); err != nil {
+			return "", err
+		}
+	}
+
+	if autoCharge && renewal.ChargedAt == "" {
+		p, err := s.checkout.ChargeSaved(ctx, renewal.OwnerId, inv.Id, "")
+
+		detail := ""
+		switch {
+		case errors.Is(err, ErrNoPaymentMethod), errors.Is(err, ErrInvoiceNotPayable), errors.Is(err, ErrPaymentProcessing), errors.Is(err, ErrPaymentInProgress):
+			detail = err.Error()
+		case err != nil:
+			return "", err
+		default:
+			detail = string(p.Status) + " " + p.StatusDetail
+		}
+
+		if err := s.renewals.markCharged(ctx, renewal.Id, detail, s.clock.Now()); err != nil {
+			return "", err
+		}
+	}
+
+	return RenewalDone, nil
+}
+
+// createInvoice gera a fatura e liga ela à renovação na mesma transação.
+// Retorna false quando o serviço já não precisa dessa renovação
+func (s *RenewalScheduler) createInvoice(ctx context.Context, renewal *Renewal) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var invoiceId sql.NullString
+	err = tx.QueryRowContext(ctx, "SELECT invoice_id FROM renewals WHERE id = ? FOR UPDATE", renewal.Id).Scan(&invoiceId)
+	if err != nil {
+		return false, err
+	}
+
+	if invoiceId.Valid {
+		renewal.InvoiceId = invoiceId.String
+		return true, nil
+	}
+
+	service, err := s.services.GetForUpdate(ctx, tx, renewal.OwnerId, renewal.ServiceId)
+	if errors.Is(err, account.ErrServiceNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// Serviço cancelado ou já renovado para outro período
+	if service.Status != account.StatusActive || service.Date != renewal.PeriodStart {
+		return false, nil
+	}
+
+	dueDate, err := time.ParseInLocation(time.DateTime, renewal.PeriodStart, time.Local)
+	if err != nil {
+		return false, fmt.Errorf("renewal %s: %w", renewal.Id, err)
+	}
+
+	inv, err := serviceInvoice(renewal.OwnerId, []account.Services{*service}, dueDate)
+	if err != nil {
+		return false, err
+	}
+
+	// Cupons recorrentes do serviço entram como desconto na renovação
+	if err := s.coupons.CreateInvoiceTx(ctx, tx, inv, ""); err != nil {
+		return false, err
+	}
+
+	if err := s.renewals.setInvoiceTx(ctx, tx, renewal.Id, inv.Id, s.clock.Now()); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	renewal.InvoiceId = inv.Id
+	return true, nil
+}
+
+func (s *RenewalScheduler) remind(ctx context.Context, renewal *Renewal, inv *Invoice, autoCharge bool) error {
+	email, err := s.users.FindEmailByUUID(ctx, renewal.OwnerId)
+	if err != nil {
+		return err
+	}
+
+	name := inv.Number
+	if len(inv.Items) > 0 {
+		name = inv.Items[0].Description
+	}
+
+	dueDate := inv.DueDate
+	if parsed, err := time.ParseInLocation(time.DateTime, inv.DueDate, time.Local); err == nil {
+		dueDate = parsed.Format("02/01/2006")
+	}
+
+	emailHandler.SendRenewalReminder(email, name, inv.Total.String(), dueDate, autoCharge)
+
+	return s.renewals.markReminded(ctx, renewal.Id, s.clock.Now())
+}
@@ -0,0 +1,135 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"prodata/database"
+	"prodata/database/account"
+	"time"
+)
+
+type RenewalRepository struct {
+	db *sql.DB
+}
+
+func NewRenewalRepository(db *sql.DB) *RenewalRepository {
+	return &RenewalRepository{db: db}
+}
+
+const renewalColumns = "id, service_id, owner_uuid, period_start, status, invoice_id, reminded_at, charged_at, attempts, last_error, next_attempt_at"
+
+func scanRenewal(row interface{ Scan(dest ...any) error }) (*Renewal, error) {
+	var r Renewal
+	var invoiceId, remindedAt, chargedAt sql.NullString
+
+	err := row.Scan(
+		&r.Id,
+		&r.ServiceId,
+		&r.OwnerId,
+		&r.PeriodStart,
+		&r.Status,
+		&invoiceId,
+		&remindedAt,
+		&chargedAt,
+		&r.Attempts,
+		&r.LastError,
+		&r.NextAttemptAt)
+	if err != nil {
+		return nil, err
+	}
+
+	r.InvoiceId = invoiceId.String
+	r.RemindedAt = remindedAt.String
+	r.ChargedAt = chargedAt.String
+
+	return &r, nil
+}
+
+// Schedule cria a renovação dos serviços ativos que vencem até until. O
+// INSERT IGNORE com a chave (service_id, period_start) deixa várias
+// instâncias rodarem isso ao mesmo tempo sem duplicar nada
+func (r *RenewalRepository) Schedule(ctx context.Context, until, now time.Time) (int64, error) {
+	at := now.Format(time.DateTime)
+
+	result, err := r.db.ExecContext(ctx, `INSERT IGNORE INTO renewals (id, service_id, owner_uuid, period_start, status, next_attempt_at, created_at, updated_at)
+SELECT UUID(), id, owner_uuid, due_date, ?, ?, ?, ? FROM services WHERE status = ? AND due_date IS NOT NULL AND due_date <= ?`,
+		RenewalPending,
+		at,
+		at,
+		at,
+		account.StatusActive,
+		until.Format(time.DateTime))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// Claim reserva até limit renovações para owner durante lease. Uma reserva
+// vencida é de uma instância que caiu e volta a ficar disponível
+func (r *RenewalRepository) Claim(ctx context.Context, owner string, limit int, lease time.Duration, now time.Time) ([]Renewal, error) {
+	at := now.Format(time.DateTime)
+
+	_, err := r.db.ExecContext(ctx, `UPDATE renewals SET locked_by = ?, locked_until = ?, updated_at = ?
+WHERE status = ? AND next_attempt_at <= ? AND (locked_until IS NULL OR locked_until < ?)
+ORDER BY next_attempt_at LIMIT ?`,
+		owner,
+		now.Add(lease).Format(time.DateTime),
+		at,
+		RenewalPending,
+		at,
+		at,
+		limit)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.QueryContext(ctx, "SELECT "+renewalColumns+" FROM renewals WHERE locked_by = ? AND status = ?", owner, RenewalPending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	renewals := []Renewal{}
+	for rows.Next() {
+		renewal, err := scanRenewal(rows)
+		if err != nil {
+			return nil, err
+		}
+		renewals = append(renewals, *renewal)
+	}
+
+	return renewals, rows.Err()
+}
+
+func (r *RenewalRepository) setInvoiceTx(ctx context.Context, tx *sql.Tx, id, invoiceId string, now time.Time) error {
+	_, err := tx.ExecContext(ctx, "UPDATE renewals SET invoice_id = ?, updated_at = ? WHERE id = ?", invoiceId, now.Format(time.DateTime), id)
+	return err
+}
+
+func (r *RenewalRepository) markReminded(ctx context.Context, id string, now time.Time) error {
+	at := now.Format(time.DateTime)
+	_, err := r.db.ExecContext(ctx, "UPDATE renewals SET reminded_at = ?, updated_at = ? WHERE id = ?", at, at, id)
+	return err
+}
+
+func (r *RenewalRepository) markCharged(ctx context.Context, id, detail string, now time.Time) error {
+	at := now.Format(time.DateTime)
+	_, err := r.db.ExecContext(ctx, "UPDATE renewals SET charged_at = ?, last_error = ?, updated_at = ? WHERE id = ?", at, database.Truncate(detail, 512), at, id)
+	return err
+}
+
+// finish encerra a renovação e solta a reserva
+func (r *RenewalRepository) finish(ctx context.Context, id string, status RenewalStatus, now time.Time) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE renewals SET status = ?, locked_by = NULL, locked_until = NULL, updated_at = ? WHERE id = ?",
+		status, now.Format(time.DateTime), id)
+	return err
+}
+
+// retry guarda o erro e solta a reserva para tentar de novo em next
+func (r *RenewalRepository) retry(ctx context.Context, id string, status RenewalStatus, cause error, next, now time.Time) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE renewals SET status = ?, attempts = attempts + 1, last_error = ?, next_attempt_at = ?, locked_by = NULL, locked_until = NULL, updated_at = ? WHERE id = ?",
+		status, database.Truncate(cause.Error(), 512), next.Format(time.DateTime), now.Format(time.DateTime), id)
+	return err
+}
@@ -0,0 +1,48 @@
+package billing
+
+import (
+	"errors"
+	"prodata/money"
+)
+
+type WalletSource string
+
+const (
+	WalletOverpayment WalletSource = "overpayment"
+	WalletDowngrade   WalletSource = "downgrade"
+	WalletRefund      WalletSource = "refund"
+	WalletManual      WalletSource = "manual"
+	// O que já tinha sido pago num pedido cancelado antes de quitar
+	WalletCancellation WalletSource = "cancellation"
+	// Saldo usado para pagar uma fatura, sempre negativo
+	WalletInvoice WalletSource = "invoice"
+	// O valor pago a mais que voltou pelo gateway num estorno, sempre
+	// negativo. Se o estorno falha o crédito volta como overpayment com o
+	// id do estorno na reference
+	WalletOverpaymentRefund WalletSource = "overpayment_refund"
+)
+
+// Pagamentos feitos com o saldo entram na tabela de pagamentos com esse
+// provider, assim a conciliação trata eles igual aos do gateway
+const ProviderWallet = "wallet"
+
+var (
+	ErrInsufficientCredit = errors.New("credit balance cannot go negative")
+	ErrInvalidWalletEntry = errors.New("amount must not be zero")
+)
+
+// WalletEntry é um lançamento no saldo do cliente, nunca é alterado nem
+// apagado. Correções são feitas com outro lançamento
+type WalletEntry struct {
+	Id           int64        `json:"id"`
+	OwnerId      string       `json:"-"`
+	Source       WalletSource `json:"source"`
+	Amount       money.Money  `json:"amount"`
+	BalanceAfter money.Money  `json:"balance_after"`
+	InvoiceId    string       `json:"invoice_id,omitempty"`
+	PaymentId    string       `json:"payment_id,omitempty"`
+	Reference    string       `json:"reference,omitempty"`
+	Description  string       `json:"description"`
+	Actor        string       `json:"actor"`
+	CreatedAt    string       `json:"created_at"`
+}
@@ -0,0 +1,176 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"prodata/database"
+	"prodata/finances"
+	"prodata/money"
+	"strconv"
+	"time"
+)
+
+type WalletRepository struct {
+	db *sql.DB
+}
+
+func NewWalletRepository(db *sql.DB) *WalletRepository {
+	return &WalletRepository{db: db}
+}
+
+// balanceTx trava o saldo do cliente até o fim da transação, criando a linha
+// na primeira vez
+func (r *WalletRepository) balanceTx(ctx context.Context, tx *sql.Tx, ownerId string) (money.Money, error) {
+	var balance money.Money
+
+	_, err := tx.ExecContext(ctx, "INSERT IGNORE INTO wallets (owner_uuid, balance, updated_at) VALUES (?, 0, ?)", ownerId, time.Now().Format(time.DateTime))
+	if err != nil {
+		return balance, err
+	}
+
+	err = tx.QueryRowContext(ctx, "SELECT balance FROM wallets WHERE owner_uuid = ? FOR UPDATE", ownerId).Scan(&balance)
+	return balance, err
+}
+
+// addTx lança a entrada e atualiza o saldo. Retorna false quando a mesma
+// source e reference já tinham sido lançadas
+func (r *WalletRepository) addTx(ctx context.Context, tx *sql.Tx, entry *WalletEntry) (bool, error) {
+	if entry.Amount.IsZero() {
+		return false, ErrInvalidWalletEntry
+	}
+
+	balance, err := r.balanceTx(ctx, tx, entry.OwnerId)
+	if err != nil {
+		return false, err
+	}
+
+	entry.BalanceAfter, err = balance.Add(entry.Amount)
+	if err != nil {
+		return false, err
+	}
+
+	if entry.BalanceAfter.IsNegative() {
+		return false, ErrInsufficientCredit
+	}
+
+	entry.CreatedAt = time.Now().Format(time.DateTime)
+
+	result, err := tx.ExecContext(ctx, "INSERT INTO wallet_entries (owner_uuid, source, amount, balance_after, invoice_id, payment_id, reference, description, actor, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		entry.OwnerId,
+		entry.Source,
+		entry.Amount,
+		entry.BalanceAfter,
+		nullable(entry.InvoiceId),
+		nullable(entry.PaymentId),
+		nullable(entry.Reference),
+		database.Truncate(entry.Description, 255),
+		entry.Actor,
+		entry.CreatedAt)
+	if database.IsDuplicate(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	entry.Id, err = result.LastInsertId()
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE wallets SET balance = ?, updated_at = ? WHERE owner_uuid = ?", entry.BalanceAfter, entry.CreatedAt, entry.OwnerId)
+	if err != nil {
+		return false, err
+	}
+
+	// Sobras de pagamento, estornos e uso em fatura já entram no livro pelo
+	// lançamento do pagamento ou do estorno
+	if entry.Source == WalletDowngrade || entry.Source == WalletCancellation || entry.Source == WalletManual {
+		journal := finances.CreditGranted(strconv.FormatInt(entry.Id, 10), entry.OwnerId, entry.Description, entry.Source != WalletManual, entry.Amount)
+		if _, err := finances.PostTx(ctx, tx, journal); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// overpaidTx é o crédito que o pagamento deixou no saldo e que ainda não
+// voltou pelo gateway
+func (r *WalletRepository) overpaidTx(ctx context.Context, tx *sql.Tx, paymentId string) (money.Money, error) {
+	var total money.Money
+
+	err := tx.QueryRowContext(ctx, "SELECT COALESCE(SUM(amount), 0) FROM wallet_entries WHERE payment_id = ? AND source IN (?, ?)",
+		paymentId, WalletOverpayment, WalletOverpaymentRefund).Scan(&total)
+	return total, err
+}
+
+// reversedTx é quanto do crédito o estorno tirou do saldo e ainda não
+// devolveu
+func (r *WalletRepository) reversedTx(ctx context.Context, tx *sql.Tx, refundId string) (money.Money, error) {
+	var total money.Money
+
+	err := tx.QueryRowContext(ctx, "SELECT COALESCE(SUM(amount), 0) FROM wallet_entries WHERE reference = ? AND source IN (?, ?)",
+		refundId, WalletOverpayment, WalletOverpaymentRefund).Scan(&total)
+	return total.Negate(), err
+}
+
+// Add lança a entrada numa transação própria, usado nos ajustes manuais
+func (r *WalletRepository) Add(ctx context.Context, entry *WalletEntry) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := r.addTx(ctx, tx, entry); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *WalletRepository) Balance(ctx context.Context, ownerId string) (money.Money, error) {
+	var balance money.Money
+
+	err := r.db.QueryRowContext(ctx, "SELECT balance FROM wallets WHERE owner_uuid = ?", ownerId).Scan(&balance)
+	if errors.Is(err, sql.ErrNoRows) {
+		return money.New(0, money.BRL), nil
+	}
+
+	return balance, err
+}
+
+func (r *WalletRepository) Entries(ctx context.Context, ownerId string, limit, offset int) ([]WalletEntry, int, error) {
+	var total int
+	err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM wallet_entries WHERE owner_uuid = ?", ownerId).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := r.db.QueryContext(ctx, "SELECT id, owner_uuid, source, amount, balance_after, invoice_id, payment_id, reference, description, actor, created_at FROM wallet_entries WHERE owner_uuid = ? ORDER BY id DESC LIMIT ? OFFSET ?",
+		ownerId, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	entries := []WalletEntry{}
+	for rows.Next() {
+		var e WalletEntry
+		var invoiceId, paymentId, reference sql.NullString
+
+		err := rows.Scan(&e.Id, &e.OwnerId, &e.Source, &e.Amount, &e.BalanceAfter, &invoiceId, &paymentId, &reference, &e.Description, &e.Actor, &e.CreatedAt)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		e.InvoiceId = invoiceId.String
+		e.PaymentId = paymentId.String
+		e.Reference = reference.String
+		entries = append(entries, e)
+	}
+
+	return entries, total, rows.Err()
+}
@@ -0,0 +1,26 @@
+package billing
+
+import (
+	"context"
+	"prodata/logs"
+	"time"
+)
+
+// every chama run na hora e depois a cada interval até o ctx acabar. O erro
+// de uma rodada vai para o log com name e a próxima roda normalmente
+func every(ctx context.Context, interval time.Duration, name string, run func(ctx context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := run(ctx); err != nil {
+			logs.NewSistemLogger().LogAndSendSystemMessage(name + ": " + err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
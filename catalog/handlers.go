@@ -0,0 +1,335 @@
+package catalog
+
+import (
+	"errors"
+	"net/http"
+	"prodata/api"
+	"prodata/database"
+	"prodata/database/account"
+)
+
+type Handler struct {
+	repo     *Repository
+	services *account.ServiceRepository
+}
+
+func NewHandler(repo *Repository, services *account.ServiceRepository) *Handler {
+	return &Handler{repo: repo, services: services}
+}
+
+func (h *Handler) writeError(ctx *api.Context, err error) {
+	switch {
+	case errors.Is(err, ErrCategoryNotFound), errors.Is(err, ErrProductNotFound), errors.Is(err, ErrPlanNotFound):
+		ctx.Error(err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrPriceNotFound), errors.Is(err, ErrPlanUnavailable), errors.Is(err, ErrOutOfStock),
+		errors.Is(err, ErrInvalidOption), errors.Is(err, ErrOptionRequired):
+		ctx.Error(err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, ErrInUse):
+		ctx.Error(err.Error(), http.StatusConflict)
+	case database.IsDuplicate(err):
+		ctx.Error("slug already in use", http.StatusConflict)
+	default:
+		ctx.Logger.LogAndSendSystemMessage(err.Error())
+		ctx.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// Rotas públicas
+
+func (h *Handler) ListCategories(ctx *api.Context) {
+	categories, err := h.repo.ListCategories(ctx.Request.Context(), false)
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Json(categories)
+}
+
+// GET /catalog/products?category=<id ou slug>
+func (h *Handler) ListProducts(ctx *api.Context) {
+	products, err := h.repo.ListProducts(ctx.Request.Context(), ProductFilter{
+		Category: ctx.Request.URL.Query().Get("category"),
+	})
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Json(products)
+}
+
+func (h *Handler) GetProduct(ctx *api.Context) {
+	product, err := h.repo.GetProduct(ctx.Request.Context(), ctx.Param("id"), false)
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Json(product)
+}
+
+func (h *Handler) GetPlan(ctx *api.Context) {
+	plan, err := h.repo.GetPlan(ctx.Request.Context(), ctx.Param("id"), false)
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Json(plan)
+}
+
+// Rotas de administrador
+
+func (h *Handler) AdminListCategories(ctx *api.Context) {
+	categories, err := h.repo.ListCategories(ctx.Request.Context(), true)
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Json(categories)
+}
+
+func (h *Handler) AdminCreateCategory(ctx *api.Context) {
+	var category Category
+	if err := ctx.ReadJson(&category); err != nil {
+		ctx.Error(err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := category.Validate(); err != nil {
+		ctx.Error(err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.repo.CreateCategory(ctx.Request.Context(), &category); err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.JsonStatus(http.StatusCreated, category)
+}
+
+func (h *Handler) AdminUpdateCategory(ctx *api.Context) {
+	var category Category
+	if err := ctx.ReadJson(&category); err != nil {
+		ctx.Error(err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	category.Id = ctx.Param("id")
+
+	if err := category.Validate(); err != nil {
+		ctx.Error(err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.repo.UpdateCategory(ctx.Request.Context(), &category); err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Json(category)
+}
+
+func (h *Handler) AdminDeleteCategory(ctx *api.Context) {
+	if err := h.repo.DeleteCategory(ctx.Request.Context(), ctx.Param("id")); err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) AdminListProducts(ctx *api.Context) {
+	products, err := h.repo.ListProducts(ctx.Request.Context(), ProductFilter{
+		Category:      ctx.Request.URL.Query().Get("category"),
+		IncludeHidden: true,
+	})
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Json(products)
+}
+
+func (h *Handler) AdminCreateProduct(ctx *api.Context) {
+	var product Product
+	if err := ctx.ReadJson(&product); err != nil {
+		ctx.Error(err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := product.Validate(); err != nil {
+		ctx.Error(err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.repo.CreateProduct(ctx.Request.Context(), &product); err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.JsonStatus(http.StatusCreated, product)
+}
+
+func (h *Handler) AdminUpdateProduct(ctx *api.Context) {
+	var product Product
+	if err := ctx.ReadJson(&product); err != nil {
+		ctx.Error(err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	product.Id = ctx.Param("id")
+
+	if err := product.Validate(); err != nil {
+		ctx.Error(err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.repo.UpdateProduct(ctx.Request.Context(), &product); err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	updated, err := h.repo.GetProduct(ctx.Request.Context(), product.Id, true)
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Json(updated)
+}
+
+func (h *Handler) AdminDeleteProduct(ctx *api.Context) {
+	if err := h.repo.DeleteProduct(ctx.Request.Context(), ctx.Param("id")); err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.WriteHeader(http.StatusNoContent)
+}
+
+// POST /admin/catalog/products/{id}/plans
+func (h *Handler) AdminCreatePlan(ctx *api.Context) {
+	var plan Plan
+	if err := ctx.ReadJson(&plan); err != nil {
+		ctx.Error(err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	plan.ProductId = ctx.Param("id")
+
+	if err := plan.Validate(); err != nil {
+		ctx.Error(err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.repo.CreatePlan(ctx.Request.Context(), &plan); err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.JsonStatus(http.StatusCreated, plan)
+}
+
+func (h *Handler) AdminGetPlan(ctx *api.Context) {
+	plan, err := h.repo.GetPlan(ctx.Request.Context(), ctx.Param("id"), true)
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Json(plan)
+}
+
+func (h *Handler) AdminUpdatePlan(ctx *api.Context) {
+	var plan Plan
+	if err := ctx.ReadJson(&plan); err != nil {
+		ctx.Error(err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	plan.Id = ctx.Param("id")
+
+	if err := plan.Validate(); err != nil {
+		ctx.Error(err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.repo.UpdatePlan(ctx.Request.Context(), &plan); err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	updated, err := h.repo.GetPlan(ctx.Request.Context(), plan.Id, true)
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Json(updated)
+}
+
+func (h *Handler) AdminDeletePlan(ctx *api.Context) {
+	if err := h.repo.DeletePlan(ctx.Request.Context(), ctx.Param("id")); err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.WriteHeader(http.StatusNoContent)
+}
+
+// POST /admin/users/{id}/services com {"plan_id": "...", "cycle": "monthly"},
+// cria um serviço pending para o usuário a partir de um plano do catálogo
+func (h *Handler) AdminCreateService(ctx *api.Context) {
+	var body struct {
+		PlanId string       `json:"plan_id"`
+		Cycle  BillingCycle `json:"cycle"`
+	}
+
+	if err := ctx.ReadJson(&body); err != nil {
+		ctx.Error(err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if body.Cycle == "" {
+		body.Cycle = CycleMonthly
+	}
+
+	if !body.Cycle.Valid() {
+		ctx.Error("invalid billing cycle", http.StatusBadRequest)
+		return
+	}
+
+	service, err := h.repo.NewService(ctx.Request.Context(), h.services, ctx.Param("id"), body.PlanId, body.Cycle)
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.JsonStatus(http.StatusCreated, service)
+}
+
+func (h *Handler) Register(public *api.Router, admin *api.Group) {
+	public.Get("/catalog/categories", h.ListCategories)
+	public.Get("/catalog/products", h.ListProducts)
+	public.Get("/catalog/products/{id}", h.GetProduct)
+	public.Get("/catalog/plans/{id}", h.GetPlan)
+
+	admin.Get("/catalog/categories", h.AdminListCategories)
+	admin.Post("/catalog/categories", h.AdminCreateCategory)
+	admin.Put("/catalog/categories/{id}", h.AdminUpdateCategory)
+	admin.Delete("/catalog/categories/{id}", h.AdminDeleteCategory)
+	admin.Get("/catalog/products", h.AdminListProducts)
+	admin.Post("/catalog/products", h.AdminCreateProduct)
+	admin.Put("/catalog/products/{id}", h.AdminUpdateProduct)
+	admin.Delete("/catalog/products/{id}", h.AdminDeleteProduct)
+	admin.Post("/catalog/products/{id}/plans", h.AdminCreatePlan)
+	admin.Get("/catalog/plans/{id}", h.AdminGetPlan)
+	admin.Put("/catalog/plans/{id}", h.AdminUpdatePlan)
+	admin.Delete("/catalog/plans/{id}", h.AdminDeletePlan)
+	admin.Post("/users/{id}/services", h.AdminCreateService)
+}
@@ -0,0 +1,294 @@
+package catalog
+
+import (
+	"errors"
+	"prodata/finances"
+	"prodata/money"
+	"regexp"
+	"time"
+)
+
+type BillingCycle string
+
+const (
+	CycleMonthly    BillingCycle = "monthly"
+	CycleQuarterly  BillingCycle = "quarterly"
+	CycleSemiannual BillingCycle = "semiannual"
+	CycleAnnual     BillingCycle = "annual"
+)
+
+var cycleMonths = map[BillingCycle]int{
+	CycleMonthly:    1,
+	CycleQuarterly:  3,
+	CycleSemiannual: 6,
+	CycleAnnual:     12,
+}
+
+func (c BillingCycle) Valid() bool {
+	_, ok := cycleMonths[c]
+	return ok
+}
+
+// Months é quantos meses o ciclo cobre, 0 para um ciclo desconhecido
+func (c BillingCycle) Months() int {
+	return cycleMonths[c]
+}
+
+// PeriodEnd é o fim do período que começa em start, contado em meses do
+// calendário
+func (c BillingCycle) PeriodEnd(start time.Time) time.Time {
+	return finances.AddMonths(start, c.Months())
+}
+
+// RenewalEnd é o fim do período seguinte de um serviço que vence no dia
+// anchorDay, mesmo que start tenha sido cortado no fim do mês
+func (c BillingCycle) RenewalEnd(start time.Time, anchorDay int) time.Time {
+	return finances.AddMonthsOn(start, c.Months(), anchorDay)
+}
+
+// CycleOrMonthly lê o ciclo gravado no serviço, os antigos eram todos mensais
+func CycleOrMonthly(cycle string) BillingCycle {
+	if BillingCycle(cycle).Valid() {
+		return BillingCycle(cycle)
+	}
+
+	return CycleMonthly
+}
+
+var (
+	ErrCategoryNotFound = errors.New("category not found")
+	ErrProductNotFound  = errors.New("product not found")
+	ErrPlanNotFound     = errors.New("plan not found")
+	ErrPriceNotFound    = errors.New("plan has no price for this billing cycle")
+	ErrPlanUnavailable  = errors.New("plan is not available")
+	ErrOutOfStock       = errors.New("plan is out of stock")
+	ErrInUse            = errors.New("item is still referenced and cannot be deleted")
+	ErrInvalidOption    = errors.New("option or value is not offered by this plan")
+	ErrOptionRequired   = errors.New("a required plan option was not chosen")
+)
+
+type Category struct {
+	Id          string `json:"id"`
+	Slug        string `json:"slug"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Visible     bool   `json:"visible"`
+	Position    int    `json:"position"`
+}
+
+type Product struct {
+	Id          string `json:"id"`
+	CategoryId  string `json:"category_id"`
+	Slug        string `json:"slug"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Visible     bool   `json:"visible"`
+	Position    int    `json:"position"`
+	Plans       []Plan `json:"plans"`
+}
+
+type Plan struct {
+	Id          string       `json:"id"`
+	ProductId   string       `json:"product_id"`
+	Slug        string       `json:"slug"`
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	RamMB       int          `json:"ram_mb"`
+	CpuCores    int          `json:"cpu_cores"`
+	DiskGB      int          `json:"disk_gb"`
+	Slots       int          `json:"slots"`
+	Visible     bool         `json:"visible"`
+	Stock       *int         `json:"stock"`
+	Available   *int         `json:"available,omitempty"`
+	Position    int          `json:"position"`
+	Prices      []PlanPrice  `json:"prices"`
+	Options     []PlanOption `json:"options"`
+}
+
+// PlanPrice é o preço do ciclo inteiro. Com Discount o preço sai do mensal
+// vezes os meses do ciclo, menos o desconto, e acompanha as mudanças do
+// mensal. Sem Discount vale o Price informado
+type PlanPrice struct {
+	Cycle    BillingCycle `json:"cycle"`
+	Price    money.Money  `json:"price"`
+	Discount int          `json:"discount,omitempty"`
+}
+
+// resolvePrices calcula o preço dos ciclos com desconto a partir do mensal
+func resolvePrices(prices []PlanPrice) error {
+	var monthly *PlanPrice
+	for i := range prices {
+		if prices[i].Cycle == CycleMonthly {
+			monthly = &prices[i]
+		}
+	}
+
+	for i := range prices {
+		price := &prices[i]
+		if price.Discount == 0 {
+			continue
+		}
+
+		if monthly == nil || monthly.Discount != 0 {
+			return ErrPriceNotFound
+		}
+
+		full, err := monthly.Price.Multiply(int64(price.Cycle.Months()))
+		if err != nil {
+			return err
+		}
+
+		if price.Price, err = full.MulDiv(int64(100-price.Discount), 100); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PlanOption é uma escolha que o cliente faz na compra, como a localização
+// ou slots extras. Cada valor soma o seu preço ao do plano
+type PlanOption struct {
+	Id       string        `json:"id"`
+	Name     string        `json:"name"`
+	Required bool          `json:"required"`
+	Position int           `json:"position"`
+	Values   []OptionValue `json:"values"`
+}
+
+type OptionValue struct {
+	Id    string `json:"id"`
+	Label string `json:"label"`
+	// Valor mensal somado ao preço do plano
+	Price    money.Money `json:"price"`
+	Position int         `json:"position"`
+}
+
+// SelectedOption é o que a compra copia da opção escolhida
+type SelectedOption struct {
+	OptionId string      `json:"option_id"`
+	ValueId  string      `json:"value_id"`
+	Name     string      `json:"name"`
+	Label    string      `json:"label"`
+	Price    money.Money `json:"price"`
+}
+
+func (p *Plan) Price(cycle BillingCycle) (money.Money, error) {
+	for _, price := range p.Prices {
+		if price.Cycle == cycle {
+			return price.Price, nil
+		}
+	}
+
+	return money.Money{}, ErrPriceNotFound
+}
+
+var slugRegex = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+func validSlug(slug string) bool {
+	return slugRegex.MatchString(slug)
+}
+
+func (c *Category) Validate() error {
+	if c.Name == "" {
+		return errors.New("category name is required")
+	}
+
+	if !validSlug(c.Slug) {
+		return errors.New("category slug must be lowercase letters, numbers and dashes")
+	}
+
+	return nil
+}
+
+func (p *Product) Validate() error {
+	if p.Name == "" {
+		return errors.New("product name is required")
+	}
+
+	if !validSlug(p.Slug) {
+		return errors.New("product slug must be lowercase letters, numbers and dashes")
+	}
+
+	if p.CategoryId == "" {
+		return errors.New("product category_id is required")
+	}
+
+	return nil
+}
+
+func (p *Plan) Validate() error {
+	if p.Name == "" {
+		return errors.New("plan name is required")
+	}
+
+	if !validSlug(p.Slug) {
+		return errors.New("plan slug must be lowercase letters, numbers and dashes")
+	}
+
+	if p.RamMB < 0 || p.CpuCores < 0 || p.DiskGB < 0 || p.Slots < 0 {
+		return errors.New("plan resources cannot be negative")
+	}
+
+	if p.Stock != nil && *p.Stock < 0 {
+		return errors.New("plan stock cannot be negative")
+	}
+
+	if len(p.Prices) == 0 {
+		return errors.New("plan needs at least one price")
+	}
+
+	seen := map[BillingCycle]bool{}
+	for _, price := range p.Prices {
+		if !price.Cycle.Valid() {
+			return errors.New("invalid billing cycle: " + string(price.Cycle))
+		}
+
+		if seen[price.Cycle] {
+			return errors.New("duplicated billing cycle: " + string(price.Cycle))
+		}
+		seen[price.Cycle] = true
+
+		if price.Price.IsNegative() {
+			return errors.New("plan price cannot be negative")
+		}
+
+		if price.Discount < 0 || price.Discount > 99 {
+			return errors.New("cycle discount must be between 0 and 99 percent")
+		}
+
+		if price.Discount > 0 && price.Cycle == CycleMonthly {
+			return errors.New("the monthly price cannot have a cycle discount")
+		}
+	}
+
+	if err := resolvePrices(p.Prices); err != nil {
+		return errors.New("cycle discounts need a monthly price")
+	}
+
+	if p.Options == nil {
+		p.Options = []PlanOption{}
+	}
+
+	for _, option := range p.Options {
+		if option.Name == "" {
+			return errors.New("plan option name is required")
+		}
+
+		if len(option.Values) == 0 {
+			return errors.New("plan option needs at least one value: " + option.Name)
+		}
+
+		for _, value := range option.Values {
+			if value.Label == "" {
+				return errors.New("option value label is required: " + option.Name)
+			}
+
+			if value.Price.IsNegative() {
+				return errors.New("option value price cannot be negative")
+			}
+		}
+	}
+
+	return nil
+}
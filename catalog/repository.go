@@ -0,0 +1,728 @@
+package catalog
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"prodata/database/account"
+	"prodata/money"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: db}
+}
+
+type ProductFilter struct {
+	Category      string
+	IncludeHidden bool
+}
+
+// Serviços nesses status ocupam uma unidade do estoque do plano
+const stockStatuses = "'pending', 'active', 'suspended'"
+
+func (r *Repository) ListCategories(ctx context.Context, includeHidden bool) ([]Category, error) {
+	query := "SELECT id, slug, name, description, visible, position FROM catalog_categories"
+	if !includeHidden {
+		query += " WHERE visible = 1"
+	}
+	query += " ORDER BY position, name"
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []Category{}
+	for rows.Next() {
+		var c Category
+		if err := rows.Scan(&c.Id, &c.Slug, &c.Name, &c.Description, &c.Visible, &c.Position); err != nil {
+			return nil, err
+		}
+		categories = append(categories, c)
+	}
+
+	return categories, rows.Err()
+}
+
+func (r *Repository) GetCategory(ctx context.Context, id string) (*Category, error) {
+	var c Category
+	err := r.db.QueryRowContext(ctx, "SELECT id, slug, name, description, visible, position FROM catalog_categories WHERE id = ?", id).Scan(
+		&c.Id, &c.Slug, &c.Name, &c.Description, &c.Visible, &c.Position)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCategoryNotFound
+	}
+
+	return &c, err
+}
+
+func (r *Repository) CreateCategory(ctx context.Context, c *Category) error {
+	c.Id = uuid.New().String()
+	now := time.Now().Format(time.DateTime)
+
+	_, err := r.db.ExecContext(ctx, "INSERT INTO catalog_categories (id, slug, name, description, visible, position, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		c.Id, c.Slug, c.Name, c.Description, c.Visible, c.Position, now, now)
+	return err
+}
+
+func (r *Repository) UpdateCategory(ctx context.Context, c *Category) error {
+	result, err := r.db.ExecContext(ctx, "UPDATE catalog_categories SET slug = ?, name = ?, description = ?, visible = ?, position = ?, updated_at = ? WHERE id = ?",
+		c.Slug, c.Name, c.Description, c.Visible, c.Position, time.Now().Format(time.DateTime), c.Id)
+	if err != nil {
+		return err
+	}
+
+	return expectOneRow(result, ErrCategoryNotFound)
+}
+
+func (r *Repository) DeleteCategory(ctx context.Context, id string) error {
+	var products int
+	err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM catalog_products WHERE category_id = ?", id).Scan(&products)
+	if err != nil {
+		return err
+	}
+
+	if products > 0 {
+		return ErrInUse
+	}
+
+	result, err := r.db.ExecContext(ctx, "DELETE FROM catalog_categories WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	return expectOneRow(result, ErrCategoryNotFound)
+}
+
+const productColumns = "p.id, p.category_id, p.slug, p.name, p.description, p.visible, p.position"
+
+func scanProduct(row interface{ Scan(dest ...any) error }) (*Product, error) {
+	var p Product
+	err := row.Scan(&p.Id, &p.CategoryId, &p.Slug, &p.Name, &p.Description, &p.Visible, &p.Position)
+	return &p, err
+}
+
+// Produtos escondidos, ou de categorias escondidas, só aparecem com IncludeHidden
+func (r *Repository) ListProducts(ctx context.Context, filter ProductFilter) ([]Product, error) {
+	where := []string{"1 = 1"}
+	args := []any{}
+
+	if !filter.IncludeHidden {
+		where = append(where, "p.visible = 1", "c.visible = 1")
+	}
+
+	if filter.Category != "" {
+		where = append(where, "(c.id = ? OR c.slug = ?)")
+		args = append(args, filter.Category, filter.Category)
+	}
+
+	rows, err := r.db.QueryContext(ctx, "SELECT "+productColumns+" FROM catalog_products p JOIN catalog_categories c ON c.id = p.category_id WHERE "+
+		strings.Join(where, " AND ")+" ORDER BY c.position, p.position, p.name", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	products := []Product{}
+	for rows.Next() {
+		p, err := scanProduct(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		products = append(products, *p)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range products {
+		products[i].Plans, err = r.listPlans(ctx, products[i].Id, filter.IncludeHidden)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return products, nil
+}
+
+// Aceita o id ou o slug do produto
+func (r *Repository) GetProduct(ctx context.Context, idOrSlug string, includeHidden bool) (*Product, error) {
+	query := "SELECT " + productColumns + " FROM catalog_products p JOIN catalog_categories c ON c.id = p.category_id WHERE (p.id = ? OR p.slug = ?)"
+	if !includeHidden {
+		query += " AND p.visible = 1 AND c.visible = 1"
+	}
+
+	p, err := scanProduct(r.db.QueryRowContext(ctx, query, idOrSlug, idOrSlug))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrProductNotFound
+		}
+		return nil, err
+	}
+
+	p.Plans, err = r.listPlans(ctx, p.Id, includeHidden)
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (r *Repository) CreateProduct(ctx context.Context, p *Product) error {
+	if _, err := r.GetCategory(ctx, p.CategoryId); err != nil {
+		return err
+	}
+
+	p.Id = uuid.New().String()
+	p.Plans = []Plan{}
+	now := time.Now().Format(time.DateTime)
+
+	_, err := r.db.ExecContext(ctx, "INSERT INTO catalog_products (id, category_id, slug, name, description, visible, position, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		p.Id, p.CategoryId, p.Slug, p.Name, p.Description, p.Visible, p.Position, now, now)
+	return err
+}
+
+func (r *Repository) UpdateProduct(ctx context.Context, p *Product) error {
+	if _, err := r.GetCategory(ctx, p.CategoryId); err != nil {
+		return err
+	}
+
+	result, err := r.db.ExecContext(ctx, "UPDATE catalog_products SET category_id = ?, slug = ?, name = ?, description = ?, visible = ?, position = ?, updated_at = ? WHERE id = ?",
+		p.CategoryId, p.Slug, p.Name, p.Description, p.Visible, p.Position, time.Now().Format(time.DateTime), p.Id)
+	if err != nil {
+		return err
+	}
+
+	return expectOneRow(result, ErrProductNotFound)
+}
+
+func (r *Repository) DeleteProduct(ctx context.Context, id string) error {
+	var plans int
+	err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM catalog_plans WHERE product_id = ?", id).Scan(&plans)
+	if err != nil {
+		return err
+	}
+
+	if plans > 0 {
+		return ErrInUse
+	}
+
+	result, err := r.db.ExecContext(ctx, "DELETE FROM catalog_products WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	return expectOneRow(result, ErrProductNotFound)
+}
+
+const planColumns = "id, product_id, slug, name, description, ram_mb, cpu_cores, disk_gb, slots, visible, stock, position"
+
+func scanPlan(row interface{ Scan(dest ...any) error }) (*Plan, error) {
+	var p Plan
+	var stock sql.NullInt64
+
+	err := row.Scan(&p.Id, &p.ProductId, &p.Slug, &p.Name, &p.Description, &p.RamMB, &p.CpuCores, &p.DiskGB, &p.Slots, &p.Visible, &stock, &p.Position)
+	if err != nil {
+		return nil, err
+	}
+
+	if stock.Valid {
+		value := int(stock.Int64)
+		p.Stock = &value
+	}
+
+	p.Prices = []PlanPrice{}
+	p.Options = []PlanOption{}
+
+	return &p, nil
+}
+
+func (r *Repository) listPlans(ctx context.Context, productId string, includeHidden bool) ([]Plan, error) {
+	query := "SELECT " + planColumns + " FROM catalog_plans WHERE product_id = ?"
+	if !includeHidden {
+		query += " AND visible = 1"
+	}
+	query += " ORDER BY position, name"
+
+	rows, err := r.db.QueryContext(ctx, query, productId)
+	if err != nil {
+		return nil, err
+	}
+
+	plans := []Plan{}
+	for rows.Next() {
+		p, err := scanPlan(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		plans = append(plans, *p)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range plans {
+		if err := r.loadPlanDetails(ctx, &plans[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return plans, nil
+}
+
+// loadPrices lê os preços do plano do ciclo mais curto ao mais longo, com os
+// descontos já calculados
+func loadPrices(ctx context.Context, q querier, planId string) ([]PlanPrice, error) {
+	rows, err := q.QueryContext(ctx, "SELECT cycle, price, discount FROM catalog_plan_prices WHERE plan_id = ?", planId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prices := []PlanPrice{}
+	for rows.Next() {
+		var price PlanPrice
+		if err := rows.Scan(&price.Cycle, &price.Price, &price.Discount); err != nil {
+			return nil, err
+		}
+		prices = append(prices, price)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(prices, func(a, b PlanPrice) int {
+		return a.Cycle.Months() - b.Cycle.Months()
+	})
+
+	return prices, resolvePrices(prices)
+}
+
+func (r *Repository) loadPlanDetails(ctx context.Context, p *Plan) error {
+	var err error
+	if p.Prices, err = loadPrices(ctx, r.db, p.Id); err != nil {
+		return err
+	}
+
+	if p.Options, err = loadOptions(ctx, r.db, p.Id); err != nil {
+		return err
+	}
+
+	if p.Stock == nil {
+		return nil
+	}
+
+	var used int
+	err = r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM services WHERE plan_id = ? AND status IN ("+stockStatuses+")", p.Id).Scan(&used)
+	if err != nil {
+		return err
+	}
+
+	available := max(*p.Stock-used, 0)
+	p.Available = &available
+
+	return nil
+}
+
+// Sem includeHidden o plano só aparece se ele, o produto e a categoria
+// estiverem visíveis, igual na listagem
+func (r *Repository) GetPlan(ctx context.Context, id string, includeHidden bool) (*Plan, error) {
+	query := "SELECT " + planColumns + " FROM catalog_plans WHERE id = ?"
+	if !includeHidden {
+		query += " AND visible = 1 AND product_id IN (SELECT p.id FROM catalog_products p JOIN catalog_categories c ON c.id = p.category_id WHERE p.visible = 1 AND c.visible = 1)"
+	}
+
+	p, err := scanPlan(r.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrPlanNotFound
+		}
+		return nil, err
+	}
+
+	return p, r.loadPlanDetails(ctx, p)
+}
+
+func replacePrices(ctx context.Context, tx *sql.Tx, p *Plan) error {
+	_, err := tx.ExecContext(ctx, "DELETE FROM catalog_plan_prices WHERE plan_id = ?", p.Id)
+	if err != nil {
+		return err
+	}
+
+	for _, price := range p.Prices {
+		// O preço com desconto é calculado na leitura, a partir do mensal
+		var amount any = price.Price
+		if price.Discount > 0 {
+			amount = nil
+		}
+
+		_, err := tx.ExecContext(ctx, "INSERT INTO catalog_plan_prices (plan_id, cycle, price, discount) VALUES (?, ?, ?, ?)", p.Id, price.Cycle, amount, price.Discount)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// loadOptions lê as opções do plano com os valores, na ordem de position
+func loadOptions(ctx context.Context, q querier, planId string) ([]PlanOption, error) {
+	rows, err := q.QueryContext(ctx, "SELECT id, name, required, position FROM catalog_plan_options WHERE plan_id = ? ORDER BY position, name", planId)
+	if err != nil {
+		return nil, err
+	}
+
+	options := []PlanOption{}
+	index := map[string]int{}
+	for rows.Next() {
+		option := PlanOption{Values: []OptionValue{}}
+		if err := rows.Scan(&option.Id, &option.Name, &option.Required, &option.Position); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		index[option.Id] = len(options)
+		options = append(options, option)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil || len(options) == 0 {
+		return options, err
+	}
+
+	rows, err = q.QueryContext(ctx, `SELECT v.option_id, v.id, v.label, v.price, v.position
+FROM catalog_option_values v
+JOIN catalog_plan_options o ON o.id = v.option_id
+WHERE o.plan_id = ?
+ORDER BY v.position, v.label`, planId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var optionId string
+		var value OptionValue
+		if err := rows.Scan(&optionId, &value.Id, &value.Label, &value.Price, &value.Position); err != nil {
+			return nil, err
+		}
+
+		if i, ok := index[optionId]; ok {
+			options[i].Values = append(options[i].Values, value)
+		}
+	}
+
+	return options, rows.Err()
+}
+
+// replaceOptions troca as opções do plano pelas de p.Options. Opções e
+// valores que vierem com id mantêm o id, assim o que o site já mostrou
+// continua valendo depois de uma edição
+func replaceOptions(ctx context.Context, tx *sql.Tx, p *Plan) error {
+	_, err := tx.ExecContext(ctx, "DELETE FROM catalog_plan_options WHERE plan_id = ?", p.Id)
+	if err != nil {
+		return err
+	}
+
+	for i := range p.Options {
+		option := &p.Options[i]
+		if option.Id == "" {
+			option.Id = uuid.New().String()
+		}
+
+		_, err := tx.ExecContext(ctx, "INSERT INTO catalog_plan_options (id, plan_id, name, required, position) VALUES (?, ?, ?, ?, ?)",
+			option.Id, p.Id, option.Name, option.Required, option.Position)
+		if err != nil {
+			return err
+		}
+
+		for j := range option.Values {
+			value := &option.Values[j]
+			if value.Id == "" {
+				value.Id = uuid.New().String()
+			}
+
+			_, err := tx.ExecContext(ctx, "INSERT INTO catalog_option_values (id, option_id, label, price, position) VALUES (?, ?, ?, ?, ?)",
+				value.Id, option.Id, value.Label, value.Price, value.Position)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r *Repository) CreatePlan(ctx context.Context, p *Plan) error {
+	if _, err := r.GetProduct(ctx, p.ProductId, true); err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	p.Id = uuid.New().String()
+	now := time.Now().Format(time.DateTime)
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO catalog_plans ("+planColumns+", created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		p.Id, p.ProductId, p.Slug, p.Name, p.Description, p.RamMB, p.CpuCores, p.DiskGB, p.Slots, p.Visible, p.Stock, p.Position, now, now)
+	if err != nil {
+		return err
+	}
+
+	if err := replacePrices(ctx, tx, p); err != nil {
+		return err
+	}
+
+	if err := replaceOptions(ctx, tx, p); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdatePlan troca todos os preços e opções do plano pelos que vierem em p,
+// serviços já contratados continuam com o preço que foi copiado na compra
+func (r *Repository) UpdatePlan(ctx context.Context, p *Plan) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "UPDATE catalog_plans SET slug = ?, name = ?, description = ?, ram_mb = ?, cpu_cores = ?, disk_gb = ?, slots = ?, visible = ?, stock = ?, position = ?, updated_at = ? WHERE id = ?",
+		p.Slug, p.Name, p.Description, p.RamMB, p.CpuCores, p.DiskGB, p.Slots, p.Visible, p.Stock, p.Position, time.Now().Format(time.DateTime), p.Id)
+	if err != nil {
+		return err
+	}
+
+	if err := expectOneRow(result, ErrPlanNotFound); err != nil {
+		return err
+	}
+
+	if err := replacePrices(ctx, tx, p); err != nil {
+		return err
+	}
+
+	if err := replaceOptions(ctx, tx, p); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Planos com serviços não podem ser apagados, esconda com visible = false
+func (r *Repository) DeletePlan(ctx context.Context, id string) error {
+	var services int
+	err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM services WHERE plan_id = ?", id).Scan(&services)
+	if err != nil {
+		return err
+	}
+
+	if services > 0 {
+		return ErrInUse
+	}
+
+	result, err := r.db.ExecContext(ctx, "DELETE FROM catalog_plans WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	return expectOneRow(result, ErrPlanNotFound)
+}
+
+// PlanReservation é o que uma compra ou troca de plano copia do catálogo
+type PlanReservation struct {
+	PlanId string
+	Name   string
+	Type   string
+	Cycle  BillingCycle
+	Price  money.Money
+}
+
+// ReservePlan checa visibilidade, preço e estoque do plano dentro de uma
+// transação aberta. A linha do plano fica travada até o commit para que
+// duas compras ao mesmo tempo não passem do estoque
+func (r *Repository) ReservePlan(ctx context.Context, tx *sql.Tx, planId string, cycle BillingCycle) (*PlanReservation, error) {
+	return r.reservePlan(ctx, tx, planId, cycle, "")
+}
+
+// ReservePlanChange é o ReservePlan da troca de plano ou de ciclo, o serviço
+// que está trocando não conta no estoque
+func (r *Repository) ReservePlanChange(ctx context.Context, tx *sql.Tx, planId string, cycle BillingCycle, serviceId string) (*PlanReservation, error) {
+	return r.reservePlan(ctx, tx, planId, cycle, serviceId)
+}
+
+func (r *Repository) reservePlan(ctx context.Context, tx *sql.Tx, planId string, cycle BillingCycle, serviceId string) (*PlanReservation, error) {
+	var productName, categoryName string
+	var planName string
+	var planVisible, productVisible, categoryVisible bool
+	var stock sql.NullInt64
+
+	err := tx.QueryRowContext(ctx, `SELECT pl.name, pl.visible, pl.stock, p.name, p.visible, c.name, c.visible
+FROM catalog_plans pl
+JOIN catalog_products p ON p.id = pl.product_id
+JOIN catalog_categories c ON c.id = p.category_id
+WHERE pl.id = ? FOR UPDATE`, planId).Scan(&planName, &planVisible, &stock, &productName, &productVisible, &categoryName, &categoryVisible)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrPlanNotFound
+		}
+		return nil, err
+	}
+
+	if !planVisible || !productVisible || !categoryVisible {
+		return nil, ErrPlanUnavailable
+	}
+
+	prices, err := loadPrices(ctx, tx, planId)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := Plan{Prices: prices}
+	price, err := plan.Price(cycle)
+	if err != nil {
+		return nil, err
+	}
+
+	if stock.Valid {
+		var used int64
+		err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM services WHERE plan_id = ? AND id <> ? AND status IN ("+stockStatuses+")", planId, serviceId).Scan(&used)
+		if err != nil {
+			return nil, err
+		}
+
+		if used >= stock.Int64 {
+			return nil, ErrOutOfStock
+		}
+	}
+
+	return &PlanReservation{
+		PlanId: planId,
+		Name:   productName + " " + planName,
+		Type:   categoryName,
+		Cycle:  cycle,
+		Price:  price,
+	}, nil
+}
+
+// ReserveOptions confere as opções escolhidas na compra, um valor por id de
+// opção, contra as opções do plano. As obrigatórias precisam de um valor
+func (r *Repository) ReserveOptions(ctx context.Context, tx *sql.Tx, planId string, selected map[string]string) ([]SelectedOption, error) {
+	options, err := loadOptions(ctx, tx, planId)
+	if err != nil {
+		return nil, err
+	}
+
+	offered := map[string]bool{}
+	for _, option := range options {
+		offered[option.Id] = true
+	}
+
+	for optionId := range selected {
+		if !offered[optionId] {
+			return nil, ErrInvalidOption
+		}
+	}
+
+	chosen := []SelectedOption{}
+	for _, option := range options {
+		valueId := selected[option.Id]
+		if valueId == "" {
+			if option.Required {
+				return nil, fmt.Errorf("%w: %s", ErrOptionRequired, option.Name)
+			}
+			continue
+		}
+
+		index := slices.IndexFunc(option.Values, func(v OptionValue) bool { return v.Id == valueId })
+		if index < 0 {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidOption, option.Name)
+		}
+
+		value := option.Values[index]
+		chosen = append(chosen, SelectedOption{
+			OptionId: option.Id,
+			ValueId:  value.Id,
+			Name:     option.Name,
+			Label:    value.Label,
+			Price:    value.Price,
+		})
+	}
+
+	return chosen, nil
+}
+
+// NewService cria um serviço pending a partir do plano, copiando nome,
+// tipo e preço do catálogo
+func (r *Repository) NewService(ctx context.Context, services *account.ServiceRepository, ownerId, planId string, cycle BillingCycle) (*account.Services, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	plan, err := r.ReservePlan(ctx, tx, planId, cycle)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	service := &account.Services{
+		OwnerId:     ownerId,
+		PlanId:      planId,
+		Name:        plan.Name,
+		Price:       plan.Price,
+		Type:        plan.Type,
+		Cycle:       string(cycle),
+		AnchorDay:   now.Day(),
+		PeriodStart: now.Format(time.DateTime),
+		Date:        cycle.PeriodEnd(now).Format(time.DateTime),
+	}
+
+	if err := services.CreateTx(ctx, tx, service); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return service, nil
+}
+
+func expectOneRow(result sql.Result, notFound error) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return notFound
+	}
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package account
 
 import (
+	"fmt"
 	"math/rand"
 	"net/http"
 	"os"
@@ -14,7 +15,7 @@ import (
 // MiddleWare para checar se o token é válido para páginas normais
 // como áreas de cliente e informações próprias, segurança básica apenas
 // para pessoas normais
-func Authenticate(next api.ApiFuncMiddleWare) api.ApiFunc {
+func Authenticate(next api.ApiFunc) api.ApiFunc {
 	return func(ctx *api.Context) {
 		// if ctx.Request.Method == http.MethodOptions {
 		// 	ctx.WriteHeader(http.StatusOK)
@@ -37,29 +38,39 @@ func Authenticate(next api.ApiFuncMiddleWare) api.ApiFunc {
 		})
 
 		if err != nil || !token.Valid {
-			ctx.Error(err.Error(), http.StatusUnauthorized)
-			ctx.Logger.LogAndSendSystemMessage(err.Error())
+			ctx.Error("Invalid token", http.StatusUnauthorized)
+			ctx.Logger.LogAndSendSystemMessage(fmt.Sprintf("Invalid token, %v", err))
 			return
 		}
 
 		claims := token.Claims.(jwt.MapClaims)
 
-		userUuid := claims["userId"].(string)
-		if !UserExist(userUuid) {
+		userUuid, _ := claims["userId"].(string)
+		exist, err := userRepo.Exists(ctx.Request.Context(), userUuid)
+		if err != nil || !exist {
 			ctx.Error("Invalid token", http.StatusUnauthorized)
 			ctx.Logger.LogAndSendSystemMessage("Invalid token, User does not exist")
 			return
 		}
 
-		password := claims["password"].(string)
+		password, _ := claims["password"].(string)
 
-		if !ComparePasswords(userUuid, password) {
+		dbPassword, err := userRepo.PasswordHash(ctx.Request.Context(), userUuid)
+		if err != nil || dbPassword != password {
 			ctx.Error("Invalid token", http.StatusUnauthorized)
 			ctx.Logger.LogAndSendSystemMessage("Invalid token, Password is invalid")
 			return
 		}
 
-		next(ctx, userUuid)
+		deviceId, _ := claims["DeviceId"].(string)
+
+		ctx.SetUser(&api.Principal{
+			UserId:   userUuid,
+			DeviceId: deviceId,
+			Roles:    []string{api.RoleUser},
+		})
+
+		next(ctx)
 	}
 }
 
@@ -85,14 +96,15 @@ func AuthenticateAdmin(next api.ApiFunc) api.ApiFunc {
 
 		if err != nil || !token.Valid {
 			ctx.Error("Invalid Token", http.StatusUnauthorized)
-			ctx.Logger.LogAndSendSystemMessage(err.Error())
+			ctx.Logger.LogAndSendSystemMessage(fmt.Sprintf("Invalid token, %v ADMIN", err))
 			return
 		}
 
 		claims := token.Claims.(jwt.MapClaims)
 
-		uuid := claims["userId"].(string)
-		if !UserExist(uuid) {
+		uuid, _ := claims["userId"].(string)
+		exist, err := userRepo.Exists(ctx.Request.Context(), uuid)
+		if err != nil || !exist {
 			ctx.Error("Invalid Token", http.StatusUnauthorized)
 			ctx.Logger.LogAndSendSystemMessage("Invalid token, User does not exist ADMIN")
 			return
@@ -105,12 +117,21 @@ func AuthenticateAdmin(next api.ApiFunc) api.ApiFunc {
 			return
 		}
 
-		if !IsAdmin(uuid) {
+		admin, err := userRepo.IsAdmin(ctx.Request.Context(), uuid)
+		if err != nil || !admin {
 			ctx.Error("Invalid Token", http.StatusUnauthorized)
 			ctx.Logger.LogAndSendSystemMessage("Invalid token for access Admin page IP: " + ctx.Request.RemoteAddr)
 			return
 		}
 
+		deviceId, _ := claims["DeviceId"].(string)
+
+		ctx.SetUser(&api.Principal{
+			UserId:   uuid,
+			DeviceId: deviceId,
+			Roles:    []string{api.RoleUser, api.RoleAdmin},
+		})
+
 		next(ctx)
 	}
 }
@@ -0,0 +1,237 @@
+package account
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"prodata/logs"
+	"slices"
+	"sync"
+	"time"
+)
+
+type ServiceStatus string
+
+const (
+	StatusPending    ServiceStatus = "pending"
+	StatusActive     ServiceStatus = "active"
+	StatusSuspended  ServiceStatus = "suspended"
+	StatusCancelled  ServiceStatus = "cancelled"
+	StatusTerminated ServiceStatus = "terminated"
+)
+
+type ServiceAction string
+
+const (
+	ActionActivate  ServiceAction = "activate"
+	ActionSuspend   ServiceAction = "suspend"
+	ActionUnsuspend ServiceAction = "unsuspend"
+	ActionCancel    ServiceAction = "cancel"
+	ActionTerminate ServiceAction = "terminate"
+)
+
+type serviceTransition struct {
+	from []ServiceStatus
+	to   ServiceStatus
+}
+
+// Tabela de transições, qualquer mudança de status de um serviço
+// precisa passar por uma dessas ações
+var serviceTransitions = map[ServiceAction]serviceTransition{
+	ActionActivate:  {from: []ServiceStatus{StatusPending}, to: StatusActive},
+	ActionSuspend:   {from: []ServiceStatus{StatusActive}, to: StatusSuspended},
+	ActionUnsuspend: {from: []ServiceStatus{StatusSuspended}, to: StatusActive},
+	ActionCancel:    {from: []ServiceStatus{StatusPending, StatusActive, StatusSuspended}, to: StatusCancelled},
+	ActionTerminate: {from: []ServiceStatus{StatusPending, StatusActive, StatusSuspended, StatusCancelled}, to: StatusTerminated},
+}
+
+// Coluna com a data de cada status
+var statusTimestampColumns = map[ServiceStatus]string{
+	StatusActive:     "activated_at",
+	StatusSuspended:  "suspended_at",
+	StatusCancelled:  "cancelled_at",
+	StatusTerminated: "terminated_at",
+}
+
+var ErrInvalidTransition = errors.New("invalid service transition")
+var ErrUnknownAction = errors.New("unknown service action")
+
+type TransitionError struct {
+	ServiceId string
+	Action    ServiceAction
+	From      ServiceStatus
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("cannot %s service %s with status %s", e.Action, e.ServiceId, e.From)
+}
+
+func (e *TransitionError) Is(target error) bool {
+	return target == ErrInvalidTransition
+}
+
+func CanTransition(from ServiceStatus, action ServiceAction) bool {
+	transition, ok := serviceTransitions[action]
+	return ok && slices.Contains(transition.from, from)
+}
+
+type ServiceEvent struct {
+	Service Services
+	Action  ServiceAction
+	From    ServiceStatus
+	To      ServiceStatus
+	Reason  string
+	At      time.Time
+}
+
+type ServiceEventHandler func(ctx context.Context, event ServiceEvent)
+
+type ServiceLifecycle struct {
+	db       *sql.DB
+	services *ServiceRepository
+
+	mu       sync.RWMutex
+	handlers []ServiceEventHandler
+}
+
+func NewServiceLifecycle(db *sql.DB, services *ServiceRepository) *ServiceLifecycle {
+	return &ServiceLifecycle{db: db, services: services}
+}
+
+// Os handlers rodam depois do commit, em ordem de inscrição, e um panic
+// em um deles não impede os outros de receberem o evento
+func (l *ServiceLifecycle) Subscribe(handler ServiceEventHandler) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.handlers = append(l.handlers, handler)
+}
+
+func (l *ServiceLifecycle) publish(ctx context.Context, event ServiceEvent) {
+	l.mu.RLock()
+	handlers := slices.Clone(l.handlers)
+	l.mu.RUnlock()
+
+	for _, handler := range handlers {
+		func() {
+			defer func() {
+				if err := recover(); err != nil {
+					logs.NewSistemLogger().LogAndSendSystemMessage(fmt.Sprintf("service event handler panic: %v", err))
+				}
+			}()
+
+			handler(ctx, event)
+		}()
+	}
+}
+
+func (l *ServiceLifecycle) Activate(ctx context.Context, serviceId, reason string) (*Services, error) {
+	return l.Apply(ctx, serviceId, ActionActivate, reason)
+}
+
+func (l *ServiceLifecycle) Suspend(ctx context.Context, serviceId, reason string) (*Services, error) {
+	return l.Apply(ctx, serviceId, ActionSuspend, reason)
+}
+
+func (l *ServiceLifecycle) Unsuspend(ctx context.Context, serviceId, reason string) (*Services, error) {
+	return l.Apply(ctx, serviceId, ActionUnsuspend, reason)
+}
+
+func (l *ServiceLifecycle) Cancel(ctx context.Context, serviceId, reason string) (*Services, error) {
+	return l.Apply(ctx, serviceId, ActionCancel, reason)
+}
+
+func (l *ServiceLifecycle) Terminate(ctx context.Context, serviceId, reason string) (*Services, error) {
+	return l.Apply(ctx, serviceId, ActionTerminate, reason)
+}
+
+// Apply trava a linha do serviço, valida a transição, grava o novo status
+// com o histórico e só depois do commit avisa os inscritos
+func (l *ServiceLifecycle) Apply(ctx context.Context, serviceId string, action ServiceAction, reason string) (*Services, error) {
+	transition, ok := serviceTransitions[action]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownAction, action)
+	}
+
+	tx, err := l.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var from ServiceStatus
+	err = tx.QueryRowContext(ctx, "SELECT status FROM services WHERE id = ? FOR UPDATE", serviceId).Scan(&from)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrServiceNotFound
+		}
+		return nil, err
+	}
+
+	if !slices.Contains(transition.from, from) {
+		return nil, &TransitionError{ServiceId: serviceId, Action: action, From: from}
+	}
+
+	now := time.Now()
+	nowStr := now.Format(time.DateTime)
+
+	_, err = tx.ExecContext(ctx, "UPDATE services SET status = ?, status_reason = ?, status_changed_at = ?, updated_at = ?, "+statusTimestampColumns[transition.to]+" = ? WHERE id = ?",
+		transition.to, reason, nowStr, nowStr, nowStr, serviceId)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO service_transitions (service_id, action, from_status, to_status, reason, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		serviceId, action, from, transition.to, reason, nowStr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	service, err := l.services.GetByID(ctx, serviceId)
+	if err != nil {
+		return nil, err
+	}
+
+	l.publish(ctx, ServiceEvent{
+		Service: *service,
+		Action:  action,
+		From:    from,
+		To:      transition.to,
+		Reason:  reason,
+		At:      now,
+	})
+
+	return service, nil
+}
+
+type ServiceTransition struct {
+	Action    ServiceAction `json:"action"`
+	From      ServiceStatus `json:"from"`
+	To        ServiceStatus `json:"to"`
+	Reason    string        `json:"reason"`
+	CreatedAt string        `json:"created_at"`
+}
+
+func (l *ServiceLifecycle) History(ctx context.Context, serviceId string) ([]ServiceTransition, error) {
+	rows, err := l.db.QueryContext(ctx, "SELECT action, from_status, to_status, reason, created_at FROM service_transitions WHERE service_id = ? ORDER BY id", serviceId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []ServiceTransition{}
+	for rows.Next() {
+		var t ServiceTransition
+		if err := rows.Scan(&t.Action, &t.From, &t.To, &t.Reason, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		history = append(history, t)
+	}
+
+	return history, rows.Err()
+}
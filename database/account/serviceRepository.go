@@ -0,0 +1,267 @@
+package account
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrServiceNotFound = errors.New("service not found")
+
+type ServiceRepository struct {
+	db *sql.DB
+}
+
+type ServiceFilter struct {
+	Status ServiceStatus
+	Type   string
+	Limit  int
+	Offset int
+}
+
+func NewServiceRepository(db *sql.DB) *ServiceRepository {
+	return &ServiceRepository{db: db}
+}
+
+const serviceColumns = "id, owner_uuid, plan_id, name, type, cycle, anchor_day, status, price, period_start, due_date, created_at, updated_at, " +
+	"status_reason, status_changed_at, activated_at, suspended_at, cancelled_at, terminated_at"
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanService(row rowScanner) (*Services, error) {
+	var service Services
+	var planId, periodStart, dueDate, changedAt, activatedAt, suspendedAt, cancelledAt, terminatedAt sql.NullString
+
+	err := row.Scan(
+		&service.Id,
+		&service.OwnerId,
+		&planId,
+		&service.Name,
+		&service.Type,
+		&service.Cycle,
+		&service.AnchorDay,
+		&service.Status,
+		&service.Price,
+		&periodStart,
+		&dueDate,
+		&service.CreatedAt,
+		&service.UpdatedAt,
+		&service.StatusReason,
+		&changedAt,
+		&activatedAt,
+		&suspendedAt,
+		&cancelledAt,
+		&terminatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	service.PlanId = planId.String
+	service.PeriodStart = periodStart.String
+	service.Date = dueDate.String
+	service.StatusChangedAt = changedAt.String
+	service.ActivatedAt = activatedAt.String
+	service.SuspendedAt = suspendedAt.String
+	service.CancelledAt = cancelledAt.String
+	service.TerminatedAt = terminatedAt.String
+
+	return &service, nil
+}
+
+// Converte string vazia em NULL para colunas opcionais
+func nullable(value string) any {
+	if value == "" {
+		return nil
+	}
+
+	return value
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// Todo serviço nasce como pending, para mudar o status use o ServiceLifecycle
+func (r *ServiceRepository) Create(ctx context.Context, service *Services) error {
+	return createService(ctx, r.db, service)
+}
+
+// CreateTx cria o serviço dentro de uma transação que já está aberta,
+// usado quando o serviço depende de outras linhas travadas (estoque, pedidos)
+func (r *ServiceRepository) CreateTx(ctx context.Context, tx *sql.Tx, service *Services) error {
+	return createService(ctx, tx, service)
+}
+
+func createService(ctx context.Context, exec execer, service *Services) error {
+	if service.Id == "" {
+		service.Id = uuid.New().String()
+	}
+
+	now := time.Now().Format(time.DateTime)
+	service.CreatedAt = now
+	service.UpdatedAt = now
+	service.Status = StatusPending
+	service.StatusChangedAt = now
+
+	if service.Cycle == "" {
+		service.Cycle = "monthly"
+	}
+
+	_, err := exec.ExecContext(ctx, "INSERT INTO services (id, owner_uuid, plan_id, name, type, cycle, anchor_day, status, price, period_start, due_date, created_at, updated_at, status_changed_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		service.Id,
+		service.OwnerId,
+		nullable(service.PlanId),
+		service.Name,
+		service.Type,
+		service.Cycle,
+		service.AnchorDay,
+		service.Status,
+		service.Price,
+		nullable(service.PeriodStart),
+		nullable(service.Date),
+		service.CreatedAt,
+		service.UpdatedAt,
+		service.StatusChangedAt)
+	return err
+}
+
+// Get só retorna o serviço se ele pertencer ao usuário
+func (r *ServiceRepository) Get(ctx context.Context, ownerId, id string) (*Services, error) {
+	row := r.db.QueryRowContext(ctx, "SELECT "+serviceColumns+" FROM services WHERE id = ? AND owner_uuid = ?", id, ownerId)
+	service, err := scanService(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrServiceNotFound
+	}
+
+	return service, err
+}
+
+// GetForUpdate trava a linha do serviço até o fim da transação
+func (r *ServiceRepository) GetForUpdate(ctx context.Context, tx *sql.Tx, ownerId, id string) (*Services, error) {
+	row := tx.QueryRowContext(ctx, "SELECT "+serviceColumns+" FROM services WHERE id = ? AND owner_uuid = ? FOR UPDATE", id, ownerId)
+	service, err := scanService(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrServiceNotFound
+	}
+
+	return service, err
+}
+
+// GetByID não checa o dono, é para uso interno e de administradores
+func (r *ServiceRepository) GetByID(ctx context.Context, id string) (*Services, error) {
+	row := r.db.QueryRowContext(ctx, "SELECT "+serviceColumns+" FROM services WHERE id = ?", id)
+	service, err := scanService(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrServiceNotFound
+	}
+
+	return service, err
+}
+
+// List retorna a página pedida e o total de serviços que batem com o filtro
+func (r *ServiceRepository) List(ctx context.Context, ownerId string, filter ServiceFilter) ([]Services, int, error) {
+	where := []string{"owner_uuid = ?"}
+	args := []any{ownerId}
+
+	if filter.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, filter.Status)
+	}
+
+	if filter.Type != "" {
+		where = append(where, "type = ?")
+		args = append(args, filter.Type)
+	}
+
+	clause := strings.Join(where, " AND ")
+
+	var total int
+	err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM services WHERE "+clause, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := "SELECT " + serviceColumns + " FROM services WHERE " + clause + " ORDER BY created_at DESC, id"
+	if filter.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, filter.Limit, filter.Offset)
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	services := []Services{}
+	for rows.Next() {
+		service, err := scanService(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		services = append(services, *service)
+	}
+
+	return services, total, rows.Err()
+}
+
+// Update não mexe no status, ele só muda pelo ServiceLifecycle
+func (r *ServiceRepository) Update(ctx context.Context, service *Services) error {
+	return updateService(ctx, r.db, service)
+}
+
+func (r *ServiceRepository) UpdateTx(ctx context.Context, tx *sql.Tx, service *Services) error {
+	return updateService(ctx, tx, service)
+}
+
+func updateService(ctx context.Context, exec execer, service *Services) error {
+	service.UpdatedAt = time.Now().Format(time.DateTime)
+
+	result, err := exec.ExecContext(ctx, "UPDATE services SET plan_id = ?, name = ?, type = ?, cycle = ?, anchor_day = ?, price = ?, period_start = ?, due_date = ?, updated_at = ? WHERE id = ? AND owner_uuid = ?",
+		nullable(service.PlanId),
+		service.Name,
+		service.Type,
+		service.Cycle,
+		service.AnchorDay,
+		service.Price,
+		nullable(service.PeriodStart),
+		nullable(service.Date),
+		service.UpdatedAt,
+		service.Id,
+		service.OwnerId)
+	if err != nil {
+		return err
+	}
+
+	return expectOneRow(result, ErrServiceNotFound)
+}
+
+func (r *ServiceRepository) Delete(ctx context.Context, ownerId, id string) error {
+	result, err := r.db.ExecContext(ctx, "DELETE FROM services WHERE id = ? AND owner_uuid = ?", id, ownerId)
+	if err != nil {
+		return err
+	}
+
+	return expectOneRow(result, ErrServiceNotFound)
+}
+
+// O DSN usa clientFoundRows, então RowsAffected conta as linhas encontradas
+// mesmo quando o UPDATE não muda nenhum valor
+func expectOneRow(result sql.Result, notFound error) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return notFound
+	}
+
+	return nil
+}
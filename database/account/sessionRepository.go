@@ -0,0 +1,169 @@
+package account
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// SessionRepository cuida de tudo que é ligado ao login: magic links,
+// dispositivos, tokens de redefinição de senha e tentativas de acesso
+type SessionRepository struct {
+	db *sql.DB
+}
+
+func NewSessionRepository(db *sql.DB) *SessionRepository {
+	return &SessionRepository{db: db}
+}
+
+func (r *SessionRepository) GetUserData(ctx context.Context, userUuid string) (*UserData, error) {
+	var userData UserData
+	var magicAuthExpirationStr string
+	var dataString string
+
+	err := r.db.QueryRowContext(ctx, "SELECT auth, magic_auth_id, magic_auth_verified, magic_auth_expiration, devices FROM userinfo WHERE uuid = ?", userUuid).Scan(
+		&userData.Auth,
+		&userData.MagicLinkId,
+		&userData.MagicLinkVerified,
+		&magicAuthExpirationStr,
+		&dataString)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	err = json.Unmarshal([]byte(dataString), &userData.Devices)
+	if err != nil {
+		return nil, err
+	}
+
+	userData.MagicLinkExpiration, err = time.ParseInLocation(time.DateTime, magicAuthExpirationStr, time.Local)
+	if err != nil {
+		return nil, err
+	}
+
+	return &userData, nil
+}
+
+func (r *SessionRepository) SetMagicLink(ctx context.Context, userUuid, magicId string, expiration time.Time) error {
+	query := "UPDATE userinfo SET magic_auth_id = ?, magic_auth_verified = ?, magic_auth_expiration = ? WHERE uuid = ?"
+	_, err := r.db.ExecContext(ctx, query, magicId, 0, expiration.Format(time.DateTime), userUuid)
+	return err
+}
+
+// Limpa o magic link usado e salva os dispositivos que passaram pela verificação
+func (r *SessionRepository) ConfirmMagicLink(ctx context.Context, userUuid string, devices []Devices) error {
+	bytes, err := json.Marshal(devices)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, "UPDATE userinfo SET magic_auth_id = ?, magic_auth_verified = ?, devices = ? WHERE uuid = ?", "", 1, string(bytes), userUuid)
+	return err
+}
+
+func (r *SessionRepository) HasDevices(ctx context.Context, userUuid string) (bool, error) {
+	var devices string
+	err := r.db.QueryRowContext(ctx, "SELECT devices FROM userinfo WHERE uuid = ?", userUuid).Scan(&devices)
+	if err != nil {
+		return false, err
+	}
+
+	return devices != "", nil
+}
+
+func (r *SessionRepository) SetPasswordToken(ctx context.Context, userUuid, token string, expiration time.Time) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE userinfo SET magic_password_id = ?, magic_password_expiration = ? WHERE uuid = ?",
+		token,
+		expiration.Format(time.DateTime),
+		userUuid)
+	return err
+}
+
+func (r *SessionRepository) PasswordToken(ctx context.Context, userUuid string) (string, time.Time, error) {
+	var token string
+	var expirationStr string
+
+	err := r.db.QueryRowContext(ctx, "SELECT magic_password_id, magic_password_expiration FROM userinfo WHERE uuid = ?", userUuid).Scan(&token, &expirationStr)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	expiration, err := time.ParseInLocation(time.DateTime, expirationStr, time.Local)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return token, expiration, nil
+}
+
+func (r *SessionRepository) ClearPasswordToken(ctx context.Context, userUuid string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE userinfo SET magic_password_id = ? WHERE uuid = ?", "", userUuid)
+	return err
+}
+
+// Retorna nil, nil quando o IP ainda não tem nenhuma tentativa registrada
+func (r *SessionRepository) FindAttempts(ctx context.Context, ip string) (*Attempts, error) {
+	var attempts Attempts
+	var date string
+	var canBack string
+
+	err := r.db.QueryRowContext(ctx, "SELECT email, ip, attempts, date, can_back FROM registration_attempts WHERE ip = ?", ip).Scan(
+		&attempts.Email,
+		&attempts.IpAddress,
+		&attempts.Attempts,
+		&date,
+		&canBack)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	attempts.Date, err = time.ParseInLocation(time.DateTime, date, time.Local)
+	if err != nil {
+		return nil, err
+	}
+
+	attempts.CanBackDate, err = time.ParseInLocation(time.DateTime, canBack, time.Local)
+	if err != nil {
+		return nil, err
+	}
+
+	return &attempts, nil
+}
+
+func (r *SessionRepository) CreateAttempts(ctx context.Context, email, ip string) error {
+	now := time.Now().Format(time.DateTime)
+	_, err := r.db.ExecContext(ctx, "INSERT INTO registration_attempts (email, ip, attempts, date, can_back) VALUES (?, ?, ?, ?, ?)",
+		email, ip, 1, now, now)
+	return err
+}
+
+func (r *SessionRepository) UpdateAttemptsEmail(ctx context.Context, ip, email string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE registration_attempts SET email = ? WHERE ip = ?", email, ip)
+	return err
+}
+
+func (r *SessionRepository) SetAttempts(ctx context.Context, ip string, attempts int) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE registration_attempts SET attempts = ?, date = ? WHERE ip = ?",
+		attempts, time.Now().Format(time.DateTime), ip)
+	return err
+}
+
+func (r *SessionRepository) BlockAttempts(ctx context.Context, email string, canBack time.Time) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE registration_attempts SET attempts = ?, can_back = ? WHERE email = ?",
+		0, canBack.Format(time.DateTime), email)
+	return err
+}
+
+func (r *SessionRepository) ResetAttempts(ctx context.Context, ip string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE registration_attempts SET attempts = ? WHERE ip = ?", 0, ip)
+	return err
+}
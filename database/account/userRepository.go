@@ -0,0 +1,210 @@
+package account
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrUserNotFound = errors.New("user not found")
+var ErrUserAlreadyExists = errors.New("user already exists")
+
+type UserRepository struct {
+	db *sql.DB
+}
+
+func NewUserRepository(db *sql.DB) *UserRepository {
+	return &UserRepository{db: db}
+}
+
+// Retorna o usuário com os nomes e a senha já descriptografados
+func (r *UserRepository) FindByEmail(ctx context.Context, email string) (*DataUser, error) {
+	var user DataUser
+	query := "SELECT uuid, first_name, last_name, email, password FROM userdata WHERE email = ?"
+	err := r.db.QueryRowContext(ctx, query, email).Scan(
+		&user.UUID,
+		&user.FirstName,
+		&user.LastName,
+		&user.Email,
+		&user.Password)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	user.FirstName = Decrypt(user.FirstName)
+	user.LastName = Decrypt(user.LastName)
+	user.Password = Decrypt(user.Password)
+
+	return &user, nil
+}
+
+func (r *UserRepository) FindUUIDByEmail(ctx context.Context, email string) (string, error) {
+	var userUuid string
+	err := r.db.QueryRowContext(ctx, "SELECT uuid FROM userdata WHERE email = ?", email).Scan(&userUuid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrUserNotFound
+	}
+
+	return userUuid, err
+}
+
+func (r *UserRepository) FindEmailByUUID(ctx context.Context, userUuid string) (string, error) {
+	var email string
+	err := r.db.QueryRowContext(ctx, "SELECT email FROM userdata WHERE uuid = ?", userUuid).Scan(&email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrUserNotFound
+	}
+
+	return email, err
+}
+
+// Retorna nome e sobrenome já descriptografados
+func (r *UserRepository) FindNameByUUID(ctx context.Context, userUuid string) (string, string, error) {
+	var firstName, lastName string
+	err := r.db.QueryRowContext(ctx, "SELECT first_name, last_name FROM userdata WHERE uuid = ?", userUuid).Scan(&firstName, &lastName)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", "", ErrUserNotFound
+	}
+
+	if err != nil {
+		return "", "", err
+	}
+
+	return Decrypt(firstName), Decrypt(lastName), nil
+}
+
+// DisputeFlaggedAt diz quando o usuário foi marcado por contestações de
+// pagamento, vazio quando não está marcado
+func (r *UserRepository) DisputeFlaggedAt(ctx context.Context, userUuid string) (string, error) {
+	var flaggedAt sql.NullString
+	err := r.db.QueryRowContext(ctx, "SELECT dispute_flagged_at FROM userinfo WHERE uuid = ?", userUuid).Scan(&flaggedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrUserNotFound
+	}
+
+	return flaggedAt.String, err
+}
+
+// SetDisputeFlag marca ou desmarca o usuário, marcar de novo mantém a data
+// da primeira vez
+func (r *UserRepository) SetDisputeFlag(ctx context.Context, userUuid string, flagged bool) error {
+	query := "UPDATE userinfo SET dispute_flagged_at = NULL WHERE uuid = ?"
+	args := []any{userUuid}
+	if flagged {
+		query = "UPDATE userinfo SET dispute_flagged_at = COALESCE(dispute_flagged_at, ?) WHERE uuid = ?"
+		args = []any{time.Now().Format(time.DateTime), userUuid}
+	}
+
+	result, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+func (r *UserRepository) Exists(ctx context.Context, userUuid string) (bool, error) {
+	var value string
+	err := r.db.QueryRowContext(ctx, "SELECT uuid FROM userdata WHERE uuid = ?", userUuid).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (r *UserRepository) ExistsByEmail(ctx context.Context, email string) (bool, error) {
+	var value string
+	err := r.db.QueryRowContext(ctx, "SELECT email FROM userdata WHERE email = ?", email).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// Cria o userdata e o userinfo na mesma transação, os campos do usuário
+// são criptografados aqui dentro
+func (r *UserRepository) Create(ctx context.Context, user *DataUserRegistry) (string, error) {
+	exist, err := r.ExistsByEmail(ctx, user.Email)
+	if err != nil {
+		return "", err
+	}
+
+	if exist {
+		return "", ErrUserAlreadyExists
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	userUuid := uuid.New().String()
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO userdata (uuid, first_name, last_name, email, password) VALUES (?,?,?,?,?)",
+		userUuid,
+		Encrypt(user.FirstName),
+		Encrypt(user.LastName),
+		user.Email,
+		Encrypt(HashPassword(user.Password)))
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO userinfo (uuid, auth, admin, devices, data) VALUES (?, ?, ?, ?, ?)",
+		userUuid, 0, 0, "[]", "[]")
+	if err != nil {
+		return "", err
+	}
+
+	return userUuid, tx.Commit()
+}
+
+func (r *UserRepository) IsAdmin(ctx context.Context, userUuid string) (bool, error) {
+	var value bool
+	err := r.db.QueryRowContext(ctx, "SELECT admin FROM userinfo WHERE uuid = ?", userUuid).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, ErrUserNotFound
+	}
+
+	return value, err
+}
+
+// Retorna o hash da senha já descriptografado
+func (r *UserRepository) PasswordHash(ctx context.Context, userUuid string) (string, error) {
+	var password string
+	err := r.db.QueryRowContext(ctx, "SELECT password FROM userdata WHERE uuid = ?", userUuid).Scan(&password)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrUserNotFound
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return Decrypt(password), nil
+}
+
+func (r *UserRepository) UpdatePassword(ctx context.Context, email, newPassword string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE userdata SET password = ? WHERE email = ?",
+		Encrypt(HashPassword(newPassword)), email)
+	return err
+}
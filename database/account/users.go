@@ -1,12 +1,12 @@
 package account
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"prodata/database"
 	"prodata/logs"
+	"prodata/money"
 	"time"
 
 	"github.com/google/uuid"
@@ -37,93 +37,54 @@ type DataUser struct {
 	Company   string `json:"company,omitempty"`
 }
 
-func GetUser(email string) *DataUser {
-	logger := logs.NewSistemLogger()
-
-	db, err := database.InitializeDB()
-	if err != nil {
-		logger.LogAndSendSystemMessage(err.Error())
-		return nil
-	}
+var (
+	userRepo    *UserRepository
+	sessionRepo *SessionRepository
+	serviceRepo *ServiceRepository
+	lifecycle   *ServiceLifecycle
+)
 
-	defer func(db *sql.DB) {
-		err := db.Close()
-		if err != nil {
-			logger.LogAndSendSystemMessage(err.Error())
-			return
-		}
-	}(db)
+// Init registra o pool de conexões usado pelas funções deste pacote,
+// deve ser chamado uma vez no main antes de subir o servidor
+func Init(db *sql.DB) {
+	userRepo = NewUserRepository(db)
+	sessionRepo = NewSessionRepository(db)
+	serviceRepo = NewServiceRepository(db)
+	lifecycle = NewServiceLifecycle(db, serviceRepo)
+}
 
-	var user DataUser
-	query := "SELECT uuid, first_name, last_name, email, password FROM userdata WHERE email =?"
-	err = db.QueryRow(query, email).Scan(
-		&user.UUID,
-		&user.FirstName,
-		&user.LastName,
-		&user.Email,
-		&user.Password)
+func Users() *UserRepository {
+	return userRepo
+}
 
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			logger.LogAndSendSystemMessage("User not found")
-			return nil
-		}
-		logger.LogAndSendSystemMessage(err.Error())
-		return nil
-	}
+func Sessions() *SessionRepository {
+	return sessionRepo
+}
 
-	user.FirstName = Decrypt(user.FirstName)
-	user.LastName = Decrypt(user.LastName)
-	user.Password = Decrypt(user.Password)
+func ServicesRepository() *ServiceRepository {
+	return serviceRepo
+}
 
-	return &user
+func Lifecycle() *ServiceLifecycle {
+	return lifecycle
 }
 
-func CreateUser(user *DataUserRegistry) {
+func GetUser(email string) *DataUser {
 	logger := logs.NewSistemLogger()
 
-	db, err := database.InitializeDB()
-	if err != nil {
-		logger.LogAndSendSystemMessage(err.Error())
-		return
-	}
-
-	defer func(db *sql.DB) {
-		err := db.Close()
-		if err != nil {
-			logger.LogAndSendSystemMessage(err.Error())
-			return
-		}
-	}(db)
-
-	exist := UserExistFromEmail(user.Email)
-	if exist {
-		logger.LogAndSendSystemMessage("User already exists")
-		return
-	}
-
-	user.FirstName = Encrypt(user.FirstName)
-	user.LastName = Encrypt(user.LastName)
-	user.Password = Encrypt(HashPassword(user.Password))
-
-	query := "INSERT INTO userdata (uuid, first_name, last_name, email, password) VALUES (?,?,?,?,?)"
-	_, err = db.Exec(query,
-		uuid.New(),
-		user.FirstName,
-		user.LastName,
-		user.Email,
-		user.Password)
+	user, err := userRepo.FindByEmail(context.Background(), email)
 	if err != nil {
 		logger.LogAndSendSystemMessage(err.Error())
-		return
+		return nil
 	}
 
-	userUUID := GetUserUUID(user.Email)
+	return user
+}
 
-	query = "INSERT INTO userinfo (uuid, auth, admin, devices, data) VALUES (?, ?, ?, ?, ?)"
-	_, err = db.Exec(query,
-		userUUID, 0, 0, "[]", "[]")
+func CreateUser(user *DataUserRegistry) {
+	logger := logs.NewSistemLogger()
 
+	_, err := userRepo.Create(context.Background(), user)
 	if err != nil {
 		logger.LogAndSendSystemMessage(err.Error())
 		return
@@ -132,18 +93,8 @@ func CreateUser(user *DataUserRegistry) {
 
 func GetUserUUID(email string) string {
 	logger := logs.NewSistemLogger()
-	db, err := database.InitializeDB()
-
-	if err != nil {
-		logger.LogAndSendSystemMessage(err.Error())
-		return ""
-	}
-	defer db.Close()
-
-	var userUuid string
-	query := "SELECT uuid FROM userdata WHERE email = ?"
-	err = db.QueryRow(query, email).Scan(&userUuid)
 
+	userUuid, err := userRepo.FindUUIDByEmail(context.Background(), email)
 	if err != nil {
 		logger.LogAndSendSystemMessage(err.Error())
 		return ""
@@ -153,67 +104,23 @@ func GetUserUUID(email string) string {
 }
 
 func UserExist(userID string) bool {
-	logger := logs.NewSistemLogger()
-
-	db, err := database.InitializeDB()
+	exist, err := userRepo.Exists(context.Background(), userID)
 	if err != nil {
-		logger.LogAndSendSystemMessage(err.Error())
+		logs.NewSistemLogger().LogAndSendSystemMessage(err.Error())
 		return false
 	}
 
-	defer func(db *sql.DB) {
-		err := db.Close()
-		if err != nil {
-			logger.LogAndSendSystemMessage(err.Error())
-			return
-		}
-	}(db)
-
-	var user string
-	query := "SELECT uuid FROM userdata WHERE uuid =?"
-	err = db.QueryRow(query, userID).Scan(&user)
-
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return false
-		}
-		logger.LogAndSendSystemMessage(err.Error())
-		return false
-	}
-
-	return true
+	return exist
 }
 
 func UserExistFromEmail(email string) bool {
-	logger := logs.NewSistemLogger()
-
-	db, err := database.InitializeDB()
-	if err != nil {
-		logger.LogAndSendSystemMessage(err.Error())
-		return false
-	}
-
-	defer func(db *sql.DB) {
-		err := db.Close()
-		if err != nil {
-			logger.LogAndSendSystemMessage(err.Error())
-			return
-		}
-	}(db)
-
-	var user string
-	query := "SELECT email FROM userdata WHERE email =?"
-	err = db.QueryRow(query, email).Scan(&user)
-
+	exist, err := userRepo.ExistsByEmail(context.Background(), email)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return false
-		}
-		logger.LogAndSendSystemMessage(err.Error())
+		logs.NewSistemLogger().LogAndSendSystemMessage(err.Error())
 		return false
 	}
 
-	return true
+	return exist
 }
 
 type UserData struct {
@@ -231,56 +138,13 @@ type Devices struct {
 }
 
 func GetDataInfoUser(userUuid string) *UserData {
-	logger := logs.NewSistemLogger()
-
-	db, err := database.InitializeDB()
+	userData, err := sessionRepo.GetUserData(context.Background(), userUuid)
 	if err != nil {
-		logger.LogAndSendSystemMessage(err.Error())
+		logs.NewSistemLogger().LogAndSendSystemMessage(err.Error())
 		return nil
 	}
-	defer db.Close()
-
-	var userData UserData
 
-	var auth bool
-	var magicAuthId string
-	var magicAuthVerified bool
-	var magicAuthExpirationStr string
-	var dataString string
-
-	err = db.QueryRow("SELECT auth, magic_auth_id, magic_auth_verified, magic_auth_expiration, devices FROM userinfo WHERE uuid = ?", userUuid).Scan(
-		&auth,
-		&magicAuthId,
-		&magicAuthVerified,
-		&magicAuthExpirationStr,
-		&dataString)
-
-	if err != nil {
-		logger.LogAndSendSystemMessage(err.Error())
-		return nil
-	}
-
-	var values []Devices
-
-	err = json.Unmarshal([]byte(dataString), &values)
-	if err != nil {
-		logger.LogAndSendSystemMessage(err.Error())
-		return nil
-	}
-
-	magicAuthExpiration, err := time.ParseInLocation(time.DateTime, magicAuthExpirationStr, time.Local)
-	if err != nil {
-		logger.LogAndSendSystemMessage(err.Error())
-		return nil
-	}
-
-	userData.Devices = values
-	userData.Auth = auth
-	userData.MagicLinkId = magicAuthId
-	userData.MagicLinkVerified = magicAuthVerified
-	userData.MagicLinkExpiration = magicAuthExpiration
-
-	return &userData
+	return userData
 }
 
 func CreateNewData(device, ip string) Devices {
@@ -299,19 +163,10 @@ func CreateNewData(device, ip string) Devices {
 func MagicLinkMarker(email, magicId string) string {
 	logger := logs.NewSistemLogger()
 
-	db, err := database.InitializeDB()
-	if err != nil {
-		logger.LogAndSendSystemMessage(err.Error())
-		return ""
-	}
-	defer db.Close()
-
 	userUUID := GetUserUUID(email)
 	expiration := time.Now().Add(time.Minute * 10)
 
-	query := "UPDATE userinfo SET magic_auth_id = ?, magic_auth_verified = ?, magic_auth_expiration = ? WHERE uuid = ?"
-	_, err = db.Exec(query, magicId, 0, expiration.Format(time.DateTime), userUUID)
-
+	err := sessionRepo.SetMagicLink(context.Background(), userUUID, magicId, expiration)
 	if err != nil {
 		logger.LogAndSendSystemMessage(err.Error())
 		return ""
@@ -321,43 +176,21 @@ func MagicLinkMarker(email, magicId string) string {
 }
 
 func HasData(email string) bool {
-	logger := logs.NewSistemLogger()
-	db, err := database.InitializeDB()
-
-	if err != nil {
-		logger.LogAndSendSystemMessage(err.Error())
-		return false
-	}
-	defer db.Close()
-
 	userUuid := GetUserUUID(email)
 
-	var devices string
-
-	err = db.QueryRow("SELECT devices FROM userinfo WHERE uuid = ?", userUuid).Scan(&devices)
+	ok, err := sessionRepo.HasDevices(context.Background(), userUuid)
 	if err != nil {
-		logger.LogAndSendSystemMessage(err.Error())
+		logs.NewSistemLogger().LogAndSendSystemMessage(err.Error())
 		return false
 	}
 
-	return devices != ""
+	return ok
 }
 
 func GetEmailByUuid(userUuid string) string {
-	logger := logs.NewSistemLogger()
-
-	db, err := database.InitializeDB()
+	email, err := userRepo.FindEmailByUUID(context.Background(), userUuid)
 	if err != nil {
-		logger.LogAndSendSystemMessage(err.Error())
-		return ""
-	}
-	defer db.Close()
-
-	var email string
-
-	err = db.QueryRow("SELECT email FROM userdata WHERE uuid = ?", userUuid).Scan(&email)
-	if err != nil {
-		logger.LogAndSendSystemMessage(err.Error())
+		logs.NewSistemLogger().LogAndSendSystemMessage(err.Error())
 		return ""
 	}
 
@@ -366,6 +199,9 @@ func GetEmailByUuid(userUuid string) string {
 
 func IsValidMagicLink(userUuid, magicLink string) bool {
 	data := GetDataInfoUser(userUuid)
+	if data == nil {
+		return false
+	}
 
 	if data.MagicLinkId != "" && data.MagicLinkId == magicLink && data.MagicLinkExpiration.After(time.Now()) {
 		return true
@@ -386,23 +222,11 @@ func ConvertToJson(devices *[]Devices, value *string) {
 }
 
 func ValidMagicLink(userUuid, device, ip string) string {
-	logger := logs.NewSistemLogger()
-	db, err := database.InitializeDB()
-	if err != nil {
-		logger.LogAndSendSystemMessage(err.Error())
-		return ""
-	}
-	defer db.Close()
-
 	devices := CreateNewData(device, ip)
-	byte, err := json.Marshal([]Devices{devices})
+
+	err := sessionRepo.ConfirmMagicLink(context.Background(), userUuid, []Devices{devices})
 	if err != nil {
-		logger.LogAndSendSystemMessage(err.Error())
-		return ""
-	}
-	_, err = db.Exec("UPDATE userinfo SET magic_auth_id =?, magic_auth_verified =?, devices =? WHERE uuid =?", "", 1, string(byte), userUuid)
-	if err != nil {
-		logger.LogAndSendSystemMessage(err.Error())
+		logs.NewSistemLogger().LogAndSendSystemMessage(err.Error())
 		return ""
 	}
 
@@ -410,18 +234,9 @@ func ValidMagicLink(userUuid, device, ip string) string {
 }
 
 func IsAdmin(userId string) bool {
-	logger := logs.NewSistemLogger()
-	db, err := database.InitializeDB()
+	value, err := userRepo.IsAdmin(context.Background(), userId)
 	if err != nil {
-		logger.LogAndSendSystemMessage(err.Error())
-		return false
-	}
-	defer db.Close()
-
-	var value bool
-	err = db.QueryRow("SELECT admin FROM userinfo WHERE uuid = ?", userId).Scan(&value)
-	if err != nil {
-		logger.LogAndSendSystemMessage(err.Error())
+		logs.NewSistemLogger().LogAndSendSystemMessage(err.Error())
 		return false
 	}
 
@@ -438,63 +253,37 @@ type Attempts struct {
 
 func RegisterAttempts(email, ipAddress string) {
 	logger := logs.NewSistemLogger()
+	ctx := context.Background()
 
-	db, err := database.InitializeDB()
+	attempts, err := sessionRepo.FindAttempts(ctx, ipAddress)
 	if err != nil {
 		logger.LogAndSendSystemMessage(err.Error())
 		return
 	}
-	defer db.Close()
-
-	var attempts int
-	var canBackStr string
-	var email2 string
 
-	err = db.QueryRow("SELECT attempts, email, can_back FROM registration_attempts WHERE ip = ?", ipAddress).Scan(&attempts, &email2, &canBackStr)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			_, err = db.Exec("INSERT INTO registration_attempts (email, ip, attempts, date, can_back) VALUES (?, ?, ?, ?, ?)",
-				email,
-				ipAddress,
-				1,
-				time.Now().Format(time.DateTime),
-				time.Now().Format(time.DateTime))
-
-			if err != nil {
-				logger.LogAndSendSystemMessage(err.Error())
-			}
-
-			return
-		} else {
+	if attempts == nil {
+		err = sessionRepo.CreateAttempts(ctx, email, ipAddress)
+		if err != nil {
 			logger.LogAndSendSystemMessage(err.Error())
-			return
 		}
-	}
 
-	canBack, err := time.ParseInLocation(time.DateTime, canBackStr, time.Local)
-
-	if err != nil {
-		logger.LogAndSendSystemMessage(err.Error())
 		return
 	}
 
-	if email != email2 {
-		_, err := db.Exec("UPDATE registration_attempts SET email = ? WHERE ip = ?", email, ipAddress)
+	if email != attempts.Email {
+		err := sessionRepo.UpdateAttemptsEmail(ctx, ipAddress, email)
 		if err != nil {
 			logger.LogAndSendSystemMessage(err.Error())
 			return
 		}
 	}
 
-	if canBack.After(time.Now()) {
+	if attempts.CanBackDate.After(time.Now()) {
 		return
 	}
 
-	if attempts+1 == 5 {
-		_, err := db.Exec("UPDATE registration_attempts SET attempts = ?, can_back = ? WHERE email = ?",
-			0,
-			time.Now().Add(10*time.Minute).Format(time.DateTime),
-			email)
+	if attempts.Attempts+1 == 5 {
+		err := sessionRepo.BlockAttempts(ctx, email, time.Now().Add(10*time.Minute))
 		if err != nil {
 			logger.LogAndSendSystemMessage(err.Error())
 		}
@@ -502,7 +291,7 @@ func RegisterAttempts(email, ipAddress string) {
 		return
 	} else {
 		fmt.Println("Colocando Attempts")
-		_, err := db.Exec("UPDATE registration_attempts SET attempts = ?, date = ? WHERE ip = ?", attempts+1, time.Now().Format(time.DateTime), ipAddress)
+		err := sessionRepo.SetAttempts(ctx, ipAddress, attempts.Attempts+1)
 		if err != nil {
 			logger.LogAndSendSystemMessage(err.Error())
 		}
@@ -512,47 +301,13 @@ func RegisterAttempts(email, ipAddress string) {
 }
 
 func GetAttempts(ip string) *Attempts {
-	logger := logs.NewSistemLogger()
-	db, err := database.InitializeDB()
-	if err != nil {
-		logger.LogAndSendSystemMessage(err.Error())
-		return nil
-	}
-
-	defer db.Close()
-
-	var attempts Attempts
-
-	var date string
-	var canBack string
-
-	err = db.QueryRow("SELECT * FROM registration_attempts WHERE ip = ?", ip).Scan(
-		&attempts.Email,
-		&attempts.IpAddress,
-		&attempts.Attempts,
-		&date,
-		&canBack)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil
-		}
-		logger.LogAndSendSystemMessage(err.Error())
-		return nil
-	}
-
-	attempts.Date, err = time.ParseInLocation(time.DateTime, date, time.Local)
+	attempts, err := sessionRepo.FindAttempts(context.Background(), ip)
 	if err != nil {
-		logger.LogAndSendSystemMessage(err.Error())
+		logs.NewSistemLogger().LogAndSendSystemMessage(err.Error())
 		return nil
 	}
 
-	attempts.CanBackDate, err = time.ParseInLocation(time.DateTime, canBack, time.Local)
-	if err != nil {
-		logger.LogAndSendSystemMessage(err.Error())
-		return nil
-	}
-
-	return &attempts
+	return attempts
 }
 
 func CanLogin(email string) bool {
@@ -570,58 +325,30 @@ func CanLogin(email string) bool {
 }
 
 func ResetAttempts(ip string) {
-	logger := logs.NewSistemLogger()
-
-	db, err := database.InitializeDB()
-	if err != nil {
-		logger.LogAndSendSystemMessage(err.Error())
-		return
-	}
-
-	defer db.Close()
-
-	_, err = db.Exec("UPDATE registration_attempts SET attempts = ? WHERE ip = ?", 0, ip)
+	err := sessionRepo.ResetAttempts(context.Background(), ip)
 	if err != nil {
-		logger.LogAndSendSystemMessage(err.Error())
+		logs.NewSistemLogger().LogAndSendSystemMessage(err.Error())
 		return
 	}
 }
 
 func RegistryPasswordToken(email, token string) {
-	logger := logs.NewSistemLogger()
-	db, err := database.InitializeDB()
-	if err != nil {
-		logger.LogAndSendSystemMessage(err.Error())
-		return
-	}
-	defer db.Close()
-
 	userUuid := GetUserUUID(email)
 
-	_, err = db.Exec("UPDATE userinfo SET magic_password_id = ?, magic_password_expiration = ? WHERE uuid = ?",
-		token,
-		time.Now().Add(10*time.Minute).Format(time.DateTime),
-		userUuid)
+	err := sessionRepo.SetPasswordToken(context.Background(), userUuid, token, time.Now().Add(10*time.Minute))
 	if err != nil {
-		logger.LogAndSendSystemMessage(err.Error())
+		logs.NewSistemLogger().LogAndSendSystemMessage(err.Error())
 		return
 	}
 }
 
 func IsValidPasswordToken(email, token string) bool {
 	logger := logs.NewSistemLogger()
-	db, err := database.InitializeDB()
-	if err != nil {
-		logger.LogAndSendSystemMessage(err.Error())
-		return false
-	}
-	defer db.Close()
+	ctx := context.Background()
 
-	var dToken string
-	var expirationStr string
 	userUuid := GetUserUUID(email)
 
-	err = db.QueryRow("SELECT magic_password_id, magic_password_expiration FROM userinfo WHERE uuid = ? ", userUuid).Scan(&dToken, &expirationStr)
+	dToken, expiration, err := sessionRepo.PasswordToken(ctx, userUuid)
 	if err != nil {
 		logger.LogAndSendSystemMessage(err.Error())
 		return false
@@ -631,15 +358,9 @@ func IsValidPasswordToken(email, token string) bool {
 		return false
 	}
 
-	expiration, err := time.ParseInLocation(time.DateTime, expirationStr, time.Local)
-	if err != nil {
-		logger.LogAndSendSystemMessage(err.Error())
-		return false
-	}
-
 	if expiration.Before(time.Now()) {
 		logger.LogAndSendSystemMessage("Before")
-		_, err = db.Exec("UPDATE userinfo SET magic_password_id = ? WHERE uuid = ?", "", userUuid)
+		err = sessionRepo.ClearPasswordToken(ctx, userUuid)
 		if err != nil {
 			logger.LogAndSendSystemMessage(err.Error())
 		}
@@ -651,16 +372,9 @@ func IsValidPasswordToken(email, token string) bool {
 
 func ChangePassword(email, newPassword string) {
 	logger := logs.NewSistemLogger()
-	db, err := database.InitializeDB()
-	if err != nil {
-		logger.LogAndSendSystemMessage(err.Error())
-		return
-	}
-	defer db.Close()
+	ctx := context.Background()
 
-	newPassword = Encrypt(HashPassword(newPassword))
-
-	_, err = db.Exec("UPDATE userdata SET password = ? WHERE email = ?", newPassword, email)
+	err := userRepo.UpdatePassword(ctx, email, newPassword)
 	if err != nil {
 		logger.LogAndSendSystemMessage(err.Error())
 		return
@@ -668,91 +382,53 @@ func ChangePassword(email, newPassword string) {
 
 	userUuid := GetUserUUID(email)
 
-	_, err = db.Exec("UPDATE userinfo SET magic_password_id = ? WHERE uuid = ?", "", userUuid)
-}
-
-func ComparePasswords(userId, jwtPassword string) bool {
-	logger := logs.NewSistemLogger()
-	db, err := database.InitializeDB()
+	err = sessionRepo.ClearPasswordToken(ctx, userUuid)
 	if err != nil {
 		logger.LogAndSendSystemMessage(err.Error())
-		return false
 	}
-	defer db.Close()
-
-	var dbPassword string
+}
 
-	err = db.QueryRow("SELECT password FROM userdata WHERE uuid = ?", userId).Scan(&dbPassword)
+func ComparePasswords(userId, jwtPassword string) bool {
+	dbPassword, err := userRepo.PasswordHash(context.Background(), userId)
 	if err != nil {
-		logger.LogAndSendSystemMessage(err.Error())
+		logs.NewSistemLogger().LogAndSendSystemMessage(err.Error())
 		return false
 	}
 
-	return Decrypt(dbPassword) == jwtPassword
+	return dbPassword == jwtPassword
 }
 
 type Services struct {
-	Id     string
-	Name   string
-	Price  float64
-	Status string
-	Type   string
-	Date   string
+	Id              string
+	OwnerId         string `json:"-"`
+	PlanId          string `json:",omitempty"`
+	Name            string
+	Price           money.Money
+	Status          ServiceStatus
+	StatusReason    string `json:",omitempty"`
+	StatusChangedAt string `json:",omitempty"`
+	ActivatedAt     string `json:",omitempty"`
+	SuspendedAt     string `json:",omitempty"`
+	CancelledAt     string `json:",omitempty"`
+	TerminatedAt    string `json:",omitempty"`
+	Type            string
+	Cycle           string
+	AnchorDay       int    `json:",omitempty"`
+	PeriodStart     string `json:",omitempty"`
+	Date            string
+	CreatedAt       string
+	UpdatedAt       string
 }
 
 func HasServices(userId string) bool {
-	logger := logs.NewSistemLogger()
-	db, err := database.InitializeDB()
-	if err != nil {
-		logger.LogAndSendSystemMessage(err.Error())
-		return false
-	}
-	defer db.Close()
-
-	ok := UserExist(userId)
-
-	if !ok {
-		logger.LogAndSendSystemMessage("Usuário com id: " + userId + " não existe")
-	}
-
-	var dataStr string
-	err = db.QueryRow("SELECT data FROM userinfo WHERE uuid = ?", userId).Scan(&dataStr)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return false
-		}
-
-		logger.LogAndSendSystemMessage(err.Error())
-		return false
-	}
-
-	return true
+	services := GetServices(userId)
+	return len(services) > 0
 }
 
 func GetServices(userId string) []Services {
-	logger := logs.NewSistemLogger()
-	db, err := database.InitializeDB()
+	services, _, err := serviceRepo.List(context.Background(), userId, ServiceFilter{})
 	if err != nil {
-		logger.LogAndSendSystemMessage(err.Error())
-		return nil
-	}
-	defer db.Close()
-
-	if !HasServices(userId) {
-		return nil
-	}
-
-	var dataStr string
-	err = db.QueryRow("SELECT data FROM userinfo WHERE uuid = ?", userId).Scan(&dataStr)
-	if err != nil {
-		logger.LogAndSendSystemMessage(err.Error())
-		return nil
-	}
-
-	var services []Services
-	err = json.Unmarshal([]byte(dataStr), &services)
-	if err != nil {
-		logger.LogAndSendSystemMessage(err.Error())
+		logs.NewSistemLogger().LogAndSendSystemMessage(err.Error())
 		return nil
 	}
 
@@ -760,53 +436,20 @@ func GetServices(userId string) []Services {
 }
 
 func AddServices(userId string, service *Services) {
-	logger := logs.NewSistemLogger()
-	db, err := database.InitializeDB()
-	if err != nil {
-		logger.LogAndSendSystemMessage(err.Error())
-		return
-	}
-	defer db.Close()
-
-	if !HasServices(userId) {
-		_, err := db.Exec("UPDATE userinfo SET data = ? WHERE uuid = ?", "[]", userId)
-		if err != nil {
-			logger.LogAndSendSystemMessage(err.Error())
-			return
-		}
-	}
-
-	var bytes []byte
+	service.OwnerId = userId
 
-	servicesPointer := GetServices(userId)
-	if servicesPointer == nil {
-		bytes, err = json.Marshal([]Services{*service})
-		if err != nil {
-			logger.LogAndSendSystemMessage(err)
-			return
-		}
-	} else {
-		services := servicesPointer
-
-		services = append(services, *service)
-		bytes, err = json.Marshal(services)
-		if err != nil {
-			logger.LogAndSendSystemMessage(err.Error())
-			return
-		}
-	}
-
-	_, err = db.Exec("UPDATE userinfo SET data = ? WHERE uuid = ?", string(bytes), userId)
+	err := serviceRepo.Create(context.Background(), service)
 	if err != nil {
-		logger.LogAndSendSystemMessage(err.Error())
+		logs.NewSistemLogger().LogAndSendSystemMessage(err.Error())
 		return
 	}
 }
 
-func RemoveServices() {}
-
-func EditServices() {}
+func RemoveServices(userId, serviceId string) error {
+	return serviceRepo.Delete(context.Background(), userId, serviceId)
+}
 
-func GenerateInvoice() {
-	
-}
\ No newline at end of file
+func EditServices(userId string, service *Services) error {
+	service.OwnerId = userId
+	return serviceRepo.Update(context.Background(), service)
+}
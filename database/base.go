@@ -2,27 +2,101 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
+	"strconv"
+	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 )
 
-func InitializeDB() (*sql.DB, error) {
-	user := os.Getenv("DB_USER")
-	password := os.Getenv("DB_PASSWORD")
-	host := os.Getenv("DB_HOST")
-	port := os.Getenv("DB_PORT")
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/BalliHost", user, password, host, port)
+type Config struct {
+	User            string
+	Password        string
+	Host            string
+	Port            string
+	Name            string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+func envInt(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return fallback
+	}
+
+	return value
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil {
+		return fallback
+	}
+
+	return value
+}
+
+func ConfigFromEnv() Config {
+	name := os.Getenv("DB_NAME")
+	if name == "" {
+		name = "BalliHost"
+	}
+
+	return Config{
+		User:            os.Getenv("DB_USER"),
+		Password:        os.Getenv("DB_PASSWORD"),
+		Host:            os.Getenv("DB_HOST"),
+		Port:            os.Getenv("DB_PORT"),
+		Name:            name,
+		MaxOpenConns:    envInt("DB_MAX_OPEN_CONNS", 25),
+		MaxIdleConns:    envInt("DB_MAX_IDLE_CONNS", 10),
+		ConnMaxLifetime: envDuration("DB_CONN_MAX_LIFETIME", 5*time.Minute),
+		ConnMaxIdleTime: envDuration("DB_CONN_MAX_IDLE_TIME", time.Minute),
+	}
+}
+
+// Open cria o pool de conexões que deve viver durante toda a aplicação,
+// ele é criado uma vez no main e repassado para os repositórios
+func Open(cfg Config) (*sql.DB, error) {
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?clientFoundRows=true", cfg.User, cfg.Password, cfg.Host, cfg.Port, cfg.Name)
 	db, err := sql.Open("mysql", dsn)
 
 	if err != nil {
 		return db, err
 	}
 
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
 	if err := db.Ping(); err != nil {
-		return db, err
+		db.Close()
+		return nil, err
 	}
 
 	return db, nil
 }
+
+// IsDuplicate diz se o erro veio de uma chave UNIQUE ou PRIMARY repetida
+func IsDuplicate(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062
+}
+
+// Truncate corta o texto em size caracteres para caber num VARCHAR(size).
+// Conta runas e não bytes, cortar no meio de um caractere acentuado faz o
+// MySQL recusar o insert no modo estrito
+func Truncate(value string, size int) string {
+	runes := []rune(value)
+	if len(runes) <= size {
+		return value
+	}
+
+	return string(runes[:size])
+}
@@ -0,0 +1,68 @@
+// Package dbtest sobe um servidor MySQL em memória para os testes, com as
+// migrations aplicadas, sem depender de um banco de fora
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"prodata/database"
+	"testing"
+
+	sqle "github.com/dolthub/go-mysql-server"
+	"github.com/dolthub/go-mysql-server/memory"
+	"github.com/dolthub/go-mysql-server/server"
+	gms "github.com/dolthub/go-mysql-server/sql"
+	"github.com/sirupsen/logrus"
+)
+
+const name = "prodata_test"
+
+func init() {
+	// O servidor loga cada conexão e avisos de configuração que não valem
+	// para um banco de teste
+	logrus.SetLevel(logrus.ErrorLevel)
+}
+
+// Open devolve uma conexão com um banco novo e vazio, migrado até a última
+// versão. O servidor escuta numa porta livre do loopback e fecha com o teste,
+// então cada teste tem o seu banco
+func Open(t *testing.T) *sql.DB {
+	t.Helper()
+
+	memdb := memory.NewDatabase(name)
+	memdb.BaseDatabase.EnablePrimaryKeyIndexes()
+	provider := memory.NewDBProvider(memdb)
+	engine := sqle.NewDefault(provider)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := server.Config{Protocol: "tcp", Address: listener.Addr().String(), Listener: listener}
+	srv, err := server.NewServer(cfg, engine, gms.NewContext, memory.NewSessionBuilder(provider), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start()
+	t.Cleanup(func() { srv.Close() })
+
+	host, port, _ := net.SplitHostPort(cfg.Address)
+	db, err := database.Open(database.Config{User: "root", Host: host, Port: port, Name: name, MaxOpenConns: 10, MaxIdleConns: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrator, err := database.NewMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS registration_attempts;
+DROP TABLE IF EXISTS userinfo;
+DROP TABLE IF EXISTS userdata;
@@ -0,0 +1,36 @@
+-- Tabelas que já existiam antes das migrations, o IF NOT EXISTS deixa
+-- bancos antigos adotarem o schema_migrations sem recriar nada
+CREATE TABLE IF NOT EXISTS userdata (
+    uuid CHAR(36) NOT NULL,
+    first_name VARCHAR(512) NOT NULL,
+    last_name VARCHAR(512) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    password VARCHAR(512) NOT NULL,
+    PRIMARY KEY (uuid),
+    UNIQUE KEY userdata_email (email)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS userinfo (
+    uuid CHAR(36) NOT NULL,
+    auth TINYINT(1) NOT NULL DEFAULT 0,
+    admin TINYINT(1) NOT NULL DEFAULT 0,
+    devices TEXT NOT NULL,
+    data LONGTEXT NULL,
+    magic_auth_id VARCHAR(64) NOT NULL DEFAULT '',
+    magic_auth_verified TINYINT(1) NOT NULL DEFAULT 0,
+    magic_auth_expiration DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    magic_password_id VARCHAR(64) NOT NULL DEFAULT '',
+    magic_password_expiration DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (uuid),
+    CONSTRAINT userinfo_userdata FOREIGN KEY (uuid) REFERENCES userdata (uuid) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS registration_attempts (
+    email VARCHAR(255) NOT NULL,
+    ip VARCHAR(64) NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    date DATETIME NOT NULL,
+    can_back DATETIME NOT NULL,
+    PRIMARY KEY (ip),
+    KEY registration_attempts_email (email)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
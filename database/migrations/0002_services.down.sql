@@ -0,0 +1,13 @@
+UPDATE userinfo u SET data = COALESCE((
+    SELECT JSON_ARRAYAGG(JSON_OBJECT(
+        'Id', s.id,
+        'Name', s.name,
+        'Price', s.price,
+        'Status', s.status,
+        'Type', s.type,
+        'Date', COALESCE(DATE_FORMAT(s.due_date, '%Y-%m-%d %H:%i:%s'), '')
+    ))
+    FROM services s WHERE s.owner_uuid = u.uuid
+), '[]');
+
+DROP TABLE IF EXISTS services;
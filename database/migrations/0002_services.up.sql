@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS services (
+    id CHAR(36) NOT NULL,
+    owner_uuid CHAR(36) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    type VARCHAR(100) NOT NULL DEFAULT '',
+    status VARCHAR(32) NOT NULL,
+    price DECIMAL(12, 2) NOT NULL DEFAULT 0,
+    due_date DATETIME NULL,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    PRIMARY KEY (id),
+    KEY services_owner_status (owner_uuid, status),
+    KEY services_owner_type (owner_uuid, type),
+    CONSTRAINT services_owner FOREIGN KEY (owner_uuid) REFERENCES userdata (uuid) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Move os serviços que estavam salvos como JSON em userinfo.data
+INSERT IGNORE INTO services (id, owner_uuid, name, type, status, price, due_date, created_at, updated_at)
+SELECT jt.id, u.uuid, jt.name, COALESCE(jt.type, ''), jt.status, COALESCE(jt.price, 0), NULLIF(jt.date, ''), NOW(), NOW()
+FROM (SELECT uuid, data FROM userinfo WHERE data IS NOT NULL AND JSON_VALID(data)) u,
+JSON_TABLE(u.data, '$[*]' COLUMNS (
+    id VARCHAR(36) PATH '$.Id',
+    name VARCHAR(255) PATH '$.Name',
+    price DECIMAL(12, 2) PATH '$.Price',
+    status VARCHAR(32) PATH '$.Status',
+    type VARCHAR(100) PATH '$.Type',
+    date VARCHAR(32) PATH '$.Date'
+)) jt
+WHERE jt.id IS NOT NULL AND jt.id <> '';
+
+UPDATE userinfo SET data = '[]';
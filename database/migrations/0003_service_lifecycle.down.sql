@@ -0,0 +1,17 @@
+DROP TABLE IF EXISTS service_transitions;
+
+UPDATE services SET status = CASE status
+    WHEN 'active' THEN 'Ativo'
+    WHEN 'suspended' THEN 'Suspenso'
+    WHEN 'cancelled' THEN 'Cancelado'
+    WHEN 'terminated' THEN 'Encerrado'
+    ELSE 'Pendente'
+END;
+
+ALTER TABLE services
+    DROP COLUMN status_reason,
+    DROP COLUMN status_changed_at,
+    DROP COLUMN activated_at,
+    DROP COLUMN suspended_at,
+    DROP COLUMN cancelled_at,
+    DROP COLUMN terminated_at;
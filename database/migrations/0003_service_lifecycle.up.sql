@@ -0,0 +1,30 @@
+ALTER TABLE services
+    ADD COLUMN status_reason VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN status_changed_at DATETIME NULL,
+    ADD COLUMN activated_at DATETIME NULL,
+    ADD COLUMN suspended_at DATETIME NULL,
+    ADD COLUMN cancelled_at DATETIME NULL,
+    ADD COLUMN terminated_at DATETIME NULL;
+
+UPDATE services SET status = CASE status
+    WHEN 'Ativo' THEN 'active'
+    WHEN 'Suspenso' THEN 'suspended'
+    WHEN 'Cancelado' THEN 'cancelled'
+    WHEN 'Encerrado' THEN 'terminated'
+    ELSE 'pending'
+END, status_changed_at = updated_at;
+
+UPDATE services SET activated_at = created_at WHERE status IN ('active', 'suspended');
+
+CREATE TABLE IF NOT EXISTS service_transitions (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    service_id CHAR(36) NOT NULL,
+    action VARCHAR(32) NOT NULL,
+    from_status VARCHAR(32) NOT NULL,
+    to_status VARCHAR(32) NOT NULL,
+    reason VARCHAR(255) NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (id),
+    KEY service_transitions_service (service_id, created_at),
+    CONSTRAINT service_transitions_service FOREIGN KEY (service_id) REFERENCES services (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
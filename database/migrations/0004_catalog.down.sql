@@ -0,0 +1,7 @@
+ALTER TABLE services DROP FOREIGN KEY services_plan;
+ALTER TABLE services DROP COLUMN plan_id;
+
+DROP TABLE IF EXISTS catalog_plan_prices;
+DROP TABLE IF EXISTS catalog_plans;
+DROP TABLE IF EXISTS catalog_products;
+DROP TABLE IF EXISTS catalog_categories;
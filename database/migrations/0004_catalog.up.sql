@@ -0,0 +1,74 @@
+CREATE TABLE IF NOT EXISTS catalog_categories (
+    id CHAR(36) NOT NULL,
+    slug VARCHAR(100) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL,
+    visible TINYINT(1) NOT NULL DEFAULT 1,
+    position INT NOT NULL DEFAULT 0,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY catalog_categories_slug (slug)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS catalog_products (
+    id CHAR(36) NOT NULL,
+    category_id CHAR(36) NOT NULL,
+    slug VARCHAR(100) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL,
+    visible TINYINT(1) NOT NULL DEFAULT 1,
+    position INT NOT NULL DEFAULT 0,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY catalog_products_slug (slug),
+    CONSTRAINT catalog_products_category FOREIGN KEY (category_id) REFERENCES catalog_categories (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS catalog_plans (
+    id CHAR(36) NOT NULL,
+    product_id CHAR(36) NOT NULL,
+    slug VARCHAR(100) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL,
+    ram_mb INT NOT NULL DEFAULT 0,
+    cpu_cores INT NOT NULL DEFAULT 0,
+    disk_gb INT NOT NULL DEFAULT 0,
+    slots INT NOT NULL DEFAULT 0,
+    visible TINYINT(1) NOT NULL DEFAULT 1,
+    stock INT NULL,
+    position INT NOT NULL DEFAULT 0,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY catalog_plans_product_slug (product_id, slug),
+    CONSTRAINT catalog_plans_product FOREIGN KEY (product_id) REFERENCES catalog_products (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS catalog_plan_prices (
+    plan_id CHAR(36) NOT NULL,
+    cycle VARCHAR(32) NOT NULL,
+    price DECIMAL(12, 2) NOT NULL,
+    PRIMARY KEY (plan_id, cycle),
+    CONSTRAINT catalog_plan_prices_plan FOREIGN KEY (plan_id) REFERENCES catalog_plans (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+ALTER TABLE services
+    ADD COLUMN plan_id CHAR(36) NULL AFTER owner_uuid,
+    ADD CONSTRAINT services_plan FOREIGN KEY (plan_id) REFERENCES catalog_plans (id);
+
+-- O plano que antes era criado no main.go a cada boot
+INSERT INTO catalog_categories (id, slug, name, description, visible, position, created_at, updated_at)
+VALUES ('5b0f7c36-4f3e-4a55-9a8e-0c6f3f0a1001', 'hospedagem-de-jogos', 'Hospedagem de Jogos', '', 1, 0, NOW(), NOW());
+
+INSERT INTO catalog_products (id, category_id, slug, name, description, visible, position, created_at, updated_at)
+VALUES ('5b0f7c36-4f3e-4a55-9a8e-0c6f3f0a2001', '5b0f7c36-4f3e-4a55-9a8e-0c6f3f0a1001', 'minecraft', 'Minecraft', '', 1, 0, NOW(), NOW());
+
+INSERT INTO catalog_plans (id, product_id, slug, name, description, ram_mb, cpu_cores, disk_gb, slots, visible, stock, position, created_at, updated_at)
+VALUES ('5b0f7c36-4f3e-4a55-9a8e-0c6f3f0a3001', '5b0f7c36-4f3e-4a55-9a8e-0c6f3f0a2001', 'premium-48gb', 'Premium 48GB', '', 49152, 0, 0, 0, 1, NULL, 0, NOW(), NOW());
+
+INSERT INTO catalog_plan_prices (plan_id, cycle, price)
+VALUES ('5b0f7c36-4f3e-4a55-9a8e-0c6f3f0a3001', 'monthly', 480.00);
+
+UPDATE services SET plan_id = '5b0f7c36-4f3e-4a55-9a8e-0c6f3f0a3001' WHERE name = 'Minecraft Premium 48GB';
@@ -0,0 +1,47 @@
+CREATE TABLE IF NOT EXISTS invoice_sequences (
+    year INT NOT NULL,
+    last_number INT NOT NULL,
+    PRIMARY KEY (year)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS invoices (
+    id CHAR(36) NOT NULL,
+    number VARCHAR(32) NULL,
+    owner_uuid CHAR(36) NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    currency CHAR(3) NOT NULL DEFAULT 'BRL',
+    subtotal DECIMAL(12, 2) NOT NULL DEFAULT 0,
+    discount DECIMAL(12, 2) NOT NULL DEFAULT 0,
+    tax DECIMAL(12, 2) NOT NULL DEFAULT 0,
+    total DECIMAL(12, 2) NOT NULL DEFAULT 0,
+    due_date DATETIME NOT NULL,
+    notes TEXT NOT NULL,
+    void_reason VARCHAR(255) NOT NULL DEFAULT '',
+    issued_at DATETIME NULL,
+    paid_at DATETIME NULL,
+    voided_at DATETIME NULL,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY invoices_number (number),
+    KEY invoices_owner_status (owner_uuid, status),
+    KEY invoices_status_due (status, due_date),
+    CONSTRAINT invoices_owner FOREIGN KEY (owner_uuid) REFERENCES userdata (uuid)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS invoice_items (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    invoice_id CHAR(36) NOT NULL,
+    service_id CHAR(36) NULL,
+    kind VARCHAR(16) NOT NULL,
+    description VARCHAR(255) NOT NULL,
+    quantity INT NOT NULL DEFAULT 1,
+    unit_price DECIMAL(12, 2) NOT NULL,
+    amount DECIMAL(12, 2) NOT NULL,
+    position INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (id),
+    KEY invoice_items_invoice (invoice_id, position),
+    KEY invoice_items_service (service_id),
+    CONSTRAINT invoice_items_invoice FOREIGN KEY (invoice_id) REFERENCES invoices (id) ON DELETE CASCADE,
+    CONSTRAINT invoice_items_service FOREIGN KEY (service_id) REFERENCES services (id) ON DELETE SET NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS service_plan_changes;
+
+ALTER TABLE services
+    DROP COLUMN period_start;
@@ -0,0 +1,26 @@
+ALTER TABLE services
+    ADD COLUMN period_start DATETIME NULL AFTER price;
+
+-- Até aqui todo serviço era mensal, o período atual termina no vencimento
+UPDATE services SET period_start = DATE_SUB(due_date, INTERVAL 1 MONTH) WHERE due_date IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS service_plan_changes (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    service_id CHAR(36) NOT NULL,
+    owner_uuid CHAR(36) NOT NULL,
+    old_plan_id CHAR(36) NULL,
+    new_plan_id CHAR(36) NOT NULL,
+    old_price DECIMAL(12, 2) NOT NULL,
+    new_price DECIMAL(12, 2) NOT NULL,
+    charge DECIMAL(12, 2) NOT NULL DEFAULT 0,
+    credit DECIMAL(12, 2) NOT NULL DEFAULT 0,
+    period_start DATETIME NOT NULL,
+    period_end DATETIME NOT NULL,
+    invoice_id CHAR(36) NULL,
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (id),
+    KEY service_plan_changes_service (service_id, created_at),
+    KEY service_plan_changes_owner (owner_uuid, created_at),
+    CONSTRAINT service_plan_changes_service FOREIGN KEY (service_id) REFERENCES services (id) ON DELETE CASCADE,
+    CONSTRAINT service_plan_changes_invoice FOREIGN KEY (invoice_id) REFERENCES invoices (id) ON DELETE SET NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS payments (
+    id CHAR(36) NOT NULL,
+    invoice_id CHAR(36) NOT NULL,
+    owner_uuid CHAR(36) NOT NULL,
+    provider VARCHAR(32) NOT NULL,
+    provider_payment_id VARCHAR(64) NOT NULL,
+    method VARCHAR(16) NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    status_detail VARCHAR(64) NOT NULL DEFAULT '',
+    amount DECIMAL(12, 2) NOT NULL,
+    qr_code TEXT NULL,
+    qr_code_base64 MEDIUMTEXT NULL,
+    ticket_url VARCHAR(512) NOT NULL DEFAULT '',
+    expires_at DATETIME NULL,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY payments_provider (provider, provider_payment_id),
+    KEY payments_invoice_status (invoice_id, status),
+    CONSTRAINT payments_invoice FOREIGN KEY (invoice_id) REFERENCES invoices (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS webhook_events (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    provider VARCHAR(32) NOT NULL,
+    event_id VARCHAR(64) NOT NULL,
+    request_id VARCHAR(64) NOT NULL DEFAULT '',
+    type VARCHAR(64) NOT NULL,
+    action VARCHAR(64) NOT NULL DEFAULT '',
+    resource_id VARCHAR(64) NOT NULL DEFAULT '',
+    live_mode TINYINT(1) NOT NULL DEFAULT 0,
+    payload MEDIUMTEXT NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT NULL,
+    received_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    processed_at DATETIME NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY webhook_events_event (provider, event_id),
+    KEY webhook_events_resource (provider, type, resource_id),
+    KEY webhook_events_status (status, updated_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
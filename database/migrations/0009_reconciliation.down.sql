@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS balance_adjustments;
+
+ALTER TABLE invoice_items
+    DROP COLUMN period_start,
+    DROP COLUMN period_end;
+
+ALTER TABLE invoices
+    DROP COLUMN amount_paid;
@@ -0,0 +1,24 @@
+ALTER TABLE invoices
+    ADD COLUMN amount_paid DECIMAL(12, 2) NOT NULL DEFAULT 0 AFTER total;
+
+UPDATE invoices SET amount_paid = total WHERE status = 'paid';
+
+-- Quando preenchido, pagar o item renova o serviço para esse período
+ALTER TABLE invoice_items
+    ADD COLUMN period_start DATETIME NULL AFTER amount,
+    ADD COLUMN period_end DATETIME NULL AFTER period_start;
+
+CREATE TABLE IF NOT EXISTS balance_adjustments (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    owner_uuid CHAR(36) NOT NULL,
+    kind VARCHAR(32) NOT NULL,
+    amount DECIMAL(12, 2) NOT NULL,
+    invoice_id CHAR(36) NULL,
+    payment_id CHAR(36) NULL,
+    description VARCHAR(255) NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (id),
+    KEY balance_adjustments_owner (owner_uuid, created_at),
+    CONSTRAINT balance_adjustments_invoice FOREIGN KEY (invoice_id) REFERENCES invoices (id) ON DELETE SET NULL,
+    CONSTRAINT balance_adjustments_payment FOREIGN KEY (payment_id) REFERENCES payments (id) ON DELETE SET NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
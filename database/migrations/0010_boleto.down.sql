@@ -0,0 +1,3 @@
+ALTER TABLE payments
+    DROP COLUMN due_date,
+    DROP COLUMN barcode_line;
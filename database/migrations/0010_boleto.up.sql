@@ -0,0 +1,5 @@
+-- Linha digitável e vencimento do boleto. O expires_at do boleto já conta
+-- os dias de compensação depois do vencimento
+ALTER TABLE payments
+    ADD COLUMN barcode_line VARCHAR(64) NULL AFTER ticket_url,
+    ADD COLUMN due_date DATE NULL AFTER barcode_line;
@@ -0,0 +1,20 @@
+-- Cartões salvos no gateway. Só os ids do cliente e do cartão no gateway e o
+-- que aparece para o cliente, o número do cartão nunca passa por aqui
+CREATE TABLE IF NOT EXISTS payment_methods (
+    id CHAR(36) NOT NULL,
+    owner_uuid CHAR(36) NOT NULL,
+    provider VARCHAR(32) NOT NULL,
+    customer_id VARCHAR(64) NOT NULL,
+    card_id VARCHAR(64) NOT NULL,
+    brand VARCHAR(32) NOT NULL DEFAULT '',
+    last_four CHAR(4) NOT NULL DEFAULT '',
+    expiration_month TINYINT NOT NULL DEFAULT 0,
+    expiration_year SMALLINT NOT NULL DEFAULT 0,
+    holder_name VARCHAR(255) NOT NULL DEFAULT '',
+    is_default TINYINT(1) NOT NULL DEFAULT 0,
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY payment_methods_card (provider, card_id),
+    KEY payment_methods_owner (owner_uuid, is_default),
+    CONSTRAINT payment_methods_owner FOREIGN KEY (owner_uuid) REFERENCES userdata (uuid) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
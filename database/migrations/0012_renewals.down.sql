@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS renewals;
+DROP TABLE IF EXISTS billing_preferences;
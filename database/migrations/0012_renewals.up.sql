@@ -0,0 +1,34 @@
+-- Com auto_charge a renovação tenta cobrar no cartão padrão
+CREATE TABLE IF NOT EXISTS billing_preferences (
+    owner_uuid CHAR(36) NOT NULL,
+    auto_charge TINYINT(1) NOT NULL DEFAULT 0,
+    updated_at DATETIME NOT NULL,
+    PRIMARY KEY (owner_uuid),
+    CONSTRAINT billing_preferences_owner FOREIGN KEY (owner_uuid) REFERENCES userdata (uuid) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Uma linha por serviço e período, a chave única impede que duas instâncias
+-- gerem a mesma renovação. Cada passo grava quando terminou, assim uma
+-- renovação interrompida continua de onde parou
+CREATE TABLE IF NOT EXISTS renewals (
+    id CHAR(36) NOT NULL,
+    service_id CHAR(36) NOT NULL,
+    owner_uuid CHAR(36) NOT NULL,
+    period_start DATETIME NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    invoice_id CHAR(36) NULL,
+    reminded_at DATETIME NULL,
+    charged_at DATETIME NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    last_error VARCHAR(512) NOT NULL DEFAULT '',
+    next_attempt_at DATETIME NOT NULL,
+    locked_by CHAR(36) NULL,
+    locked_until DATETIME NULL,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY renewals_period (service_id, period_start),
+    KEY renewals_pending (status, next_attempt_at),
+    CONSTRAINT renewals_service FOREIGN KEY (service_id) REFERENCES services (id) ON DELETE CASCADE,
+    CONSTRAINT renewals_invoice FOREIGN KEY (invoice_id) REFERENCES invoices (id) ON DELETE SET NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS dunning_actions;
+DROP TABLE IF EXISTS dunning_overrides;
@@ -0,0 +1,33 @@
+-- Regras de cobrança diferentes para um cliente, definidas por um admin.
+-- Colunas NULL usam a política padrão
+CREATE TABLE IF NOT EXISTS dunning_overrides (
+    owner_uuid CHAR(36) NOT NULL,
+    paused TINYINT(1) NOT NULL DEFAULT 0,
+    suspend_after INT NULL,
+    terminate_after INT NULL,
+    expires_at DATETIME NULL,
+    reason VARCHAR(255) NOT NULL DEFAULT '',
+    updated_by CHAR(36) NOT NULL,
+    updated_at DATETIME NOT NULL,
+    PRIMARY KEY (owner_uuid),
+    CONSTRAINT dunning_overrides_owner FOREIGN KEY (owner_uuid) REFERENCES userdata (uuid) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Trilha de auditoria da cobrança. A chave única faz cada ação acontecer uma
+-- vez por fatura e alvo (serviço ou dia do lembrete), mesmo com várias
+-- instâncias rodando
+CREATE TABLE IF NOT EXISTS dunning_actions (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    owner_uuid CHAR(36) NOT NULL,
+    invoice_id CHAR(36) NULL,
+    action VARCHAR(32) NOT NULL,
+    target VARCHAR(64) NOT NULL DEFAULT '',
+    detail VARCHAR(255) NOT NULL DEFAULT '',
+    actor VARCHAR(64) NOT NULL,
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY dunning_actions_once (invoice_id, action, target),
+    KEY dunning_actions_owner (owner_uuid, created_at),
+    KEY dunning_actions_target (target, created_at),
+    CONSTRAINT dunning_actions_invoice FOREIGN KEY (invoice_id) REFERENCES invoices (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS credit_notes;
+DROP TABLE IF EXISTS credit_note_sequences;
+DROP TABLE IF EXISTS refunds;
+
+ALTER TABLE invoices
+    DROP COLUMN amount_refunded;
@@ -0,0 +1,53 @@
+ALTER TABLE invoices
+    ADD COLUMN amount_refunded DECIMAL(12, 2) NOT NULL DEFAULT 0 AFTER amount_paid;
+
+-- Estornos pedidos por um admin (source admin) ou feitos direto no painel do
+-- gateway e descobertos pelo webhook (source gateway). O estorno do admin
+-- nasce pending, antes de chamar o gateway, para o valor ficar reservado
+CREATE TABLE IF NOT EXISTS refunds (
+    id CHAR(36) NOT NULL,
+    payment_id CHAR(36) NOT NULL,
+    invoice_id CHAR(36) NOT NULL,
+    owner_uuid CHAR(36) NOT NULL,
+    provider VARCHAR(32) NOT NULL,
+    provider_refund_id VARCHAR(64) NULL,
+    amount DECIMAL(12, 2) NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    source VARCHAR(16) NOT NULL,
+    reason VARCHAR(255) NOT NULL DEFAULT '',
+    actor VARCHAR(64) NOT NULL,
+    cancel_services TINYINT(1) NOT NULL DEFAULT 0,
+    last_error VARCHAR(512) NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY refunds_provider (provider, provider_refund_id),
+    KEY refunds_payment (payment_id, status),
+    KEY refunds_invoice (invoice_id),
+    CONSTRAINT refunds_payment FOREIGN KEY (payment_id) REFERENCES payments (id) ON DELETE CASCADE,
+    CONSTRAINT refunds_invoice FOREIGN KEY (invoice_id) REFERENCES invoices (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS credit_note_sequences (
+    year INT NOT NULL,
+    last_number INT NOT NULL,
+    PRIMARY KEY (year)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Uma nota de crédito para cada estorno aprovado, é o que aparece na fatura
+CREATE TABLE IF NOT EXISTS credit_notes (
+    id CHAR(36) NOT NULL,
+    number VARCHAR(32) NOT NULL,
+    invoice_id CHAR(36) NOT NULL,
+    owner_uuid CHAR(36) NOT NULL,
+    refund_id CHAR(36) NOT NULL,
+    amount DECIMAL(12, 2) NOT NULL,
+    reason VARCHAR(255) NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY credit_notes_number (number),
+    UNIQUE KEY credit_notes_refund (refund_id),
+    KEY credit_notes_invoice (invoice_id),
+    CONSTRAINT credit_notes_invoice FOREIGN KEY (invoice_id) REFERENCES invoices (id) ON DELETE CASCADE,
+    CONSTRAINT credit_notes_refund FOREIGN KEY (refund_id) REFERENCES refunds (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS dispute_evidence;
+DROP TABLE IF EXISTS dispute_services;
+DROP TABLE IF EXISTS disputes;
+
+ALTER TABLE userinfo
+    DROP COLUMN dispute_flagged_at;
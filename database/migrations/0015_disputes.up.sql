@@ -0,0 +1,48 @@
+-- Marcado quando o cliente passa do limite de contestações, só um admin tira
+ALTER TABLE userinfo
+    ADD COLUMN dispute_flagged_at DATETIME NULL;
+
+-- Uma contestação (chargeback) por pagamento. services_suspended_at fica NULL
+-- até os serviços da fatura serem suspensos, assim o passo é retomado se cair
+CREATE TABLE IF NOT EXISTS disputes (
+    id CHAR(36) NOT NULL,
+    payment_id CHAR(36) NOT NULL,
+    invoice_id CHAR(36) NOT NULL,
+    owner_uuid CHAR(36) NOT NULL,
+    provider VARCHAR(32) NOT NULL,
+    amount DECIMAL(12, 2) NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    provider_detail VARCHAR(64) NOT NULL DEFAULT '',
+    outcome_note VARCHAR(255) NOT NULL DEFAULT '',
+    resolved_by VARCHAR(64) NOT NULL DEFAULT '',
+    services_suspended_at DATETIME NULL,
+    resolved_at DATETIME NULL,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY disputes_payment (payment_id),
+    KEY disputes_owner (owner_uuid, created_at),
+    KEY disputes_status (status, created_at),
+    CONSTRAINT disputes_payment FOREIGN KEY (payment_id) REFERENCES payments (id) ON DELETE CASCADE,
+    CONSTRAINT disputes_invoice FOREIGN KEY (invoice_id) REFERENCES invoices (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Serviços suspensos por causa da contestação, voltam se ela for ganha
+CREATE TABLE IF NOT EXISTS dispute_services (
+    dispute_id CHAR(36) NOT NULL,
+    service_id CHAR(36) NOT NULL,
+    PRIMARY KEY (dispute_id, service_id),
+    KEY dispute_services_service (service_id),
+    CONSTRAINT dispute_services_dispute FOREIGN KEY (dispute_id) REFERENCES disputes (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS dispute_evidence (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    dispute_id CHAR(36) NOT NULL,
+    note TEXT NOT NULL,
+    author VARCHAR(64) NOT NULL,
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (id),
+    KEY dispute_evidence_dispute (dispute_id, created_at),
+    CONSTRAINT dispute_evidence_dispute FOREIGN KEY (dispute_id) REFERENCES disputes (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
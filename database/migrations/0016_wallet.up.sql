@@ -0,0 +1,32 @@
+-- Saldo atual de cada cliente. A linha é travada em cada lançamento, o que
+-- mantém balance igual à soma de wallet_entries
+CREATE TABLE IF NOT EXISTS wallets (
+    owner_uuid CHAR(36) NOT NULL,
+    balance DECIMAL(12, 2) NOT NULL DEFAULT 0,
+    updated_at DATETIME NOT NULL,
+    PRIMARY KEY (owner_uuid),
+    CONSTRAINT wallets_owner FOREIGN KEY (owner_uuid) REFERENCES userdata (uuid) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Livro de lançamentos, só recebe INSERT. Crédito positivo, uso negativo.
+-- A chave (source, reference) impede lançar duas vezes o mesmo fato, os
+-- ajustes manuais ficam com reference NULL
+CREATE TABLE IF NOT EXISTS wallet_entries (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    owner_uuid CHAR(36) NOT NULL,
+    source VARCHAR(32) NOT NULL,
+    amount DECIMAL(12, 2) NOT NULL,
+    balance_after DECIMAL(12, 2) NOT NULL,
+    invoice_id CHAR(36) NULL,
+    payment_id CHAR(36) NULL,
+    reference VARCHAR(64) NULL,
+    description VARCHAR(255) NOT NULL DEFAULT '',
+    actor VARCHAR(64) NOT NULL,
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY wallet_entries_once (source, reference),
+    KEY wallet_entries_owner (owner_uuid, id),
+    CONSTRAINT wallet_entries_owner FOREIGN KEY (owner_uuid) REFERENCES userdata (uuid) ON DELETE CASCADE,
+    CONSTRAINT wallet_entries_invoice FOREIGN KEY (invoice_id) REFERENCES invoices (id) ON DELETE SET NULL,
+    CONSTRAINT wallet_entries_payment FOREIGN KEY (payment_id) REFERENCES payments (id) ON DELETE SET NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS journal_lines;
+DROP TABLE IF EXISTS journals;
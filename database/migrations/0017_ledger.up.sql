@@ -0,0 +1,26 @@
+-- Livro de partidas dobradas. Cada fato do faturamento gera um journal com
+-- linhas que somam o mesmo débito e crédito. A chave (kind, reference)
+-- impede lançar o mesmo fato duas vezes quando a conciliação roda de novo
+CREATE TABLE IF NOT EXISTS journals (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    kind VARCHAR(32) NOT NULL,
+    reference VARCHAR(64) NOT NULL,
+    owner_uuid CHAR(36) NULL,
+    description VARCHAR(255) NOT NULL DEFAULT '',
+    posted_at DATETIME NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY journals_once (kind, reference),
+    KEY journals_posted (posted_at),
+    KEY journals_owner (owner_uuid, posted_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS journal_lines (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    journal_id BIGINT NOT NULL,
+    account VARCHAR(32) NOT NULL,
+    debit DECIMAL(12, 2) NOT NULL DEFAULT 0,
+    credit DECIMAL(12, 2) NOT NULL DEFAULT 0,
+    PRIMARY KEY (id),
+    KEY journal_lines_account (account, journal_id),
+    CONSTRAINT journal_lines_journal FOREIGN KEY (journal_id) REFERENCES journals (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
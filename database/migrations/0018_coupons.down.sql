@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS service_coupons;
+DROP TABLE IF EXISTS coupon_redemptions;
+DROP TABLE IF EXISTS coupon_plans;
+DROP TABLE IF EXISTS coupons;
@@ -0,0 +1,56 @@
+CREATE TABLE IF NOT EXISTS coupons (
+    id CHAR(36) NOT NULL,
+    code VARCHAR(32) NOT NULL,
+    description VARCHAR(255) NOT NULL DEFAULT '',
+    kind VARCHAR(16) NOT NULL,
+    percent INT NOT NULL DEFAULT 0,
+    amount DECIMAL(12, 2) NOT NULL DEFAULT 0,
+    duration VARCHAR(16) NOT NULL,
+    -- Faturas cobertas pelo cupom recorrente contando a primeira, NULL é para sempre
+    cycles INT NULL,
+    max_redemptions INT NULL,
+    max_per_user INT NULL,
+    starts_at DATETIME NULL,
+    ends_at DATETIME NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    redemptions INT NOT NULL DEFAULT 0,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY coupons_code (code)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Sem linhas o cupom vale para todos os planos
+CREATE TABLE IF NOT EXISTS coupon_plans (
+    coupon_id CHAR(36) NOT NULL,
+    plan_id CHAR(36) NOT NULL,
+    PRIMARY KEY (coupon_id, plan_id),
+    CONSTRAINT coupon_plans_coupon FOREIGN KEY (coupon_id) REFERENCES coupons (id) ON DELETE CASCADE,
+    CONSTRAINT coupon_plans_plan FOREIGN KEY (plan_id) REFERENCES catalog_plans (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS coupon_redemptions (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    coupon_id CHAR(36) NOT NULL,
+    owner_uuid CHAR(36) NOT NULL,
+    invoice_id CHAR(36) NOT NULL,
+    amount DECIMAL(12, 2) NOT NULL,
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY coupon_redemptions_coupon_invoice (coupon_id, invoice_id),
+    KEY coupon_redemptions_owner (coupon_id, owner_uuid),
+    CONSTRAINT coupon_redemptions_coupon FOREIGN KEY (coupon_id) REFERENCES coupons (id),
+    CONSTRAINT coupon_redemptions_invoice FOREIGN KEY (invoice_id) REFERENCES invoices (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Desconto recorrente preso ao serviço, aplicado em cada fatura de renovação.
+-- remaining NULL é para sempre
+CREATE TABLE IF NOT EXISTS service_coupons (
+    service_id CHAR(36) NOT NULL,
+    coupon_id CHAR(36) NOT NULL,
+    remaining INT NULL,
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (service_id),
+    CONSTRAINT service_coupons_service FOREIGN KEY (service_id) REFERENCES services (id) ON DELETE CASCADE,
+    CONSTRAINT service_coupons_coupon FOREIGN KEY (coupon_id) REFERENCES coupons (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS order_item_options;
+DROP TABLE IF EXISTS order_items;
+DROP TABLE IF EXISTS orders;
+DROP TABLE IF EXISTS catalog_option_values;
+DROP TABLE IF EXISTS catalog_plan_options;
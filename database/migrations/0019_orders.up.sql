@@ -0,0 +1,75 @@
+-- Escolhas que o cliente faz ao comprar um plano, como localização ou slots
+-- extras. O preço do valor escolhido soma ao do plano
+CREATE TABLE IF NOT EXISTS catalog_plan_options (
+    id CHAR(36) NOT NULL,
+    plan_id CHAR(36) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    required TINYINT(1) NOT NULL DEFAULT 0,
+    position INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (id),
+    KEY catalog_plan_options_plan (plan_id, position),
+    CONSTRAINT catalog_plan_options_plan FOREIGN KEY (plan_id) REFERENCES catalog_plans (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS catalog_option_values (
+    id CHAR(36) NOT NULL,
+    option_id CHAR(36) NOT NULL,
+    label VARCHAR(255) NOT NULL,
+    price DECIMAL(12, 2) NOT NULL DEFAULT 0,
+    position INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (id),
+    KEY catalog_option_values_option (option_id, position),
+    CONSTRAINT catalog_option_values_option FOREIGN KEY (option_id) REFERENCES catalog_plan_options (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Pedido feito pelo cliente. Nasce pending com a fatura e os serviços
+-- pending, vira paid quando a fatura é quitada e cancelled ou expired se não
+-- for paga
+CREATE TABLE IF NOT EXISTS orders (
+    id CHAR(36) NOT NULL,
+    owner_uuid CHAR(36) NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    invoice_id CHAR(36) NOT NULL,
+    coupon_code VARCHAR(32) NOT NULL DEFAULT '',
+    total DECIMAL(12, 2) NOT NULL,
+    expires_at DATETIME NOT NULL,
+    cancel_reason VARCHAR(255) NOT NULL DEFAULT '',
+    paid_at DATETIME NULL,
+    cancelled_at DATETIME NULL,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY orders_invoice (invoice_id),
+    KEY orders_owner (owner_uuid, created_at),
+    KEY orders_status_expires (status, expires_at),
+    CONSTRAINT orders_owner FOREIGN KEY (owner_uuid) REFERENCES userdata (uuid),
+    CONSTRAINT orders_invoice FOREIGN KEY (invoice_id) REFERENCES invoices (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Um item por serviço criado, com o preço e as opções copiados do catálogo
+CREATE TABLE IF NOT EXISTS order_items (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    order_id CHAR(36) NOT NULL,
+    plan_id CHAR(36) NOT NULL,
+    service_id CHAR(36) NULL,
+    cycle VARCHAR(32) NOT NULL,
+    description VARCHAR(255) NOT NULL,
+    price DECIMAL(12, 2) NOT NULL,
+    PRIMARY KEY (id),
+    KEY order_items_order (order_id),
+    CONSTRAINT order_items_order FOREIGN KEY (order_id) REFERENCES orders (id) ON DELETE CASCADE,
+    CONSTRAINT order_items_plan FOREIGN KEY (plan_id) REFERENCES catalog_plans (id),
+    CONSTRAINT order_items_service FOREIGN KEY (service_id) REFERENCES services (id) ON DELETE SET NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Sem chave para o catálogo, a opção pode mudar ou sumir depois da compra
+CREATE TABLE IF NOT EXISTS order_item_options (
+    order_item_id BIGINT NOT NULL,
+    option_id CHAR(36) NOT NULL,
+    value_id CHAR(36) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    label VARCHAR(255) NOT NULL,
+    price DECIMAL(12, 2) NOT NULL,
+    PRIMARY KEY (order_item_id, option_id),
+    CONSTRAINT order_item_options_item FOREIGN KEY (order_item_id) REFERENCES order_items (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
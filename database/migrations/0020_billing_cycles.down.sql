@@ -0,0 +1,12 @@
+ALTER TABLE service_plan_changes
+    DROP COLUMN new_cycle,
+    DROP COLUMN old_cycle;
+
+DELETE FROM catalog_plan_prices WHERE price IS NULL;
+
+ALTER TABLE catalog_plan_prices
+    DROP COLUMN discount,
+    MODIFY COLUMN price DECIMAL(12, 2) NOT NULL;
+
+ALTER TABLE services
+    DROP COLUMN cycle;
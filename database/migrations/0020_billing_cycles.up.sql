@@ -0,0 +1,12 @@
+-- Ciclo escolhido na compra, até aqui todo serviço era mensal
+ALTER TABLE services
+    ADD COLUMN cycle VARCHAR(32) NOT NULL DEFAULT 'monthly' AFTER type;
+
+-- Ciclos com discount não guardam preço, ele sai do mensal na leitura
+ALTER TABLE catalog_plan_prices
+    MODIFY COLUMN price DECIMAL(12, 2) NULL,
+    ADD COLUMN discount INT NOT NULL DEFAULT 0 AFTER price;
+
+ALTER TABLE service_plan_changes
+    ADD COLUMN old_cycle VARCHAR(32) NOT NULL DEFAULT 'monthly' AFTER new_plan_id,
+    ADD COLUMN new_cycle VARCHAR(32) NOT NULL DEFAULT 'monthly' AFTER old_cycle;
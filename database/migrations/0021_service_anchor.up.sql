@@ -0,0 +1,9 @@
+-- Dia do mês em que o ciclo do serviço vence. O vencimento de 31/01 cai em
+-- 28/02 e a renovação seguinte volta para 31/03 pelo anchor_day, sem ele o
+-- dia 28 ficava para sempre. Nos serviços antigos o maior dia entre o início
+-- e o fim do período é o que não foi cortado no fim do mês
+ALTER TABLE services
+    ADD COLUMN anchor_day TINYINT NOT NULL DEFAULT 0 AFTER cycle;
+
+UPDATE services
+    SET anchor_day = GREATEST(COALESCE(DAY(period_start), 0), COALESCE(DAY(due_date), 0));
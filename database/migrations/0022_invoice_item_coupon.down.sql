@@ -0,0 +1,2 @@
+ALTER TABLE invoice_items
+    DROP COLUMN coupon_id;
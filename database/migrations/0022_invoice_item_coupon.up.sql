@@ -0,0 +1,4 @@
+-- Cupom que gerou a linha de desconto. Anular uma fatura de renovação devolve
+-- ao serviço o ciclo do cupom recorrente que ela gastou
+ALTER TABLE invoice_items
+    ADD COLUMN coupon_id CHAR(36) NULL AFTER service_id;
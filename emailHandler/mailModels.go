@@ -1,11 +1,13 @@
 package emailHandler
 
 import (
+	"html"
 	"io"
 	"os"
 	"path/filepath"
 	"prodata/database/account"
 	"prodata/logs"
+	"strconv"
 	"strings"
 )
 
@@ -59,8 +61,7 @@ func SetNoreply() *EmailHandler {
 }
 
 func VariableHTML(html *string, varName, value string) {
-	s := strings.Split(*html, varName)
-	*html = s[0] + value + s[1]
+	*html = strings.Replace(*html, varName, value, 1)
 }
 
 func SendMagicLinkVerification(email string) {
@@ -98,3 +99,84 @@ func SendMagicPasswordReset(email string) {
 	sender.Message = BuilderHTML(&sender, magicEmail)
 	SendEmail(&sender, noreply)
 }
+
+type ReceiptItem struct {
+	Name  string
+	Price string
+}
+
+func SendPaymentReceipt(email string, items []ReceiptItem, total string) {
+	noreply := SetNoreply()
+	receipt := LoadHTMLFiles("payment")
+
+	var list strings.Builder
+	list.WriteString(`<div class="items-list">`)
+	for _, item := range items {
+		list.WriteString(`<div class="item"><span class="item-name">` + html.EscapeString(item.Name) + `</span><span class="item-price">` + html.EscapeString(item.Price) + `</span></div>`)
+	}
+	list.WriteString(`</div><p class="total">Total: ` + html.EscapeString(total) + `</p>`)
+
+	VariableHTML(&receipt, "%s", list.String())
+
+	sender := SimpleSender{
+		From:    noreply.SmtpAddress,
+		To:      email,
+		Subject: "Recibo de pagamento",
+	}
+
+	sender.Message = BuilderHTML(&sender, receipt)
+	SendEmail(&sender, noreply)
+}
+
+// SendRenewalReminder avisa que a fatura de renovação foi gerada. autoCharge
+// muda o texto para dizer que o cartão salvo será cobrado
+func SendRenewalReminder(email, service, amount, dueDate string, autoCharge bool) {
+	noreply := SetNoreply()
+	reminder := LoadHTMLFiles("renewal_reminder")
+	if reminder == "" {
+		return
+	}
+
+	charge := "A fatura já está disponível no painel para pagamento por PIX, boleto ou cartão."
+	if autoCharge {
+		charge = "Vamos cobrar automaticamente no seu cartão salvo, não é preciso fazer nada."
+	}
+
+	VariableHTML(&reminder, "%SERVICE%", html.EscapeString(service))
+	VariableHTML(&reminder, "%DUE_DATE%", html.EscapeString(dueDate))
+	VariableHTML(&reminder, "%AMOUNT%", html.EscapeString(amount))
+	VariableHTML(&reminder, "%CHARGE%", charge)
+
+	sender := SimpleSender{
+		From:    noreply.SmtpAddress,
+		To:      email,
+		Subject: "Renovação de " + service,
+	}
+
+	sender.Message = BuilderHTML(&sender, reminder)
+	SendEmail(&sender, noreply)
+}
+
+// SendOverdueReminder cobra uma fatura vencida, warning diz o que acontece
+// com os serviços se ela continuar sem pagamento
+func SendOverdueReminder(email, invoice, amount string, days int, warning string) {
+	noreply := SetNoreply()
+	reminder := LoadHTMLFiles("overdue_reminder")
+	if reminder == "" {
+		return
+	}
+
+	VariableHTML(&reminder, "%INVOICE%", html.EscapeString(invoice))
+	VariableHTML(&reminder, "%DAYS%", strconv.Itoa(days))
+	VariableHTML(&reminder, "%AMOUNT%", html.EscapeString(amount))
+	VariableHTML(&reminder, "%WARNING%", html.EscapeString(warning))
+
+	sender := SimpleSender{
+		From:    noreply.SmtpAddress,
+		To:      email,
+		Subject: "Fatura " + invoice + " vencida",
+	}
+
+	sender.Message = BuilderHTML(&sender, reminder)
+	SendEmail(&sender, noreply)
+}
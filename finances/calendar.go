@@ -0,0 +1,27 @@
+package finances
+
+import "time"
+
+// AddMonths soma meses pelo calendário. Quando o dia não existe no mês de
+// destino fica o último dia dele, 31/01 mais um mês é 28/02 ou 29/02 e não
+// 02/03 como no time.AddDate
+func AddMonths(t time.Time, months int) time.Time {
+	return AddMonthsOn(t, months, t.Day())
+}
+
+// AddMonthsOn é o AddMonths que cai no dia day em vez do dia de t, é o que
+// mantém um vencimento no dia 31 depois de passar por fevereiro. day menor
+// que 1 usa o dia de t
+func AddMonthsOn(t time.Time, months, day int) time.Time {
+	year, month, _ := t.Date()
+	hour, minute, second := t.Clock()
+
+	if day < 1 {
+		day = t.Day()
+	}
+
+	first := time.Date(year, month+time.Month(months), 1, hour, minute, second, t.Nanosecond(), t.Location())
+	last := first.AddDate(0, 1, -1).Day()
+
+	return first.AddDate(0, 0, min(day, last)-1)
+}
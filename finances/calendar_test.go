@@ -0,0 +1,63 @@
+package finances
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAddMonths(t *testing.T) {
+	tests := []struct {
+		name   string
+		t      time.Time
+		months int
+		want   time.Time
+	}{
+		{"mesmo dia", date(2024, 1, 15), 1, date(2024, 2, 15)},
+		{"31/01 em ano bissexto", date(2024, 1, 31), 1, date(2024, 2, 29)},
+		{"31/01 em ano comum", date(2023, 1, 31), 1, date(2023, 2, 28)},
+		{"31 para mês de 30", date(2024, 3, 31), 1, date(2024, 4, 30)},
+		{"29/02 mais um ano", date(2024, 2, 29), 12, date(2025, 2, 28)},
+		{"virada do ano", date(2024, 11, 30), 3, date(2025, 2, 28)},
+		{"meses negativos", date(2024, 3, 31), -1, date(2024, 2, 29)},
+		{"negativo na virada do ano", date(2024, 1, 15), -2, date(2023, 11, 15)},
+		{"zero meses", date(2024, 1, 31), 0, date(2024, 1, 31)},
+		{"mantém a hora", time.Date(2024, 1, 31, 14, 30, 5, 0, time.UTC), 1, time.Date(2024, 2, 29, 14, 30, 5, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		if got := AddMonths(tt.t, tt.months); !got.Equal(tt.want) {
+			t.Errorf("%s: AddMonths(%v, %d) = %v, want %v", tt.name, tt.t, tt.months, got, tt.want)
+		}
+	}
+}
+
+func TestAddMonthsOn(t *testing.T) {
+	tests := []struct {
+		name   string
+		t      time.Time
+		months int
+		day    int
+		want   time.Time
+	}{
+		{"volta ao dia 31 depois de fevereiro", date(2024, 2, 29), 1, 31, date(2024, 3, 31)},
+		{"dia 30 depois de fevereiro", date(2023, 2, 28), 1, 30, date(2023, 3, 30)},
+		{"corta de novo no mês curto", date(2024, 3, 31), 1, 31, date(2024, 4, 30)},
+		{"trimestral a partir do corte", date(2024, 2, 29), 3, 31, date(2024, 5, 31)},
+		{"sem dia usa o de t", date(2024, 2, 29), 1, 0, date(2024, 3, 29)},
+	}
+
+	for _, tt := range tests {
+		if got := AddMonthsOn(tt.t, tt.months, tt.day); !got.Equal(tt.want) {
+			t.Errorf("%s: AddMonthsOn(%v, %d, %d) = %v, want %v", tt.name, tt.t, tt.months, tt.day, got, tt.want)
+		}
+	}
+
+	// Uma sequência de renovações mensais desde 31/01 não fica presa no 29
+	end := date(2024, 1, 31)
+	for _, want := range []time.Time{date(2024, 2, 29), date(2024, 3, 31), date(2024, 4, 30), date(2024, 5, 31)} {
+		end = AddMonthsOn(end, 1, 31)
+		if !end.Equal(want) {
+			t.Fatalf("renovação = %v, want %v", end, want)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package finances
+
+import (
+	"errors"
+	"net/http"
+	"prodata/api"
+	"strconv"
+	"time"
+)
+
+type Handler struct {
+	ledger *Ledger
+}
+
+func NewHandler(ledger *Ledger) *Handler {
+	return &Handler{ledger: ledger}
+}
+
+func (h *Handler) writeError(ctx *api.Context, err error) {
+	switch {
+	case errors.Is(err, ErrAccountNotFound):
+		ctx.Error(err.Error(), http.StatusNotFound)
+	default:
+		ctx.Logger.LogAndSendSystemMessage(err.Error())
+		ctx.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// period lê from e to (2006-01-02, os dois inclusos) da query. Sem eles vale
+// o mês atual
+func period(ctx *api.Context) (time.Time, time.Time, bool) {
+	query := ctx.Request.URL.Query()
+
+	now := time.Now()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)
+	to := from.AddDate(0, 1, 0)
+
+	if value := query.Get("from"); value != "" {
+		parsed, err := time.ParseInLocation(time.DateOnly, value, time.Local)
+		if err != nil {
+			return from, to, false
+		}
+		from = parsed
+	}
+
+	if value := query.Get("to"); value != "" {
+		parsed, err := time.ParseInLocation(time.DateOnly, value, time.Local)
+		if err != nil {
+			return from, to, false
+		}
+		to = parsed.AddDate(0, 0, 1)
+	}
+
+	return from, to, to.After(from)
+}
+
+// GET /admin/ledger/accounts
+func (h *Handler) ListAccounts(ctx *api.Context) {
+	ctx.Json(Accounts)
+}
+
+// GET /admin/ledger/trial-balance?from=2025-01-01&to=2025-01-31
+func (h *Handler) TrialBalance(ctx *api.Context) {
+	from, to, ok := period(ctx)
+	if !ok {
+		ctx.Error("from and to must be dates like 2006-01-02, from before to", http.StatusBadRequest)
+		return
+	}
+
+	trial, err := h.ledger.TrialBalance(ctx.Request.Context(), from, to)
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Json(trial)
+}
+
+// GET /admin/ledger/accounts/{code}/statement?from=&to=, o razão de cash é o
+// que se confere com os repasses do Mercado Pago
+func (h *Handler) Statement(ctx *api.Context) {
+	from, to, ok := period(ctx)
+	if !ok {
+		ctx.Error("from and to must be dates like 2006-01-02, from before to", http.StatusBadRequest)
+		return
+	}
+
+	statement, err := h.ledger.Statement(ctx.Request.Context(), AccountCode(ctx.Param("code")), from, to)
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Json(statement)
+}
+
+// GET /admin/ledger/journals?from=&to=&kind=&owner=<uuid>
+func (h *Handler) ListJournals(ctx *api.Context) {
+	from, to, ok := period(ctx)
+	if !ok {
+		ctx.Error("from and to must be dates like 2006-01-02, from before to", http.StatusBadRequest)
+		return
+	}
+
+	limit := min(ctx.QueryInt("limit", 50), 200)
+	page := ctx.QueryInt("page", 1)
+
+	journals, total, err := h.ledger.Journals(ctx.Request.Context(), JournalFilter{
+		From:    from,
+		To:      to,
+		Kind:    JournalKind(ctx.Request.URL.Query().Get("kind")),
+		OwnerId: ctx.Request.URL.Query().Get("owner"),
+		Limit:   limit,
+		Offset:  (page - 1) * limit,
+	})
+	if err != nil {
+		h.writeError(ctx, err)
+		return
+	}
+
+	ctx.Writer.Header().Set("X-Total-Count", strconv.Itoa(total))
+	ctx.Json(journals)
+}
+
+func (h *Handler) Register(admin *api.Group) {
+	admin.Get("/ledger/accounts", h.ListAccounts)
+	admin.Get("/ledger/accounts/{code}/statement", h.Statement)
+	admin.Get("/ledger/trial-balance", h.TrialBalance)
+	admin.Get("/ledger/journals", h.ListJournals)
+}
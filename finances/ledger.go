@@ -0,0 +1,284 @@
+package finances
+
+import (
+	"errors"
+	"prodata/money"
+	"time"
+)
+
+type AccountKind string
+
+const (
+	KindAsset     AccountKind = "asset"
+	KindLiability AccountKind = "liability"
+	KindRevenue   AccountKind = "revenue"
+	KindExpense   AccountKind = "expense"
+)
+
+type AccountCode string
+
+const (
+	// Faturas emitidas e ainda não pagas
+	AccountReceivables AccountCode = "receivables"
+	// Saldo no gateway, é o que o Mercado Pago repassa
+	AccountCash AccountCode = "cash"
+	// Saldo da carteira dos clientes, devido a eles
+	AccountCustomerCredit AccountCode = "customer_credit"
+	AccountTaxPayable     AccountCode = "tax_payable"
+	AccountRevenue        AccountCode = "revenue"
+	// Devoluções e créditos de downgrade, reduzem a receita
+	AccountRefunds     AccountCode = "refunds"
+	AccountChargebacks AccountCode = "chargebacks"
+	AccountFees        AccountCode = "fees"
+	// Ajustes manuais de saldo feitos por um admin
+	AccountAdjustments AccountCode = "adjustments"
+)
+
+type Account struct {
+	Code AccountCode `json:"code"`
+	Name string      `json:"name"`
+	Kind AccountKind `json:"kind"`
+}
+
+// Plano de contas, na ordem em que aparece no balancete
+var Accounts = []Account{
+	{AccountCash, "Saldo no gateway", KindAsset},
+	{AccountReceivables, "Contas a receber", KindAsset},
+	{AccountCustomerCredit, "Crédito de clientes", KindLiability},
+	{AccountTaxPayable, "Impostos a recolher", KindLiability},
+	{AccountRevenue, "Receita de serviços", KindRevenue},
+	{AccountRefunds, "Devoluções", KindExpense},
+	{AccountChargebacks, "Chargebacks", KindExpense},
+	{AccountFees, "Tarifas do gateway", KindExpense},
+	{AccountAdjustments, "Ajustes de saldo", KindExpense},
+}
+
+func FindAccount(code AccountCode) (Account, bool) {
+	for _, account := range Accounts {
+		if account.Code == code {
+			return account, true
+		}
+	}
+
+	return Account{}, false
+}
+
+// DebitNormal diz se o saldo da conta cresce com débitos
+func (a Account) DebitNormal() bool {
+	return a.Kind == KindAsset || a.Kind == KindExpense
+}
+
+type JournalKind string
+
+const (
+	JournalInvoiceIssued      JournalKind = "invoice_issued"
+	JournalInvoiceVoided      JournalKind = "invoice_voided"
+	JournalPayment            JournalKind = "payment"
+	JournalGatewayFee         JournalKind = "gateway_fee"
+	JournalRefund             JournalKind = "refund"
+	JournalChargeback         JournalKind = "chargeback"
+	JournalChargebackReversal JournalKind = "chargeback_reversal"
+	JournalCredit             JournalKind = "credit"
+)
+
+var (
+	ErrUnbalancedJournal = errors.New("journal debits and credits must be equal")
+	ErrInvalidJournal    = errors.New("journal lines need a known account and exactly one positive side")
+	ErrAccountNotFound   = errors.New("account not found")
+)
+
+type JournalLine struct {
+	Account AccountCode `json:"account"`
+	Debit   money.Money `json:"debit"`
+	Credit  money.Money `json:"credit"`
+}
+
+// Journal é um lançamento de partidas dobradas. Kind e Reference identificam
+// o fato que gerou o lançamento, o mesmo fato nunca é lançado duas vezes
+type Journal struct {
+	Id          int64         `json:"id"`
+	Kind        JournalKind   `json:"kind"`
+	Reference   string        `json:"reference"`
+	OwnerId     string        `json:"owner_id,omitempty"`
+	Description string        `json:"description"`
+	PostedAt    string        `json:"posted_at"`
+	Lines       []JournalLine `json:"lines"`
+}
+
+func (j *Journal) debit(account AccountCode, amount money.Money) {
+	if amount.IsPositive() {
+		j.Lines = append(j.Lines, JournalLine{Account: account, Debit: amount, Credit: money.New(0, amount.Currency())})
+	}
+}
+
+func (j *Journal) credit(account AccountCode, amount money.Money) {
+	if amount.IsPositive() {
+		j.Lines = append(j.Lines, JournalLine{Account: account, Debit: money.New(0, amount.Currency()), Credit: amount})
+	}
+}
+
+// Empty é o lançamento de um fato sem valor, que não precisa ser gravado
+func (j *Journal) Empty() bool {
+	return len(j.Lines) == 0
+}
+
+func (j *Journal) Validate() error {
+	if len(j.Lines) < 2 {
+		return ErrInvalidJournal
+	}
+
+	var debits, credits money.Money
+	for i, line := range j.Lines {
+		if _, ok := FindAccount(line.Account); !ok {
+			return ErrInvalidJournal
+		}
+
+		if line.Debit.IsNegative() || line.Credit.IsNegative() || line.Debit.IsPositive() == line.Credit.IsPositive() {
+			return ErrInvalidJournal
+		}
+
+		if i == 0 {
+			debits = money.New(0, line.Debit.Currency())
+			credits = money.New(0, line.Credit.Currency())
+		}
+
+		var err error
+		if debits, err = debits.Add(line.Debit); err != nil {
+			return err
+		}
+		if credits, err = credits.Add(line.Credit); err != nil {
+			return err
+		}
+	}
+
+	if debits.Compare(credits) != 0 {
+		return ErrUnbalancedJournal
+	}
+
+	return nil
+}
+
+func newJournal(kind JournalKind, reference, ownerId, description string) *Journal {
+	return &Journal{
+		Kind:        kind,
+		Reference:   reference,
+		OwnerId:     ownerId,
+		Description: description,
+		PostedAt:    time.Now().Format(time.DateTime),
+	}
+}
+
+// Regras de lançamento. Cada fato do faturamento vira um lançamento
+// balanceado, as regras só montam as partidas e quem chama grava na mesma
+// transação do fato
+
+// InvoiceIssued reconhece a receita da fatura emitida, o imposto vai para a
+// conta a recolher
+func InvoiceIssued(invoiceId, ownerId, number string, total, tax money.Money) (*Journal, error) {
+	j := newJournal(JournalInvoiceIssued, invoiceId, ownerId, "Emissão da fatura "+number)
+
+	revenue, err := total.Sub(tax)
+	if err != nil {
+		return nil, err
+	}
+
+	j.debit(AccountReceivables, total)
+	j.credit(AccountRevenue, revenue)
+	j.credit(AccountTaxPayable, tax)
+	return j, nil
+}
+
+// InvoiceVoided desfaz a parte ainda não paga da fatura cancelada
+func InvoiceVoided(invoiceId, ownerId, number string, unpaid money.Money) *Journal {
+	j := newJournal(JournalInvoiceVoided, invoiceId, ownerId, "Cancelamento da fatura "+number)
+	j.debit(AccountRevenue, unpaid)
+	j.credit(AccountReceivables, unpaid)
+	return j
+}
+
+// PaymentReceived baixa o recebível com o pagamento. Pago com o saldo da
+// carteira, o débito sai do crédito do cliente em vez do gateway. O que
+// passou da fatura volta como crédito do cliente
+func PaymentReceived(paymentId, ownerId, description string, fromCredit bool, applied, excess money.Money) (*Journal, error) {
+	j := newJournal(JournalPayment, paymentId, ownerId, description)
+
+	received, err := applied.Add(excess)
+	if err != nil {
+		return nil, err
+	}
+
+	if fromCredit {
+		j.debit(AccountCustomerCredit, received)
+	} else {
+		j.debit(AccountCash, received)
+	}
+	j.credit(AccountReceivables, applied)
+	j.credit(AccountCustomerCredit, excess)
+	return j, nil
+}
+
+// GatewayFee lança a tarifa que o gateway desconta do repasse
+func GatewayFee(paymentId, ownerId, provider string, fee money.Money) *Journal {
+	j := newJournal(JournalGatewayFee, paymentId, ownerId, "Tarifa do "+provider)
+	j.debit(AccountFees, fee)
+	j.credit(AccountCash, fee)
+	return j
+}
+
+// Refunded lança o estorno, pago pelo gateway ou devolvido como saldo.
+// fromCredit é a parte que devolve o valor pago a mais, que estava no
+// crédito do cliente e nunca foi receita
+func Refunded(refundId, ownerId, description string, toCredit bool, amount, fromCredit money.Money) (*Journal, error) {
+	j := newJournal(JournalRefund, refundId, ownerId, description)
+
+	applied, err := amount.Sub(fromCredit)
+	if err != nil {
+		return nil, err
+	}
+
+	j.debit(AccountRefunds, applied)
+	j.debit(AccountCustomerCredit, fromCredit)
+	if toCredit {
+		j.credit(AccountCustomerCredit, amount)
+	} else {
+		j.credit(AccountCash, amount)
+	}
+	return j, nil
+}
+
+// ChargedBack lança o valor que o gateway tirou com a contestação
+func ChargedBack(paymentId, ownerId, description string, amount money.Money) *Journal {
+	j := newJournal(JournalChargeback, paymentId, ownerId, description)
+	j.debit(AccountChargebacks, amount)
+	j.credit(AccountCash, amount)
+	return j
+}
+
+// ChargebackReversed devolve o valor da contestação ganha
+func ChargebackReversed(disputeId, ownerId, description string, amount money.Money) *Journal {
+	j := newJournal(JournalChargebackReversal, disputeId, ownerId, description)
+	j.debit(AccountCash, amount)
+	j.credit(AccountChargebacks, amount)
+	return j
+}
+
+// CreditGranted lança o saldo dado ao cliente fora de um pagamento ou
+// estorno. O downgrade e o pedido cancelado devolvem receita, o ajuste
+// manual é despesa e pode ser negativo, tirando saldo
+func CreditGranted(reference, ownerId, description string, downgrade bool, amount money.Money) *Journal {
+	j := newJournal(JournalCredit, reference, ownerId, description)
+
+	account := AccountAdjustments
+	if downgrade {
+		account = AccountRefunds
+	}
+
+	if amount.IsNegative() {
+		j.debit(AccountCustomerCredit, amount.Negate())
+		j.credit(account, amount.Negate())
+	} else {
+		j.debit(account, amount)
+		j.credit(AccountCustomerCredit, amount)
+	}
+	return j
+}
@@ -0,0 +1,345 @@
+package finances
+
+import (
+	"context"
+	"database/sql"
+	"prodata/database"
+	"prodata/money"
+	"strings"
+	"time"
+)
+
+// PostTx grava o lançamento na transação do fato que ele registra. Devolve
+// false quando o fato já tinha sido lançado ou não tem valor
+func PostTx(ctx context.Context, tx *sql.Tx, j *Journal) (bool, error) {
+	if j.Empty() {
+		return false, nil
+	}
+
+	if err := j.Validate(); err != nil {
+		return false, err
+	}
+
+	result, err := tx.ExecContext(ctx, "INSERT INTO journals (kind, reference, owner_uuid, description, posted_at) VALUES (?, ?, ?, ?, ?)",
+		j.Kind,
+		j.Reference,
+		nullable(j.OwnerId),
+		database.Truncate(j.Description, 255),
+		j.PostedAt)
+	if database.IsDuplicate(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	j.Id, err = result.LastInsertId()
+	if err != nil {
+		return false, err
+	}
+
+	for _, line := range j.Lines {
+		_, err := tx.ExecContext(ctx, "INSERT INTO journal_lines (journal_id, account, debit, credit) VALUES (?, ?, ?, ?)",
+			j.Id, line.Account, line.Debit, line.Credit)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func nullable(value string) any {
+	if value == "" {
+		return nil
+	}
+
+	return value
+}
+
+// Ledger lê o livro para os relatórios do contador
+type Ledger struct {
+	db *sql.DB
+}
+
+func NewLedger(db *sql.DB) *Ledger {
+	return &Ledger{db: db}
+}
+
+type JournalFilter struct {
+	From    time.Time
+	To      time.Time
+	Kind    JournalKind
+	OwnerId string
+	Limit   int
+	Offset  int
+}
+
+func (l *Ledger) Journals(ctx context.Context, filter JournalFilter) ([]Journal, int, error) {
+	where := []string{"posted_at >= ?", "posted_at < ?"}
+	args := []any{filter.From.Format(time.DateTime), filter.To.Format(time.DateTime)}
+
+	if filter.Kind != "" {
+		where = append(where, "kind = ?")
+		args = append(args, filter.Kind)
+	}
+
+	if filter.OwnerId != "" {
+		where = append(where, "owner_uuid = ?")
+		args = append(args, filter.OwnerId)
+	}
+
+	clause := strings.Join(where, " AND ")
+
+	var total int
+	err := l.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM journals WHERE "+clause, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := "SELECT id, kind, reference, owner_uuid, description, posted_at FROM journals WHERE " + clause + " ORDER BY posted_at, id"
+	if filter.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, filter.Limit, filter.Offset)
+	}
+
+	rows, err := l.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	journals := []Journal{}
+	index := map[int64]int{}
+	for rows.Next() {
+		var j Journal
+		var ownerId sql.NullString
+
+		if err := rows.Scan(&j.Id, &j.Kind, &j.Reference, &ownerId, &j.Description, &j.PostedAt); err != nil {
+			return nil, 0, err
+		}
+
+		j.OwnerId = ownerId.String
+		j.Lines = []JournalLine{}
+		index[j.Id] = len(journals)
+		journals = append(journals, j)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	if len(journals) == 0 {
+		return journals, total, nil
+	}
+
+	ids := make([]any, 0, len(journals))
+	for _, j := range journals {
+		ids = append(ids, j.Id)
+	}
+
+	lines, err := l.db.QueryContext(ctx, "SELECT journal_id, account, debit, credit FROM journal_lines WHERE journal_id IN (?"+strings.Repeat(", ?", len(ids)-1)+") ORDER BY id", ids...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer lines.Close()
+
+	for lines.Next() {
+		var journalId int64
+		var line JournalLine
+
+		if err := lines.Scan(&journalId, &line.Account, &line.Debit, &line.Credit); err != nil {
+			return nil, 0, err
+		}
+
+		j := &journals[index[journalId]]
+		j.Lines = append(j.Lines, line)
+	}
+
+	return journals, total, lines.Err()
+}
+
+type TrialBalanceLine struct {
+	Account
+	Opening money.Money `json:"opening"`
+	Debit   money.Money `json:"debit"`
+	Credit  money.Money `json:"credit"`
+	Closing money.Money `json:"closing"`
+}
+
+// TrialBalance mostra o balancete do período. Os saldos estão no lado normal
+// de cada conta e a soma dos débitos sempre fecha com a dos créditos
+type TrialBalance struct {
+	From        string             `json:"from"`
+	To          string             `json:"to"`
+	Accounts    []TrialBalanceLine `json:"accounts"`
+	TotalDebit  money.Money        `json:"total_debit"`
+	TotalCredit money.Money        `json:"total_credit"`
+}
+
+// balance devolve o saldo no lado normal da conta
+func (a Account) balance(debit, credit money.Money) (money.Money, error) {
+	if a.DebitNormal() {
+		return debit.Sub(credit)
+	}
+
+	return credit.Sub(debit)
+}
+
+func (l *Ledger) TrialBalance(ctx context.Context, from, to time.Time) (*TrialBalance, error) {
+	start := from.Format(time.DateTime)
+	end := to.Format(time.DateTime)
+
+	rows, err := l.db.QueryContext(ctx, `SELECT l.account,
+		COALESCE(SUM(CASE WHEN j.posted_at < ? THEN l.debit ELSE 0 END), 0),
+		COALESCE(SUM(CASE WHEN j.posted_at < ? THEN l.credit ELSE 0 END), 0),
+		COALESCE(SUM(CASE WHEN j.posted_at >= ? THEN l.debit ELSE 0 END), 0),
+		COALESCE(SUM(CASE WHEN j.posted_at >= ? THEN l.credit ELSE 0 END), 0)
+		FROM journal_lines l JOIN journals j ON j.id = l.journal_id
+		WHERE j.posted_at < ?
+		GROUP BY l.account`, start, start, start, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type sums struct{ openDebit, openCredit, debit, credit money.Money }
+	totals := map[AccountCode]sums{}
+
+	for rows.Next() {
+		var code AccountCode
+		var s sums
+
+		if err := rows.Scan(&code, &s.openDebit, &s.openCredit, &s.debit, &s.credit); err != nil {
+			return nil, err
+		}
+		totals[code] = s
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	trial := &TrialBalance{
+		From:     start,
+		To:       end,
+		Accounts: make([]TrialBalanceLine, 0, len(Accounts)),
+	}
+
+	for _, account := range Accounts {
+		s := totals[account.Code]
+
+		line := TrialBalanceLine{Account: account, Debit: s.debit, Credit: s.credit}
+
+		if line.Opening, err = account.balance(s.openDebit, s.openCredit); err != nil {
+			return nil, err
+		}
+
+		debit, err := s.openDebit.Add(s.debit)
+		if err != nil {
+			return nil, err
+		}
+
+		credit, err := s.openCredit.Add(s.credit)
+		if err != nil {
+			return nil, err
+		}
+
+		if line.Closing, err = account.balance(debit, credit); err != nil {
+			return nil, err
+		}
+
+		if trial.TotalDebit, err = trial.TotalDebit.Add(s.debit); err != nil {
+			return nil, err
+		}
+
+		if trial.TotalCredit, err = trial.TotalCredit.Add(s.credit); err != nil {
+			return nil, err
+		}
+
+		trial.Accounts = append(trial.Accounts, line)
+	}
+
+	return trial, nil
+}
+
+type StatementLine struct {
+	JournalId   int64       `json:"journal_id"`
+	Kind        JournalKind `json:"kind"`
+	Reference   string      `json:"reference"`
+	OwnerId     string      `json:"owner_id,omitempty"`
+	Description string      `json:"description"`
+	PostedAt    string      `json:"posted_at"`
+	Debit       money.Money `json:"debit"`
+	Credit      money.Money `json:"credit"`
+	Balance     money.Money `json:"balance"`
+}
+
+// Statement é o razão de uma conta no período, com o saldo depois de cada
+// lançamento
+type Statement struct {
+	Account Account         `json:"account"`
+	From    string          `json:"from"`
+	To      string          `json:"to"`
+	Opening money.Money     `json:"opening"`
+	Closing money.Money     `json:"closing"`
+	Lines   []StatementLine `json:"lines"`
+}
+
+func (l *Ledger) Statement(ctx context.Context, code AccountCode, from, to time.Time) (*Statement, error) {
+	account, ok := FindAccount(code)
+	if !ok {
+		return nil, ErrAccountNotFound
+	}
+
+	statement := &Statement{
+		Account: account,
+		From:    from.Format(time.DateTime),
+		To:      to.Format(time.DateTime),
+		Lines:   []StatementLine{},
+	}
+
+	var openDebit, openCredit money.Money
+	err := l.db.QueryRowContext(ctx, "SELECT COALESCE(SUM(l.debit), 0), COALESCE(SUM(l.credit), 0) FROM journal_lines l JOIN journals j ON j.id = l.journal_id WHERE l.account = ? AND j.posted_at < ?",
+		code, statement.From).Scan(&openDebit, &openCredit)
+	if err != nil {
+		return nil, err
+	}
+
+	if statement.Opening, err = account.balance(openDebit, openCredit); err != nil {
+		return nil, err
+	}
+
+	rows, err := l.db.QueryContext(ctx, "SELECT j.id, j.kind, j.reference, j.owner_uuid, j.description, j.posted_at, l.debit, l.credit FROM journal_lines l JOIN journals j ON j.id = l.journal_id WHERE l.account = ? AND j.posted_at >= ? AND j.posted_at < ? ORDER BY j.posted_at, j.id, l.id",
+		code, statement.From, statement.To)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	running := statement.Opening
+	for rows.Next() {
+		var line StatementLine
+		var ownerId sql.NullString
+
+		if err := rows.Scan(&line.JournalId, &line.Kind, &line.Reference, &ownerId, &line.Description, &line.PostedAt, &line.Debit, &line.Credit); err != nil {
+			return nil, err
+		}
+
+		change, err := account.balance(line.Debit, line.Credit)
+		if err != nil {
+			return nil, err
+		}
+
+		if running, err = running.Add(change); err != nil {
+			return nil, err
+		}
+
+		line.OwnerId = ownerId.String
+		line.Balance = running
+		statement.Lines = append(statement.Lines, line)
+	}
+
+	statement.Closing = running
+	return statement, rows.Err()
+}
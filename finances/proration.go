@@ -0,0 +1,168 @@
+package finances
+
+import (
+	"errors"
+	"fmt"
+	"prodata/money"
+	"time"
+)
+
+var (
+	ErrInvalidPeriod = errors.New("billing period must end after it starts")
+	ErrOutsidePeriod = errors.New("change date is outside the current billing period")
+)
+
+// Clock existe para que a proporcionalidade possa ser calculada numa data
+// fixa, em produção use SystemClock
+type Clock interface {
+	Now() time.Time
+}
+
+type ClockFunc func() time.Time
+
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+var SystemClock Clock = ClockFunc(time.Now)
+
+// Period é o ciclo que o cliente já pagou, de Start até o vencimento em End
+type Period struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+func (p Period) Valid() bool {
+	return p.End.After(p.Start)
+}
+
+type ProrationItem struct {
+	Description string      `json:"description"`
+	From        time.Time   `json:"from"`
+	To          time.Time   `json:"to"`
+	Amount      money.Money `json:"amount"`
+}
+
+// ProrationRequest descreve a troca de plano, os preços são do ciclo inteiro.
+// Com NewMonths o ciclo também muda: o período novo começa na troca e dura
+// esse tanto de meses do calendário, cobrado inteiro pelo NewPrice
+type ProrationRequest struct {
+	Period    Period
+	OldPlan   string
+	OldPrice  money.Money
+	NewPlan   string
+	NewPrice  money.Money
+	NewMonths int
+}
+
+// Proration é o resultado detalhado da troca. Charge é o que o cliente
+// paga agora e Credit o que volta como saldo, nunca os dois ao mesmo tempo
+type Proration struct {
+	Period Period `json:"period"`
+	// Só na troca de ciclo, o período que passa a valer
+	NewPeriod *Period         `json:"new_period,omitempty"`
+	ChangedAt time.Time       `json:"changed_at"`
+	Items     []ProrationItem `json:"items"`
+	Charge    money.Money     `json:"charge"`
+	Credit    money.Money     `json:"credit"`
+}
+
+func (p *Proration) IsUpgrade() bool {
+	return p.Charge.IsPositive()
+}
+
+func (p *Proration) IsDowngrade() bool {
+	return p.Credit.IsPositive()
+}
+
+type Prorator struct {
+	clock Clock
+}
+
+func NewProrator(clock Clock) *Prorator {
+	if clock == nil {
+		clock = SystemClock
+	}
+
+	return &Prorator{clock: clock}
+}
+
+func formatDate(t time.Time) string {
+	return t.Format("02/01/2006")
+}
+
+// Calculate devolve o crédito pelo tempo que sobrou do plano antigo e a
+// cobrança do plano novo pelo mesmo tempo, contados em segundos sobre o
+// período real do serviço. Na troca de ciclo o plano novo é cobrado pelo
+// período novo inteiro
+func (p *Prorator) Calculate(req ProrationRequest) (*Proration, error) {
+	if !req.Period.Valid() {
+		return nil, ErrInvalidPeriod
+	}
+
+	if !req.OldPrice.SameCurrency(req.NewPrice) {
+		return nil, money.ErrCurrencyMismatch
+	}
+
+	now := p.clock.Now().Truncate(time.Second)
+	if now.Before(req.Period.Start) || !now.Before(req.Period.End) {
+		return nil, ErrOutsidePeriod
+	}
+
+	total := int64(req.Period.End.Sub(req.Period.Start) / time.Second)
+	remaining := int64(req.Period.End.Sub(now) / time.Second)
+
+	unused, err := req.OldPrice.MulDiv(remaining, total)
+	if err != nil {
+		return nil, err
+	}
+
+	charge, err := req.NewPrice.MulDiv(remaining, total)
+	if err != nil {
+		return nil, err
+	}
+
+	end := req.Period.End
+	var newPeriod *Period
+	if req.NewMonths > 0 {
+		end = AddMonths(now, req.NewMonths)
+		newPeriod = &Period{Start: now, End: end}
+		charge = req.NewPrice
+	}
+
+	proration := &Proration{
+		Period:    req.Period,
+		NewPeriod: newPeriod,
+		ChangedAt: now,
+		Items: []ProrationItem{
+			{
+				Description: fmt.Sprintf("Crédito pelo tempo não usado de %s (%s a %s)", req.OldPlan, formatDate(now), formatDate(req.Period.End)),
+				From:        now,
+				To:          req.Period.End,
+				Amount:      unused.Negate(),
+			},
+			{
+				Description: fmt.Sprintf("%s (%s a %s)", req.NewPlan, formatDate(now), formatDate(end)),
+				From:        now,
+				To:          end,
+				Amount:      charge,
+			},
+		},
+		Charge: money.New(0, req.NewPrice.Currency()),
+		Credit: money.New(0, req.NewPrice.Currency()),
+	}
+
+	difference, err := charge.Sub(unused)
+	if err != nil {
+		return nil, err
+	}
+
+	// Downgrade nunca vira preço negativo, a diferença vira crédito na conta
+	if difference.IsNegative() {
+		proration.Credit = difference.Negate()
+	} else {
+		proration.Charge = difference
+	}
+
+	return proration, nil
+}
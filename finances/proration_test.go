@@ -0,0 +1,152 @@
+package finances
+
+import (
+	"errors"
+	"prodata/money"
+	"testing"
+	"time"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func fixedClock(t time.Time) Clock {
+	return ClockFunc(func() time.Time { return t })
+}
+
+func TestCalculate(t *testing.T) {
+	january := Period{Start: date(2024, 1, 1), End: date(2024, 1, 31)}
+	february := Period{Start: date(2024, 2, 1), End: date(2024, 3, 1)}
+
+	tests := []struct {
+		name     string
+		now      time.Time
+		period   Period
+		oldPrice string
+		newPrice string
+		charge   string
+		credit   string
+		unused   string
+	}{
+		{"upgrade no meio do ciclo", date(2024, 1, 16), january, "30.00", "60.00", "15.00", "0.00", "-15.00"},
+		{"downgrade vira crédito", date(2024, 1, 16), january, "60.00", "30.00", "0.00", "15.00", "-30.00"},
+		{"mesmo preço", date(2024, 1, 16), january, "30.00", "30.00", "0.00", "0.00", "-15.00"},
+		{"no primeiro segundo", january.Start, january, "30.00", "60.00", "30.00", "0.00", "-30.00"},
+		{"arredonda cada parte", date(2024, 2, 11), february, "99.90", "149.90", "32.76", "0.00", "-65.45"},
+		{"plano grátis", date(2024, 1, 16), january, "0.00", "60.00", "30.00", "0.00", "0.00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewProrator(fixedClock(tt.now)).Calculate(ProrationRequest{
+				Period:   tt.period,
+				OldPlan:  "Antigo",
+				OldPrice: money.MustParse(tt.oldPrice),
+				NewPlan:  "Novo",
+				NewPrice: money.MustParse(tt.newPrice),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if p.Charge.Decimal() != tt.charge || p.Credit.Decimal() != tt.credit {
+				t.Errorf("charge = %s, credit = %s, want %s and %s", p.Charge.Decimal(), p.Credit.Decimal(), tt.charge, tt.credit)
+			}
+
+			if p.IsUpgrade() && p.IsDowngrade() {
+				t.Error("charge e credit ao mesmo tempo")
+			}
+
+			if len(p.Items) != 2 || p.Items[0].Amount.Decimal() != tt.unused {
+				t.Fatalf("items = %+v, want crédito de %s", p.Items, tt.unused)
+			}
+
+			if !p.Items[1].To.Equal(tt.period.End) || p.NewPeriod != nil {
+				t.Errorf("sem troca de ciclo o item vai até %v, got %v", tt.period.End, p.Items[1].To)
+			}
+		})
+	}
+}
+
+func TestCalculateCycleChange(t *testing.T) {
+	january := Period{Start: date(2024, 1, 1), End: date(2024, 1, 31)}
+	thirtyOne := Period{Start: date(2024, 1, 1), End: date(2024, 2, 1)}
+
+	tests := []struct {
+		name     string
+		now      time.Time
+		period   Period
+		oldPrice string
+		newPrice string
+		months   int
+		end      time.Time
+		charge   string
+		credit   string
+	}{
+		{"mensal para trimestral", date(2024, 1, 16), january, "30.00", "80.00", 3, date(2024, 4, 16), "65.00", "0.00"},
+		{"mensal para anual vira crédito", date(2024, 1, 16), january, "300.00", "100.00", 12, date(2025, 1, 16), "0.00", "50.00"},
+		{"período novo cortado no fim do mês", date(2024, 1, 31), thirtyOne, "31.00", "60.00", 1, date(2024, 2, 29), "59.00", "0.00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewProrator(fixedClock(tt.now)).Calculate(ProrationRequest{
+				Period:    tt.period,
+				OldPlan:   "Antigo",
+				OldPrice:  money.MustParse(tt.oldPrice),
+				NewPlan:   "Novo",
+				NewPrice:  money.MustParse(tt.newPrice),
+				NewMonths: tt.months,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if p.NewPeriod == nil || !p.NewPeriod.Start.Equal(tt.now) || !p.NewPeriod.End.Equal(tt.end) {
+				t.Fatalf("new period = %+v, want %v a %v", p.NewPeriod, tt.now, tt.end)
+			}
+
+			if p.Charge.Decimal() != tt.charge || p.Credit.Decimal() != tt.credit {
+				t.Errorf("charge = %s, credit = %s, want %s and %s", p.Charge.Decimal(), p.Credit.Decimal(), tt.charge, tt.credit)
+			}
+
+			// O plano novo é cobrado pelo período novo inteiro
+			if len(p.Items) != 2 || p.Items[1].Amount.Decimal() != tt.newPrice || !p.Items[1].To.Equal(tt.end) {
+				t.Errorf("items = %+v, want %s até %v", p.Items, tt.newPrice, tt.end)
+			}
+		})
+	}
+}
+
+func TestCalculateErrors(t *testing.T) {
+	january := Period{Start: date(2024, 1, 1), End: date(2024, 1, 31)}
+
+	tests := []struct {
+		name     string
+		now      time.Time
+		period   Period
+		newPrice money.Money
+		err      error
+	}{
+		{"antes do período", date(2023, 12, 31), january, money.MustParse("10"), ErrOutsidePeriod},
+		{"no vencimento", january.End, january, money.MustParse("10"), ErrOutsidePeriod},
+		{"depois do período", date(2024, 2, 5), january, money.MustParse("10"), ErrOutsidePeriod},
+		{"período invertido", date(2024, 1, 16), Period{Start: january.End, End: january.Start}, money.MustParse("10"), ErrInvalidPeriod},
+		{"período vazio", date(2024, 1, 16), Period{Start: january.Start, End: january.Start}, money.MustParse("10"), ErrInvalidPeriod},
+		{"moedas diferentes", date(2024, 1, 16), january, money.New(1000, "USD"), money.ErrCurrencyMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewProrator(fixedClock(tt.now)).Calculate(ProrationRequest{
+				Period:   tt.period,
+				OldPrice: money.MustParse("10"),
+				NewPrice: tt.newPrice,
+			})
+			if !errors.Is(err, tt.err) {
+				t.Errorf("err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
@@ -1,16 +1,22 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"os"
 	"prodata/api"
+	"prodata/bank"
 	"prodata/bank/tx"
+	"prodata/billing"
+	"prodata/catalog"
+	"prodata/database"
 	"prodata/database/account"
+	"prodata/finances"
 	"prodata/logs"
 	"prodata/user"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 )
 
@@ -21,30 +27,113 @@ func main() {
 		panic(err)
 	}
 
-	account.AddServices("01fd92c3-a8cc-4664-9851-4914a5b49842", &account.Services{
-		Id:     uuid.New().String(),
-		Name:   "Minecraft Premium 48GB",
-		Price:  float64(480),
-		Status: "Ativo",
-		Date:   time.Now().Add(30 * time.Hour * 24).Format(time.DateTime),
-		Type:   "Hospedagem de Jogos",
-	})
-
-	api.Post("/account/login", user.HandlerLogin)
-	api.Post("/account/register", user.HandlerRegister)
-	api.Post("/account/verify/", user.HandlerMagicLink)
-	api.Post("/account/auth/generate", user.HandlerNewMagicLink)
-	api.Post("/account/query-password", user.HandlerMakePasswordResetPage)
-	api.Post("/account/reset-password/", user.HandlerChangePasswordReset)
-	api.Get("/dashboard/navbar", account.Authenticate(user.UserNav))
-	api.Get("/dashboard/recent-services", account.Authenticate(user.RecentServices))
-	api.Post("/information/error", user.HandlerErrors)
-	api.Post("/transaction/hook", tx.WebHookHandler)
-	api.Post("/account/auth", account.AuthenticateAdmin(func(ctx *api.Context) {
+	db, err := database.Open(database.ConfigFromEnv())
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(db, os.Args[2:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if os.Getenv("DB_AUTO_MIGRATE") == "true" {
+		if err := runMigrate(db, []string{"up"}); err != nil {
+			panic(err)
+		}
+	}
+
+	account.Init(db)
+
+	gateway, err := bank.GatewayFromEnv()
+	if err != nil {
+		panic(err)
+	}
+
+	router := api.NewRouter()
+	router.Use(api.Logging, api.Recovery, api.CORS("*"))
+
+	accountGroup := router.Group("/account", api.RateLimit(20, time.Minute))
+	accountGroup.Post("/login", user.HandlerLogin)
+	accountGroup.Post("/register", user.HandlerRegister)
+	accountGroup.Post("/verify/{token}", user.HandlerMagicLink)
+	accountGroup.Post("/auth/generate", user.HandlerNewMagicLink)
+	accountGroup.Post("/query-password", user.HandlerMakePasswordResetPage)
+	accountGroup.Post("/reset-password/{token}", user.HandlerChangePasswordReset)
+	accountGroup.Post("/auth", account.AuthenticateAdmin(func(ctx *api.Context) {
 		fmt.Fprintln(ctx.Writer, "Acesso concedido, Administrador")
 	}))
 
-	err = http.ListenAndServe(":8080", nil)
+	dashboard := router.Group("/dashboard", account.Authenticate)
+	dashboard.Get("/navbar", user.UserNav)
+	dashboard.Get("/recent-services", user.RecentServices)
+
+	admin := router.Group("/admin", account.AuthenticateAdmin)
+	admin.Post("/services/{id}/{action}", user.HandlerServiceTransition)
+	admin.Get("/services/{id}/history", user.HandlerServiceHistory)
+
+	catalogRepo := catalog.NewRepository(db)
+	catalog.NewHandler(catalogRepo, account.ServicesRepository()).Register(router, admin)
+
+	invoices := billing.NewInvoiceRepository(db)
+	billingGroup := router.Group("/billing", account.Authenticate)
+	billing.NewHandler(invoices, account.ServicesRepository()).Register(billingGroup, admin)
+
+	payments := billing.NewPaymentRepository(db)
+	dunningRepo := billing.NewDunningRepository(db)
+	walletRepo := billing.NewWalletRepository(db)
+	billing.NewWalletHandler(walletRepo).Register(dashboard, admin)
+	refunder := billing.NewRefunder(db, invoices, payments, billing.NewRefundRepository(db), account.Lifecycle(), walletRepo, gateway)
+	billing.NewRefundHandler(refunder).Register(admin)
+	disputes := billing.NewDisputes(db, billing.NewDisputeRepository(db), invoices, dunningRepo, account.Lifecycle(), account.Users(), billing.DisputeConfigFromEnv())
+	billing.NewDisputeHandler(disputes).Register(admin)
+	reconciler := billing.NewReconciler(db, invoices, payments, account.ServicesRepository(), account.Lifecycle(), account.Users(), dunningRepo, refunder, disputes, walletRepo, gateway)
+	paymentMethods := billing.NewPaymentMethodRepository(db)
+	checkout := billing.NewCheckout(db, invoices, payments, paymentMethods, account.Users(), walletRepo, gateway, reconciler, billing.CheckoutConfigFromEnv())
+	billing.NewCheckoutHandler(checkout).Register(billingGroup, dashboard)
+
+	coupons := billing.NewCoupons(db, billing.NewCouponRepository(db), invoices, account.ServicesRepository())
+	billing.NewCouponHandler(coupons).Register(billingGroup, admin)
+	renewals := billing.NewRenewalScheduler(db, invoices, coupons, billing.NewRenewalRepository(db), account.ServicesRepository(), paymentMethods, account.Users(), checkout, finances.SystemClock, billing.RenewalConfigFromEnv())
+	go renewals.Run(context.Background())
+
+	ordersGroup := router.Group("/orders", account.Authenticate)
+	orders := billing.NewOrders(db, billing.NewOrderRepository(db), invoices, payments, coupons, catalogRepo, account.ServicesRepository(), account.Lifecycle(), walletRepo, gateway, reconciler, finances.SystemClock, billing.OrderConfigFromEnv())
+	billing.NewOrderHandler(orders).Register(ordersGroup, admin)
+	go orders.Run(context.Background())
+
+	dunning := billing.NewDunning(db, invoices, dunningRepo, account.ServicesRepository(), account.Lifecycle(), account.Users(), finances.SystemClock, billing.DunningConfigFromEnv())
+	billing.NewDunningHandler(dunning).Register(admin)
+	go dunning.Run(context.Background())
+
+	servicesGroup := router.Group("/services", account.Authenticate)
+	planChanger := billing.NewPlanChanger(db, invoices, account.ServicesRepository(), catalogRepo, finances.NewProrator(finances.SystemClock), walletRepo)
+	billing.NewPlanChangeHandler(planChanger).Register(servicesGroup)
+	finances.NewHandler(finances.NewLedger(db)).Register(admin)
+
+	router.Post("/information/error", user.HandlerErrors)
+	webhookSecret := os.Getenv("MP_WEBHOOK_SECRET")
+	webhook := tx.NewWebhookReceiver(tx.NewEventRepository(db), webhookSecret)
+	webhook.Handle("payment", reconciler.HandlePaymentEvent)
+	router.Post("/transaction/hook", webhook.WebHookHandler)
+
+	// Com o gateway fake as mudanças de status voltam pelo mesmo webhook,
+	// assinadas com o mesmo segredo, sem sair da máquina
+	if fake, ok := gateway.(*bank.FakeGateway); ok {
+		fake.OnChange(func(ctx context.Context, p bank.Payment) {
+			status, err := tx.Deliver(router, "/transaction/hook", webhookSecret, p.Id)
+			if err != nil || status != http.StatusOK {
+				logs.NewSistemLogger().LogAndSendSystemMessage(fmt.Sprintf("fake gateway webhook %s: status %d, %v", p.Id, status, err))
+			}
+		})
+		admin.Post("/fake-gateway/payments/{id}/{status}", fake.HandleSetStatus)
+	}
+
+	err = http.ListenAndServe(":8080", router)
 	if err != nil {
 		logs.NewSistemLogger().LogAndSendSystemMessage(err.Error())
 		return
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"prodata/database"
+	"strconv"
+)
+
+// Uso: ./prodata migrate up | down [quantidade] | status
+func runMigrate(db *sql.DB, args []string) error {
+	migrator, err := database.NewMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "up":
+		done, err := migrator.Up(ctx)
+		for _, migration := range done {
+			fmt.Printf("applied %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Println("nothing to migrate")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("invalid number of steps: %s", args[1])
+			}
+		}
+
+		done, err := migrator.Down(ctx, steps)
+		for _, migration := range done {
+			fmt.Printf("reverted %04d_%s\n", migration.Version, migration.Name)
+		}
+		return err
+	case "status":
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, s := range status {
+			appliedAt := "pending"
+			if s.Applied {
+				appliedAt = s.AppliedAt
+			}
+			fmt.Printf("%04d_%-40s %s\n", s.Version, s.Name, appliedAt)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q, use up, down or status", command)
+	}
+}
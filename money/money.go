@@ -0,0 +1,382 @@
+package money
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+type Currency string
+
+const BRL Currency = "BRL"
+
+var symbols = map[Currency]string{
+	BRL:   "R$",
+	"USD": "US$",
+	"EUR": "€",
+}
+
+var (
+	ErrCurrencyMismatch = errors.New("money: currency mismatch")
+	ErrOverflow         = errors.New("money: amount overflow")
+	ErrInvalidAmount    = errors.New("money: invalid amount")
+)
+
+// Money guarda o valor em centavos inteiros, assim somas e divisões nunca
+// perdem centavos como acontecia com float64. O valor zero é R$ 0,00
+type Money struct {
+	cents    int64
+	currency Currency
+}
+
+func New(cents int64, currency Currency) Money {
+	return Money{cents: cents, currency: currency}
+}
+
+func FromCents(cents int64) Money {
+	return New(cents, BRL)
+}
+
+// Parse lê valores decimais como "1234.56", "-10" ou "0.5", com no máximo
+// duas casas depois do ponto
+func Parse(value string, currency Currency) (Money, error) {
+	value = strings.TrimSpace(value)
+
+	negative := false
+	if strings.HasPrefix(value, "-") {
+		negative = true
+		value = value[1:]
+	} else if strings.HasPrefix(value, "+") {
+		value = value[1:]
+	}
+
+	whole, fraction, _ := strings.Cut(value, ".")
+	if whole == "" && fraction == "" {
+		return Money{}, ErrInvalidAmount
+	}
+
+	// Zeros a mais no fim vêm do DECIMAL do banco ou de JSON como 10.500
+	fraction = strings.TrimRight(fraction, "0")
+	if len(fraction) > 2 {
+		return Money{}, fmt.Errorf("%w: %q has more than two decimal places", ErrInvalidAmount, value)
+	}
+	fraction += strings.Repeat("0", 2-len(fraction))
+
+	if whole == "" {
+		whole = "0"
+	}
+
+	for _, digits := range []string{whole, fraction} {
+		for _, r := range digits {
+			if r < '0' || r > '9' {
+				return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, value)
+			}
+		}
+	}
+
+	cents, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return Money{}, ErrOverflow
+	}
+
+	if negative {
+		cents = -cents
+	}
+
+	return New(cents, currency), nil
+}
+
+// MustParse é para valores fixos no código, entra em pânico se for inválido
+func MustParse(value string) Money {
+	m, err := Parse(value, BRL)
+	if err != nil {
+		panic(err)
+	}
+
+	return m
+}
+
+func (m Money) Cents() int64 {
+	return m.cents
+}
+
+func (m Money) Currency() Currency {
+	if m.currency == "" {
+		return BRL
+	}
+
+	return m.currency
+}
+
+func (m Money) SameCurrency(other Money) bool {
+	return m.Currency() == other.Currency()
+}
+
+func (m Money) IsZero() bool {
+	return m.cents == 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.cents < 0
+}
+
+func (m Money) IsPositive() bool {
+	return m.cents > 0
+}
+
+// Compare devolve -1, 0 ou 1, as duas quantias devem ter a mesma moeda
+func (m Money) Compare(other Money) int {
+	switch {
+	case m.cents < other.cents:
+		return -1
+	case m.cents > other.cents:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (m Money) Negate() Money {
+	return New(-m.cents, m.currency)
+}
+
+func (m Money) Abs() Money {
+	if m.cents < 0 {
+		return m.Negate()
+	}
+
+	return m
+}
+
+func (m Money) Add(other Money) (Money, error) {
+	if !m.SameCurrency(other) {
+		return Money{}, ErrCurrencyMismatch
+	}
+
+	if (other.cents > 0 && m.cents > math.MaxInt64-other.cents) || (other.cents < 0 && m.cents < math.MinInt64-other.cents) {
+		return Money{}, ErrOverflow
+	}
+
+	return New(m.cents+other.cents, m.Currency()), nil
+}
+
+func (m Money) Sub(other Money) (Money, error) {
+	if other.cents == math.MinInt64 {
+		return Money{}, ErrOverflow
+	}
+
+	return m.Add(other.Negate())
+}
+
+func (m Money) Multiply(factor int64) (Money, error) {
+	return m.MulDiv(factor, 1)
+}
+
+// MulDiv calcula m * num / den arredondando a metade para longe do zero,
+// é o que a proporcionalidade usa para cobrar só os dias consumidos
+func (m Money) MulDiv(num, den int64) (Money, error) {
+	if den == 0 {
+		return Money{}, ErrInvalidAmount
+	}
+
+	product := new(big.Int).Mul(big.NewInt(m.cents), big.NewInt(num))
+	divisor := big.NewInt(den)
+
+	quotient, remainder := new(big.Int).QuoRem(product, divisor, new(big.Int))
+
+	// |resto| * 2 >= |divisor| arredonda para longe do zero
+	twice := new(big.Int).Lsh(new(big.Int).Abs(remainder), 1)
+	if twice.Cmp(new(big.Int).Abs(divisor)) >= 0 {
+		if product.Sign()*divisor.Sign() < 0 {
+			quotient.Sub(quotient, big.NewInt(1))
+		} else {
+			quotient.Add(quotient, big.NewInt(1))
+		}
+	}
+
+	if !quotient.IsInt64() {
+		return Money{}, ErrOverflow
+	}
+
+	return New(quotient.Int64(), m.Currency()), nil
+}
+
+// Allocate divide o valor pelos pesos sem perder nenhum centavo, a sobra da
+// divisão vai um centavo por vez para as primeiras partes
+func (m Money) Allocate(ratios ...int64) ([]Money, error) {
+	if len(ratios) == 0 {
+		return nil, ErrInvalidAmount
+	}
+
+	var total int64
+	for _, ratio := range ratios {
+		if ratio < 0 {
+			return nil, ErrInvalidAmount
+		}
+		total += ratio
+	}
+
+	if total <= 0 {
+		return nil, ErrInvalidAmount
+	}
+
+	parts := make([]Money, len(ratios))
+	remainder := m.cents
+
+	for i, ratio := range ratios {
+		share := new(big.Int).Mul(big.NewInt(m.cents), big.NewInt(ratio))
+		share.Quo(share, big.NewInt(total))
+
+		parts[i] = New(share.Int64(), m.Currency())
+		remainder -= share.Int64()
+	}
+
+	step := int64(1)
+	if remainder < 0 {
+		step = -1
+	}
+
+	for i := 0; remainder != 0; i = (i + 1) % len(parts) {
+		if ratios[i] == 0 {
+			continue
+		}
+
+		parts[i].cents += step
+		remainder -= step
+	}
+
+	return parts, nil
+}
+
+// Sum soma todas as quantias, a moeda vem da primeira
+func Sum(values ...Money) (Money, error) {
+	var total Money
+	if len(values) > 0 {
+		total = New(0, values[0].Currency())
+	}
+
+	for _, value := range values {
+		var err error
+		if total, err = total.Add(value); err != nil {
+			return Money{}, err
+		}
+	}
+
+	return total, nil
+}
+
+// Decimal devolve o valor no formato que o banco e o JSON usam, "1234.56"
+func (m Money) Decimal() string {
+	cents := m.cents
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+	}
+
+	abs := new(big.Int).Abs(big.NewInt(cents)).String()
+	for len(abs) < 3 {
+		abs = "0" + abs
+	}
+
+	return sign + abs[:len(abs)-2] + "." + abs[len(abs)-2:]
+}
+
+// Float64 só existe para o SDK do Mercado Pago, que recebe o valor como
+// float, não use para fazer contas
+func (m Money) Float64() float64 {
+	value, _ := strconv.ParseFloat(m.Decimal(), 64)
+	return value
+}
+
+// String formata no padrão brasileiro, "R$ 1.234,56"
+func (m Money) String() string {
+	decimal := m.Decimal()
+
+	sign := ""
+	if strings.HasPrefix(decimal, "-") {
+		sign = "-"
+		decimal = decimal[1:]
+	}
+
+	whole, fraction, _ := strings.Cut(decimal, ".")
+
+	var grouped strings.Builder
+	for i, r := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			grouped.WriteByte('.')
+		}
+		grouped.WriteRune(r)
+	}
+
+	symbol, ok := symbols[m.Currency()]
+	if !ok {
+		symbol = string(m.Currency())
+	}
+
+	return sign + symbol + " " + grouped.String() + "," + fraction
+}
+
+// No JSON o valor sai como número decimal exato, 1234.56, e na entrada
+// aceita tanto número quanto string
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.Decimal()), nil
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	value := strings.Trim(string(data), `"`)
+	if value == "null" {
+		*m = Money{}
+		return nil
+	}
+
+	parsed, err := Parse(value, m.Currency())
+	if err != nil {
+		return err
+	}
+
+	*m = parsed
+	return nil
+}
+
+// No banco os valores ficam em colunas DECIMAL(12, 2)
+func (m Money) Value() (driver.Value, error) {
+	return m.Decimal(), nil
+}
+
+func (m *Money) Scan(src any) error {
+	var value string
+
+	switch v := src.(type) {
+	case nil:
+		*m = New(0, m.Currency())
+		return nil
+	case []byte:
+		value = string(v)
+	case string:
+		value = v
+	case int64:
+		value = strconv.FormatInt(v, 10)
+	case float64:
+		value = strconv.FormatFloat(v, 'f', 2, 64)
+	default:
+		return fmt.Errorf("money: cannot scan %T", src)
+	}
+
+	parsed, err := Parse(value, m.Currency())
+	if err != nil {
+		return err
+	}
+
+	*m = parsed
+	return nil
+}
+
+// FromFloat converte os valores que chegam do SDK do Mercado Pago,
+// arredondando para o centavo mais próximo
+func FromFloat(value float64, currency Currency) (Money, error) {
+	return Parse(strconv.FormatFloat(value, 'f', 2, 64), currency)
+}
@@ -0,0 +1,281 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		value string
+		cents int64
+		err   error
+	}{
+		{"1234.56", 123456, nil},
+		{"-10", -1000, nil},
+		{"+0.5", 50, nil},
+		{".5", 50, nil},
+		{"10.500", 1050, nil},
+		{" 7.1 ", 710, nil},
+		{"0.001", 0, ErrInvalidAmount},
+		{"1,50", 0, ErrInvalidAmount},
+		{"", 0, ErrInvalidAmount},
+		{".", 0, ErrInvalidAmount},
+		{"abc", 0, ErrInvalidAmount},
+		{"92233720368547758.08", 0, ErrOverflow},
+	}
+
+	for _, tt := range tests {
+		m, err := Parse(tt.value, BRL)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Parse(%q) err = %v, want %v", tt.value, err, tt.err)
+			continue
+		}
+		if err == nil && m.Cents() != tt.cents {
+			t.Errorf("Parse(%q) = %d, want %d", tt.value, m.Cents(), tt.cents)
+		}
+	}
+}
+
+func TestAddSub(t *testing.T) {
+	tests := []struct {
+		name   string
+		a, b   Money
+		add    int64
+		sub    int64
+		addErr error
+		subErr error
+	}{
+		{"simples", FromCents(150), FromCents(75), 225, 75, nil, nil},
+		{"negativos", FromCents(-150), FromCents(-75), -225, -75, nil, nil},
+		{"estouro na soma", FromCents(math.MaxInt64), FromCents(1), 0, math.MaxInt64 - 1, ErrOverflow, nil},
+		{"estouro na subtração", FromCents(math.MinInt64), FromCents(1), math.MinInt64 + 1, 0, nil, ErrOverflow},
+		{"subtrair o mínimo", FromCents(0), FromCents(math.MinInt64), math.MinInt64, 0, nil, ErrOverflow},
+		{"moedas diferentes", FromCents(1), New(1, "USD"), 0, 0, ErrCurrencyMismatch, ErrCurrencyMismatch},
+		{"zero vale como BRL", Money{}, FromCents(10), 10, -10, nil, nil},
+	}
+
+	for _, tt := range tests {
+		sum, err := tt.a.Add(tt.b)
+		if !errors.Is(err, tt.addErr) {
+			t.Errorf("%s: Add err = %v, want %v", tt.name, err, tt.addErr)
+		} else if err == nil && sum.Cents() != tt.add {
+			t.Errorf("%s: Add = %d, want %d", tt.name, sum.Cents(), tt.add)
+		}
+
+		diff, err := tt.a.Sub(tt.b)
+		if !errors.Is(err, tt.subErr) {
+			t.Errorf("%s: Sub err = %v, want %v", tt.name, err, tt.subErr)
+		} else if err == nil && diff.Cents() != tt.sub {
+			t.Errorf("%s: Sub = %d, want %d", tt.name, diff.Cents(), tt.sub)
+		}
+	}
+}
+
+func TestMulDiv(t *testing.T) {
+	tests := []struct {
+		cents    int64
+		num, den int64
+		want     int64
+		err      error
+	}{
+		{1000, 1, 3, 333, nil},
+		{1000, 2, 3, 667, nil},
+		{5, 1, 2, 3, nil},
+		{-5, 1, 2, -3, nil},
+		{5, -1, 2, -3, nil},
+		{5, 1, -2, -3, nil},
+		{-5, -1, 2, 3, nil},
+		{7, 1, 4, 2, nil},
+		{9999, 30, 31, 9676, nil},
+		{math.MaxInt64, 2, 2, math.MaxInt64, nil},
+		{math.MaxInt64, 2, 1, 0, ErrOverflow},
+		{100, 1, 0, 0, ErrInvalidAmount},
+	}
+
+	for _, tt := range tests {
+		got, err := FromCents(tt.cents).MulDiv(tt.num, tt.den)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("MulDiv(%d, %d, %d) err = %v, want %v", tt.cents, tt.num, tt.den, err, tt.err)
+			continue
+		}
+		if err == nil && got.Cents() != tt.want {
+			t.Errorf("MulDiv(%d, %d, %d) = %d, want %d", tt.cents, tt.num, tt.den, got.Cents(), tt.want)
+		}
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		cents  int64
+		ratios []int64
+		want   []int64
+	}{
+		{100, []int64{1, 1, 1}, []int64{34, 33, 33}},
+		{-100, []int64{1, 1, 1}, []int64{-34, -33, -33}},
+		{5, []int64{0, 1, 1}, []int64{0, 3, 2}},
+		{1000, []int64{70, 30}, []int64{700, 300}},
+	}
+
+	for _, tt := range tests {
+		parts, err := FromCents(tt.cents).Allocate(tt.ratios...)
+		if err != nil {
+			t.Fatalf("Allocate(%d, %v): %v", tt.cents, tt.ratios, err)
+		}
+		for i, part := range parts {
+			if part.Cents() != tt.want[i] {
+				t.Errorf("Allocate(%d, %v)[%d] = %d, want %d", tt.cents, tt.ratios, i, part.Cents(), tt.want[i])
+			}
+		}
+	}
+
+	if _, err := FromCents(100).Allocate(0, 0); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("Allocate sem pesos err = %v, want %v", err, ErrInvalidAmount)
+	}
+}
+
+func TestFromFloat(t *testing.T) {
+	tests := []struct {
+		value float64
+		cents int64
+		err   error
+	}{
+		{10, 1000, nil},
+		{0.1 + 0.2, 30, nil},
+		{19.999, 2000, nil},
+		{19.994, 1999, nil},
+		{-2.5, -250, nil},
+		{1234.56, 123456, nil},
+		{math.NaN(), 0, ErrInvalidAmount},
+		{math.Inf(1), 0, ErrInvalidAmount},
+	}
+
+	for _, tt := range tests {
+		m, err := FromFloat(tt.value, BRL)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("FromFloat(%v) err = %v, want %v", tt.value, err, tt.err)
+			continue
+		}
+		if err == nil && m.Cents() != tt.cents {
+			t.Errorf("FromFloat(%v) = %d, want %d", tt.value, m.Cents(), tt.cents)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		m       Money
+		decimal string
+		str     string
+	}{
+		{FromCents(0), "0.00", "R$ 0,00"},
+		{FromCents(5), "0.05", "R$ 0,05"},
+		{FromCents(-5), "-0.05", "-R$ 0,05"},
+		{FromCents(123456789), "1234567.89", "R$ 1.234.567,89"},
+		{New(100000, "USD"), "1000.00", "US$ 1.000,00"},
+		{New(100, "JPY"), "1.00", "JPY 1,00"},
+		{FromCents(math.MinInt64), "-92233720368547758.08", "-R$ 92.233.720.368.547.758,08"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.m.Decimal(); got != tt.decimal {
+			t.Errorf("Decimal(%d) = %q, want %q", tt.m.Cents(), got, tt.decimal)
+		}
+		if got := tt.m.String(); got != tt.str {
+			t.Errorf("String(%d) = %q, want %q", tt.m.Cents(), got, tt.str)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	for _, cents := range []int64{0, 1, -1, 150, 123456, -987654321} {
+		data, err := json.Marshal(FromCents(cents))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var m Money
+		if err := json.Unmarshal(data, &m); err != nil {
+			t.Fatalf("Unmarshal(%s): %v", data, err)
+		}
+		if m.Cents() != cents {
+			t.Errorf("round-trip de %d = %d (%s)", cents, m.Cents(), data)
+		}
+	}
+
+	tests := []struct {
+		data  string
+		cents int64
+		err   bool
+	}{
+		{`12.5`, 1250, false},
+		{`"12.50"`, 1250, false},
+		{`null`, 0, false},
+		{`12.345`, 0, true},
+		{`"abc"`, 0, true},
+	}
+
+	for _, tt := range tests {
+		var m Money
+		err := json.Unmarshal([]byte(tt.data), &m)
+		if (err != nil) != tt.err {
+			t.Errorf("Unmarshal(%s) err = %v", tt.data, err)
+			continue
+		}
+		if err == nil && m.Cents() != tt.cents {
+			t.Errorf("Unmarshal(%s) = %d, want %d", tt.data, m.Cents(), tt.cents)
+		}
+	}
+
+	var body struct {
+		Price Money `json:"price"`
+	}
+	if err := json.Unmarshal([]byte(`{"price": 10}`), &body); err != nil || body.Price.Cents() != 1000 {
+		t.Errorf("Unmarshal em struct = %d, %v", body.Price.Cents(), err)
+	}
+}
+
+func TestSQL(t *testing.T) {
+	for _, cents := range []int64{0, 99, -99, 123456} {
+		value, err := FromCents(cents).Value()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var m Money
+		if err := m.Scan(value); err != nil {
+			t.Fatalf("Scan(%v): %v", value, err)
+		}
+		if m.Cents() != cents {
+			t.Errorf("round-trip de %d = %d", cents, m.Cents())
+		}
+	}
+
+	tests := []struct {
+		src   any
+		cents int64
+		err   bool
+	}{
+		{[]byte("10.50"), 1050, false},
+		{"10.50", 1050, false},
+		{int64(7), 700, false},
+		{float64(1.5), 150, false},
+		{nil, 0, false},
+		{true, 0, true},
+		{"1.234", 0, true},
+	}
+
+	for _, tt := range tests {
+		m := FromCents(999)
+		err := m.Scan(tt.src)
+		if (err != nil) != tt.err {
+			t.Errorf("Scan(%v) err = %v", tt.src, err)
+			continue
+		}
+		if err == nil && m.Cents() != tt.cents {
+			t.Errorf("Scan(%v) = %d, want %d", tt.src, m.Cents(), tt.cents)
+		}
+	}
+}
@@ -6,11 +6,25 @@ import (
 	"net/http"
 	"prodata/api"
 	"prodata/database/account"
+	"strconv"
 )
 
-func UserNav(ctx *api.Context, userId string) {
-	email := account.GetEmailByUuid(userId)
-	data := account.GetUser(email)
+func UserNav(ctx *api.Context) {
+	users := account.Users()
+
+	email, err := users.FindEmailByUUID(ctx.Request.Context(), ctx.User().UserId)
+	if err != nil {
+		ctx.Logger.LogAndSendSystemMessage(err.Error())
+		ctx.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	data, err := users.FindByEmail(ctx.Request.Context(), email)
+	if err != nil {
+		ctx.Logger.LogAndSendSystemMessage(err.Error())
+		ctx.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 
 	hash := sha256.Sum256([]byte(email))
 	avatar := hex.EncodeToString(hash[:])
@@ -23,16 +37,31 @@ func UserNav(ctx *api.Context, userId string) {
 	})
 }
 
-func RecentServices(ctx *api.Context, userId string) {
-	services := account.GetServices(userId)
+// Aceita ?status=, ?type=, ?page= e ?limit=, o total vai no header X-Total-Count
+func RecentServices(ctx *api.Context) {
+	query := ctx.Request.URL.Query()
+
+	limit := ctx.QueryInt("limit", 5)
+	if limit > 100 {
+		limit = 100
+	}
+	page := ctx.QueryInt("page", 1)
 
-	if len(services) == 0 {
-		ctx.WriteHeader(http.StatusOK)
-		ctx.Json([]account.Services{})
+	services, total, err := account.ServicesRepository().List(ctx.Request.Context(), ctx.User().UserId, account.ServiceFilter{
+		Status: account.ServiceStatus(query.Get("status")),
+		Type:   query.Get("type"),
+		Limit:  limit,
+		Offset: (page - 1) * limit,
+	})
+	if err != nil {
+		ctx.Logger.LogAndSendSystemMessage(err.Error())
+		ctx.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	err := ctx.Json(services)
+	ctx.Writer.Header().Set("X-Total-Count", strconv.Itoa(total))
+
+	err = ctx.Json(services)
 	ok := ctx.IfErrNotNull(err)
 	if !ok {
 		return
@@ -245,7 +245,7 @@ func HandlerMagicLink(ctx *api.Context) {
 	}
 
 	userUuid := account.GetUserUUID(email["email"])
-	ok := account.IsValidMagicLink(userUuid, ctx.NewRoutes().DynamicRoute())
+	ok := account.IsValidMagicLink(userUuid, ctx.Param("token"))
 
 	if ok {
 		dId := account.ValidMagicLink(userUuid, ctx.Request.Header.Get("User-Agent"), ctx.Request.RemoteAddr)
@@ -366,7 +366,7 @@ func HandlerChangePasswordReset(ctx *api.Context) {
 		return
 	}
 
-	token := ctx.NewRoutes().DynamicRoute()
+	token := ctx.Param("token")
 	if len(token) != 30 {
 		ctx.WriteHeader(http.StatusBadRequest)
 		return
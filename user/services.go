@@ -0,0 +1,54 @@
+package user
+
+import (
+	"errors"
+	"net/http"
+	"prodata/api"
+	"prodata/database/account"
+)
+
+// Rota de administrador: POST /admin/services/{id}/{action}
+// com o corpo {"reason": "..."}
+func HandlerServiceTransition(ctx *api.Context) {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+
+	if ctx.Request.ContentLength != 0 {
+		err := ctx.ReadJson(&body)
+		if err != nil {
+			ctx.Error(err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	action := account.ServiceAction(ctx.Param("action"))
+	service, err := account.Lifecycle().Apply(ctx.Request.Context(), ctx.Param("id"), action, body.Reason)
+	if err != nil {
+		switch {
+		case errors.Is(err, account.ErrServiceNotFound):
+			ctx.Error(err.Error(), http.StatusNotFound)
+		case errors.Is(err, account.ErrInvalidTransition):
+			ctx.Error(err.Error(), http.StatusConflict)
+		case errors.Is(err, account.ErrUnknownAction):
+			ctx.Error(err.Error(), http.StatusBadRequest)
+		default:
+			ctx.Logger.LogAndSendSystemMessage(err.Error())
+			ctx.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	ctx.Json(service)
+}
+
+func HandlerServiceHistory(ctx *api.Context) {
+	history, err := account.Lifecycle().History(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		ctx.Logger.LogAndSendSystemMessage(err.Error())
+		ctx.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	ctx.Json(history)
+}